@@ -5,6 +5,10 @@ import Register from './pages/Register'
 import Dashboard from './pages/Dashboard'
 import Terminal from './pages/Terminal'
 import AuthCallback from './pages/AuthCallback'
+import ForgotPassword from './pages/ForgotPassword'
+import ResetPassword from './pages/ResetPassword'
+import VerifyEmail from './pages/VerifyEmail'
+import Account from './pages/Account'
 import Navbar from './components/Navbar'
 
 function ProtectedRoute({ children }) {
@@ -60,12 +64,24 @@ function App() {
                         <Register />
                     </PublicRoute>
                 } />
+                <Route path="/forgot-password" element={
+                    <PublicRoute>
+                        <ForgotPassword />
+                    </PublicRoute>
+                } />
                 <Route path="/auth/callback" element={<AuthCallback />} />
+                <Route path="/reset-password" element={<ResetPassword />} />
+                <Route path="/verify-email" element={<VerifyEmail />} />
                 <Route path="/dashboard" element={
                     <ProtectedRoute>
                         <Dashboard />
                     </ProtectedRoute>
                 } />
+                <Route path="/account" element={
+                    <ProtectedRoute>
+                        <Account />
+                    </ProtectedRoute>
+                } />
                 <Route path="/terminal/:id" element={
                     <ProtectedRoute>
                         <Terminal />
@@ -17,12 +17,12 @@ function Navbar() {
 
                 {user && (
                     <div className="navbar-user">
-                        <div className="user-info">
+                        <Link to="/account" className="user-info" title="Account settings">
                             <div className="user-avatar">
                                 {user.name?.charAt(0).toUpperCase()}
                             </div>
                             <span>{user.name}</span>
-                        </div>
+                        </Link>
                         <button onClick={logout} className="btn btn-ghost">
                             <svg width="18" height="18" viewBox="0 0 24 24" fill="none" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round">
                                 <path d="M9 21H5a2 2 0 0 1-2-2V5a2 2 0 0 1 2-2h4"></path>
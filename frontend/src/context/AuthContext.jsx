@@ -1,5 +1,6 @@
 import { createContext, useContext, useState, useEffect } from 'react'
-import api from '../services/api'
+import api, { clearTokens } from '../services/api'
+import * as webauthn from '../services/webauthn'
 
 const AuthContext = createContext(null)
 
@@ -23,7 +24,7 @@ export function AuthProvider({ children }) {
             const response = await api.get('/auth/me')
             setUser(response.data)
         } catch (err) {
-            localStorage.removeItem('token')
+            clearTokens()
         } finally {
             setLoading(false)
         }
@@ -33,7 +34,12 @@ export function AuthProvider({ children }) {
         setError(null)
         try {
             const response = await api.post('/auth/login', { email, password })
+            if (response.data.mfa_token) {
+                // Caller finishes the login with verifyMfa or the setup calls
+                return response.data
+            }
             localStorage.setItem('token', response.data.token)
+            localStorage.setItem('refresh_token', response.data.refresh_token)
             setUser(response.data.user)
             return response.data
         } catch (err) {
@@ -47,7 +53,12 @@ export function AuthProvider({ children }) {
         setError(null)
         try {
             const response = await api.post('/auth/register', { email, password, name })
+            if (response.data.mfa_setup_required) {
+                // Two-factor enrollment happens on the first sign in
+                return response.data
+            }
             localStorage.setItem('token', response.data.token)
+            localStorage.setItem('refresh_token', response.data.refresh_token)
             setUser(response.data.user)
             return response.data
         } catch (err) {
@@ -57,17 +68,64 @@ export function AuthProvider({ children }) {
         }
     }
 
-    const loginWithGoogle = (mode = 'login') => {
-        window.location.href = `/api/auth/google?mode=${mode}`
+    const storeSession = async (data) => {
+        localStorage.setItem('token', data.token)
+        localStorage.setItem('refresh_token', data.refresh_token)
+        await checkAuth()
+    }
+
+    const verifyMfa = async (mfaToken, code) => {
+        const response = await api.post('/auth/mfa/verify', { mfa_token: mfaToken, code })
+        await storeSession(response.data)
+    }
+
+    const verifyPasskeyMfa = async (mfaToken) => {
+        await storeSession(await webauthn.verifyPasskeyMfa(mfaToken))
     }
 
-    const handleGoogleCallback = async (token) => {
+    const loginWithPasskey = async () => {
+        await storeSession(await webauthn.loginWithPasskey())
+    }
+
+    const beginMfaSetup = async (mfaToken) => {
+        const response = await api.post('/auth/mfa/setup', { mfa_token: mfaToken })
+        return response.data
+    }
+
+    // completeMfaSetup returns the recovery codes so they can be shown once
+    const completeMfaSetup = async (mfaToken, code) => {
+        const response = await api.post('/auth/mfa/setup/confirm', { mfa_token: mfaToken, code })
+        await storeSession(response.data)
+        return response.data.recovery_codes
+    }
+
+    // loginWithProvider leaves the app to sign in with an identity provider,
+    // which redirects back to /auth/callback
+    const loginWithProvider = (provider, mode = 'login') => {
+        window.location.href = `/api/auth/oidc/${encodeURIComponent(provider)}?mode=${mode}`
+    }
+
+    const handleProviderCallback = async (token, refreshToken) => {
         localStorage.setItem('token', token)
+        localStorage.setItem('refresh_token', refreshToken)
         await checkAuth()
     }
 
-    const logout = () => {
-        localStorage.removeItem('token')
+    const logout = async () => {
+        try {
+            // Revoke the session server-side so the tokens stop working now
+            await api.post('/auth/logout')
+        } catch (err) {
+            // Clear local state even if the server could not be reached
+        }
+        clearTokens()
+        setUser(null)
+    }
+
+    // Drops the tokens without telling the server, for sessions it has
+    // already ended, e.g. when the account was deleted
+    const clearSession = () => {
+        clearTokens()
         setUser(null)
     }
 
@@ -78,9 +136,16 @@ export function AuthProvider({ children }) {
             error,
             login,
             register,
-            loginWithGoogle,
-            handleGoogleCallback,
-            logout
+            verifyMfa,
+            verifyPasskeyMfa,
+            loginWithPasskey,
+            beginMfaSetup,
+            completeMfaSetup,
+            loginWithProvider,
+            handleProviderCallback,
+            refreshUser: checkAuth,
+            logout,
+            clearSession
         }}>
             {children}
         </AuthContext.Provider>
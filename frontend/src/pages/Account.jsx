@@ -0,0 +1,304 @@
+import { useState, useEffect, useRef } from 'react'
+import { useNavigate, useSearchParams } from 'react-router-dom'
+import api, { accountApi } from '../services/api'
+import { useAuth } from '../context/AuthContext'
+
+function Account() {
+    const navigate = useNavigate()
+    const [params] = useSearchParams()
+    const { user, clearSession } = useAuth()
+    const [message, setMessage] = useState('')
+    const [error, setError] = useState(params.get('error') || '')
+
+    const [currentPassword, setCurrentPassword] = useState('')
+    const [newPassword, setNewPassword] = useState('')
+    const [confirmPassword, setConfirmPassword] = useState('')
+    const [savingPassword, setSavingPassword] = useState(false)
+
+    const [providers, setProviders] = useState([])
+    const [identities, setIdentities] = useState([])
+
+    const [deleteConfirm, setDeleteConfirm] = useState('')
+    const [deleteCode, setDeleteCode] = useState('')
+    const [deleting, setDeleting] = useState(false)
+
+    // A provider sign in started from this page comes back with a link to
+    // confirm. Confirming twice fails, so StrictMode's second run is skipped.
+    const linkHandled = useRef(false)
+
+    useEffect(() => {
+        api.get('/auth/providers')
+            .then((response) => setProviders(response.data))
+            .catch(() => setProviders([]))
+        loadIdentities()
+
+        // The link token arrives in the fragment so it never reaches a server
+        const fragment = new URLSearchParams(window.location.hash.slice(1))
+        const linkToken = fragment.get('link')
+        if (linkToken && !linkHandled.current) {
+            linkHandled.current = true
+            const provider = fragment.get('provider')
+            window.history.replaceState(null, '', window.location.pathname)
+            const password = window.prompt(`Enter your password to link this ${provider} account so you can sign in with it.`)
+            if (password) {
+                accountApi.confirmLink(linkToken, password)
+                    .then(() => {
+                        setMessage(`Your ${provider} account is now linked.`)
+                        loadIdentities()
+                    })
+                    .catch((err) => setError(err.response?.data || 'Could not link the account'))
+            }
+        }
+    }, [])
+
+    const loadIdentities = () => {
+        accountApi.listIdentities()
+            .then((response) => setIdentities(response.data))
+            .catch(() => setIdentities([]))
+    }
+
+    const handleChangePassword = async (e) => {
+        e.preventDefault()
+        setMessage('')
+        setError('')
+        if (newPassword !== confirmPassword) {
+            setError('Passwords do not match')
+            return
+        }
+        setSavingPassword(true)
+        try {
+            await accountApi.changePassword(currentPassword, newPassword)
+            setCurrentPassword('')
+            setNewPassword('')
+            setConfirmPassword('')
+            setMessage('Your password has been changed. Other devices have been signed out.')
+        } catch (err) {
+            setError(err.response?.data || 'Could not change your password')
+        } finally {
+            setSavingPassword(false)
+        }
+    }
+
+    const handleLink = async (provider) => {
+        setError('')
+        if (provider.directory) {
+            return handleLinkDirectory(provider)
+        }
+        try {
+            const response = await accountApi.linkIdentity(provider.name)
+            window.location.href = response.data.url
+        } catch (err) {
+            setError(err.response?.data || 'Could not reach the identity provider')
+        }
+    }
+
+    // The directory has no sign in page to send the browser to, so its
+    // credentials are asked for here
+    const handleLinkDirectory = async (provider) => {
+        const username = window.prompt(`Your ${provider.display_name} username`)
+        if (!username) return
+        const directoryPassword = window.prompt(`Your ${provider.display_name} password`)
+        if (!directoryPassword) return
+        const password = window.prompt('Your password for this account')
+        if (!password) return
+        setMessage('')
+        try {
+            await accountApi.linkDirectory(username, directoryPassword, password)
+            setMessage(`Your ${provider.display_name} account is now linked.`)
+            loadIdentities()
+        } catch (err) {
+            setError(err.response?.data || 'Could not link the account')
+        }
+    }
+
+    const handleUnlink = async (identity) => {
+        if (!window.confirm(`Unlink ${identity.email || identity.provider}? You won't be able to sign in with it anymore.`)) {
+            return
+        }
+        setMessage('')
+        setError('')
+        try {
+            await accountApi.unlinkIdentity(identity.id)
+            loadIdentities()
+        } catch (err) {
+            setError(err.response?.data || 'Could not unlink the account')
+        }
+    }
+
+    const handleExport = async () => {
+        setError('')
+        try {
+            const response = await accountApi.exportData()
+            const url = URL.createObjectURL(response.data)
+            const link = document.createElement('a')
+            link.href = url
+            link.download = 'account-export.json'
+            link.click()
+            URL.revokeObjectURL(url)
+        } catch (err) {
+            setError('Could not export your data')
+        }
+    }
+
+    const handleDelete = async (e) => {
+        e.preventDefault()
+        if (!window.confirm('Delete your account, connections and recordings for good?')) {
+            return
+        }
+        setError('')
+        setDeleting(true)
+        try {
+            if (user.has_password) {
+                await accountApi.deleteAccount(deleteConfirm, '', '')
+            } else {
+                await accountApi.deleteAccount('', deleteConfirm, deleteCode)
+            }
+            clearSession()
+            navigate('/login', { replace: true })
+        } catch (err) {
+            setError(err.response?.data || 'Could not delete your account')
+            setDeleting(false)
+        }
+    }
+
+    const linkedProviders = new Set(identities.map((identity) => identity.provider))
+    const providerName = (name) => providers.find((p) => p.name === name)?.display_name || name
+
+    return (
+        <main className="main-content">
+            <div className="dashboard-header">
+                <h1 className="dashboard-title">Account</h1>
+            </div>
+
+            {message && <div className="alert">{message}</div>}
+            {error && <div className="alert alert-error">{error}</div>}
+
+            {user?.has_password && (
+                <section className="account-section">
+                    <h2>Change password</h2>
+                    <form onSubmit={handleChangePassword}>
+                        <div className="form-group">
+                            <label className="form-label">Current password</label>
+                            <input
+                                type="password"
+                                className="form-input"
+                                autoComplete="current-password"
+                                value={currentPassword}
+                                onChange={(e) => setCurrentPassword(e.target.value)}
+                                required
+                            />
+                        </div>
+                        <div className="form-group">
+                            <label className="form-label">New password</label>
+                            <input
+                                type="password"
+                                className="form-input"
+                                autoComplete="new-password"
+                                value={newPassword}
+                                onChange={(e) => setNewPassword(e.target.value)}
+                                required
+                            />
+                        </div>
+                        <div className="form-group">
+                            <label className="form-label">Confirm new password</label>
+                            <input
+                                type="password"
+                                className="form-input"
+                                autoComplete="new-password"
+                                value={confirmPassword}
+                                onChange={(e) => setConfirmPassword(e.target.value)}
+                                required
+                            />
+                        </div>
+                        <button type="submit" className="btn btn-primary" disabled={savingPassword}>
+                            {savingPassword ? <span className="spinner"></span> : 'Change password'}
+                        </button>
+                    </form>
+                </section>
+            )}
+
+            {(providers.length > 0 || identities.length > 0) && (
+                <section className="account-section">
+                    <h2>Linked accounts</h2>
+                    {identities.map((identity) => (
+                        <div key={identity.id} className="account-row">
+                            <span>
+                                {providerName(identity.provider)}
+                                {identity.email && <span className="account-muted"> · {identity.email}</span>}
+                            </span>
+                            <button onClick={() => handleUnlink(identity)} className="btn btn-ghost">
+                                Unlink
+                            </button>
+                        </div>
+                    ))}
+                    {providers.filter((p) => !linkedProviders.has(p.name)).map((provider) => (
+                        <div key={provider.name} className="account-row">
+                            <span className="account-muted">{provider.display_name}</span>
+                            <button onClick={() => handleLink(provider)} className="btn btn-secondary">
+                                Link
+                            </button>
+                        </div>
+                    ))}
+                </section>
+            )}
+
+            <section className="account-section">
+                <h2>Your data</h2>
+                <p className="account-muted">
+                    Download your profile, connections, team memberships and activity as JSON. Saved
+                    passwords and keys are left out.
+                </p>
+                <button onClick={handleExport} className="btn btn-secondary">
+                    Export data
+                </button>
+            </section>
+
+            <section className="account-section">
+                <h2>Delete account</h2>
+                <p className="account-muted">
+                    This removes your account, your SSH connections, including those shared with
+                    teams, and your session recordings. It cannot be undone.
+                </p>
+                <form onSubmit={handleDelete}>
+                    <div className="form-group">
+                        <label className="form-label">
+                            {user?.has_password ? 'Password' : `Type ${user?.email} to confirm`}
+                        </label>
+                        <input
+                            type={user?.has_password ? 'password' : 'text'}
+                            className="form-input"
+                            autoComplete={user?.has_password ? 'current-password' : 'off'}
+                            value={deleteConfirm}
+                            onChange={(e) => setDeleteConfirm(e.target.value)}
+                            required
+                        />
+                    </div>
+                    {!user?.has_password && user?.mfa_enabled && (
+                        <div className="form-group">
+                            <label className="form-label">Two-factor code</label>
+                            <input
+                                type="text"
+                                className="form-input"
+                                autoComplete="one-time-code"
+                                value={deleteCode}
+                                onChange={(e) => setDeleteCode(e.target.value)}
+                            />
+                        </div>
+                    )}
+                    {!user?.has_password && (
+                        <p className="account-muted">
+                            {user?.mfa_enabled
+                                ? 'Enter a two-factor code, or sign out and back in, before deleting your account.'
+                                : 'Sign out and back in before deleting your account.'}
+                        </p>
+                    )}
+                    <button type="submit" className="btn btn-danger" disabled={deleting}>
+                        {deleting ? <span className="spinner"></span> : 'Delete account'}
+                    </button>
+                </form>
+            </section>
+        </main>
+    )
+}
+
+export default Account
@@ -1,22 +1,25 @@
 import { useEffect } from 'react'
-import { useNavigate, useSearchParams } from 'react-router-dom'
+import { useLocation, useNavigate } from 'react-router-dom'
 import { useAuth } from '../context/AuthContext'
 
 function AuthCallback() {
     const navigate = useNavigate()
-    const [searchParams] = useSearchParams()
-    const { handleGoogleCallback } = useAuth()
+    const location = useLocation()
+    const { handleProviderCallback } = useAuth()
 
     useEffect(() => {
-        const token = searchParams.get('token')
+        // Tokens arrive in the fragment so they never reach a server or its logs
+        const params = new URLSearchParams(location.hash.slice(1))
+        window.history.replaceState(null, '', location.pathname)
+        const token = params.get('token')
         if (token) {
-            handleGoogleCallback(token).then(() => {
+            handleProviderCallback(token, params.get('refresh_token')).then(() => {
                 navigate('/dashboard')
             })
         } else {
             navigate('/login')
         }
-    }, [searchParams, handleGoogleCallback, navigate])
+    }, [location, handleProviderCallback, navigate])
 
     return (
         <div className="auth-page">
@@ -1,16 +1,19 @@
 import { useState, useEffect } from 'react'
 import { useNavigate } from 'react-router-dom'
-import { sshApi } from '../services/api'
+import { useAuth } from '../context/AuthContext'
+import { sshApi, accountApi } from '../services/api'
 import SSHConnectionCard from '../components/SSHConnectionCard'
 import SSHConnectionForm from '../components/SSHConnectionForm'
 
 function Dashboard() {
     const navigate = useNavigate()
+    const { user } = useAuth()
     const [connections, setConnections] = useState([])
     const [loading, setLoading] = useState(true)
     const [showForm, setShowForm] = useState(false)
     const [editConnection, setEditConnection] = useState(null)
     const [error, setError] = useState('')
+    const [verificationSent, setVerificationSent] = useState(false)
 
     useEffect(() => {
         fetchConnections()
@@ -27,6 +30,15 @@ function Dashboard() {
         }
     }
 
+    const resendVerification = async () => {
+        try {
+            await accountApi.resendVerification()
+            setVerificationSent(true)
+        } catch (err) {
+            setError(err.response?.data || 'Failed to send verification email')
+        }
+    }
+
     const handleConnect = (id) => {
         navigate(`/terminal/${id}`)
     }
@@ -80,6 +92,19 @@ function Dashboard() {
                 </button>
             </div>
 
+            {user && !user.email_verified && (
+                <div className="alert">
+                    {verificationSent
+                        ? `We sent a new verification link to ${user.email}.`
+                        : `Please confirm ${user.email} using the link we emailed you.`}
+                    {!verificationSent && (
+                        <button onClick={resendVerification} className="btn btn-ghost" style={{ marginLeft: 'auto' }}>
+                            Resend link
+                        </button>
+                    )}
+                </div>
+            )}
+
             {error && (
                 <div className="alert alert-error">
                     {error}
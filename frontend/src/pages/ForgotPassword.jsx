@@ -0,0 +1,72 @@
+import { useState } from 'react'
+import { Link } from 'react-router-dom'
+import { accountApi } from '../services/api'
+
+function ForgotPassword() {
+    const [email, setEmail] = useState('')
+    const [loading, setLoading] = useState(false)
+    const [sent, setSent] = useState(false)
+    const [formError, setFormError] = useState('')
+
+    const handleSubmit = async (e) => {
+        e.preventDefault()
+        setFormError('')
+        setLoading(true)
+
+        try {
+            await accountApi.forgotPassword(email)
+            setSent(true)
+        } catch (err) {
+            setFormError(err.response?.data || 'Could not send the reset link')
+        } finally {
+            setLoading(false)
+        }
+    }
+
+    return (
+        <div className="auth-page">
+            <div className="auth-container">
+                <div className="auth-card">
+                    <h1 className="auth-title">Reset your password</h1>
+
+                    {formError && <div className="alert alert-error">{formError}</div>}
+
+                    {sent ? (
+                        <p className="auth-subtitle">
+                            If an account with a password uses {email}, a reset link is on its way. It works once and expires soon.
+                        </p>
+                    ) : (
+                        <>
+                            <p className="auth-subtitle">Enter your email and we'll send you a link to choose a new password</p>
+                            <form onSubmit={handleSubmit}>
+                                <div className="form-group">
+                                    <label className="form-label">Email</label>
+                                    <input
+                                        type="email"
+                                        className="form-input"
+                                        autoComplete="email"
+                                        placeholder="you@example.com"
+                                        value={email}
+                                        onChange={(e) => setEmail(e.target.value)}
+                                        autoFocus
+                                        required
+                                    />
+                                </div>
+
+                                <button type="submit" className="btn btn-primary btn-full btn-lg" disabled={loading}>
+                                    {loading ? <span className="spinner"></span> : 'Send reset link'}
+                                </button>
+                            </form>
+                        </>
+                    )}
+
+                    <p className="auth-footer">
+                        <Link to="/login">Back to sign in</Link>
+                    </p>
+                </div>
+            </div>
+        </div>
+    )
+}
+
+export default ForgotPassword
@@ -1,14 +1,20 @@
 import { useState, useEffect } from 'react'
 import { Link, useNavigate } from 'react-router-dom'
 import { useAuth } from '../context/AuthContext'
+import ProviderButtons from '../components/ProviderButtons'
+import { passkeySupported } from '../services/webauthn'
 
 function Login() {
     const navigate = useNavigate()
-    const { login, loginWithGoogle, error } = useAuth()
+    const { login, verifyMfa, verifyPasskeyMfa, loginWithPasskey, beginMfaSetup, completeMfaSetup, error } = useAuth()
     const [email, setEmail] = useState('')
     const [password, setPassword] = useState('')
     const [loading, setLoading] = useState(false)
     const [formError, setFormError] = useState('')
+    // Set when the password was accepted but a second factor is still due
+    const [mfa, setMfa] = useState(null)
+    const [mfaCode, setMfaCode] = useState('')
+    const [recoveryCodes, setRecoveryCodes] = useState(null)
 
     // Check for error in URL
     useEffect(() => {
@@ -17,15 +23,50 @@ function Login() {
         if (errorMsg) {
             setFormError(errorMsg)
         }
+
+        // Provider sign in redirects here when a second factor is due, with
+        // the token in the fragment so it never reaches a server or its logs
+        const fragment = new URLSearchParams(window.location.hash.slice(1))
+        const mfaToken = fragment.get('mfa_token')
+        if (mfaToken) {
+            window.history.replaceState(null, '', window.location.pathname + window.location.search)
+            startMfa({
+                mfa_token: mfaToken,
+                mfa_methods: fragment.get('mfa_methods')?.split(',').filter(Boolean),
+                mfa_setup_required: fragment.get('mfa_setup_required') === 'true'
+            }).catch((err) => setFormError(err.response?.data || err.message))
+        }
     }, [])
 
+    const startMfa = async (data) => {
+        const enrollment = data.mfa_setup_required ? await beginMfaSetup(data.mfa_token) : null
+        setMfa({ token: data.mfa_token, methods: data.mfa_methods || [], enrollment })
+    }
+
+    const runPasskey = async (action) => {
+        setFormError('')
+        setLoading(true)
+        try {
+            await action()
+            navigate('/dashboard')
+        } catch (err) {
+            setFormError(err.response?.data || 'Passkey sign in failed')
+        } finally {
+            setLoading(false)
+        }
+    }
+
     const handleSubmit = async (e) => {
         e.preventDefault()
         setFormError('')
         setLoading(true)
 
         try {
-            await login(email, password)
+            const data = await login(email, password)
+            if (data.mfa_token) {
+                await startMfa(data)
+                return
+            }
             navigate('/dashboard')
         } catch (err) {
             setFormError(err.message)
@@ -34,6 +75,30 @@ function Login() {
         }
     }
 
+    const handleMfaSubmit = async (e) => {
+        e.preventDefault()
+        setFormError('')
+        setLoading(true)
+
+        try {
+            if (mfa.enrollment) {
+                setRecoveryCodes(await completeMfaSetup(mfa.token, mfaCode))
+            } else {
+                await verifyMfa(mfa.token, mfaCode)
+                navigate('/dashboard')
+            }
+        } catch (err) {
+            setFormError(err.response?.data || 'Verification failed')
+            if (err.response?.status === 401 && /token/i.test(err.response.data)) {
+                // Too many attempts or expired: start over with the password
+                setMfa(null)
+            }
+        } finally {
+            setLoading(false)
+            setMfaCode('')
+        }
+    }
+
     return (
         <div className="auth-page">
             <div className="auth-container">
@@ -60,47 +125,108 @@ function Login() {
                         </div>
                     )}
 
-                    <button onClick={() => loginWithGoogle('login')} className="btn btn-google btn-full btn-lg">
-                        <svg width="20" height="20" viewBox="0 0 24 24">
-                            <path fill="#4285F4" d="M22.56 12.25c0-.78-.07-1.53-.2-2.25H12v4.26h5.92c-.26 1.37-1.04 2.53-2.21 3.31v2.77h3.57c2.08-1.92 3.28-4.74 3.28-8.09z" />
-                            <path fill="#34A853" d="M12 23c2.97 0 5.46-.98 7.28-2.66l-3.57-2.77c-.98.66-2.23 1.06-3.71 1.06-2.86 0-5.29-1.93-6.16-4.53H2.18v2.84C3.99 20.53 7.7 23 12 23z" />
-                            <path fill="#FBBC05" d="M5.84 14.09c-.22-.66-.35-1.36-.35-2.09s.13-1.43.35-2.09V7.07H2.18C1.43 8.55 1 10.22 1 12s.43 3.45 1.18 4.93l2.85-2.22.81-.62z" />
-                            <path fill="#EA4335" d="M12 5.38c1.62 0 3.06.56 4.21 1.64l3.15-3.15C17.45 2.09 14.97 1 12 1 7.7 1 3.99 3.47 2.18 7.07l3.66 2.84c.87-2.6 3.3-4.53 6.16-4.53z" />
-                        </svg>
-                        Continue with Google
-                    </button>
-
-                    <div className="auth-divider">or</div>
-
-                    <form onSubmit={handleSubmit}>
-                        <div className="form-group">
-                            <label className="form-label">Email</label>
-                            <input
-                                type="email"
-                                className="form-input"
-                                placeholder="you@example.com"
-                                value={email}
-                                onChange={(e) => setEmail(e.target.value)}
-                                required
-                            />
-                        </div>
+                    {recoveryCodes ? (
+                        <>
+                            <p className="auth-subtitle">
+                                Save these recovery codes somewhere safe. Each one signs you in once if you lose your authenticator.
+                            </p>
+                            <div className="form-group connection-details">
+                                {recoveryCodes.map((code) => <p key={code}>{code}</p>)}
+                            </div>
+                            <button onClick={() => navigate('/dashboard')} className="btn btn-primary btn-full btn-lg">
+                                Continue
+                            </button>
+                        </>
+                    ) : mfa ? (
+                        <>
+                            {mfa.methods.includes('webauthn') && (
+                                <button onClick={() => runPasskey(() => verifyPasskeyMfa(mfa.token))} className="btn btn-primary btn-full btn-lg" disabled={loading}>
+                                    Use a passkey
+                                </button>
+                            )}
+                            {mfa.methods.length > 1 && <div className="auth-divider">or</div>}
+                            {(mfa.enrollment || mfa.methods.includes('totp')) && (
+                                <form onSubmit={handleMfaSubmit}>
+                                    {mfa.enrollment && (
+                                        <div className="form-group connection-details">
+                                            <p className="auth-subtitle">
+                                                Your account requires two-factor authentication. Add this key to your authenticator app:
+                                            </p>
+                                            <p>{mfa.enrollment.secret}</p>
+                                            <p><a href={mfa.enrollment.otpauth_uri}>Open in authenticator</a></p>
+                                        </div>
+                                    )}
 
-                        <div className="form-group">
-                            <label className="form-label">Password</label>
-                            <input
-                                type="password"
-                                className="form-input"
-                                placeholder="••••••••"
-                                value={password}
-                                onChange={(e) => setPassword(e.target.value)}
-                                required
-                            />
-                        </div>
+                                    <div className="form-group">
+                                        <label className="form-label">
+                                            {mfa.enrollment ? 'Code from your authenticator' : 'Authentication or recovery code'}
+                                        </label>
+                                        <input
+                                            type="text"
+                                            className="form-input"
+                                            autoComplete="one-time-code"
+                                            placeholder="123456"
+                                            value={mfaCode}
+                                            onChange={(e) => setMfaCode(e.target.value)}
+                                            autoFocus
+                                            required
+                                        />
+                                    </div>
+
+                                    <button type="submit" className="btn btn-primary btn-full btn-lg" disabled={loading}>
+                                        {loading ? <span className="spinner"></span> : 'Verify'}
+                                    </button>
+                                </form>
+                            )}
+                        </>
+                    ) : (
+                        <>
+                            <ProviderButtons mode="login" />
+
+                            {passkeySupported() && (
+                                <button onClick={() => runPasskey(loginWithPasskey)} className="btn btn-ghost btn-full btn-lg" disabled={loading}>
+                                    Sign in with a passkey
+                                </button>
+                            )}
+
+                            <div className="auth-divider">or</div>
 
-                        <button type="submit" className="btn btn-primary btn-full btn-lg" disabled={loading}>
-                            {loading ? <span className="spinner"></span> : 'Sign in'}
-                        </button>
-                    </form>
+                            <form onSubmit={handleSubmit}>
+                                <div className="form-group">
+                                    <label className="form-label">Email or username</label>
+                                    <input
+                                        type="text"
+                                        className="form-input"
+                                        autoComplete="username"
+                                        placeholder="you@example.com"
+                                        value={email}
+                                        onChange={(e) => setEmail(e.target.value)}
+                                        required
+                                    />
+                                </div>
+
+                                <div className="form-group">
+                                    <label className="form-label">Password</label>
+                                    <input
+                                        type="password"
+                                        className="form-input"
+                                        placeholder="••••••••"
+                                        value={password}
+                                        onChange={(e) => setPassword(e.target.value)}
+                                        required
+                                    />
+                                </div>
+
+                                <button type="submit" className="btn btn-primary btn-full btn-lg" disabled={loading}>
+                                    {loading ? <span className="spinner"></span> : 'Sign in'}
+                                </button>
+                            </form>
+
+                            <p className="auth-footer">
+                                <Link to="/forgot-password">Forgot your password?</Link>
+                            </p>
+                        </>
+                    )}
 
                     <p className="auth-footer">
                         Don't have an account? <Link to="/register">Sign up</Link>
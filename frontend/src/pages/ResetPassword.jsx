@@ -0,0 +1,82 @@
+import { useState } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { accountApi } from '../services/api'
+
+function ResetPassword() {
+    const [params] = useSearchParams()
+    const [password, setPassword] = useState('')
+    const [confirm, setConfirm] = useState('')
+    const [loading, setLoading] = useState(false)
+    const [done, setDone] = useState(false)
+    const [formError, setFormError] = useState('')
+
+    const handleSubmit = async (e) => {
+        e.preventDefault()
+        if (password !== confirm) {
+            setFormError('Passwords do not match')
+            return
+        }
+        setFormError('')
+        setLoading(true)
+
+        try {
+            await accountApi.resetPassword(params.get('token') || '', password)
+            setDone(true)
+        } catch (err) {
+            setFormError(err.response?.data || 'Could not reset your password')
+        } finally {
+            setLoading(false)
+        }
+    }
+
+    return (
+        <div className="auth-page">
+            <div className="auth-container">
+                <div className="auth-card">
+                    <h1 className="auth-title">Choose a new password</h1>
+
+                    {formError && <div className="alert alert-error">{formError}</div>}
+
+                    {done ? (
+                        <p className="auth-subtitle">
+                            Your password has been changed and you were signed out everywhere. <Link to="/login">Sign in</Link> with the new one.
+                        </p>
+                    ) : (
+                        <form onSubmit={handleSubmit}>
+                            <div className="form-group">
+                                <label className="form-label">New password</label>
+                                <input
+                                    type="password"
+                                    className="form-input"
+                                    autoComplete="new-password"
+                                    value={password}
+                                    onChange={(e) => setPassword(e.target.value)}
+                                    autoFocus
+                                    required
+                                />
+                            </div>
+
+                            <div className="form-group">
+                                <label className="form-label">Confirm password</label>
+                                <input
+                                    type="password"
+                                    className="form-input"
+                                    autoComplete="new-password"
+                                    value={confirm}
+                                    onChange={(e) => setConfirm(e.target.value)}
+                                    required
+                                />
+                            </div>
+
+                            <button type="submit" className="btn btn-primary btn-full btn-lg" disabled={loading}>
+                                {loading ? <span className="spinner"></span> : 'Set password'}
+                            </button>
+                        </form>
+                    )}
+                </div>
+            </div>
+        </div>
+    )
+}
+
+export default ResetPassword
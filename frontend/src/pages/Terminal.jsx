@@ -4,7 +4,7 @@ import { Terminal } from 'xterm'
 import { FitAddon } from 'xterm-addon-fit'
 import { WebLinksAddon } from 'xterm-addon-web-links'
 import 'xterm/css/xterm.css'
-import { sshApi } from '../services/api'
+import { sshApi, ensureAccessToken } from '../services/api'
 
 function TerminalPage() {
     const { id } = useParams()
@@ -13,10 +13,14 @@ function TerminalPage() {
     const termRef = useRef(null)
     const fitAddonRef = useRef(null)
     const wsRef = useRef(null)
+    const sessionIdRef = useRef(null)
     const [connection, setConnection] = useState(null)
     const [status, setStatus] = useState('connecting')
     const [error, setError] = useState('')
     const [isTerminalReady, setIsTerminalReady] = useState(false)
+    // Set while the server waits for the passphrase of a key that has none stored
+    const [passphrasePrompt, setPassphrasePrompt] = useState(null)
+    const [passphrase, setPassphrase] = useState('')
 
     // Fetch connection info
     useEffect(() => {
@@ -125,13 +129,16 @@ function TerminalPage() {
     // Connect WebSocket when terminal is ready
     useEffect(() => {
         if (!isTerminalReady) return
+        let cancelled = false
 
-        const connect = () => {
+        const connect = async () => {
             if (wsRef.current) {
                 wsRef.current.close()
             }
 
-            const token = localStorage.getItem('token')
+            // WebSockets carry the token in the URL, so make sure it is fresh
+            const token = await ensureAccessToken()
+            if (cancelled) return
             const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
             const wsUrl = `${wsProtocol}//${window.location.host}/ws/terminal/${id}?token=${token}`
 
@@ -170,9 +177,17 @@ function TerminalPage() {
                     const parsed = JSON.parse(data)
                     if (parsed.type === 'output' && termRef.current) {
                         termRef.current.write(parsed.data)
+                    } else if (parsed.type === 'session') {
+                        sessionIdRef.current = parsed.session_id
+                        setPassphrasePrompt(null)
+                    } else if (parsed.type === 'passphrase_required') {
+                        setPassphrasePrompt(parsed)
+                    } else if (parsed.type === 'exit') {
+                        sessionIdRef.current = null
                     } else if (parsed.type === 'error') {
                         setError(parsed.data)
                         setStatus('disconnected')
+                        setPassphrasePrompt(null)
                     }
                 } catch (e) {
                     // Raw output, write directly to terminal
@@ -185,6 +200,7 @@ function TerminalPage() {
             ws.onclose = () => {
                 console.log('WebSocket closed')
                 setStatus('disconnected')
+                setPassphrasePrompt(null)
             }
 
             ws.onerror = (e) => {
@@ -197,23 +213,40 @@ function TerminalPage() {
         connect()
 
         return () => {
+            cancelled = true
             wsRef.current?.close()
         }
     }, [id, isTerminalReady])
 
+    // An empty passphrase tells the server to give up on the key
+    const answerPassphrase = (value) => {
+        if (wsRef.current && wsRef.current.readyState === WebSocket.OPEN) {
+            wsRef.current.send(JSON.stringify({ type: 'passphrase', data: value }))
+        }
+        setPassphrase('')
+        setPassphrasePrompt(null)
+    }
+
+    const handlePassphraseSubmit = (e) => {
+        e.preventDefault()
+        answerPassphrase(passphrase)
+    }
+
     const handleDisconnect = () => {
         wsRef.current?.close()
         navigate('/dashboard')
     }
 
-    const handleReconnect = () => {
+    const handleReconnect = async () => {
         if (termRef.current) {
             termRef.current.clear()
         }
 
-        const token = localStorage.getItem('token')
+        // Reattach to the still-running session if there is one
+        const token = await ensureAccessToken()
         const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
-        const wsUrl = `${wsProtocol}//${window.location.host}/ws/terminal/${id}?token=${token}`
+        const wsPath = sessionIdRef.current ? `session/${sessionIdRef.current}` : id
+        const wsUrl = `${wsProtocol}//${window.location.host}/ws/terminal/${wsPath}?token=${token}`
 
         setStatus('connecting')
         setError('')
@@ -243,9 +276,17 @@ function TerminalPage() {
                 const parsed = JSON.parse(data)
                 if (parsed.type === 'output' && termRef.current) {
                     termRef.current.write(parsed.data)
+                } else if (parsed.type === 'session') {
+                    sessionIdRef.current = parsed.session_id
+                    setPassphrasePrompt(null)
+                } else if (parsed.type === 'passphrase_required') {
+                    setPassphrasePrompt(parsed)
+                } else if (parsed.type === 'exit') {
+                    sessionIdRef.current = null
                 } else if (parsed.type === 'error') {
                     setError(parsed.data)
                     setStatus('disconnected')
+                    setPassphrasePrompt(null)
                 }
             } catch (e) {
                 if (termRef.current && typeof data === 'string') {
@@ -254,7 +295,10 @@ function TerminalPage() {
             }
         }
 
-        ws.onclose = () => setStatus('disconnected')
+        ws.onclose = () => {
+            setStatus('disconnected')
+            setPassphrasePrompt(null)
+        }
         ws.onerror = () => {
             setError('WebSocket connection failed')
             setStatus('disconnected')
@@ -296,6 +340,28 @@ function TerminalPage() {
                     </div>
                 )}
 
+                {passphrasePrompt && (
+                    <form onSubmit={handlePassphraseSubmit} className="alert" style={{ margin: '0.5rem', borderRadius: '4px' }}>
+                        <label className="form-label">
+                            {passphrasePrompt.retry ? 'Wrong passphrase. ' : ''}
+                            Enter the key passphrase for {passphrasePrompt.name} ({passphrasePrompt.host})
+                        </label>
+                        <div style={{ display: 'flex', gap: '0.5rem' }}>
+                            <input
+                                type="password"
+                                className="form-input"
+                                autoComplete="off"
+                                autoFocus
+                                value={passphrase}
+                                onChange={(e) => setPassphrase(e.target.value)}
+                                required
+                            />
+                            <button type="submit" className="btn btn-primary">Unlock</button>
+                            <button type="button" onClick={() => answerPassphrase('')} className="btn btn-ghost">Cancel</button>
+                        </div>
+                    </form>
+                )}
+
                 <div
                     className="terminal-body"
                     ref={terminalRef}
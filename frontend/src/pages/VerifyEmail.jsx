@@ -0,0 +1,48 @@
+import { useState, useEffect, useRef } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { useAuth } from '../context/AuthContext'
+import { accountApi } from '../services/api'
+
+function VerifyEmail() {
+    const [params] = useSearchParams()
+    const { user, refreshUser } = useAuth()
+    const [status, setStatus] = useState('pending')
+    const [message, setMessage] = useState('')
+    // The link works once; don't spend it twice when effects run twice
+    const started = useRef(false)
+
+    useEffect(() => {
+        if (started.current) return
+        started.current = true
+
+        accountApi.verifyEmail(params.get('token') || '')
+            .then(() => {
+                setStatus('done')
+                refreshUser()
+            })
+            .catch((err) => {
+                setStatus('failed')
+                setMessage(err.response?.data || 'Could not verify your email address')
+            })
+    }, [])
+
+    return (
+        <div className="auth-page">
+            <div className="auth-container">
+                <div className="auth-card">
+                    <h1 className="auth-title">Email verification</h1>
+
+                    {status === 'pending' && <div className="spinner" style={{ width: 40, height: 40 }}></div>}
+                    {status === 'done' && <p className="auth-subtitle">Your email address is confirmed.</p>}
+                    {status === 'failed' && <div className="alert alert-error">{message}</div>}
+
+                    <p className="auth-footer">
+                        {user ? <Link to="/dashboard">Go to your connections</Link> : <Link to="/login">Sign in</Link>}
+                    </p>
+                </div>
+            </div>
+        </div>
+    )
+}
+
+export default VerifyEmail
@@ -20,12 +20,70 @@ api.interceptors.request.use(
 )
 
 
+// refreshTokens swaps the stored refresh token for a new pair. Concurrent
+// callers share one request, since each refresh token only works once.
+let refreshing = null
+
+export function refreshTokens() {
+    if (!refreshing) {
+        const refreshToken = localStorage.getItem('refresh_token')
+        refreshing = (refreshToken
+            ? axios.post('/api/auth/refresh', { refresh_token: refreshToken })
+            : Promise.reject(new Error('No refresh token'))
+        )
+            .then((response) => {
+                localStorage.setItem('token', response.data.token)
+                localStorage.setItem('refresh_token', response.data.refresh_token)
+                return response.data.token
+            })
+            .finally(() => {
+                refreshing = null
+            })
+    }
+    return refreshing
+}
+
+export function clearTokens() {
+    localStorage.removeItem('token')
+    localStorage.removeItem('refresh_token')
+}
+
+// ensureAccessToken returns a token that will not expire in the next 30s,
+// for WebSocket URLs which cannot go through the 401 retry below
+export async function ensureAccessToken() {
+    const token = localStorage.getItem('token')
+    try {
+        const payload = JSON.parse(atob(token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/')))
+        if (payload.exp * 1000 > Date.now() + 30000) {
+            return token
+        }
+    } catch {
+        // Fall through and refresh
+    }
+    try {
+        return await refreshTokens()
+    } catch {
+        return token
+    }
+}
+
+
 api.interceptors.response.use(
     (response) => response,
-    (error) => {
-        if (error.response?.status === 401) {
-            localStorage.removeItem('token')
-            window.location.href = '/login'
+    async (error) => {
+        const original = error.config
+        // Requests sent without a token (login, MFA) report their own 401s
+        const authenticated = Boolean(original?.headers?.Authorization)
+        if (error.response?.status === 401 && authenticated && !original._retried) {
+            original._retried = true
+            try {
+                const token = await refreshTokens()
+                original.headers.Authorization = `Bearer ${token}`
+                return api(original)
+            } catch {
+                clearTokens()
+                window.location.href = '/login'
+            }
         }
         return Promise.reject(error)
     }
@@ -40,4 +98,21 @@ export const sshApi = {
     delete: (id) => api.delete(`/ssh/${id}`)
 }
 
+export const accountApi = {
+    forgotPassword: (email) => api.post('/auth/password/forgot', { email }),
+    resetPassword: (token, password) => api.post('/auth/password/reset', { token, password }),
+    verifyEmail: (token) => api.post('/auth/email/verify', { token }),
+    resendVerification: () => api.post('/auth/email/verify/resend'),
+    changePassword: (currentPassword, newPassword) =>
+        api.post('/auth/password', { current_password: currentPassword, new_password: newPassword }),
+    listIdentities: () => api.get('/auth/identities'),
+    linkIdentity: (provider) => api.post('/auth/identities', { provider }),
+    confirmLink: (token, password) => api.post('/auth/identities/confirm', { token, password }),
+    linkDirectory: (username, directoryPassword, password) =>
+        api.post('/auth/identities/directory', { username, directory_password: directoryPassword, password }),
+    unlinkIdentity: (id) => api.delete(`/auth/identities/${id}`),
+    exportData: () => api.get('/auth/account/export', { responseType: 'blob' }),
+    deleteAccount: (password, email, code) => api.delete('/auth/account', { data: { password, email, code } })
+}
+
 export default api
@@ -0,0 +1,81 @@
+import api from './api'
+
+// The server sends binary fields base64url encoded; the WebAuthn browser API
+// wants ArrayBuffers and returns them too.
+function toBuffer(value) {
+    const base64 = value.replace(/-/g, '+').replace(/_/g, '/')
+    const binary = atob(base64.padEnd(base64.length + (4 - base64.length % 4) % 4, '='))
+    return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer
+}
+
+function toBase64url(buffer) {
+    const bytes = new Uint8Array(buffer)
+    let binary = ''
+    bytes.forEach((b) => { binary += String.fromCharCode(b) })
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+}
+
+async function createCredential(options) {
+    const publicKey = {
+        ...options.publicKey,
+        challenge: toBuffer(options.publicKey.challenge),
+        user: { ...options.publicKey.user, id: toBuffer(options.publicKey.user.id) },
+        excludeCredentials: (options.publicKey.excludeCredentials || []).map((c) => ({ ...c, id: toBuffer(c.id) }))
+    }
+    const credential = await navigator.credentials.create({ publicKey })
+    return {
+        id: credential.id,
+        rawId: toBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: toBase64url(credential.response.clientDataJSON),
+            attestationObject: toBase64url(credential.response.attestationObject),
+            transports: credential.response.getTransports?.() || []
+        }
+    }
+}
+
+async function getCredential(options) {
+    const publicKey = {
+        ...options.publicKey,
+        challenge: toBuffer(options.publicKey.challenge),
+        allowCredentials: (options.publicKey.allowCredentials || []).map((c) => ({ ...c, id: toBuffer(c.id) }))
+    }
+    const credential = await navigator.credentials.get({ publicKey })
+    return {
+        id: credential.id,
+        rawId: toBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: toBase64url(credential.response.clientDataJSON),
+            authenticatorData: toBase64url(credential.response.authenticatorData),
+            signature: toBase64url(credential.response.signature),
+            userHandle: credential.response.userHandle ? toBase64url(credential.response.userHandle) : null
+        }
+    }
+}
+
+export const passkeySupported = () => Boolean(window.PublicKeyCredential)
+
+export async function registerPasskey(name) {
+    const { data } = await api.post('/auth/webauthn/register/begin')
+    const credential = await createCredential(data.options)
+    const response = await api.post('/auth/webauthn/register/finish', { session_id: data.session_id, name, credential })
+    return response.data
+}
+
+// loginWithPasskey returns a token pair without a password
+export async function loginWithPasskey() {
+    const { data } = await api.post('/auth/webauthn/login/begin')
+    const credential = await getCredential(data.options)
+    const response = await api.post('/auth/webauthn/login/finish', { session_id: data.session_id, credential })
+    return response.data
+}
+
+// verifyPasskeyMfa completes a login that returned an mfa_token
+export async function verifyPasskeyMfa(mfaToken) {
+    const { data } = await api.post('/auth/webauthn/mfa/begin', { mfa_token: mfaToken })
+    const credential = await getCredential(data.options)
+    const response = await api.post('/auth/webauthn/mfa/finish', { mfa_token: mfaToken, session_id: data.session_id, credential })
+    return response.data
+}
@@ -4,11 +4,10 @@ go 1.21
 
 require (
 	github.com/glebarez/sqlite v1.10.0
-	github.com/golang-jwt/jwt v3.2.2+incompatible
-	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/websocket v1.5.1
-	golang.org/x/crypto v0.17.0
+	github.com/pkg/sftp v1.13.6
+	golang.org/x/crypto v0.24.0
 	golang.org/x/oauth2 v0.15.0
 	gorm.io/gorm v1.25.5
 )
@@ -19,17 +18,38 @@ require (
 	github.com/dustin/go-humanize v1.0.1
 	github.com/glebarez/go-sqlite v1.21.2
 	github.com/golang/protobuf v1.5.3
-	github.com/google/uuid v1.3.0
+	github.com/google/uuid v1.6.0
 	github.com/jinzhu/inflection v1.0.0
 	github.com/jinzhu/now v1.1.5
+	github.com/kr/fs v0.1.0
 	github.com/mattn/go-isatty v0.0.17
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec
-	golang.org/x/net v0.19.0
-	golang.org/x/sys v0.15.0
-	google.golang.org/appengine v1.6.7
+	golang.org/x/net v0.22.0
+	golang.org/x/sys v0.21.0
+	google.golang.org/appengine v1.6.8
 	google.golang.org/protobuf v1.31.0
 	modernc.org/libc v1.22.5
 	modernc.org/mathutil v1.5.0
 	modernc.org/memory v1.5.0
 	modernc.org/sqlite v1.23.1
 )
+
+require (
+	filippo.io/age v1.2.1
+	github.com/coreos/go-oidc/v3 v3.9.0
+	github.com/go-asn1-ber/asn1-ber v1.5.5
+	github.com/go-jose/go-jose/v3 v3.0.1
+	github.com/go-ldap/ldap/v3 v3.4.8
+)
+
+require github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+
+require (
+	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/go-webauthn/webauthn v0.9.4
+	github.com/go-webauthn/x v0.1.5 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0
+	github.com/google/go-tpm v0.9.0 // indirect
+	github.com/mitchellh/mapstructure v1.5.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
@@ -1,27 +1,264 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
 	DatabasePath       string
-	JWTSecret          string
+	JWTAlgorithm       string        // "EdDSA" or "RS256", for keys created from now on
+	JWTIssuer          string        // iss claim of issued tokens
+	JWTKeyRotation     time.Duration // How long each signing key signs before the next takes over
 	GoogleClientID     string
 	GoogleClientSecret string
 	GoogleRedirectURL  string
-	EncryptionKey      string
+	EncryptionKey      string // Master key that seals stored secrets
+	EncryptionKeyID    string // Stored with each secret to name the master key that sealed it
+	// EncryptionOldKeys are retired master keys by ID, kept to read secrets
+	// until `server rewrap` has moved them to the current key
+	EncryptionOldKeys  map[string]string
 	FrontendURL        string
+	RecordingsPath     string
+	RecordAllSessions  bool
+	SessionGracePeriod time.Duration
+	SessionBufferSize  int
+	SSHCertTTL         time.Duration // How long certificates from the built-in SSH CA stay valid
+	AdminEmails        []string      // Accounts that are admins once they verify these addresses
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	PATDefaultTTL      time.Duration // Lifetime of a personal access token created without an expiry
+	PATMaxTTL          time.Duration // Longest lifetime a personal access token may be given
+	MFARequired        bool
+	MFAIssuer          string
+	WebAuthnRPID       string
+	WebAuthnRPName     string
+	WebAuthnOrigins    []string
+	LoginMaxFailures   int // Failed logins before an account is locked for LoginLockout
+	LoginLockout       time.Duration
+	LoginBackoffMax    time.Duration
+	OIDCProviders      []OIDCProviderConfig
+	LDAP               LDAPConfig
+
+	// RequireVerifiedEmail keeps users who haven't confirmed their address
+	// from opening terminal sessions
+	RequireVerifiedEmail bool
+	EmailVerificationTTL time.Duration
+	PasswordResetTTL     time.Duration
+	Mail                 MailConfig
+	SecretStore          SecretStoreConfig
+}
+
+// SecretStoreConfig selects where connection passwords and private keys are
+// kept. Secrets already saved in another configured store stay readable.
+type SecretStoreConfig struct {
+	Backend string // "local" (sealed in the database), "age" or "vault"
+
+	AgeFile         string // Vault file, encrypted to the identity's recipient
+	AgeIdentityFile string // age identity (AGE-SECRET-KEY-1...) that opens AgeFile
+
+	VaultAddr      string // HashiCorp Vault is used when set
+	VaultToken     string
+	VaultTokenFile string // Read instead of VaultToken, and again whenever it changes
+	VaultNamespace string // Enterprise namespace, if any
+	VaultMount     string // Mount path of a KV version 2 secrets engine
+	VaultPrefix    string // Path under the mount the app's secrets are kept at
+}
+
+// MailConfig selects how account emails are delivered
+type MailConfig struct {
+	Driver string // "smtp", "file", "log" or "none"
+	From   string
+	Dir    string // Where the file driver writes messages
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTLS      string // "starttls", "tls" (implicit, usually port 465) or "none"
+}
+
+// LDAPConfig points password logins at a corporate directory. LDAP is off
+// when URL is empty.
+type LDAPConfig struct {
+	URL                string // ldap:// or ldaps://
+	StartTLS           bool
+	InsecureSkipVerify bool
+	CACertFile         string // PEM bundle trusted for the directory's certificate
+
+	// BindDN and BindPassword are the service account used to find users;
+	// both empty binds anonymously
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // {username} is replaced with the escaped login name
+
+	IDAttribute    string // Stable identifier such as entryUUID or objectGUID; the DN when empty
+	EmailAttribute string
+	EmailVerified  bool // The directory vouches for EmailAttribute; otherwise new accounts verify by email
+	NameAttribute  string
+	GroupAttribute string // Group DNs on the user entry, e.g. memberOf
+	GroupFilter    string // Searched under BaseDN for group DNs when set; {dn} is the user's DN
+
+	// GroupTeams is a JSON list of {"group", "team_id", "role"} granting team
+	// membership to members of a directory group
+	GroupTeams string
+}
+
+// OIDCProviderConfig describes an OpenID Connect issuer users can sign in
+// with. Endpoints and signing keys are found through discovery.
+type OIDCProviderConfig struct {
+	Name         string // Used in URLs and to link accounts, e.g. "keycloak"
+	DisplayName  string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Claims read from the ID token (or userinfo) for the account details
+	EmailClaim  string
+	NameClaim   string
+	GroupsClaim string
+
+	// AllowedGroups, when set, limits sign in to members of these groups
+	AllowedGroups []string
 }
 
 func Load() *Config {
-	return &Config{
+	cfg := &Config{
 		DatabasePath:       getEnv("DATABASE_PATH", "./ssh_terminal.db"),
-		JWTSecret:          getEnv("JWT_SECRET", "your-super-secret-jwt-key-change-in-production"),
+		JWTAlgorithm:       getEnv("JWT_ALGORITHM", "EdDSA"),
+		JWTIssuer:          getEnv("JWT_ISSUER", "ssh-terminal"),
+		JWTKeyRotation:     getEnvDuration("JWT_KEY_ROTATION", 30*24*time.Hour),
 		GoogleClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
 		GoogleClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
 		GoogleRedirectURL:  getEnv("GOOGLE_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback"),
-		EncryptionKey:      getEnv("ENCRYPTION_KEY", "a-32-byte-encryption-key-here!!"),
+		EncryptionKey:      getEnv("ENCRYPTION_KEY", ""),
+		EncryptionKeyID:    getEnv("ENCRYPTION_KEY_ID", "1"),
+		EncryptionOldKeys:  getEnvKeyMap("ENCRYPTION_OLD_KEYS"),
 		FrontendURL:        getEnv("FRONTEND_URL", "http://localhost:5173"),
+		RecordingsPath:     getEnv("RECORDINGS_PATH", "./recordings"),
+		RecordAllSessions:  getEnv("RECORD_ALL_SESSIONS", "false") == "true",
+		SessionGracePeriod: getEnvDuration("SESSION_GRACE_PERIOD", 5*time.Minute),
+		SSHCertTTL:         getEnvDuration("SSH_CERT_TTL", 5*time.Minute),
+		SessionBufferSize:  getEnvInt("SESSION_BUFFER_SIZE", 64*1024),
+		AdminEmails:        getEnvList("ADMIN_EMAILS"),
+		AccessTokenTTL:     getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:    getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		PATDefaultTTL:      getEnvDuration("PAT_DEFAULT_TTL", 30*24*time.Hour),
+		PATMaxTTL:          getEnvDuration("PAT_MAX_TTL", 365*24*time.Hour),
+		MFARequired:        getEnv("MFA_REQUIRED", "false") == "true",
+		MFAIssuer:          getEnv("MFA_ISSUER", "SSH Terminal"),
+		WebAuthnRPID:       getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:     getEnv("WEBAUTHN_RP_NAME", "SSH Terminal"),
+		WebAuthnOrigins:    getEnvList("WEBAUTHN_ORIGINS"),
+		LoginMaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 10),
+		LoginLockout:       getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		LoginBackoffMax:    getEnvDuration("LOGIN_BACKOFF_MAX", time.Minute),
+		LDAP: LDAPConfig{
+			URL:                getEnv("LDAP_URL", ""),
+			StartTLS:           getEnv("LDAP_START_TLS", "false") == "true",
+			InsecureSkipVerify: getEnv("LDAP_INSECURE_SKIP_VERIFY", "false") == "true",
+			CACertFile:         getEnv("LDAP_CA_CERT_FILE", ""),
+			BindDN:             getEnv("LDAP_BIND_DN", ""),
+			BindPassword:       getEnv("LDAP_BIND_PASSWORD", ""),
+			BaseDN:             getEnv("LDAP_BASE_DN", ""),
+			UserFilter:         getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(|(uid={username})(mail={username})))"),
+			IDAttribute:        getEnv("LDAP_ID_ATTRIBUTE", ""),
+			EmailAttribute:     getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+			EmailVerified:      getEnv("LDAP_EMAIL_VERIFIED", "false") == "true",
+			NameAttribute:      getEnv("LDAP_NAME_ATTRIBUTE", "cn"),
+			GroupAttribute:     getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+			GroupFilter:        getEnv("LDAP_GROUP_FILTER", ""),
+			GroupTeams:         getEnv("LDAP_GROUP_TEAMS", ""),
+		},
+		RequireVerifiedEmail: getEnv("REQUIRE_VERIFIED_EMAIL", "false") == "true",
+		EmailVerificationTTL: getEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		PasswordResetTTL:     getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "none"),
+			From:         getEnv("MAIL_FROM", "SSH Terminal <no-reply@localhost>"),
+			Dir:          getEnv("MAIL_DIR", "./mail"),
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnvInt("SMTP_PORT", 587),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			SMTPTLS:      getEnv("SMTP_TLS", "starttls"),
+		},
+		SecretStore: SecretStoreConfig{
+			Backend:         getEnv("SECRET_STORE", "local"),
+			AgeFile:         getEnv("SECRET_STORE_AGE_FILE", "./secrets.age"),
+			AgeIdentityFile: getEnv("SECRET_STORE_AGE_IDENTITY", ""),
+			VaultAddr:       getEnv("VAULT_ADDR", ""),
+			VaultToken:      getEnv("VAULT_TOKEN", ""),
+			VaultTokenFile:  getEnv("VAULT_TOKEN_FILE", ""),
+			VaultNamespace:  getEnv("VAULT_NAMESPACE", ""),
+			VaultMount:      getEnv("VAULT_KV_MOUNT", "secret"),
+			VaultPrefix:     getEnv("VAULT_KV_PREFIX", "ssh-terminal"),
+		},
 	}
+
+	// Passkeys are created in the browser, so the frontend is the origin
+	if len(cfg.WebAuthnOrigins) == 0 {
+		cfg.WebAuthnOrigins = []string{cfg.FrontendURL}
+	}
+
+	// Google keeps its own variables and callback URL from before other
+	// providers were supported
+	if cfg.GoogleClientID != "" {
+		cfg.OIDCProviders = append(cfg.OIDCProviders, OIDCProviderConfig{
+			Name:         "google",
+			DisplayName:  "Google",
+			IssuerURL:    "https://accounts.google.com",
+			ClientID:     cfg.GoogleClientID,
+			ClientSecret: cfg.GoogleClientSecret,
+			RedirectURL:  cfg.GoogleRedirectURL,
+			Scopes:       []string{"email", "profile"},
+			EmailClaim:   "email",
+			NameClaim:    "name",
+		})
+	}
+	for _, name := range getEnvList("OIDC_PROVIDERS") {
+		cfg.OIDCProviders = append(cfg.OIDCProviders, loadOIDCProvider(strings.ToLower(name)))
+	}
+	return cfg
+}
+
+// loadOIDCProvider reads OIDC_<NAME>_* variables for the named provider
+func loadOIDCProvider(name string) OIDCProviderConfig {
+	prefix := "OIDC_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_"
+	scopes := getEnvList(prefix + "SCOPES")
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	return OIDCProviderConfig{
+		Name:          name,
+		DisplayName:   getEnv(prefix+"DISPLAY_NAME", name),
+		IssuerURL:     getEnv(prefix+"ISSUER_URL", ""),
+		ClientID:      getEnv(prefix+"CLIENT_ID", ""),
+		ClientSecret:  getEnv(prefix+"CLIENT_SECRET", ""),
+		RedirectURL:   getEnv(prefix+"REDIRECT_URL", "http://localhost:8080/api/auth/oidc/"+name+"/callback"),
+		Scopes:        scopes,
+		EmailClaim:    getEnv(prefix+"EMAIL_CLAIM", "email"),
+		NameClaim:     getEnv(prefix+"NAME_CLAIM", "name"),
+		GroupsClaim:   getEnv(prefix+"GROUPS_CLAIM", "groups"),
+		AllowedGroups: getEnvList(prefix + "ALLOWED_GROUPS"),
+	}
+}
+
+// IsAdminEmail reports whether email is one of the configured admin
+// addresses. It says nothing about whether the account holding it owns the
+// address; see service.isAdmin.
+func (c *Config) IsAdminEmail(email string) bool {
+	for _, admin := range c.AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
 }
 
 func getEnv(key, defaultValue string) string {
@@ -30,3 +267,42 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvKeyMap reads a comma-separated list of id:value pairs. An entry
+// without an ID is kept under the empty ID, for the caller to reject.
+func getEnvKeyMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, entry := range getEnvList(key) {
+		id, value, _ := strings.Cut(entry, ":")
+		if value == "" {
+			id, value = "", entry
+		}
+		values[strings.TrimSpace(id)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
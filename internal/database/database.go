@@ -16,10 +16,86 @@ func Initialize(dbPath string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.SSHConnection{})
+	// Accounts from before email verification keep working as they did
+	grandfatherVerified := !db.Migrator().HasColumn(&models.User{}, "email_verified_at")
+
+	err = db.AutoMigrate(&models.User{}, &models.SSHConnection{}, &models.KnownHost{}, &models.SessionRecording{}, &models.Team{}, &models.TeamMember{}, &models.AuditEvent{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.RecoveryCode{}, &models.WebAuthnCredential{}, &models.UserIdentity{}, &models.PersonalAccessToken{}, &models.EmailToken{}, &models.SigningKey{}, &models.SSHIdentity{}, &models.SSHCAKey{}, &models.SchemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Team members created before roles were introduced could connect
+	err = runOnce(db, "team_member_roles", func(tx *gorm.DB) error {
+		return tx.Model(&models.TeamMember{}).Where("role = ?", "member").Update("role", models.TeamRoleOperator).Error
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Passkeys used to become a second factor as soon as one was registered
+	err = runOnce(db, "passkey_mfa", func(tx *gorm.DB) error {
+		return tx.Model(&models.User{}).Where("id IN (?)", tx.Model(&models.WebAuthnCredential{}).Select("user_id")).
+			Update("passkey_mfa", true).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateGoogleIDs(db); err != nil {
+		return nil, err
+	}
+
+	if grandfatherVerified {
+		err = db.Model(&models.User{}).Where("email_verified_at IS NULL").
+			Update("email_verified_at", gorm.Expr("created_at")).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
+
+// runOnce applies a data migration the first time the server starts with it,
+// recording its name in the same transaction
+func runOnce(db *gorm.DB, name string, migrate func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.SchemaMigration{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		return tx.Create(&models.SchemaMigration{Name: name}).Error
+	})
+}
+
+// migrateGoogleIDs moves Google accounts linked before identity providers
+// were generalized into user_identities. Google's subject claim is the same
+// ID its userinfo endpoint returned.
+func migrateGoogleIDs(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("users", "google_id") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO user_identities (created_at, updated_at, user_id, provider, subject, email)
+			SELECT CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, id, 'google', google_id, email FROM users
+			WHERE google_id IS NOT NULL AND google_id <> ''`).Error
+		if err != nil {
+			return err
+		}
+		// The column's unique index also stopped a second password-only
+		// user from registering, since both had an empty Google ID
+		if tx.Migrator().HasIndex("users", "idx_users_google_id") {
+			if err := tx.Migrator().DropIndex("users", "idx_users_google_id"); err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropColumn(&models.User{}, "google_id")
+	})
+}
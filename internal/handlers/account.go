@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type LinkIdentityRequest struct {
+	Provider string `json:"provider"`
+}
+
+type ConfirmIdentityLinkRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type LinkDirectoryRequest struct {
+	Username          string `json:"username"`
+	DirectoryPassword string `json:"directory_password"`
+	Password          string `json:"password"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Email    string `json:"email"` // Confirms deletion of an account without a password
+	Code     string `json:"code"`  // Optional two-factor code for an account without a password
+}
+
+// ChangePassword sets a new password after checking the current one. Other
+// sessions are signed out; the one making the request stays signed in.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetAccessClaims(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ChangePassword(claims, req.CurrentPassword, req.NewPassword, clientAddr(r)); err != nil {
+		h.writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListIdentities returns the identity provider accounts linked to the user
+func (h *AuthHandler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	identities, err := h.service.ListIdentities(userID)
+	if err != nil {
+		http.Error(w, "Error listing linked accounts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identities)
+}
+
+// LinkIdentity returns the provider URL to send the browser to. The
+// provider's callback brings it back to the account page to confirm.
+func (h *AuthHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req LinkIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Provider == "" {
+		http.Error(w, "provider is required", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.service.BeginProviderLink(r.Context(), userID, req.Provider)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			http.Error(w, "Identity provider not configured", http.StatusNotFound)
+		} else {
+			log.Printf("Identity provider %s unavailable: %v", req.Provider, err)
+			http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"url": url})
+}
+
+// ConfirmIdentityLink links the identity a provider sign in came back with
+func (h *AuthHandler) ConfirmIdentityLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req ConfirmIdentityLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		http.Error(w, "password is required", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.service.ConfirmIdentityLink(userID, req.Token, req.Password, clientAddr(r))
+	if err != nil {
+		h.writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identity)
+}
+
+// LinkDirectory links the user's directory account after signing in to the
+// directory with it
+func (h *AuthHandler) LinkDirectory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req LinkDirectoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || req.DirectoryPassword == "" {
+		http.Error(w, "username and directory_password are required", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		http.Error(w, "password is required", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.service.LinkDirectory(userID, req.Username, req.DirectoryPassword, req.Password, clientAddr(r))
+	if err != nil {
+		h.writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identity)
+}
+
+func (h *AuthHandler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid identity ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.UnlinkIdentity(userID, uint(id), clientAddr(r)); err != nil {
+		h.writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportAccount downloads the user's data as JSON
+func (h *AuthHandler) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	export, err := h.accounts.Export(userID, clientAddr(r))
+	if err != nil {
+		log.Printf("Account export for user %d: %v", userID, err)
+		http.Error(w, "Error exporting account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"account-%s.json\"", time.Now().UTC().Format("20060102-150405")))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(export)
+}
+
+// DeleteAccount permanently removes the user and everything they own
+func (h *AuthHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetAccessClaims(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accounts.Delete(claims, req.Password, req.Code, req.Email, clientAddr(r)); err != nil {
+		h.writeAccountError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAccountError maps account errors to responses. A wrong current
+// password is 403 rather than 401, which the frontend takes to mean the
+// session has ended.
+func (h *AuthHandler) writeAccountError(w http.ResponseWriter, err error) {
+	var limited *service.RateLimitError
+	switch {
+	case errors.As(err, &limited):
+		writeRateLimited(w, limited)
+	case errors.Is(err, service.ErrWrongPassword),
+		errors.Is(err, service.ErrConfirmationMismatch),
+		errors.Is(err, service.ErrRecentSignInRequired),
+		errors.Is(err, service.ErrInvalidMFACode):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrPasswordRequired),
+		errors.Is(err, service.ErrMFANotEnabled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrIdentityNotFound),
+		errors.Is(err, service.ErrNoDirectory):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidCredentials):
+		http.Error(w, "Directory sign in failed: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrDirectoryUnavailable):
+		http.Error(w, "Directory unavailable, try again later", http.StatusServiceUnavailable)
+	case errors.Is(err, service.ErrNoLocalPassword),
+		errors.Is(err, service.ErrLastLoginMethod),
+		errors.Is(err, service.ErrLastTeamOwner),
+		errors.Is(err, service.ErrIdentityInUse),
+		errors.Is(err, service.ErrSSHIdentityInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrProviderLoginGone):
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		log.Printf("Account request failed: %v", err)
+		http.Error(w, "Error updating account", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/repository"
+	"ssh-terminal-app/internal/service"
+)
+
+type AuditHandler struct {
+	service service.AuditService
+	cfg     *config.Config
+}
+
+func NewAuditHandler(service service.AuditService, cfg *config.Config) *AuditHandler {
+	return &AuditHandler{
+		service: service,
+		cfg:     cfg,
+	}
+}
+
+// List returns audit events, newest first. Filters: user_id, action (a
+// trailing "." matches a prefix), target_type, target_id, since and until
+// (RFC 3339), before_id and limit for paging.
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.service.List(userID, filter)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// Export streams every matching event as JSON Lines, oldest first
+func (h *AuditHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Exports are never paged
+	filter.BeforeID, filter.Limit = 0, 0
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-%s.jsonl\"", time.Now().UTC().Format("20060102-150405")))
+	out := &exportWriter{w: w}
+	if err := h.service.Export(userID, filter, out); err != nil {
+		// Once the body has started the status is sent; all we can do is stop
+		if out.started {
+			log.Printf("AuditHandler: Export for user %d stopped early: %v", userID, err)
+			return
+		}
+		h.writeError(w, err)
+	}
+}
+
+// exportWriter notes whether any of the response body has been written
+type exportWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	e.started = true
+	return e.w.Write(p)
+}
+
+func (h *AuditHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := h.service.Verify(userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func parseAuditFilter(r *http.Request) (repository.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := repository.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+	}
+
+	ids := map[string]*uint{
+		"user_id":   &filter.UserID,
+		"target_id": &filter.TargetID,
+		"before_id": &filter.BeforeID,
+	}
+	for name, dest := range ids {
+		if value := query.Get(name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s", name)
+			}
+			*dest = uint(id)
+		}
+	}
+
+	times := map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	}
+	for name, dest := range times {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, expected RFC 3339", name)
+			}
+			*dest = t.UTC()
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return filter, errors.New("invalid limit")
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+func (h *AuditHandler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrAuditAdminOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Error reading audit log", http.StatusInternalServerError)
+	}
+}
@@ -2,22 +2,41 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"ssh-terminal-app/internal/config"
 	"ssh-terminal-app/internal/middleware"
 	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// providerLoginCookie ties a provider sign in to the browser that started it.
+// It lives as long as the service keeps the login's state.
+const (
+	providerLoginCookie    = "provider_login"
+	providerLoginCookieTTL = 10 * time.Minute
 )
 
 type AuthHandler struct {
-	service service.AuthService
-	cfg     *config.Config
+	service  service.AuthService
+	accounts service.AccountService
+	cfg      *config.Config
 }
 
-func NewAuthHandler(service service.AuthService, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(service service.AuthService, accounts service.AccountService, cfg *config.Config) *AuthHandler {
 	return &AuthHandler{
-		service: service,
-		cfg:     cfg,
+		service:  service,
+		accounts: accounts,
+		cfg:      cfg,
 	}
 }
 
@@ -44,9 +63,12 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.service.Register(req.Email, req.Password, req.Name)
+	user, err := h.service.Register(req.Email, req.Password, req.Name, clientAddr(r))
 	if err != nil {
-		if err.Error() == "user already exists" {
+		var limited *service.RateLimitError
+		if errors.As(err, &limited) {
+			writeRateLimited(w, limited)
+		} else if err.Error() == "user already exists" {
 			http.Error(w, "User already exists", http.StatusConflict)
 		} else {
 			http.Error(w, "Error creating user", http.StatusInternalServerError)
@@ -54,8 +76,23 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate token for automatic login after registration
-	token, err := h.service.GenerateToken(user.ID)
+	if h.cfg.MFARequired {
+		// No session until an authenticator is enrolled at first login
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mfa_setup_required": true,
+			"user": map[string]interface{}{
+				"id":    user.ID,
+				"email": user.Email,
+				"name":  user.Name,
+			},
+		})
+		return
+	}
+
+	// Issue tokens for automatic login after registration
+	tokens, err := h.service.IssueTokens(user.ID, clientAddr(r))
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
@@ -64,7 +101,9 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token": token,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 		"user": map[string]interface{}{
 			"id":    user.ID,
 			"email": user.Email,
@@ -80,15 +119,32 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.service.Login(req.Email, req.Password)
+	result, err := h.service.Login(req.Email, req.Password, clientAddr(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		var limited *service.RateLimitError
+		if errors.As(err, &limited) {
+			writeRateLimited(w, limited)
+		} else if errors.Is(err, service.ErrDirectoryUnavailable) {
+			log.Printf("Login for %s: %v", req.Email, err)
+			http.Error(w, "Directory unavailable, try again later", http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if result.MFAToken != "" {
+		// Second step: POST the code to /auth/mfa/verify, or enroll first
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	tokens := result.TokenPair
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"token": token,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 		"user": map[string]interface{}{
 			"id":    0, // We don't have user here easily, frontend will call /auth/me
 			"email": req.Email,
@@ -96,46 +152,168 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *AuthHandler) GoogleLogin(w http.ResponseWriter, r *http.Request) {
-	if h.cfg.GoogleClientID == "" {
-		http.Error(w, "Google OAuth not configured", http.StatusNotImplemented)
-		return
-	}
+// ListProviders returns the identity providers shown on the login page
+func (h *AuthHandler) ListProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.service.IdentityProviders())
+}
 
+// ProviderLogin sends the browser to an identity provider to sign in
+func (h *AuthHandler) ProviderLogin(w http.ResponseWriter, r *http.Request) {
 	mode := r.URL.Query().Get("mode")
-	if mode == "" {
+	if mode != "register" {
 		mode = "login"
 	}
 
-	url := h.service.GoogleLogin(mode)
+	url, binding, err := h.service.BeginProviderLogin(r.Context(), mux.Vars(r)["provider"], mode)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			http.Error(w, "Identity provider not configured", http.StatusNotFound)
+		} else {
+			log.Printf("Identity provider %s unavailable: %v", mux.Vars(r)["provider"], err)
+			http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		}
+		return
+	}
+	// Only this browser can finish the sign in, so an attacker can't send
+	// someone a callback that logs them into the attacker's account
+	http.SetCookie(w, &http.Cookie{
+		Name:     providerLoginCookie,
+		Value:    binding,
+		Path:     "/api/auth/",
+		MaxAge:   int(providerLoginCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
-func (h *AuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
+// ProviderCallback finishes a sign in when the identity provider sends the
+// browser back, handing the session to the frontend
+func (h *AuthHandler) ProviderCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		// The user cancelled or the provider refused
+		h.redirectLoginError(w, r, "Sign in failed: "+reason)
+		return
+	}
+	code := query.Get("code")
 	if code == "" {
 		http.Error(w, "No code in callback", http.StatusBadRequest)
 		return
 	}
 
-	mode := r.URL.Query().Get("state")
+	var binding string
+	if cookie, err := r.Cookie(providerLoginCookie); err == nil {
+		binding = cookie.Value
+	}
+	http.SetCookie(w, &http.Cookie{Name: providerLoginCookie, Path: "/api/auth/", MaxAge: -1, HttpOnly: true})
 
-	token, err := h.service.GoogleCallback(r.Context(), code, mode)
+	provider := mux.Vars(r)["provider"]
+	result, err := h.service.FinishProviderLogin(r.Context(), provider, query.Get("state"), binding, code, clientAddr(r))
 	if err != nil {
-		// Handle specific errors like "User not found"
-		if err.Error() == "User not found. Please register first." {
-			frontendURL := h.cfg.FrontendURL + "/login?error=User not found. Please register first."
-			http.Redirect(w, r, frontendURL, http.StatusTemporaryRedirect)
-			return
+		var linkErr *service.ProviderLinkError
+		switch {
+		case errors.Is(err, service.ErrUnknownProvider):
+			http.Error(w, "Identity provider not configured", http.StatusNotFound)
+		case errors.As(err, &linkErr):
+			log.Printf("Identity provider %s link failed: %v", provider, err)
+			params := url.Values{"error": {service.ErrProviderExchange.Error()}}
+			http.Redirect(w, r, h.cfg.FrontendURL+"/account?"+params.Encode(), http.StatusTemporaryRedirect)
+		case errors.Is(err, service.ErrAccountNotFound),
+			errors.Is(err, service.ErrProviderLoginGone),
+			errors.Is(err, service.ErrIdentityNotAllowed),
+			errors.Is(err, service.ErrIdentityNotLinked),
+			errors.Is(err, service.ErrEmailNotVerified):
+			h.redirectLoginError(w, r, err.Error())
+		case errors.Is(err, service.ErrProviderExchange):
+			log.Printf("Identity provider %s sign in failed: %v", provider, err)
+			h.redirectLoginError(w, r, service.ErrProviderExchange.Error())
+		default:
+			log.Printf("Identity provider %s callback failed: %v", provider, err)
+			h.redirectLoginError(w, r, "Sign in failed")
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	frontendURL := h.cfg.FrontendURL + "/auth/callback?token=" + token
+	// Tokens go in the fragment, which stays in the browser: it isn't sent
+	// to the frontend's server, logged by proxies or passed on in the
+	// Referer header
+	params := url.Values{}
+	if result.PendingLink != "" {
+		// The account page confirms the link with the user's session
+		params.Set("link", result.PendingLink)
+		params.Set("provider", provider)
+		http.Redirect(w, r, h.cfg.FrontendURL+"/account#"+params.Encode(), http.StatusTemporaryRedirect)
+		return
+	}
+	if result.MFAToken != "" {
+		// The login page finishes with the second factor
+		params.Set("mfa_token", result.MFAToken)
+		params.Set("mfa_methods", strings.Join(result.MFAMethods, ","))
+		if result.MFASetupRequired {
+			params.Set("mfa_setup_required", "true")
+		}
+		http.Redirect(w, r, h.cfg.FrontendURL+"/login#"+params.Encode(), http.StatusTemporaryRedirect)
+		return
+	}
+
+	params.Set("token", result.AccessToken)
+	params.Set("refresh_token", result.RefreshToken)
+	frontendURL := h.cfg.FrontendURL + "/auth/callback#" + params.Encode()
 	http.Redirect(w, r, frontendURL, http.StatusTemporaryRedirect)
 }
 
+func (h *AuthHandler) redirectLoginError(w http.ResponseWriter, r *http.Request, message string) {
+	params := url.Values{"error": {message}}
+	http.Redirect(w, r, h.cfg.FrontendURL+"/login?"+params.Encode(), http.StatusTemporaryRedirect)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new token pair. Each refresh token
+// works once; the one sent is replaced by the one returned.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.service.Refresh(req.RefreshToken, clientAddr(r))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout revokes the access token used for the request along with every
+// refresh token issued in the same login
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetAccessClaims(r)
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.Logout(claims, clientAddr(r)); err != nil {
+		http.Error(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by auth middleware)
 	userID, ok := middleware.GetUserID(r)
@@ -153,8 +331,30 @@ func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":    user.ID,
-		"email": user.Email,
-		"name":  user.Name,
+		"id":             user.ID,
+		"email":          user.Email,
+		"name":           user.Name,
+		"mfa_enabled":    user.MFAEnabled,
+		"email_verified": user.EmailVerifiedAt != nil,
+		"has_password":   user.Password != "",
 	})
 }
+
+// writeRateLimited tells the client how many seconds to wait before retrying
+func writeRateLimited(w http.ResponseWriter, limited *service.RateLimitError) {
+	seconds := int(math.Ceil(limited.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, limited.Error(), http.StatusTooManyRequests)
+}
+
+// clientAddr returns the host part of the request's remote address
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// RequireConnection only lets a request through when the user holds perm on
+// the connection named by the {id} route variable. It must run after
+// middleware.Auth.
+func RequireConnection(authz service.Authorizer, perm service.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, ok := middleware.GetUserID(r)
+			if !ok {
+				http.Error(w, "User not found", http.StatusUnauthorized)
+				return
+			}
+
+			id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+			if err != nil {
+				http.Error(w, "Invalid connection ID", http.StatusBadRequest)
+				return
+			}
+
+			if _, err := authz.AuthorizeConnection(uint(id), userID, perm); err != nil {
+				switch {
+				case errors.Is(err, service.ErrConnectionNotFound):
+					http.Error(w, "Connection not found", http.StatusNotFound)
+				case errors.Is(err, service.ErrPermissionDenied):
+					http.Error(w, err.Error(), http.StatusForbidden)
+				default:
+					http.Error(w, "Error checking permissions", http.StatusInternalServerError)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ForgotPassword emails a reset link. It answers 202 whether or not the
+// address has an account.
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(req.Email, clientAddr(r)); err != nil {
+		var limited *service.RateLimitError
+		if errors.As(err, &limited) {
+			writeRateLimited(w, limited)
+			return
+		}
+		log.Printf("Password reset for %s: %v", req.Email, err)
+		http.Error(w, "Error sending reset link", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token from a reset link
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ResetPassword(req.Token, req.Password, clientAddr(r)); err != nil {
+		h.writeEmailTokenError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail confirms the address a verification link was sent to
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.VerifyEmail(req.Token, clientAddr(r)); err != nil {
+		h.writeEmailTokenError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification emails the signed in user a new verification link
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.SendVerificationEmail(userID); err != nil {
+		var limited *service.RateLimitError
+		switch {
+		case errors.As(err, &limited):
+			writeRateLimited(w, limited)
+		case errors.Is(err, service.ErrEmailAlreadyVerified):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Error sending verification email", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthHandler) writeEmailTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidEmailToken), errors.Is(err, service.ErrPasswordRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error processing link", http.StatusInternalServerError)
+	}
+}
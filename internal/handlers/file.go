@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type FileHandler struct {
+	service service.FileService
+	cfg     *config.Config
+}
+
+func NewFileHandler(service service.FileService, cfg *config.Config) *FileHandler {
+	return &FileHandler{
+		service: service,
+		cfg:     cfg,
+	}
+}
+
+type RenameFileRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type ChmodFileRequest struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"` // Octal, e.g. "0755"
+}
+
+type MkdirRequest struct {
+	Path string `json:"path"`
+}
+
+func (h *FileHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	dir, files, err := h.service.List(connID, userID, r.URL.Query().Get("path"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":  dir,
+		"files": files,
+	})
+}
+
+func (h *FileHandler) Stat(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := h.service.Stat(connID, userID, r.URL.Query().Get("path"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (h *FileHandler) Download(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	file, info, err := h.service.Open(connID, userID, r.URL.Query().Get("path"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+
+	// The status is already sent, so a failure can only be logged; the short
+	// body tells the client the download broke off
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("FileHandler: Download of %s from connection %d failed: %v", info.Path, connID, err)
+	}
+	if err := file.Close(); err != nil {
+		log.Printf("FileHandler: Closing %s on connection %d failed: %v", info.Path, connID, err)
+	}
+}
+
+// Upload streams each file part of a multipart body into the directory given by ?path=
+func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	dir := r.URL.Query().Get("path")
+	if dir == "" {
+		http.Error(w, "Target directory is required", http.StatusBadRequest)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	uploaded, err := h.service.Upload(connID, userID, dir, &multipartUpload{reader: reader})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(uploaded)
+}
+
+// multipartUpload yields the file parts of a multipart body, skipping
+// regular form fields
+type multipartUpload struct {
+	reader *multipart.Reader
+	part   *multipart.Part
+}
+
+func (u *multipartUpload) Next() (string, io.Reader, error) {
+	for {
+		if u.part != nil {
+			u.part.Close()
+		}
+		part, err := u.reader.NextPart()
+		if err == io.EOF {
+			return "", nil, io.EOF
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: malformed multipart body", service.ErrInvalidUpload)
+		}
+		u.part = part
+
+		// Reject names that try to escape the target directory
+		name := part.FileName()
+		if name == "" {
+			continue
+		}
+		if name != path.Base(name) || name == ".." {
+			return "", nil, fmt.Errorf("%w: invalid file name %q", service.ErrInvalidPath, name)
+		}
+		return name, part, nil
+	}
+}
+
+func (h *FileHandler) Rename(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req RenameFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Rename(connID, userID, req.From, req.To); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FileHandler) Chmod(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChmodFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mode, err := strconv.ParseUint(req.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		http.Error(w, "Invalid mode", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Chmod(connID, userID, req.Path, os.FileMode(mode)); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FileHandler) Mkdir(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req MkdirRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Mkdir(connID, userID, req.Path); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *FileHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	recursive := query.Get("recursive") == "true"
+
+	if err := h.service.Delete(connID, userID, query.Get("path"), recursive); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *FileHandler) parseRequest(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid connection ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return userID, uint(id), true
+}
+
+func (h *FileHandler) writeError(w http.ResponseWriter, err error) {
+	var mismatch *service.HostKeyMismatchError
+	switch {
+	case errors.Is(err, service.ErrConnectionNotFound):
+		http.Error(w, "Connection not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrPermissionDenied),
+		errors.Is(err, service.ErrEmailVerificationRequired):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrInvalidPath), errors.Is(err, service.ErrInvalidUpload):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "File not found", http.StatusNotFound)
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "Permission denied", http.StatusForbidden)
+	case errors.As(err, &mismatch):
+		http.Error(w, "Host key verification failed: "+mismatch.Error(), http.StatusBadGateway)
+	case errors.Is(err, service.ErrSSHIdentityRevoked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrPassphraseRequired):
+		// There is no one to ask for it outside a terminal
+		http.Error(w, "The private key needs its passphrase saved to browse files", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
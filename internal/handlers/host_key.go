@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type HostKeyHandler struct {
+	service service.HostKeyService
+	cfg     *config.Config
+}
+
+func NewHostKeyHandler(service service.HostKeyService, cfg *config.Config) *HostKeyHandler {
+	return &HostKeyHandler{
+		service: service,
+		cfg:     cfg,
+	}
+}
+
+type PinHostKeyRequest struct {
+	PublicKey string `json:"public_key"`
+}
+
+func (h *HostKeyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	known, err := h.service.Get(connID, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(known)
+}
+
+func (h *HostKeyHandler) Pin(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req PinHostKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.PublicKey == "" {
+		http.Error(w, "public_key is required", http.StatusBadRequest)
+		return
+	}
+
+	known, err := h.service.Pin(connID, userID, req.PublicKey)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(known)
+}
+
+func (h *HostKeyHandler) AcceptChanged(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	known, err := h.service.AcceptChanged(connID, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(known)
+}
+
+func (h *HostKeyHandler) Clear(w http.ResponseWriter, r *http.Request) {
+	userID, connID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Clear(connID, userID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HostKeyHandler) parseRequest(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid connection ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return userID, uint(id), true
+}
+
+func (h *HostKeyHandler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrNoHostKey):
+		http.Error(w, "No host key recorded", http.StatusNotFound)
+	case errors.Is(err, service.ErrNoPendingHostKey):
+		http.Error(w, "No changed host key to accept", http.StatusConflict)
+	case errors.Is(err, service.ErrConnectionNotFound):
+		http.Error(w, "Connection not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidHostKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error updating host key", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"ssh-terminal-app/internal/service"
+)
+
+type JWKSHandler struct {
+	signer service.TokenSigner
+}
+
+func NewJWKSHandler(signer service.TokenSigner) *JWKSHandler {
+	return &JWKSHandler{signer: signer}
+}
+
+// Get publishes the public keys access tokens are signed with, for other
+// services to verify them. Keys are published well before they first sign,
+// so verifiers may cache the set for a while.
+func (h *JWKSHandler) Get(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.signer.JWKS()
+	if err != nil {
+		log.Printf("JWKS: %v", err)
+		http.Error(w, "Error loading signing keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=900")
+	json.NewEncoder(w).Encode(keys)
+}
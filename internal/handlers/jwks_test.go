@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/database"
+	"ssh-terminal-app/internal/repository"
+	"ssh-terminal-app/internal/service"
+	"ssh-terminal-app/internal/utils"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm/logger"
+)
+
+func newTestSigner(t *testing.T) service.TokenSigner {
+	t.Helper()
+	logger.Default = logger.Discard
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	keyring, err := utils.NewKeyring("test", "a master key only the tests use", nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	signer, err := service.NewTokenSigner(repository.NewSigningKeyRepository(db), keyring, &config.Config{
+		JWTAlgorithm:   "EdDSA",
+		JWTIssuer:      "ssh-terminal-test",
+		JWTKeyRotation: 30 * 24 * time.Hour,
+		AccessTokenTTL: 15 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewTokenSigner: %v", err)
+	}
+	return signer
+}
+
+func TestJWKSVerifiesIssuedTokens(t *testing.T) {
+	signer := newTestSigner(t)
+	token, err := signer.Sign(service.TokenTypeAccess, jwt.MapClaims{"sub": "1", "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	NewJWKSHandler(signer).Get(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/jwk-set+json" {
+		t.Errorf("Content-Type %q, want application/jwk-set+json", got)
+	}
+
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(w.Body).Decode(&set); err != nil {
+		t.Fatalf("decode JWKS: %v", err)
+	}
+	for _, key := range set.Keys {
+		if !key.IsPublic() {
+			t.Fatalf("JWKS publishes a private key %s", key.KeyID)
+		}
+	}
+
+	// An outside verifier needs nothing but the published set
+	_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		keys := set.Key(kid)
+		if len(keys) != 1 {
+			t.Fatalf("kid %q is published %d times, want once", kid, len(keys))
+		}
+		if keys[0].Algorithm != token.Method.Alg() || keys[0].Use != "sig" {
+			t.Errorf("published key %+v doesn't match the token's %s", keys[0], token.Method.Alg())
+		}
+		return keys[0].Key, nil
+	})
+	if err != nil {
+		t.Errorf("verifying with the JWKS: %v", err)
+	}
+}
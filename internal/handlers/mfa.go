@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// MFATokenRequest continues a login that returned an mfa_token
+type MFATokenRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type MFAPolicyRequest struct {
+	Required bool `json:"required"`
+}
+
+type PasskeyMFARequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// VerifyMFA completes a password login with a TOTP or recovery code
+func (h *AuthHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFATokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "mfa_token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.service.VerifyMFA(req.MFAToken, req.Code, clientAddr(r))
+	if err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// BeginMFASetup starts enrollment for a login held back because the account
+// must use MFA but has not set it up
+func (h *AuthHandler) BeginMFASetup(w http.ResponseWriter, r *http.Request) {
+	var req MFATokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "mfa_token is required", http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.service.BeginMFASetup(req.MFAToken)
+	if err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// CompleteMFASetup confirms enrollment and returns the session along with
+// the recovery codes, which are only ever shown this once
+func (h *AuthHandler) CompleteMFASetup(w http.ResponseWriter, r *http.Request) {
+	var req MFATokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "mfa_token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.CompleteMFASetup(req.MFAToken, req.Code, clientAddr(r))
+	if err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *AuthHandler) MFAStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	status, err := h.service.GetMFAStatus(userID)
+	if err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *AuthHandler) BeginMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	enrollment, err := h.service.BeginMFAEnrollment(userID)
+	if err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (h *AuthHandler) ConfirmMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.parseMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.service.ConfirmMFAEnrollment(userID, req.Code, clientAddr(r))
+	if err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+func (h *AuthHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.parseMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DisableMFA(userID, req.Code, clientAddr(r)); err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.parseMFACodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(userID, req.Code, clientAddr(r))
+	if err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// SetPasskeyMFA turns passkeys on or off as the user's second factor
+func (h *AuthHandler) SetPasskeyMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req PasskeyMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetPasskeyMFA(userID, req.Enabled, clientAddr(r)); err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetMFARequired lets an admin require MFA for a single user
+func (h *AuthHandler) SetMFARequired(w http.ResponseWriter, r *http.Request) {
+	adminID, targetID, ok := h.parseAdminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req MFAPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetMFARequired(adminID, targetID, req.Required, clientAddr(r)); err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetMFA lets an admin remove a user's second factor
+func (h *AuthHandler) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	adminID, targetID, ok := h.parseAdminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.ResetMFA(adminID, targetID, clientAddr(r)); err != nil {
+		h.writeMFAError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) parseMFACodeRequest(w http.ResponseWriter, r *http.Request) (uint, MFACodeRequest, bool) {
+	var req MFACodeRequest
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, req, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return 0, req, false
+	}
+
+	return userID, req, true
+}
+
+func (h *AuthHandler) parseAdminRequest(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	adminID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return adminID, uint(id), true
+}
+
+func (h *AuthHandler) writeMFAError(w http.ResponseWriter, err error) {
+	var limited *service.RateLimitError
+	switch {
+	case errors.As(err, &limited):
+		writeRateLimited(w, limited)
+	case errors.Is(err, service.ErrInvalidMFAToken),
+		errors.Is(err, service.ErrInvalidMFACode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, service.ErrPermissionDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrMFARequired),
+		errors.Is(err, service.ErrMFAAlreadyEnabled),
+		errors.Is(err, service.ErrMFANotEnabled),
+		errors.Is(err, service.ErrMFAEnrollmentNotStarted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "Error processing two-factor request", http.StatusInternalServerError)
+	}
+}
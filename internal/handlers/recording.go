@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type RecordingHandler struct {
+	service service.RecordingService
+	tokens  middleware.TokenValidator
+	cfg     *config.Config
+}
+
+func NewRecordingHandler(service service.RecordingService, tokens middleware.TokenValidator, cfg *config.Config) *RecordingHandler {
+	return &RecordingHandler{
+		service: service,
+		tokens:  tokens,
+		cfg:     cfg,
+	}
+}
+
+func (h *RecordingHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var connID uint64
+	if value := r.URL.Query().Get("connection_id"); value != "" {
+		var err error
+		connID, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid connection ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	recordings, err := h.service.List(userID, uint(connID))
+	if err != nil {
+		http.Error(w, "Error fetching recordings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recordings)
+}
+
+func (h *RecordingHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rec, err := h.service.Get(id, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
+
+func (h *RecordingHandler) Download(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	file, rec, err := h.service.Open(id, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	defer file.Close()
+
+	name := fmt.Sprintf("session-%d.cast", rec.ID)
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(w, r, name, rec.UpdatedAt, file)
+}
+
+func (h *RecordingHandler) Search(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	var connID uint64
+	if value := query.Get("connection_id"); value != "" {
+		var err error
+		connID, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid connection ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	matches, truncated, err := h.service.Search(userID, uint(connID), query.Get("q"))
+	if err != nil {
+		if errors.Is(err, service.ErrEmptySearch) {
+			http.Error(w, "Search query is required", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Error searching recordings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if truncated {
+		// Narrow the search, e.g. with connection_id, to see the rest
+		w.Header().Set("X-Search-Truncated", "true")
+	}
+	json.NewEncoder(w).Encode(matches)
+}
+
+// Playback replays a recording over a WebSocket; ?speed= and ?seek= (seconds)
+// set the initial rate and position
+func (h *RecordingHandler) Playback(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, "Failed to upgrade connection", http.StatusInternalServerError)
+		return
+	}
+	defer ws.Close()
+
+	userID, err := authenticateWebSocket(r, h.tokens, "")
+	if err != nil {
+		sendWebSocketError(ws, err.Error())
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		sendWebSocketError(ws, "Invalid recording ID")
+		return
+	}
+
+	query := r.URL.Query()
+	speed, seek := 1.0, 0.0
+	if value := query.Get("speed"); value != "" {
+		if speed, err = strconv.ParseFloat(value, 64); err != nil {
+			sendWebSocketError(ws, "Invalid speed")
+			return
+		}
+	}
+	if value := query.Get("seek"); value != "" {
+		if seek, err = strconv.ParseFloat(value, 64); err != nil {
+			sendWebSocketError(ws, "Invalid seek position")
+			return
+		}
+	}
+
+	if err := h.service.Play(ws, uint(id), userID, speed, seek); err != nil {
+		sendWebSocketError(ws, fmt.Sprintf("Playback error: %v", err))
+	}
+}
+
+func (h *RecordingHandler) parseRequest(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return userID, uint(id), true
+}
+
+func (h *RecordingHandler) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrRecordingNotFound) {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Error fetching recording", http.StatusInternalServerError)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -14,12 +15,14 @@ import (
 
 type SSHHandler struct {
 	service service.SSHService
+	authz   service.Authorizer
 	cfg     *config.Config
 }
 
-func NewSSHHandler(service service.SSHService, cfg *config.Config) *SSHHandler {
+func NewSSHHandler(service service.SSHService, authz service.Authorizer, cfg *config.Config) *SSHHandler {
 	return &SSHHandler{
 		service: service,
+		authz:   authz,
 		cfg:     cfg,
 	}
 }
@@ -33,6 +36,14 @@ type SSHConnectionResponse struct {
 	Username  string `json:"username"`
 	AuthType  string `json:"auth_type"`
 	CreatedAt string `json:"created_at"`
+
+	RecordSessions bool   `json:"record_sessions"`
+	JumpHostIDs    []uint `json:"jump_host_ids"`
+	TeamID         *uint  `json:"team_id"`
+	IdentityID     *uint  `json:"identity_id"`
+
+	// What the requesting user may do with the connection
+	Permissions []service.Permission `json:"permissions"`
 }
 
 func (h *SSHHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -58,6 +69,13 @@ func (h *SSHHandler) List(w http.ResponseWriter, r *http.Request) {
 			Username:  conn.Username,
 			AuthType:  conn.AuthType,
 			CreatedAt: conn.CreatedAt.Format("2006-01-02 15:04:05"),
+
+			RecordSessions: conn.RecordSessions,
+			JumpHostIDs:    conn.JumpHostIDs,
+			TeamID:         conn.TeamID,
+			IdentityID:     conn.IdentityID,
+
+			Permissions: h.authz.ConnectionPermissions(&conn, userID),
 		}
 	}
 
@@ -93,6 +111,13 @@ func (h *SSHHandler) Get(w http.ResponseWriter, r *http.Request) {
 		Username:  conn.Username,
 		AuthType:  conn.AuthType,
 		CreatedAt: conn.CreatedAt.Format("2006-01-02 15:04:05"),
+
+		RecordSessions: conn.RecordSessions,
+		JumpHostIDs:    conn.JumpHostIDs,
+		TeamID:         conn.TeamID,
+		IdentityID:     conn.IdentityID,
+
+		Permissions: h.authz.ConnectionPermissions(conn, userID),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -114,7 +139,7 @@ func (h *SSHHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	conn, err := h.service.Create(userID, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
@@ -126,6 +151,13 @@ func (h *SSHHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Username:  conn.Username,
 		AuthType:  conn.AuthType,
 		CreatedAt: conn.CreatedAt.Format("2006-01-02 15:04:05"),
+
+		RecordSessions: conn.RecordSessions,
+		JumpHostIDs:    conn.JumpHostIDs,
+		TeamID:         conn.TeamID,
+		IdentityID:     conn.IdentityID,
+
+		Permissions: h.authz.ConnectionPermissions(conn, userID),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -155,7 +187,7 @@ func (h *SSHHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	conn, err := h.service.Update(uint(id), userID, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
@@ -167,6 +199,13 @@ func (h *SSHHandler) Update(w http.ResponseWriter, r *http.Request) {
 		Username:  conn.Username,
 		AuthType:  conn.AuthType,
 		CreatedAt: conn.CreatedAt.Format("2006-01-02 15:04:05"),
+
+		RecordSessions: conn.RecordSessions,
+		JumpHostIDs:    conn.JumpHostIDs,
+		TeamID:         conn.TeamID,
+		IdentityID:     conn.IdentityID,
+
+		Permissions: h.authz.ConnectionPermissions(conn, userID),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -188,9 +227,26 @@ func (h *SSHHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.Delete(uint(id), userID); err != nil {
-		http.Error(w, "Error deleting connection", http.StatusInternalServerError)
+		h.writeError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *SSHHandler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrConnectionNotFound):
+		http.Error(w, "Connection not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrTeamNotFound):
+		http.Error(w, "Team not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrSSHIdentityNotFound):
+		http.Error(w, "SSH identity not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrPermissionDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrConnectionInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type SSHCAHandler struct {
+	service service.SSHCAService
+	cfg     *config.Config
+}
+
+func NewSSHCAHandler(service service.SSHCAService, cfg *config.Config) *SSHCAHandler {
+	return &SSHCAHandler{
+		service: service,
+		cfg:     cfg,
+	}
+}
+
+// PrincipalsRequest DTO; an empty list removes the mapping
+type PrincipalsRequest struct {
+	Principals []string `json:"principals"`
+}
+
+// PublicKey serves the CA keys as a file to use as sshd's TrustedUserCAKeys
+func (h *SSHCAHandler) PublicKey(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.PublicKey()
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="ssh-terminal-ca.pub"`)
+	w.Write([]byte(keys + "\n"))
+}
+
+func (h *SSHCAHandler) GetUserPrincipals(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := h.parseAdminRequest(w, r, "user")
+	if !ok {
+		return
+	}
+
+	principals, err := h.service.UserPrincipals(adminID, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePrincipals(w, principals)
+}
+
+func (h *SSHCAHandler) SetUserPrincipals(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := h.parseAdminRequest(w, r, "user")
+	if !ok {
+		return
+	}
+
+	var req PrincipalsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	principals, err := h.service.SetUserPrincipals(adminID, userID, req.Principals, clientAddr(r))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writePrincipals(w, principals)
+}
+
+func (h *SSHCAHandler) GetTeamPrincipals(w http.ResponseWriter, r *http.Request) {
+	adminID, teamID, ok := h.parseAdminRequest(w, r, "team")
+	if !ok {
+		return
+	}
+
+	principals, err := h.service.TeamPrincipals(adminID, teamID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(principals)
+}
+
+func (h *SSHCAHandler) SetTeamPrincipals(w http.ResponseWriter, r *http.Request) {
+	adminID, teamID, ok := h.parseAdminRequest(w, r, "team")
+	if !ok {
+		return
+	}
+
+	// Team principals also name the member roles that get them
+	var req service.TeamSSHPrincipals
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	principals, err := h.service.SetTeamPrincipals(adminID, teamID, req, clientAddr(r))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(principals)
+}
+
+func (h *SSHCAHandler) writePrincipals(w http.ResponseWriter, principals []string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PrincipalsRequest{Principals: principals})
+}
+
+// parseAdminRequest reads the calling admin and the ID of the user or team
+// the request is about
+func (h *SSHCAHandler) parseAdminRequest(w http.ResponseWriter, r *http.Request, target string) (uint, uint, bool) {
+	adminID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid "+target+" ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return adminID, uint(id), true
+}
+
+func (h *SSHCAHandler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPermissionDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrTeamNotFound):
+		http.Error(w, "Team not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidPrincipals):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("SSH CA request failed: %v", err)
+		http.Error(w, "Error processing SSH CA request", http.StatusInternalServerError)
+	}
+}
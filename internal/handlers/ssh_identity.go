@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type SSHIdentityHandler struct {
+	service service.SSHIdentityService
+	cfg     *config.Config
+}
+
+func NewSSHIdentityHandler(service service.SSHIdentityService, cfg *config.Config) *SSHIdentityHandler {
+	return &SSHIdentityHandler{
+		service: service,
+		cfg:     cfg,
+	}
+}
+
+// SSHIdentityResponse tells which credentials an identity holds without
+// revealing them
+type SSHIdentityResponse struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Username    string `json:"username"`
+	PublicKey   string `json:"public_key"`
+	Certificate string `json:"certificate"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+
+	HasPassword   bool `json:"has_password"`
+	HasPrivateKey bool `json:"has_private_key"`
+	HasPassphrase bool `json:"has_passphrase"`
+}
+
+// SSHIdentityUsageResponse is a connection that logs in with an identity
+type SSHIdentityUsageResponse struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	TeamID   *uint  `json:"team_id"`
+}
+
+func (h *SSHIdentityHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	identities, err := h.service.List(userID)
+	if err != nil {
+		http.Error(w, "Error fetching SSH identities", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SSHIdentityResponse, len(identities))
+	for i := range identities {
+		response[i] = newSSHIdentityResponse(&identities[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *SSHIdentityHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	identity, err := h.service.Get(id, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSSHIdentityResponse(identity))
+}
+
+func (h *SSHIdentityHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req service.SSHIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.service.Create(userID, req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newSSHIdentityResponse(identity))
+}
+
+// Generate creates an identity with a new server-side keypair and returns
+// its public key, ready to be added to authorized_keys
+func (h *SSHIdentityHandler) Generate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req service.GenerateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.service.Generate(userID, req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newSSHIdentityResponse(identity))
+}
+
+// Update replaces the identity's credentials for all of its connections at
+// once when any credential is given
+func (h *SSHIdentityHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req service.SSHIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.service.Update(id, userID, req)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSSHIdentityResponse(identity))
+}
+
+func (h *SSHIdentityHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(id, userID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Connections lists the hosts that use the identity
+func (h *SSHIdentityHandler) Connections(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	connections, err := h.service.Connections(id, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	response := make([]SSHIdentityUsageResponse, len(connections))
+	for i, conn := range connections {
+		response[i] = SSHIdentityUsageResponse{
+			ID:       conn.ID,
+			Name:     conn.Name,
+			Host:     conn.Host,
+			Port:     conn.Port,
+			Username: conn.Username,
+			TeamID:   conn.TeamID,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func newSSHIdentityResponse(identity *models.SSHIdentity) SSHIdentityResponse {
+	return SSHIdentityResponse{
+		ID:          identity.ID,
+		Name:        identity.Name,
+		Username:    identity.Username,
+		PublicKey:   identity.PublicKey,
+		Certificate: identity.Certificate,
+		CreatedAt:   identity.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:   identity.UpdatedAt.Format("2006-01-02 15:04:05"),
+
+		HasPassword:   identity.Password != "",
+		HasPrivateKey: identity.PrivateKey != "",
+		HasPassphrase: identity.Passphrase != "",
+	}
+}
+
+func (h *SSHIdentityHandler) parseRequest(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid SSH identity ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return userID, uint(id), true
+}
+
+func (h *SSHIdentityHandler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrSSHIdentityNotFound):
+		http.Error(w, "SSH identity not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrSSHIdentityInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrInvalidSSHIdentity):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("SSH identity request failed: %v", err)
+		http.Error(w, "Error saving SSH identity", http.StatusInternalServerError)
+	}
+}
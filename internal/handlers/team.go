@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+type TeamHandler struct {
+	service service.TeamService
+	cfg     *config.Config
+}
+
+func NewTeamHandler(service service.TeamService, cfg *config.Config) *TeamHandler {
+	return &TeamHandler{
+		service: service,
+		cfg:     cfg,
+	}
+}
+
+type TeamRequest struct {
+	Name string `json:"name"`
+}
+
+type AddTeamMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type UpdateTeamMemberRequest struct {
+	Role string `json:"role"`
+}
+
+func (h *TeamHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	teams, err := h.service.List(userID)
+	if err != nil {
+		http.Error(w, "Error fetching teams", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+func (h *TeamHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	team, err := h.service.Create(userID, req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(team)
+}
+
+func (h *TeamHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	team, err := h.service.Get(teamID, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(team)
+}
+
+func (h *TeamHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	team, err := h.service.Rename(teamID, userID, req.Name)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(team)
+}
+
+func (h *TeamHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(teamID, userID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TeamHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.service.ListMembers(teamID, userID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *TeamHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req AddTeamMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	member, err := h.service.AddMember(teamID, userID, req.Email, req.Role)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *TeamHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+	memberID, ok := h.parseMemberID(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateTeamMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	member, err := h.service.UpdateMember(teamID, userID, memberID, req.Role)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *TeamHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userID, teamID, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+	memberID, ok := h.parseMemberID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RemoveMember(teamID, userID, memberID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TeamHandler) parseRequest(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid team ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return userID, uint(id), true
+}
+
+func (h *TeamHandler) parseMemberID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["userID"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (h *TeamHandler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrTeamNotFound):
+		http.Error(w, "Team not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrMemberNotFound):
+		http.Error(w, "Team member not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrPermissionDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrLastTeamOwner),
+		errors.Is(err, service.ErrTeamNotEmpty),
+		errors.Is(err, service.ErrMemberExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -1,38 +1,92 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/middleware"
 	"ssh-terminal-app/internal/service"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
 type TerminalHandler struct {
 	service service.TerminalService
+	tokens  middleware.TokenValidator
 	cfg     *config.Config
 }
 
-func NewTerminalHandler(service service.TerminalService, cfg *config.Config) *TerminalHandler {
+func NewTerminalHandler(service service.TerminalService, tokens middleware.TokenValidator, cfg *config.Config) *TerminalHandler {
 	return &TerminalHandler{
 		service: service,
+		tokens:  tokens,
 		cfg:     cfg,
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+func (h *TerminalHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, "Failed to upgrade connection", http.StatusInternalServerError)
+		return
+	}
+	defer ws.Close()
+
+	userID, err := authenticateWebSocket(r, h.tokens, service.ScopeSessionsOpen)
+	if err != nil {
+		h.sendError(ws, err.Error())
+		return
+	}
+
+	vars := mux.Vars(r)
+	connID, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err != nil {
+		h.sendError(ws, "Invalid connection ID")
+		return
+	}
+
+	if err := h.service.StartSession(ws, uint(connID), userID, clientAddr(r)); err != nil {
+		var mismatch *service.HostKeyMismatchError
+		if errors.As(err, &mismatch) {
+			h.sendHostKeyMismatch(ws, mismatch)
+			return
+		}
+		h.sendError(ws, fmt.Sprintf("Session error: %v", err))
+		return
+	}
 }
 
-func (h *TerminalHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+// HandleAttach reconnects to a terminal session that outlived its WebSocket
+func (h *TerminalHandler) HandleAttach(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, "Failed to upgrade connection", http.StatusInternalServerError)
+		return
+	}
+	defer ws.Close()
+
+	userID, err := authenticateWebSocket(r, h.tokens, service.ScopeSessionsOpen)
+	if err != nil {
+		h.sendError(ws, err.Error())
+		return
+	}
+
+	sessionID := mux.Vars(r)["sessionID"]
+	if err := h.service.AttachSession(ws, sessionID, userID); err != nil {
+		h.sendError(ws, "Session not found or already ended")
+		return
+	}
+}
 
+// HandleJoinShared attaches another user to a live session through a share link
+func (h *TerminalHandler) HandleJoinShared(w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, "Failed to upgrade connection", http.StatusInternalServerError)
@@ -40,43 +94,181 @@ func (h *TerminalHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request
 	}
 	defer ws.Close()
 
-	tokenString := r.URL.Query().Get("token")
-	if tokenString == "" {
-		h.sendError(ws, "Unauthorized: No token provided")
+	userID, err := authenticateWebSocket(r, h.tokens, "")
+	if err != nil {
+		h.sendError(ws, err.Error())
 		return
 	}
 
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(h.cfg.JWTSecret), nil
+	shareToken := mux.Vars(r)["shareToken"]
+	if err := h.service.JoinSharedSession(ws, shareToken, userID, clientAddr(r)); err != nil {
+		if errors.Is(err, service.ErrEmailVerificationRequired) {
+			h.sendError(ws, err.Error())
+			return
+		}
+		h.sendError(ws, "Share link is invalid, expired or no longer available")
+		return
+	}
+}
+
+func (h *TerminalHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.service.ListSessions(userID))
+}
+
+func (h *TerminalHandler) TerminateSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.TerminateSession(mux.Vars(r)["sessionID"], userID); err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendHostKeyMismatch reports a changed host key as a JSON error frame so the
+// client can offer to review and accept the new key
+func (h *TerminalHandler) sendHostKeyMismatch(ws *websocket.Conn, mismatch *service.HostKeyMismatchError) {
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":                  "error",
+		"code":                  "host_key_mismatch",
+		"data":                  "Host key verification failed: " + mismatch.Error(),
+		"connection_id":         mismatch.ConnectionID,
+		"host":                  mismatch.Host,
+		"previous_host":         mismatch.PreviousHost,
+		"expected_fingerprint":  mismatch.ExpectedFingerprint,
+		"presented_fingerprint": mismatch.PresentedFingerprint,
 	})
+	ws.WriteMessage(websocket.TextMessage, frame)
+	ws.Close()
+}
+
+func (h *TerminalHandler) sendError(ws *websocket.Conn, message string) {
+	sendWebSocketError(ws, message)
+}
+
+type CreateShareRequest struct {
+	ReadOnly  bool `json:"read_only"`
+	ExpiresIn int  `json:"expires_in"` // Seconds; defaults to one hour
+}
+
+func (h *TerminalHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	invite, err := h.service.ShareSession(mux.Vars(r)["sessionID"], userID, req.ReadOnly, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		h.writeSessionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      invite.Token,
+		"read_only":  invite.ReadOnly,
+		"expires_at": invite.ExpiresAt,
+		"ws_path":    "/ws/terminal/shared/" + invite.Token,
+	})
+}
+
+func (h *TerminalHandler) ListShares(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
 
-	if err != nil || !token.Valid {
-		h.sendError(ws, "Unauthorized: Invalid token")
+	invites, err := h.service.ListShares(mux.Vars(r)["sessionID"], userID)
+	if err != nil {
+		h.writeSessionError(w, err)
 		return
 	}
 
-	userIDFloat, ok := claims["user_id"].(float64)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+func (h *TerminalHandler) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
 	if !ok {
-		h.sendError(ws, "Unauthorized: Invalid token claims")
+		http.Error(w, "User not found", http.StatusUnauthorized)
 		return
 	}
-	userID := uint(userIDFloat)
 
 	vars := mux.Vars(r)
-	connID, err := strconv.ParseUint(vars["id"], 10, 32)
+	if err := h.service.RevokeShare(vars["sessionID"], userID, vars["shareToken"]); err != nil {
+		h.writeSessionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TerminalHandler) ListViewers(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	viewers, err := h.service.ListViewers(mux.Vars(r)["sessionID"], userID)
 	if err != nil {
-		h.sendError(ws, "Invalid connection ID")
+		h.writeSessionError(w, err)
 		return
 	}
 
-	if err := h.service.StartSession(ws, uint(connID), userID); err != nil {
-		h.sendError(ws, fmt.Sprintf("Session error: %v", err))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewers)
+}
+
+func (h *TerminalHandler) KickViewer(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
 		return
 	}
+
+	vars := mux.Vars(r)
+	if err := h.service.KickViewer(vars["sessionID"], userID, vars["viewerID"]); err != nil {
+		h.writeSessionError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *TerminalHandler) sendError(ws *websocket.Conn, message string) {
-	ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("\x1b[31mError: %s\r\n\x1b[0m", message)))
-	ws.Close()
+func (h *TerminalHandler) writeSessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrSessionNotFound):
+		http.Error(w, "Session not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrShareNotFound):
+		http.Error(w, "Share link not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrViewerNotFound):
+		http.Error(w, "Viewer not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrPermissionDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "Error updating session", http.StatusInternalServerError)
+	}
 }
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"ssh-terminal-app/internal/middleware"
+	"ssh-terminal-app/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// WebAuthnRequest carries the authenticator's response back to the server.
+// Credential is the PublicKeyCredential serialized as JSON by the browser.
+type WebAuthnRequest struct {
+	SessionID  string          `json:"session_id"`
+	MFAToken   string          `json:"mfa_token"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+func (h *AuthHandler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	challenge, err := h.service.BeginPasskeyRegistration(userID)
+	if err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+func (h *AuthHandler) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	req, ok := parseWebAuthnRequest(w, r)
+	if !ok {
+		return
+	}
+
+	credential, err := h.service.FinishPasskeyRegistration(userID, req.SessionID, req.Name, bytes.NewReader(req.Credential), clientAddr(r))
+	if err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+func (h *AuthHandler) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := h.service.ListPasskeys(userID)
+	if err != nil {
+		http.Error(w, "Error fetching passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+func (h *AuthHandler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeletePasskey(userID, uint(id), clientAddr(r)); err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginPasskeyLogin starts a passwordless login
+func (h *AuthHandler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	challenge, err := h.service.BeginPasskeyLogin()
+	if err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+func (h *AuthHandler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	req, ok := parseWebAuthnRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.service.FinishPasskeyLogin(req.SessionID, bytes.NewReader(req.Credential), clientAddr(r))
+	if err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// BeginPasskeyMFA challenges a passkey as the second factor of a login that
+// returned an mfa_token
+func (h *AuthHandler) BeginPasskeyMFA(w http.ResponseWriter, r *http.Request) {
+	var req WebAuthnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "mfa_token is required", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := h.service.BeginPasskeyMFA(req.MFAToken)
+	if err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+func (h *AuthHandler) FinishPasskeyMFA(w http.ResponseWriter, r *http.Request) {
+	req, ok := parseWebAuthnRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.MFAToken == "" {
+		http.Error(w, "mfa_token is required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.service.FinishPasskeyMFA(req.MFAToken, req.SessionID, bytes.NewReader(req.Credential), clientAddr(r))
+	if err != nil {
+		h.writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func parseWebAuthnRequest(w http.ResponseWriter, r *http.Request) (*WebAuthnRequest, bool) {
+	var req WebAuthnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	if req.SessionID == "" || len(req.Credential) == 0 {
+		http.Error(w, "session_id and credential are required", http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+func (h *AuthHandler) writeWebAuthnError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidPasskey),
+		errors.Is(err, service.ErrWebAuthnSessionGone):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, service.ErrPasskeyNotFound):
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrWebAuthnNotAvailable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		h.writeMFAError(w, err)
+	}
+}
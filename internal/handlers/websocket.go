@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ssh-terminal-app/internal/middleware"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// authenticateWebSocket validates the token passed in the query string, since
+// browsers cannot set an Authorization header on WebSocket requests. scope is
+// what a personal access token needs here; "" refuses them.
+func authenticateWebSocket(r *http.Request, tokens middleware.TokenValidator, scope string) (uint, error) {
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" {
+		return 0, errors.New("Unauthorized: No token provided")
+	}
+
+	claims, err := tokens.ValidateAccessToken(tokenString)
+	if err != nil {
+		return 0, errors.New("Unauthorized: Invalid token")
+	}
+	if claims.Scopes != nil && (scope == "" || !claims.HasScope(scope)) {
+		return 0, errors.New("Forbidden: token cannot open this session")
+	}
+
+	return claims.UserID, nil
+}
+
+func sendWebSocketError(ws *websocket.Conn, message string) {
+	ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("\x1b[31mError: %s\r\n\x1b[0m", message)))
+	ws.Close()
+}
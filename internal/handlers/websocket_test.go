@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/service"
+)
+
+// fakeTokens accepts the tokens it maps to claims
+type fakeTokens map[string]*models.AccessClaims
+
+func (f fakeTokens) ValidateAccessToken(token string) (*models.AccessClaims, error) {
+	claims, ok := f[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func TestAuthenticateWebSocketChecksScope(t *testing.T) {
+	tokens := fakeTokens{
+		"session": {UserID: 1},
+		"opener":  {UserID: 2, Scopes: []string{service.ScopeSessionsOpen}},
+		"reader":  {UserID: 3, Scopes: []string{service.ScopeConnectionsRead}},
+	}
+	tests := []struct {
+		token, scope string
+		want         uint // Zero when refused
+	}{
+		{"session", service.ScopeSessionsOpen, 1},
+		{"session", "", 1},
+		{"opener", service.ScopeSessionsOpen, 2},
+		{"opener", "", 0}, // e.g. joining a shared session or playing a recording
+		{"reader", service.ScopeSessionsOpen, 0},
+		{"forged", service.ScopeSessionsOpen, 0},
+		{"", service.ScopeSessionsOpen, 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws/terminal/1?token="+tt.token, nil)
+		userID, err := authenticateWebSocket(r, tokens, tt.scope)
+		if tt.want == 0 && err == nil {
+			t.Errorf("%q token with scope %q: accepted as user %d", tt.token, tt.scope, userID)
+		}
+		if tt.want != 0 && (err != nil || userID != tt.want) {
+			t.Errorf("%q token with scope %q: got %d, %v; want user %d", tt.token, tt.scope, userID, err, tt.want)
+		}
+	}
+}
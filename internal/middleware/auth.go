@@ -5,23 +5,32 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"ssh-terminal-app/internal/models"
+
+	"github.com/gorilla/mux"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey       contextKey = "userID"
+	AccessClaimsKey contextKey = "accessClaims"
+)
+
+// TokenValidator checks an access token's signature, expiry and revocation
+type TokenValidator interface {
+	ValidateAccessToken(token string) (*models.AccessClaims, error)
+}
 
-func Auth(jwtSecret string) func(http.Handler) http.Handler {
+func Auth(tokens TokenValidator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			
+
 			if r.Method == "OPTIONS" {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-		
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
 				http.Error(w, "Authorization header required", http.StatusUnauthorized)
@@ -34,43 +43,69 @@ func Auth(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			tokenString := parts[1]
-
-			
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, jwt.ErrSignatureInvalid
-				}
-				return []byte(jwtSecret), nil
-			})
-
-			if err != nil || !token.Valid {
+			claims, err := tokens.ValidateAccessToken(parts[1])
+			if err != nil {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
-			
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				http.Error(w, "Invalid token claims", http.StatusUnauthorized)
-				return
-			}
-
-			userID, ok := claims["user_id"].(float64)
-			if !ok {
-				http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
-				return
+			// Personal access tokens only reach routes that name a scope they hold
+			if claims.Scopes != nil {
+				scope, ok := routeScope(r)
+				if !ok {
+					http.Error(w, "Personal access tokens cannot be used here", http.StatusForbidden)
+					return
+				}
+				if !claims.HasScope(scope) {
+					http.Error(w, "Token lacks the "+scope+" scope", http.StatusForbidden)
+					return
+				}
 			}
 
-			
-			ctx := context.WithValue(r.Context(), UserIDKey, uint(userID))
+			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, AccessClaimsKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-
 func GetUserID(r *http.Request) (uint, bool) {
 	userID, ok := r.Context().Value(UserIDKey).(uint)
 	return userID, ok
 }
+
+// GetAccessClaims returns the claims of the access token that authenticated r
+func GetAccessClaims(r *http.Request) (*models.AccessClaims, bool) {
+	claims, ok := r.Context().Value(AccessClaimsKey).(*models.AccessClaims)
+	return claims, ok
+}
+
+// scopedHandler marks a route as open to personal access tokens with scope
+type scopedHandler struct {
+	scope string
+	next  http.Handler
+}
+
+func (h *scopedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.next.ServeHTTP(w, r)
+}
+
+// RequireScope opens a route to personal access tokens holding scope. Auth
+// enforces it; routes without one refuse personal access tokens.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &scopedHandler{scope: scope, next: next}
+	}
+}
+
+func routeScope(r *http.Request) (string, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	h, ok := route.GetHandler().(*scopedHandler)
+	if !ok {
+		return "", false
+	}
+	return h.scope, true
+}
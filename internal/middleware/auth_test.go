@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeTokens accepts the tokens it maps to claims
+type fakeTokens map[string]*models.AccessClaims
+
+func (f fakeTokens) ValidateAccessToken(token string) (*models.AccessClaims, error) {
+	claims, ok := f[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func TestAuthLimitsAccessTokensToScopedRoutes(t *testing.T) {
+	tokens := fakeTokens{
+		"session": {UserID: 1},
+		"reader":  {UserID: 1, Scopes: []string{"connections:read"}},
+		"none":    {UserID: 1, Scopes: []string{}},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := GetUserID(r); !found {
+			t.Error("user ID missing from the request context")
+		}
+	})
+
+	r := mux.NewRouter()
+	r.Use(Auth(tokens))
+	r.Handle("/ssh", RequireScope("connections:read")(ok)).Methods("GET")
+	r.Handle("/ssh", RequireScope("connections:write")(ok)).Methods("POST")
+	r.Handle("/auth/password", ok).Methods("POST")
+
+	tests := []struct {
+		token, method, path string
+		want                int
+	}{
+		{"session", "GET", "/ssh", http.StatusOK},
+		{"session", "POST", "/auth/password", http.StatusOK},
+		{"reader", "GET", "/ssh", http.StatusOK},
+		{"reader", "POST", "/ssh", http.StatusForbidden},
+		{"reader", "POST", "/auth/password", http.StatusForbidden},
+		{"none", "GET", "/ssh", http.StatusForbidden},
+		{"forged", "GET", "/ssh", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s with %s token: status %d, want %d", tt.method, tt.path, tt.token, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// AccessClaims are the verified contents of an access token
+type AccessClaims struct {
+	UserID    uint
+	TokenID   string // jti
+	FamilyID  string // Refresh family the token was issued with
+	ExpiresAt time.Time
+	AuthTime  time.Time // When the user signed in to start the family; zero for personal access tokens
+
+	// Scopes limit a personal access token; nil for session tokens
+	Scopes []string
+}
+
+// HasScope reports whether the token may be used for scope. Session tokens
+// carry no scopes and may do anything the user can.
+func (c *AccessClaims) HasScope(scope string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
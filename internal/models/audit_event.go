@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// AuditEvent is one entry of the append-only audit log. Each entry stores the
+// hash of the one before it, so editing or deleting a row breaks the chain.
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Timestamp time.Time `gorm:"not null;index" json:"timestamp"`
+
+	UserID     uint   `gorm:"index" json:"user_id"` // 0 when the actor is unknown, e.g. a failed login
+	Actor      string `json:"actor"`                // Email of the user, or the one that was tried
+	Action     string `gorm:"not null;index" json:"action"`
+	TargetType string `gorm:"index" json:"target_type,omitempty"`
+	TargetID   uint   `gorm:"index" json:"target_id,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	Details map[string]interface{} `gorm:"serializer:json" json:"details,omitempty"`
+
+	PrevHash string `gorm:"not null" json:"prev_hash"`
+	Hash     string `gorm:"not null;uniqueIndex" json:"hash"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Purposes of an EmailToken
+const (
+	EmailTokenPasswordReset = "password_reset"
+	EmailTokenVerification  = "email_verification"
+)
+
+// EmailToken is a single-use link sent to a user's inbox, proving they can
+// read mail sent to Email
+type EmailToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"not null" json:"purpose"`
+	Email     string     `gorm:"not null" json:"email"`         // Address the link was sent to
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"` // SHA-256 of the token; the token itself is never stored
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
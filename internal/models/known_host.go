@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// KnownHost is the trusted host key recorded for a single SSH connection.
+type KnownHost struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ConnectionID uint   `gorm:"not null;uniqueIndex" json:"connection_id"`
+	Host         string `gorm:"not null" json:"host"`
+	Port         int    `gorm:"not null" json:"port"`
+	KeyType      string `gorm:"not null" json:"key_type"`
+	PublicKey    string `gorm:"not null" json:"public_key"` // authorized_keys format
+	Fingerprint  string `gorm:"not null" json:"fingerprint"`
+	Pinned       bool   `gorm:"not null;default:false" json:"pinned"` // Set explicitly by the user, never replaced automatically
+
+	// Key presented by the server on the last rejected handshake
+	PendingPublicKey   string     `json:"pending_public_key,omitempty"`
+	PendingFingerprint string     `json:"pending_fingerprint,omitempty"`
+	PendingSeenAt      *time.Time `json:"pending_seen_at,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PersonalAccessToken lets scripts call the API as a user, limited to the
+// token's scopes. Only a hash of the secret is stored.
+type PersonalAccessToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token
+	Prefix     string     `gorm:"not null" json:"prefix"`        // Start of the token, to tell them apart
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"` // Nil only on tokens from before expiry was required
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// RefreshToken is a single-use token that can be exchanged for a new access
+// token. Every rotation stays in the family started at login, so replaying a
+// used token can revoke the whole family.
+type RefreshToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	FamilyID   string     `gorm:"not null;index" json:"family_id"`
+	AuthTime   time.Time  `json:"auth_time"`                     // When the login that started the family happened
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"` // SHA-256 of the token; the token itself is never stored
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	RemoteAddr string     `json:"remote_addr"`
+}
+
+// RevokedToken marks an access token ID (jti), or a whole refresh family, as
+// revoked until ExpiresAt, after which the tokens it covers are expired anyway
+type RevokedToken struct {
+	ID        string    `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+}
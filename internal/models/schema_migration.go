@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// SchemaMigration records a one-off data migration that has been applied
+type SchemaMigration struct {
+	Name      string    `gorm:"primarykey" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// SessionRecording describes a terminal session captured as an asciicast v2 file.
+type SessionRecording struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID       uint   `gorm:"not null;index" json:"user_id"`
+	ConnectionID uint   `gorm:"not null;index" json:"connection_id"`
+	Host         string `gorm:"not null" json:"host"`
+	Username     string `gorm:"not null" json:"username"`
+	FilePath     string `gorm:"not null" json:"-"`
+
+	StartedAt  time.Time  `gorm:"not null" json:"started_at"`
+	EndedAt    *time.Time `json:"ended_at"`
+	BytesIn    int64      `gorm:"not null;default:0" json:"bytes_in"`
+	BytesOut   int64      `gorm:"not null;default:0" json:"bytes_out"`
+	ExitStatus *int       `json:"exit_status"`
+}
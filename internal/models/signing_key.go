@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// SigningKey is a key pair that signs the app's JWTs. Keys are published
+// before they start signing and kept after they stop, until every token they
+// signed has expired, so verifiers always know the key a token names.
+type SigningKey struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	KID        string     `gorm:"not null;uniqueIndex" json:"kid"`
+	Algorithm  string     `gorm:"not null" json:"algorithm"`         // "EdDSA" or "RS256"
+	PrivateKey string     `gorm:"not null" json:"-"`                 // PKCS #8, encrypted with the app encryption key
+	PublicKey  []byte     `gorm:"not null" json:"-"`                 // PKIX
+	ActiveFrom time.Time  `gorm:"not null;index" json:"active_from"` // Signs new tokens from then until the next key takes over
+	RetireAt   *time.Time `gorm:"index" json:"retire_at"`            // Set once superseded; no longer trusted after it
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// SSHCAKey is the key the app signs short-lived user certificates with.
+// Servers trust it through sshd's TrustedUserCAKeys; the newest one signs,
+// and the one before it stays published until the next rotation.
+type SSHCAKey struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	PrivateKey string `gorm:"not null" json:"-"`          // OpenSSH format, encrypted with the app encryption key
+	PublicKey  string `gorm:"not null" json:"public_key"` // authorized_keys format
+}
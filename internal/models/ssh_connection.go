@@ -13,6 +13,7 @@ type SSHConnection struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
 	UserID uint   `gorm:"not null;index" json:"user_id"`
+	TeamID *uint  `gorm:"index" json:"team_id"` // Set when the connection is shared with a team
 	Name   string `gorm:"not null" json:"name"`
 	Host   string `gorm:"not null" json:"host"`
 	Port   int    `gorm:"not null;default:22" json:"port"`
@@ -20,5 +21,14 @@ type SSHConnection struct {
 	Username   string `gorm:"not null" json:"username"`
 	Password   string `gorm:"" json:"-"`      // Encrypted, not exposed in JSON
 	PrivateKey string `gorm:"" json:"-"`      // Encrypted, not exposed in JSON
+	Passphrase string `gorm:"" json:"-"`      // Encrypted, unlocks PrivateKey; asked at connect time when empty
 	AuthType   string `gorm:"not null" json:"auth_type"` // "password" or "key"
+
+	// A saved identity to log in with instead of the credentials above
+	IdentityID *uint `gorm:"index" json:"identity_id"`
+
+	RecordSessions bool `gorm:"not null;default:false" json:"record_sessions"`
+
+	// Saved connections to tunnel through, in order, before reaching Host
+	JumpHostIDs []uint `gorm:"serializer:json" json:"jump_host_ids"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// SSHIdentity is a login saved once and referenced by any number of
+// connections, so that a key used on many hosts is stored and replaced in
+// one place
+type SSHIdentity struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID   uint   `gorm:"not null;index" json:"-"`
+	Name     string `gorm:"not null" json:"name"`
+	Username string `json:"username"` // Overrides the connection's username when set
+
+	Password    string `json:"-"`           // Encrypted, not exposed in JSON
+	PrivateKey  string `json:"-"`           // Encrypted, not exposed in JSON
+	Passphrase  string `json:"-"`           // Encrypted, unlocks PrivateKey
+	Certificate string `json:"certificate"` // OpenSSH certificate for PrivateKey, public
+	PublicKey   string `json:"public_key"`  // Of PrivateKey, in authorized_keys format
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Team member roles, from most to least privileged
+const (
+	TeamRoleOwner    = "owner"    // Everything, including renaming and deleting the team
+	TeamRoleAdmin    = "admin"    // Manages members and the team's connections
+	TeamRoleOperator = "operator" // Connects to the team's connections
+	TeamRoleViewer   = "viewer"   // Sees the team's connections but cannot connect
+)
+
+// TeamMemberSourceDirectory marks memberships granted by a directory group.
+// They follow the directory at each login; others are managed by hand.
+const TeamMemberSourceDirectory = "directory"
+
+type Team struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Name string `gorm:"not null" json:"name"`
+
+	// SSHPrincipals are added to the certificates of members connecting to
+	// the team's connections, if their role is one of SSHPrincipalRoles.
+	// Both are set by an admin.
+	SSHPrincipals     []string `gorm:"serializer:json" json:"ssh_principals"`
+	SSHPrincipalRoles []string `gorm:"serializer:json" json:"ssh_principal_roles"`
+
+	Members []TeamMember `gorm:"foreignKey:TeamID" json:"-"`
+}
+
+type TeamMember struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	TeamID uint   `gorm:"not null;uniqueIndex:idx_team_member" json:"team_id"`
+	UserID uint   `gorm:"not null;uniqueIndex:idx_team_member;index" json:"user_id"`
+	Role   string `gorm:"not null" json:"role"`
+	Source string `gorm:"not null;default:''" json:"source,omitempty"`
+}
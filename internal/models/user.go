@@ -15,7 +15,25 @@ type User struct {
 	Email    string `gorm:"uniqueIndex;not null" json:"email"`
 	Password string `gorm:"" json:"-"`
 	Name     string `gorm:"not null" json:"name"`
-	GoogleID string `gorm:"uniqueIndex" json:"-"`
+
+	// EmailVerifiedAt is set once the user follows a verification link, or
+	// signs in through a provider that vouches for the address
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+
+	// TOTPSecret is encrypted with the app encryption key. It is set during
+	// enrollment and only used for logins once MFAEnabled is true.
+	TOTPSecret   string `json:"-"`
+	TOTPLastStep int64  `json:"-"` // Last accepted time step, so a code cannot be replayed
+	MFAEnabled   bool   `gorm:"not null;default:false" json:"mfa_enabled"`
+	MFARequired  bool   `gorm:"not null;default:false" json:"mfa_required"` // Set by an admin
+	// PasskeyMFA asks for a passkey after the password. Registering a passkey
+	// doesn't turn it on; the user does.
+	PasskeyMFA bool `gorm:"not null;default:false" json:"passkey_mfa"`
+
+	// SSHPrincipals go into the certificates the app's SSH CA issues for the
+	// user. Set by an admin.
+	SSHPrincipals []string `gorm:"serializer:json" json:"ssh_principals"`
 
 	SSHConnections []SSHConnection `gorm:"foreignKey:UserID" json:"-"`
+	Identities     []UserIdentity  `gorm:"foreignKey:UserID" json:"-"`
 }
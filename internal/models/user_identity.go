@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to their account at an external identity
+// provider, identified by the provider's stable subject claim
+type UserIdentity struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID   uint     `gorm:"not null;index" json:"user_id"`
+	Provider string   `gorm:"not null;uniqueIndex:idx_identity_subject" json:"provider"`
+	Subject  string   `gorm:"not null;uniqueIndex:idx_identity_subject" json:"-"`
+	Email    string   `json:"email"`                         // As last reported by the provider
+	Groups   []string `gorm:"serializer:json" json:"groups"` // As last reported by the provider
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// WebAuthnCredential is a registered passkey or security key. It can sign in
+// on its own, or serve as a second factor once User.PasskeyMFA is on.
+type WebAuthnCredential struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+
+	UserID uint   `gorm:"not null;index" json:"user_id"`
+	Name   string `gorm:"not null" json:"name"`
+
+	CredentialID    []byte   `gorm:"not null;uniqueIndex" json:"-"`
+	PublicKey       []byte   `gorm:"not null" json:"-"` // COSE encoded
+	AttestationType string   `json:"-"`
+	Transports      []string `gorm:"serializer:json" json:"transports"`
+	AAGUID          []byte   `json:"-"`
+	SignCount       uint32   `json:"-"`
+	UserVerified    bool     `json:"-"`
+	BackupEligible  bool     `json:"backup_eligible"`
+	BackupState     bool     `json:"backup_state"`
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AccessTokenRepository defines the interface for personal access token data access
+type AccessTokenRepository interface {
+	Create(token *models.PersonalAccessToken) error
+	// GetByHash returns an unrevoked token
+	GetByHash(hash string) (*models.PersonalAccessToken, error)
+	// ListByUserID returns the user's unrevoked tokens, including expired ones
+	ListByUserID(userID uint) ([]models.PersonalAccessToken, error)
+	Revoke(id, userID uint, at time.Time) error
+	// Touch records a use, skipping the write if the last one is recent
+	Touch(id uint, at time.Time, within time.Duration) error
+}
+
+// accessTokenRepository implements AccessTokenRepository using GORM
+type accessTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewAccessTokenRepository creates a new AccessTokenRepository instance
+func NewAccessTokenRepository(db *gorm.DB) AccessTokenRepository {
+	return &accessTokenRepository{db: db}
+}
+
+func (r *accessTokenRepository) Create(token *models.PersonalAccessToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *accessTokenRepository) GetByHash(hash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	err := r.db.Where("token_hash = ? AND revoked_at IS NULL", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *accessTokenRepository) ListByUserID(userID uint) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *accessTokenRepository) Revoke(id, userID uint, at time.Time) error {
+	result := r.db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *accessTokenRepository) Touch(id uint, at time.Time, within time.Duration) error {
+	return r.db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, at.Add(-within)).
+		Update("last_used_at", at).Error
+}
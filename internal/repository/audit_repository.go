@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AuditFilter narrows audit queries; zero values match everything
+type AuditFilter struct {
+	UserID     uint
+	Action     string // Exact action, or a prefix ending in "." such as "auth."
+	TargetType string
+	TargetID   uint
+	Since      time.Time
+	Until      time.Time
+	BeforeID   uint // Page backwards from this ID
+	Limit      int
+}
+
+// AuditRepository defines the interface for audit log data access
+type AuditRepository interface {
+	// Append adds event to the end of the chain in one transaction. The row is
+	// inserted first, which takes SQLite's write lock, and seal then fills in
+	// its hashes from the entry just before it (nil for the first entry).
+	Append(event *models.AuditEvent, seal func(event, prev *models.AuditEvent) error) error
+	// List returns matching events, newest first
+	List(filter AuditFilter) ([]models.AuditEvent, error)
+	// Each calls fn with matching events in batches, oldest first
+	Each(filter AuditFilter, fn func([]models.AuditEvent) error) error
+}
+
+// auditRepository implements AuditRepository using GORM
+type auditRepository struct {
+	db *gorm.DB
+}
+
+// NewAuditRepository creates a new AuditRepository instance
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) Append(event *models.AuditEvent, seal func(event, prev *models.AuditEvent) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+
+		// No other writer can commit until we do, so this is the true predecessor
+		var prev *models.AuditEvent
+		var last models.AuditEvent
+		err := tx.Where("id < ?", event.ID).Order("id DESC").First(&last).Error
+		switch {
+		case err == nil:
+			prev = &last
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+
+		if err := seal(event, prev); err != nil {
+			return err
+		}
+		return tx.Model(event).Updates(map[string]interface{}{
+			"prev_hash": event.PrevHash,
+			"hash":      event.Hash,
+		}).Error
+	})
+}
+
+func (r *auditRepository) List(filter AuditFilter) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	query := r.filtered(filter).Order("id DESC")
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	err := query.Find(&events).Error
+	return events, err
+}
+
+func (r *auditRepository) Each(filter AuditFilter, fn func([]models.AuditEvent) error) error {
+	var batch []models.AuditEvent
+	return r.filtered(filter).Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+func (r *auditRepository) filtered(filter AuditFilter) *gorm.DB {
+	query := r.db.Model(&models.AuditEvent{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if strings.HasSuffix(filter.Action, ".") {
+		query = query.Where("action LIKE ?", filter.Action+"%")
+	} else if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("timestamp >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("timestamp < ?", filter.Until)
+	}
+	return query
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// EmailTokenRepository defines the interface for password reset and email
+// verification token data access
+type EmailTokenRepository interface {
+	// Create stores a token, replacing any unused one the user has for the
+	// same purpose so only the latest link works
+	Create(token *models.EmailToken) error
+	GetByHash(hash string) (*models.EmailToken, error)
+	// Use marks the token as used and reports false if another request got
+	// there first
+	Use(id uint, at time.Time) (bool, error)
+	DeleteExpired(now time.Time) error
+}
+
+// emailTokenRepository implements EmailTokenRepository using GORM
+type emailTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewEmailTokenRepository creates a new EmailTokenRepository instance
+func NewEmailTokenRepository(db *gorm.DB) EmailTokenRepository {
+	return &emailTokenRepository{db: db}
+}
+
+func (r *emailTokenRepository) Create(token *models.EmailToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Delete(&models.EmailToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *emailTokenRepository) GetByHash(hash string) (*models.EmailToken, error) {
+	var token models.EmailToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *emailTokenRepository) Use(id uint, at time.Time) (bool, error) {
+	result := r.db.Model(&models.EmailToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *emailTokenRepository) DeleteExpired(now time.Time) error {
+	return r.db.Where("expires_at < ?", now).Delete(&models.EmailToken{}).Error
+}
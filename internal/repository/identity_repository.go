@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// IdentityRepository defines the interface for external identity data access
+type IdentityRepository interface {
+	Create(identity *models.UserIdentity) error
+	FindBySubject(provider, subject string) (*models.UserIdentity, error)
+	ListByUserID(userID uint) ([]models.UserIdentity, error)
+	Update(identity *models.UserIdentity) error
+	Delete(id, userID uint) error
+}
+
+// identityRepository implements IdentityRepository using GORM
+type identityRepository struct {
+	db *gorm.DB
+}
+
+// NewIdentityRepository creates a new IdentityRepository instance
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+func (r *identityRepository) Create(identity *models.UserIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *identityRepository) FindBySubject(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *identityRepository) ListByUserID(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+func (r *identityRepository) Update(identity *models.UserIdentity) error {
+	return r.db.Save(identity).Error
+}
+
+func (r *identityRepository) Delete(id, userID uint) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
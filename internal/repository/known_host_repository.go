@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// KnownHostRepository defines the interface for stored host key data access
+type KnownHostRepository interface {
+	GetByConnectionID(connID uint) (*models.KnownHost, error)
+	Save(host *models.KnownHost) error
+	DeleteByConnectionID(connID uint) error
+}
+
+// knownHostRepository implements KnownHostRepository using GORM
+type knownHostRepository struct {
+	db *gorm.DB
+}
+
+// NewKnownHostRepository creates a new KnownHostRepository instance
+func NewKnownHostRepository(db *gorm.DB) KnownHostRepository {
+	return &knownHostRepository{db: db}
+}
+
+func (r *knownHostRepository) GetByConnectionID(connID uint) (*models.KnownHost, error) {
+	var host models.KnownHost
+	err := r.db.Where("connection_id = ?", connID).First(&host).Error
+	if err != nil {
+		return nil, err
+	}
+	return &host, nil
+}
+
+func (r *knownHostRepository) Save(host *models.KnownHost) error {
+	return r.db.Save(host).Error
+}
+
+func (r *knownHostRepository) DeleteByConnectionID(connID uint) error {
+	return r.db.Where("connection_id = ?", connID).Delete(&models.KnownHost{}).Error
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// RecordingRepository defines the interface for session recording data access
+type RecordingRepository interface {
+	Create(rec *models.SessionRecording) error
+	Update(rec *models.SessionRecording) error
+	ListByUserID(userID uint, connID uint) ([]models.SessionRecording, error)
+	GetByID(id uint, userID uint) (*models.SessionRecording, error)
+}
+
+// recordingRepository implements RecordingRepository using GORM
+type recordingRepository struct {
+	db *gorm.DB
+}
+
+// NewRecordingRepository creates a new RecordingRepository instance
+func NewRecordingRepository(db *gorm.DB) RecordingRepository {
+	return &recordingRepository{db: db}
+}
+
+func (r *recordingRepository) Create(rec *models.SessionRecording) error {
+	return r.db.Create(rec).Error
+}
+
+func (r *recordingRepository) Update(rec *models.SessionRecording) error {
+	return r.db.Save(rec).Error
+}
+
+// ListByUserID returns the user's recordings, newest first; connID 0 means all connections
+func (r *recordingRepository) ListByUserID(userID uint, connID uint) ([]models.SessionRecording, error) {
+	var recordings []models.SessionRecording
+	query := r.db.Where("user_id = ?", userID)
+	if connID != 0 {
+		query = query.Where("connection_id = ?", connID)
+	}
+	err := query.Order("started_at DESC").Find(&recordings).Error
+	return recordings, err
+}
+
+func (r *recordingRepository) GetByID(id uint, userID uint) (*models.SessionRecording, error) {
+	var rec models.SessionRecording
+	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&rec).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeRepository defines the interface for MFA recovery code data access
+type RecoveryCodeRepository interface {
+	// Replace swaps all of a user's codes for a new set
+	Replace(userID uint, hashes []string) error
+	// Use marks a matching unused code as used and reports whether one existed
+	Use(userID uint, hash string, at time.Time) (bool, error)
+	CountUnused(userID uint) (int64, error)
+	DeleteByUserID(userID uint) error
+}
+
+// recoveryCodeRepository implements RecoveryCodeRepository using GORM
+type recoveryCodeRepository struct {
+	db *gorm.DB
+}
+
+// NewRecoveryCodeRepository creates a new RecoveryCodeRepository instance
+func NewRecoveryCodeRepository(db *gorm.DB) RecoveryCodeRepository {
+	return &recoveryCodeRepository{db: db}
+}
+
+func (r *recoveryCodeRepository) Replace(userID uint, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]models.RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *recoveryCodeRepository) Use(userID uint, hash string, at time.Time) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *recoveryCodeRepository) CountUnused(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *recoveryCodeRepository) DeleteByUserID(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// SigningKeyRepository defines the interface for JWT signing key data access
+type SigningKeyRepository interface {
+	Create(key *models.SigningKey) error
+	// List returns every stored key, oldest first
+	List() ([]models.SigningKey, error)
+	// Retire sets the retirement time of a key that doesn't have one yet
+	Retire(id uint, at time.Time) error
+	DeleteRetired(now time.Time) error
+	// ReplacePrivateKey swaps the stored private key, reporting false if it
+	// has changed since it was read
+	ReplacePrivateKey(id uint, oldKey, key string) (bool, error)
+}
+
+// signingKeyRepository implements SigningKeyRepository using GORM
+type signingKeyRepository struct {
+	db *gorm.DB
+}
+
+// NewSigningKeyRepository creates a new SigningKeyRepository instance
+func NewSigningKeyRepository(db *gorm.DB) SigningKeyRepository {
+	return &signingKeyRepository{db: db}
+}
+
+func (r *signingKeyRepository) Create(key *models.SigningKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *signingKeyRepository) List() ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.Order("active_from, id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *signingKeyRepository) Retire(id uint, at time.Time) error {
+	return r.db.Model(&models.SigningKey{}).
+		Where("id = ? AND retire_at IS NULL", id).
+		Update("retire_at", at).Error
+}
+
+func (r *signingKeyRepository) DeleteRetired(now time.Time) error {
+	return r.db.Where("retire_at < ?", now).Delete(&models.SigningKey{}).Error
+}
+
+func (r *signingKeyRepository) ReplacePrivateKey(id uint, oldKey, key string) (bool, error) {
+	result := r.db.Model(&models.SigningKey{}).
+		Where("id = ? AND private_key = ?", id, oldKey).
+		UpdateColumn("private_key", key)
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// SSHCARepository defines the interface for SSH certificate authority key
+// data access
+type SSHCARepository interface {
+	Create(key *models.SSHCAKey) error
+	// Current returns the newest key
+	Current() (*models.SSHCAKey, error)
+	List() ([]models.SSHCAKey, error)
+	// DeleteBefore removes the keys older than id
+	DeleteBefore(id uint) error
+	// ReplacePrivateKey swaps the stored private key, reporting false if it
+	// has changed since it was read
+	ReplacePrivateKey(id uint, oldKey, key string) (bool, error)
+}
+
+// sshCARepository implements SSHCARepository using GORM
+type sshCARepository struct {
+	db *gorm.DB
+}
+
+// NewSSHCARepository creates a new SSHCARepository instance
+func NewSSHCARepository(db *gorm.DB) SSHCARepository {
+	return &sshCARepository{db: db}
+}
+
+func (r *sshCARepository) Create(key *models.SSHCAKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *sshCARepository) Current() (*models.SSHCAKey, error) {
+	var key models.SSHCAKey
+	err := r.db.Order("id DESC").First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *sshCARepository) List() ([]models.SSHCAKey, error) {
+	var keys []models.SSHCAKey
+	err := r.db.Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *sshCARepository) DeleteBefore(id uint) error {
+	return r.db.Where("id < ?", id).Delete(&models.SSHCAKey{}).Error
+}
+
+func (r *sshCARepository) ReplacePrivateKey(id uint, oldKey, key string) (bool, error) {
+	result := r.db.Model(&models.SSHCAKey{}).
+		Where("id = ? AND private_key = ?", id, oldKey).
+		UpdateColumn("private_key", key)
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// SSHIdentityRepository defines the interface for saved SSH identity data access
+type SSHIdentityRepository interface {
+	Create(identity *models.SSHIdentity) error
+	ListByUserID(userID uint) ([]models.SSHIdentity, error)
+	// GetByID only finds identities owned by userID
+	GetByID(id uint, userID uint) (*models.SSHIdentity, error)
+	// FindByID finds an identity whoever owns it, to dial a connection
+	// that references it
+	FindByID(id uint) (*models.SSHIdentity, error)
+	Update(identity *models.SSHIdentity) error
+	Delete(id uint) error
+	// ListConnections returns every connection that logs in with the
+	// identity, whoever can see it
+	ListConnections(id uint) ([]models.SSHConnection, error)
+	// ListVisibleConnections narrows ListConnections down to the ones userID
+	// can see
+	ListVisibleConnections(id, userID uint) ([]models.SSHConnection, error)
+	// ListSecretsAfter returns up to limit identities with an ID above
+	// afterID, in ID order
+	ListSecretsAfter(afterID uint, limit int) ([]models.SSHIdentity, error)
+	// ReplaceSecrets swaps the stored password, key and passphrase, reporting
+	// false if any of them has changed since they were read
+	ReplaceSecrets(id uint, oldPassword, oldPrivateKey, oldPassphrase, password, privateKey, passphrase string) (bool, error)
+}
+
+// sshIdentityRepository implements SSHIdentityRepository using GORM
+type sshIdentityRepository struct {
+	db *gorm.DB
+}
+
+// NewSSHIdentityRepository creates a new SSHIdentityRepository instance
+func NewSSHIdentityRepository(db *gorm.DB) SSHIdentityRepository {
+	return &sshIdentityRepository{db: db}
+}
+
+func (r *sshIdentityRepository) Create(identity *models.SSHIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *sshIdentityRepository) ListByUserID(userID uint) ([]models.SSHIdentity, error) {
+	var identities []models.SSHIdentity
+	err := r.db.Where("user_id = ?", userID).Order("name").Find(&identities).Error
+	return identities, err
+}
+
+func (r *sshIdentityRepository) GetByID(id uint, userID uint) (*models.SSHIdentity, error) {
+	var identity models.SSHIdentity
+	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *sshIdentityRepository) FindByID(id uint) (*models.SSHIdentity, error) {
+	var identity models.SSHIdentity
+	err := r.db.First(&identity, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *sshIdentityRepository) Update(identity *models.SSHIdentity) error {
+	return r.db.Save(identity).Error
+}
+
+func (r *sshIdentityRepository) Delete(id uint) error {
+	result := r.db.Delete(&models.SSHIdentity{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *sshIdentityRepository) ListConnections(id uint) ([]models.SSHConnection, error) {
+	var connections []models.SSHConnection
+	err := r.db.Where("identity_id = ?", id).Order("name").Find(&connections).Error
+	return connections, err
+}
+
+func (r *sshIdentityRepository) ListVisibleConnections(id, userID uint) ([]models.SSHConnection, error) {
+	var connections []models.SSHConnection
+	err := accessibleConnections(r.db, userID).Where("identity_id = ?", id).Order("name").Find(&connections).Error
+	return connections, err
+}
+
+func (r *sshIdentityRepository) ListSecretsAfter(afterID uint, limit int) ([]models.SSHIdentity, error) {
+	var identities []models.SSHIdentity
+	err := r.db.Where("id > ?", afterID).Order("id").Limit(limit).Find(&identities).Error
+	return identities, err
+}
+
+func (r *sshIdentityRepository) ReplaceSecrets(id uint, oldPassword, oldPrivateKey, oldPassphrase, password, privateKey, passphrase string) (bool, error) {
+	result := r.db.Model(&models.SSHIdentity{}).
+		Where("id = ? AND password = ? AND private_key = ? AND passphrase = ?", id, oldPassword, oldPrivateKey, oldPassphrase).
+		UpdateColumns(map[string]interface{}{"password": password, "private_key": privateKey, "passphrase": passphrase})
+	return result.RowsAffected == 1, result.Error
+}
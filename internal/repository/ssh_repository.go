@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"fmt"
+
 	"ssh-terminal-app/internal/models"
 
 	"gorm.io/gorm"
@@ -9,10 +11,21 @@ import (
 // SSHRepository defines the interface for SSH connection data access
 type SSHRepository interface {
 	Create(conn *models.SSHConnection) error
+	// ListByUserID and GetByID cover the user's own connections and those
+	// shared with any team the user belongs to
 	ListByUserID(userID uint) ([]models.SSHConnection, error)
 	GetByID(id uint, userID uint) (*models.SSHConnection, error)
 	Update(conn *models.SSHConnection) error
-	Delete(id uint, userID uint) error
+	Delete(id uint) error
+	// ListJumpingThrough returns every connection, whoever owns it, whose
+	// jump host chain includes id
+	ListJumpingThrough(id uint) ([]models.SSHConnection, error)
+	// ListSecretsAfter returns up to limit connections, deleted ones included,
+	// with an ID above afterID, in ID order
+	ListSecretsAfter(afterID uint, limit int) ([]models.SSHConnection, error)
+	// ReplaceSecrets swaps the stored password, key and passphrase, reporting
+	// false if any has changed since they were read
+	ReplaceSecrets(id uint, oldPassword, oldPrivateKey, oldPassphrase, password, privateKey, passphrase string) (bool, error)
 }
 
 // sshRepository implements SSHRepository using GORM
@@ -31,13 +44,13 @@ func (r *sshRepository) Create(conn *models.SSHConnection) error {
 
 func (r *sshRepository) ListByUserID(userID uint) ([]models.SSHConnection, error) {
 	var connections []models.SSHConnection
-	err := r.db.Where("user_id = ?", userID).Find(&connections).Error
+	err := r.accessibleBy(userID).Find(&connections).Error
 	return connections, err
 }
 
 func (r *sshRepository) GetByID(id uint, userID uint) (*models.SSHConnection, error) {
 	var conn models.SSHConnection
-	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&conn).Error
+	err := r.accessibleBy(userID).Where("id = ?", id).First(&conn).Error
 	if err != nil {
 		return nil, err
 	}
@@ -48,8 +61,8 @@ func (r *sshRepository) Update(conn *models.SSHConnection) error {
 	return r.db.Save(conn).Error
 }
 
-func (r *sshRepository) Delete(id uint, userID uint) error {
-	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.SSHConnection{})
+func (r *sshRepository) Delete(id uint) error {
+	result := r.db.Delete(&models.SSHConnection{}, id)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -58,3 +71,46 @@ func (r *sshRepository) Delete(id uint, userID uint) error {
 	}
 	return nil
 }
+
+func (r *sshRepository) ListJumpingThrough(id uint) ([]models.SSHConnection, error) {
+	// The chain is stored as a JSON array; LIKE narrows it down and the
+	// exact match is done here
+	var candidates []models.SSHConnection
+	err := r.db.Where("jump_host_ids LIKE ?", fmt.Sprintf("%%%d%%", id)).Order("name").Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	var connections []models.SSHConnection
+	for _, conn := range candidates {
+		for _, jumpID := range conn.JumpHostIDs {
+			if jumpID == id {
+				connections = append(connections, conn)
+				break
+			}
+		}
+	}
+	return connections, nil
+}
+
+func (r *sshRepository) ListSecretsAfter(afterID uint, limit int) ([]models.SSHConnection, error) {
+	var connections []models.SSHConnection
+	err := r.db.Unscoped().Where("id > ?", afterID).Order("id").Limit(limit).Find(&connections).Error
+	return connections, err
+}
+
+func (r *sshRepository) ReplaceSecrets(id uint, oldPassword, oldPrivateKey, oldPassphrase, password, privateKey, passphrase string) (bool, error) {
+	result := r.db.Unscoped().Model(&models.SSHConnection{}).
+		Where("id = ? AND password = ? AND private_key = ? AND passphrase = ?", id, oldPassword, oldPrivateKey, oldPassphrase).
+		UpdateColumns(map[string]interface{}{"password": password, "private_key": privateKey, "passphrase": passphrase})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *sshRepository) accessibleBy(userID uint) *gorm.DB {
+	return accessibleConnections(r.db, userID)
+}
+
+// accessibleConnections scopes a query to the connections userID may see
+func accessibleConnections(db *gorm.DB, userID uint) *gorm.DB {
+	teams := db.Model(&models.TeamMember{}).Select("team_id").Where("user_id = ?", userID)
+	return db.Where("(user_id = ? AND team_id IS NULL) OR team_id IN (?)", userID, teams)
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// TeamRepository defines the interface for team and membership data access
+type TeamRepository interface {
+	// Create stores the team together with its first owner
+	Create(team *models.Team, ownerID uint) error
+	ListByUserID(userID uint) ([]models.Team, error)
+	GetByID(id uint) (*models.Team, error)
+	Update(team *models.Team) error
+	// Delete removes the team and all of its memberships
+	Delete(id uint) error
+	GetMember(teamID, userID uint) (*models.TeamMember, error)
+	ListMembers(teamID uint) ([]models.TeamMember, error)
+	SaveMember(member *models.TeamMember) error
+	DeleteMember(teamID, userID uint) error
+	CountOwners(teamID uint) (int64, error)
+	CountConnections(teamID uint) (int64, error)
+}
+
+// teamRepository implements TeamRepository using GORM
+type teamRepository struct {
+	db *gorm.DB
+}
+
+// NewTeamRepository creates a new TeamRepository instance
+func NewTeamRepository(db *gorm.DB) TeamRepository {
+	return &teamRepository{db: db}
+}
+
+func (r *teamRepository) Create(team *models.Team, ownerID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(team).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.TeamMember{
+			TeamID: team.ID,
+			UserID: ownerID,
+			Role:   models.TeamRoleOwner,
+		}).Error
+	})
+}
+
+func (r *teamRepository) ListByUserID(userID uint) ([]models.Team, error) {
+	var teams []models.Team
+	err := r.db.Where("id IN (?)", r.db.Model(&models.TeamMember{}).Select("team_id").Where("user_id = ?", userID)).
+		Order("name").Find(&teams).Error
+	return teams, err
+}
+
+func (r *teamRepository) GetByID(id uint) (*models.Team, error) {
+	var team models.Team
+	err := r.db.First(&team, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+func (r *teamRepository) Update(team *models.Team) error {
+	return r.db.Save(team).Error
+}
+
+func (r *teamRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", id).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.Team{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (r *teamRepository) GetMember(teamID, userID uint) (*models.TeamMember, error) {
+	var member models.TeamMember
+	err := r.db.Where("team_id = ? AND user_id = ?", teamID, userID).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (r *teamRepository) ListMembers(teamID uint) ([]models.TeamMember, error) {
+	var members []models.TeamMember
+	err := r.db.Where("team_id = ?", teamID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+func (r *teamRepository) SaveMember(member *models.TeamMember) error {
+	return r.db.Save(member).Error
+}
+
+func (r *teamRepository) DeleteMember(teamID, userID uint) error {
+	result := r.db.Where("team_id = ? AND user_id = ?", teamID, userID).Delete(&models.TeamMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *teamRepository) CountOwners(teamID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.TeamMember{}).Where("team_id = ? AND role = ?", teamID, models.TeamRoleOwner).Count(&count).Error
+	return count, err
+}
+
+func (r *teamRepository) CountConnections(teamID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.SSHConnection{}).Where("team_id = ?", teamID).Count(&count).Error
+	return count, err
+}
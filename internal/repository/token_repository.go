@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// TokenRepository defines the interface for refresh token and revocation data access
+type TokenRepository interface {
+	CreateRefreshToken(token *models.RefreshToken) error
+	GetRefreshTokenByHash(hash string) (*models.RefreshToken, error)
+	// MarkUsed flags the token as used and reports false if another request
+	// got there first
+	MarkUsed(id uint, at time.Time) (bool, error)
+	RevokeFamily(familyID string, at time.Time) error
+	// RevokeUserFamilies revokes every live refresh family of the user but
+	// keepFamilyID, which may be empty, and returns their IDs
+	RevokeUserFamilies(userID uint, keepFamilyID string, at time.Time) ([]string, error)
+	// Revoke adds an access token ID or family ID to the revocation list
+	Revoke(id string, expiresAt time.Time) error
+	IsRevoked(ids ...string) (bool, error)
+	DeleteExpired(now time.Time) error
+}
+
+// tokenRepository implements TokenRepository using GORM
+type tokenRepository struct {
+	db *gorm.DB
+}
+
+// NewTokenRepository creates a new TokenRepository instance
+func NewTokenRepository(db *gorm.DB) TokenRepository {
+	return &tokenRepository{db: db}
+}
+
+func (r *tokenRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *tokenRepository) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *tokenRepository) MarkUsed(id uint, at time.Time) (bool, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *tokenRepository) RevokeFamily(familyID string, at time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error
+}
+
+func (r *tokenRepository) RevokeUserFamilies(userID uint, keepFamilyID string, at time.Time) ([]string, error) {
+	var families []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		live := func() *gorm.DB {
+			return tx.Model(&models.RefreshToken{}).
+				Where("user_id = ? AND revoked_at IS NULL AND family_id <> ?", userID, keepFamilyID)
+		}
+		if err := live().Distinct().Pluck("family_id", &families).Error; err != nil {
+			return err
+		}
+		return live().Update("revoked_at", at).Error
+	})
+	return families, err
+}
+
+func (r *tokenRepository) Revoke(id string, expiresAt time.Time) error {
+	return r.db.Save(&models.RevokedToken{ID: id, ExpiresAt: expiresAt}).Error
+}
+
+func (r *tokenRepository) IsRevoked(ids ...string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.RevokedToken{}).Where("id IN ?", ids).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *tokenRepository) DeleteExpired(now time.Time) error {
+	if err := r.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error
+}
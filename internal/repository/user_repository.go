@@ -11,8 +11,21 @@ type UserRepository interface {
 	Create(user *models.User) error
 	FindByEmail(email string) (*models.User, error)
 	FindByID(id uint) (*models.User, error)
-	FindByGoogleID(googleID string) (*models.User, error)
 	Update(user *models.User) error
+	// AdvanceTOTPStep records step as the last accepted TOTP time step,
+	// reporting false if it is not newer than the one already stored
+	AdvanceTOTPStep(id uint, step int64) (bool, error)
+	// ListTOTPSecretsAfter returns up to limit users with a TOTP secret and
+	// an ID above afterID, in ID order
+	ListTOTPSecretsAfter(afterID uint, limit int) ([]models.User, error)
+	// ReplaceTOTPSecret swaps the stored TOTP secret, reporting false if it
+	// has changed since it was read
+	ReplaceTOTPSecret(id uint, oldSecret, secret string) (bool, error)
+	// DeleteWithData permanently removes the user with their personal
+	// connections, SSH identities, recordings, credentials and team
+	// memberships. Team connections they created pass to another owner of
+	// the team. Audit events are kept.
+	DeleteWithData(id uint) error
 }
 
 // userRepository implements UserRepository using GORM
@@ -47,15 +60,75 @@ func (r *userRepository) FindByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
-func (r *userRepository) FindByGoogleID(googleID string) (*models.User, error) {
-	var user models.User
-	err := r.db.Where("google_id = ?", googleID).First(&user).Error
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
 func (r *userRepository) Update(user *models.User) error {
 	return r.db.Save(user).Error
 }
+
+func (r *userRepository) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *userRepository) ListTOTPSecretsAfter(afterID uint, limit int) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Unscoped().Where("id > ? AND totp_secret <> ''", afterID).Order("id").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+func (r *userRepository) ReplaceTOTPSecret(id uint, oldSecret, secret string) (bool, error) {
+	result := r.db.Unscoped().Model(&models.User{}).
+		Where("id = ? AND totp_secret = ?", id, oldSecret).
+		UpdateColumn("totp_secret", secret)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *userRepository) DeleteWithData(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Team connections stay with the team, under its longest-standing
+		// other owner
+		owner := tx.Model(&models.TeamMember{}).Select("team_members.user_id").
+			Where("team_members.team_id = ssh_connections.team_id AND team_members.role = ? AND team_members.user_id <> ?", models.TeamRoleOwner, id).
+			Order("team_members.id").Limit(1)
+		err := tx.Unscoped().Model(&models.SSHConnection{}).
+			Where("user_id = ? AND team_id IS NOT NULL AND EXISTS (?)", id, owner).
+			UpdateColumn("user_id", owner).Error
+		if err != nil {
+			return err
+		}
+
+		var connIDs []uint
+		err = tx.Unscoped().Model(&models.SSHConnection{}).Where("user_id = ?", id).Pluck("id", &connIDs).Error
+		if err != nil {
+			return err
+		}
+		if len(connIDs) > 0 {
+			if err := tx.Where("connection_id IN ?", connIDs).Delete(&models.KnownHost{}).Error; err != nil {
+				return err
+			}
+		}
+
+		// Connections of other users stop using the identities going away
+		identities := tx.Model(&models.SSHIdentity{}).Select("id").Where("user_id = ?", id)
+		err = tx.Unscoped().Model(&models.SSHConnection{}).Where("identity_id IN (?)", identities).
+			UpdateColumn("identity_id", nil).Error
+		if err != nil {
+			return err
+		}
+
+		// Soft deleted connections still hold encrypted credentials
+		owned := []interface{}{
+			&models.SSHConnection{}, &models.SessionRecording{}, &models.TeamMember{},
+			&models.RefreshToken{}, &models.PersonalAccessToken{}, &models.RecoveryCode{},
+			&models.WebAuthnCredential{}, &models.UserIdentity{}, &models.EmailToken{},
+			&models.SSHIdentity{},
+		}
+		for _, model := range owned {
+			if err := tx.Unscoped().Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&models.User{}, id).Error
+	})
+}
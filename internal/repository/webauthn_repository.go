@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthnRepository defines the interface for passkey data access
+type WebAuthnRepository interface {
+	Create(credential *models.WebAuthnCredential) error
+	ListByUserID(userID uint) ([]models.WebAuthnCredential, error)
+	GetByID(id, userID uint) (*models.WebAuthnCredential, error)
+	CountByUserID(userID uint) (int64, error)
+	Update(credential *models.WebAuthnCredential) error
+	Delete(credential *models.WebAuthnCredential) error
+	DeleteByUserID(userID uint) error
+}
+
+// webAuthnRepository implements WebAuthnRepository using GORM
+type webAuthnRepository struct {
+	db *gorm.DB
+}
+
+// NewWebAuthnRepository creates a new WebAuthnRepository instance
+func NewWebAuthnRepository(db *gorm.DB) WebAuthnRepository {
+	return &webAuthnRepository{db: db}
+}
+
+func (r *webAuthnRepository) Create(credential *models.WebAuthnCredential) error {
+	return r.db.Create(credential).Error
+}
+
+func (r *webAuthnRepository) ListByUserID(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error
+	return credentials, err
+}
+
+func (r *webAuthnRepository) GetByID(id, userID uint) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&credential).Error
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (r *webAuthnRepository) CountByUserID(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *webAuthnRepository) Update(credential *models.WebAuthnCredential) error {
+	return r.db.Save(credential).Error
+}
+
+func (r *webAuthnRepository) Delete(credential *models.WebAuthnCredential) error {
+	return r.db.Delete(credential).Error
+}
+
+func (r *webAuthnRepository) DeleteByUserID(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.WebAuthnCredential{}).Error
+}
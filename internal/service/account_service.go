@@ -0,0 +1,331 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+var ErrConfirmationMismatch = errors.New("type your email address to confirm")
+
+// AccountService lets users take their data out, or remove it
+type AccountService interface {
+	// Export returns everything stored about the user except secrets:
+	// connection and identity credentials, password and MFA secrets, and token hashes
+	Export(userID uint, remoteAddr string) (*AccountExport, error)
+	// Delete permanently removes the account with its personal connections
+	// and recordings. Team connections it created pass to another owner of
+	// the team. Users with a password confirm with it. Others type their
+	// email address and either enter a two-factor code or have signed in
+	// within the last few minutes. The last owner of a team can't leave it
+	// ownerless, and connections the user can see that outlive the account
+	// must first stop logging in with the user's SSH identities.
+	Delete(claims *models.AccessClaims, password, code, confirmEmail, remoteAddr string) error
+}
+
+// AccountExport is the download a user gets of their data
+type AccountExport struct {
+	ExportedAt    time.Time                    `json:"exported_at"`
+	User          *models.User                 `json:"user"`
+	Connections   []models.SSHConnection       `json:"connections"`
+	SSHIdentities []models.SSHIdentity         `json:"ssh_identities"`
+	KnownHosts    []models.KnownHost           `json:"known_hosts"`
+	Teams         []ExportedTeam               `json:"teams"`
+	Identities    []models.UserIdentity        `json:"identities"`
+	Passkeys      []models.WebAuthnCredential  `json:"passkeys"`
+	AccessTokens  []models.PersonalAccessToken `json:"access_tokens"`
+	Recordings    []models.SessionRecording    `json:"recordings"` // Metadata only
+	AuditEvents   []models.AuditEvent          `json:"audit_events"`
+}
+
+// ExportedTeam is a team the user belongs to and their role in it
+type ExportedTeam struct {
+	ID     uint   `json:"id"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Source string `json:"source,omitempty"`
+}
+
+type accountService struct {
+	users         repository.UserRepository
+	connections   repository.SSHRepository
+	sshIdentities repository.SSHIdentityRepository
+	knownHosts    repository.KnownHostRepository
+	recordings    repository.RecordingRepository
+	teams         repository.TeamRepository
+	identities    repository.IdentityRepository
+	passkeys      repository.WebAuthnRepository
+	accessTokens  repository.AccessTokenRepository
+	auditEvents   repository.AuditRepository
+	secrets       SecretStore
+	auth          AuthService
+	terminal      TerminalService
+	audit         AuditService
+}
+
+func NewAccountService(users repository.UserRepository, connections repository.SSHRepository, sshIdentities repository.SSHIdentityRepository, knownHosts repository.KnownHostRepository, recordings repository.RecordingRepository, teams repository.TeamRepository, identities repository.IdentityRepository, passkeys repository.WebAuthnRepository, accessTokens repository.AccessTokenRepository, auditEvents repository.AuditRepository, secrets SecretStore, auth AuthService, terminal TerminalService, audit AuditService) AccountService {
+	return &accountService{
+		users:         users,
+		connections:   connections,
+		sshIdentities: sshIdentities,
+		knownHosts:    knownHosts,
+		recordings:    recordings,
+		teams:         teams,
+		identities:    identities,
+		passkeys:      passkeys,
+		accessTokens:  accessTokens,
+		auditEvents:   auditEvents,
+		secrets:       secrets,
+		auth:          auth,
+		terminal:      terminal,
+		audit:         audit,
+	}
+}
+
+func (s *accountService) Export(userID uint, remoteAddr string) (*AccountExport, error) {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	export := &AccountExport{
+		ExportedAt:  time.Now().UTC(),
+		User:        user,
+		Connections: []models.SSHConnection{},
+		KnownHosts:  []models.KnownHost{},
+		Teams:       []ExportedTeam{},
+		AuditEvents: []models.AuditEvent{},
+	}
+
+	// Team connections shared by others belong to their owners
+	connections, err := s.connections.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, conn := range connections {
+		if conn.UserID != userID || conn.TeamID != nil {
+			continue
+		}
+		export.Connections = append(export.Connections, conn)
+
+		host, err := s.knownHosts.GetByConnectionID(conn.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		export.KnownHosts = append(export.KnownHosts, *host)
+	}
+
+	teams, err := s.teams.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, team := range teams {
+		member, err := s.teams.GetMember(team.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		export.Teams = append(export.Teams, ExportedTeam{
+			ID:     team.ID,
+			Name:   team.Name,
+			Role:   member.Role,
+			Source: member.Source,
+		})
+	}
+
+	if export.SSHIdentities, err = s.sshIdentities.ListByUserID(userID); err != nil {
+		return nil, err
+	}
+	if export.Identities, err = s.identities.ListByUserID(userID); err != nil {
+		return nil, err
+	}
+	if export.Passkeys, err = s.passkeys.ListByUserID(userID); err != nil {
+		return nil, err
+	}
+	if export.AccessTokens, err = s.accessTokens.ListByUserID(userID); err != nil {
+		return nil, err
+	}
+	if export.Recordings, err = s.recordings.ListByUserID(userID, 0); err != nil {
+		return nil, err
+	}
+	err = s.auditEvents.Each(repository.AuditFilter{UserID: userID}, func(events []models.AuditEvent) error {
+		export.AuditEvents = append(export.AuditEvents, events...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Actor:      user.Email,
+		Action:     AuditAccountExport,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+	})
+	return export, nil
+}
+
+func (s *accountService) Delete(claims *models.AccessClaims, password, code, confirmEmail, remoteAddr string) error {
+	userID := claims.UserID
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Password != "" {
+		if err := s.auth.Reauthenticate(userID, password, remoteAddr); err != nil {
+			return err
+		}
+	} else {
+		if !strings.EqualFold(strings.TrimSpace(confirmEmail), user.Email) {
+			return ErrConfirmationMismatch
+		}
+		if err := s.auth.ConfirmRecentSignIn(claims, code, remoteAddr); err != nil {
+			return err
+		}
+	}
+
+	teams, err := s.teams.ListByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, team := range teams {
+		member, err := s.teams.GetMember(team.ID, userID)
+		if err != nil {
+			return err
+		}
+		if member.Role != models.TeamRoleOwner {
+			continue
+		}
+		owners, err := s.teams.CountOwners(team.ID)
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return fmt.Errorf("%w: hand over or delete %q first", ErrLastTeamOwner, team.Name)
+		}
+	}
+
+	sshIdentities, err := s.sshIdentities.ListByUserID(userID)
+	if err != nil {
+		return err
+	}
+	detached, err := s.identityDependents(userID, sshIdentities)
+	if err != nil {
+		return err
+	}
+
+	// Sign out first so no new sessions start while the data goes
+	if err := s.auth.RevokeSessions(userID, ""); err != nil {
+		return err
+	}
+	for _, session := range s.terminal.ListSessions(userID) {
+		// Closing finalizes the recording before its row is deleted
+		if err := s.terminal.TerminateSession(session.ID, userID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	}
+
+	recordings, err := s.recordings.ListByUserID(userID, 0)
+	if err != nil {
+		return err
+	}
+	connections, err := s.connections.ListByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if err := s.users.DeleteWithData(userID); err != nil {
+		return err
+	}
+	for _, rec := range recordings {
+		if err := os.Remove(rec.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("AccountService: Failed to remove recording %d of deleted user %d: %v", rec.ID, userID, err)
+		}
+	}
+	// Credentials kept outside the database don't go with the rows
+	for _, conn := range connections {
+		if conn.UserID != userID || conn.TeamID != nil {
+			continue
+		}
+		for _, ref := range []string{conn.Password, conn.PrivateKey, conn.Passphrase} {
+			if ref == "" {
+				continue
+			}
+			if err := s.secrets.Delete(ref); err != nil {
+				log.Printf("AccountService: Failed to delete a secret of connection %d: %v", conn.ID, err)
+			}
+		}
+	}
+	for _, identity := range sshIdentities {
+		deleteSecrets(s.secrets, identity.Password, identity.PrivateKey, identity.Passphrase)
+	}
+	for _, conn := range detached {
+		s.audit.Record(models.AuditEvent{
+			UserID:     userID,
+			Actor:      user.Email,
+			Action:     AuditConnectionUpdate,
+			TargetType: AuditTargetConnection,
+			TargetID:   conn.ID,
+			RemoteAddr: remoteAddr,
+			Details: map[string]interface{}{
+				"changed": []string{"identity_id"},
+				"reason":  "the identity's owner deleted their account",
+			},
+		})
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Actor:      user.Email,
+		Action:     AuditAccountDelete,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"recordings": len(recordings)},
+	})
+	return nil
+}
+
+// identityDependents finds the connections outliving the account that log
+// in with one of its identities: those of other users, and team connections
+// the user created, which pass to another owner. Those the user can see must
+// be switched to other credentials first. The rest already stopped using
+// the identity when the user lost access to them, and are returned so their
+// detachment can be audited.
+func (s *accountService) identityDependents(userID uint, identities []models.SSHIdentity) ([]models.SSHConnection, error) {
+	var names []string
+	var hidden []models.SSHConnection
+	for _, identity := range identities {
+		connections, err := s.sshIdentities.ListConnections(identity.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, conn := range connections {
+			if conn.UserID == userID && conn.TeamID == nil {
+				continue
+			}
+			if _, err := s.connections.GetByID(conn.ID, userID); err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, err
+				}
+				hidden = append(hidden, conn)
+				continue
+			}
+			names = append(names, fmt.Sprintf("%q", conn.Name))
+		}
+	}
+	if len(names) > 0 {
+		return nil, fmt.Errorf("%w: switch %s to other credentials first", ErrSSHIdentityInUse, strings.Join(names, ", "))
+	}
+	return hidden, nil
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+)
+
+type testAccounts struct {
+	*testSSHServices
+	accounts AccountService
+}
+
+func newTestAccountService(t *testing.T) *testAccounts {
+	t.Helper()
+	auth, db := newTestAuthService(t, newTestConfig())
+	svc := newTestSSHServices(t, db)
+	users := repository.NewUserRepository(db)
+	accounts := NewAccountService(users, repository.NewSSHRepository(db), repository.NewSSHIdentityRepository(db),
+		repository.NewKnownHostRepository(db), repository.NewRecordingRepository(db), svc.teams,
+		repository.NewIdentityRepository(db), repository.NewWebAuthnRepository(db),
+		repository.NewAccessTokenRepository(db), repository.NewAuditRepository(db), svc.secrets, auth,
+		&terminalService{sessions: newSessionRegistry()}, auth.audit)
+	return &testAccounts{testSSHServices: svc, accounts: accounts}
+}
+
+func deleteTestAccount(a *testAccounts, user *models.User) error {
+	return a.accounts.Delete(&models.AccessClaims{UserID: user.ID}, "correct horse", "", "", "192.0.2.1")
+}
+
+func TestDeleteAccountChecksPasswordAndTeamOwnership(t *testing.T) {
+	a := newTestAccountService(t)
+	owner := createTestUser(t, a.db, "owner@example.com", "correct horse")
+	createTestTeam(t, a.teams, "ops", owner.ID, nil)
+
+	if err := a.accounts.Delete(&models.AccessClaims{UserID: owner.ID}, "wrong", "", "", ""); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("wrong password: got %v, want ErrWrongPassword", err)
+	}
+	if err := deleteTestAccount(a, owner); !errors.Is(err, ErrLastTeamOwner) {
+		t.Errorf("last owner of a team: got %v, want ErrLastTeamOwner", err)
+	}
+}
+
+func TestDeleteAccountKeepsTeamConnections(t *testing.T) {
+	a := newTestAccountService(t)
+	owner := createTestUser(t, a.db, "owner@example.com", "correct horse")
+	member := createTestUser(t, a.db, "member@example.com", "correct horse")
+	team := createTestTeam(t, a.teams, "ops", owner.ID, map[uint]string{member.ID: models.TeamRoleAdmin})
+
+	personal, err := a.ssh.Create(member.ID, SSHConnectionRequest{Name: "laptop", Host: "192.0.2.1", Username: "me", Password: "mine"})
+	if err != nil {
+		t.Fatalf("Create personal connection: %v", err)
+	}
+	shared, err := a.ssh.Create(member.ID, SSHConnectionRequest{Name: "db", Host: "192.0.2.2", Username: "ops", Password: "shared", TeamID: &team.ID})
+	if err != nil {
+		t.Fatalf("Create team connection: %v", err)
+	}
+
+	if err := deleteTestAccount(a, member); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := a.ssh.Get(personal.ID, owner.ID); err == nil {
+		t.Error("personal connection survived")
+	}
+	conn, err := a.ssh.Get(shared.ID, owner.ID)
+	if err != nil {
+		t.Fatalf("team connection: %v", err)
+	}
+	if conn.UserID != owner.ID {
+		t.Errorf("team connection owned by %d, want the team owner %d", conn.UserID, owner.ID)
+	}
+	if creds, _, err := a.ssh.GetDecryptedCredentials(shared.ID, owner.ID); err != nil || creds.Password != "shared" {
+		t.Errorf("team connection credentials: %v", err)
+	}
+}
+
+func TestDeleteAccountRefusedWhileIdentityIsShared(t *testing.T) {
+	a := newTestAccountService(t)
+	owner := createTestUser(t, a.db, "owner@example.com", "correct horse")
+	member := createTestUser(t, a.db, "member@example.com", "correct horse")
+	team := createTestTeam(t, a.teams, "ops", owner.ID, map[uint]string{member.ID: models.TeamRoleAdmin})
+
+	identity, err := a.identities.Create(member.ID, SSHIdentityRequest{Name: "deploy", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Create identity: %v", err)
+	}
+	if _, err := a.ssh.Create(member.ID, SSHConnectionRequest{Name: "laptop", Host: "192.0.2.1", Username: "me", IdentityID: &identity.ID}); err != nil {
+		t.Fatalf("Create personal connection: %v", err)
+	}
+	shared, err := a.ssh.Create(member.ID, SSHConnectionRequest{Name: "db", Host: "192.0.2.2", Username: "ops", TeamID: &team.ID, IdentityID: &identity.ID})
+	if err != nil {
+		t.Fatalf("Create team connection: %v", err)
+	}
+
+	// The personal connection goes with the account; the team's would be
+	// left without credentials
+	err = deleteTestAccount(a, member)
+	if !errors.Is(err, ErrSSHIdentityInUse) || !strings.Contains(err.Error(), `"db"`) || strings.Contains(err.Error(), `"laptop"`) {
+		t.Fatalf("Delete: got %v, want ErrSSHIdentityInUse naming only the team connection", err)
+	}
+	if _, err := a.identities.Get(identity.ID, member.ID); err != nil {
+		t.Fatalf("identity after refused deletion: %v", err)
+	}
+
+	if _, err := a.ssh.Update(shared.ID, member.ID, SSHConnectionRequest{IdentityID: uintPtr(0), Password: "own"}); err != nil {
+		t.Fatalf("switching the team connection to its own password: %v", err)
+	}
+	if err := deleteTestAccount(a, member); err != nil {
+		t.Fatalf("Delete once the identity is unused: %v", err)
+	}
+	if creds, _, err := a.ssh.GetDecryptedCredentials(shared.ID, owner.ID); err != nil || creds.Password != "own" {
+		t.Errorf("team connection credentials: %v", err)
+	}
+}
+
+func TestDeleteAccountAuditsConnectionsItCantSee(t *testing.T) {
+	a := newTestAccountService(t)
+	owner := createTestUser(t, a.db, "owner@example.com", "correct horse")
+	member := createTestUser(t, a.db, "member@example.com", "correct horse")
+	team := createTestTeam(t, a.teams, "ops", owner.ID, map[uint]string{member.ID: models.TeamRoleAdmin})
+
+	identity, err := a.identities.Create(member.ID, SSHIdentityRequest{Name: "deploy", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Create identity: %v", err)
+	}
+	shared, err := a.ssh.Create(member.ID, SSHConnectionRequest{Name: "db", Host: "192.0.2.2", Username: "ops", TeamID: &team.ID, IdentityID: &identity.ID})
+	if err != nil {
+		t.Fatalf("Create team connection: %v", err)
+	}
+	if err := a.teams.DeleteMember(team.ID, member.ID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+
+	// The identity already stopped working there when the member left
+	if err := deleteTestAccount(a, member); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	conn, err := a.ssh.Get(shared.ID, owner.ID)
+	if err != nil {
+		t.Fatalf("team connection: %v", err)
+	}
+	if conn.IdentityID != nil {
+		t.Errorf("team connection still uses identity %d", *conn.IdentityID)
+	}
+	var events []models.AuditEvent
+	err = a.db.Where("action = ? AND target_type = ? AND target_id = ?", AuditConnectionUpdate, AuditTargetConnection, shared.ID).Find(&events).Error
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(events) != 1 || events[0].UserID != member.ID {
+		t.Errorf("audit events for the detached connection: %+v, want one by the deleted user", events)
+	}
+}
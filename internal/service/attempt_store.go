@@ -0,0 +1,82 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// AttemptRecord counts recent failures for one rate limit key
+type AttemptRecord struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// AttemptStore keeps the failure counts behind login rate limiting. The
+// in-memory store is enough for one instance; deployments running several
+// need a shared one (e.g. Redis) so limits hold across instances.
+type AttemptStore interface {
+	// Get returns the record for key, or a zero record if there is none
+	Get(key string, now time.Time) (AttemptRecord, error)
+	// AddFailure atomically counts a failure and returns the updated record.
+	// A record is forgotten once ttl passes without a new failure.
+	AddFailure(key string, now time.Time, ttl time.Duration) (AttemptRecord, error)
+	Reset(key string) error
+}
+
+type memoryAttempt struct {
+	record    AttemptRecord
+	expiresAt time.Time
+}
+
+type memoryAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]memoryAttempt
+	lastSweep time.Time
+}
+
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{attempts: make(map[string]memoryAttempt)}
+}
+
+func (s *memoryAttemptStore) Get(key string, now time.Time) (AttemptRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok || now.After(attempt.expiresAt) {
+		return AttemptRecord{}, nil
+	}
+	return attempt.record, nil
+}
+
+func (s *memoryAttemptStore) AddFailure(key string, now time.Time, ttl time.Duration) (AttemptRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired records now and then so idle keys don't pile up
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, a := range s.attempts {
+			if now.After(a.expiresAt) {
+				delete(s.attempts, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	attempt, ok := s.attempts[key]
+	if !ok || now.After(attempt.expiresAt) {
+		attempt = memoryAttempt{}
+	}
+	attempt.record.Failures++
+	attempt.record.LastFailure = now
+	attempt.expiresAt = now.Add(ttl)
+	s.attempts[key] = attempt
+	return attempt.record, nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	delete(s.attempts, key)
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+)
+
+// Audited actions
+const (
+	AuditLogin                = "auth.login"
+	AuditLoginFailed          = "auth.login_failed"
+	AuditAccountLocked        = "auth.account_locked"
+	AuditPasswordResetRequest = "auth.password_reset_request"
+	AuditPasswordReset        = "auth.password_reset"
+	AuditPasswordChange       = "auth.password_change"
+	AuditEmailVerify          = "auth.email_verify"
+	AuditRegister             = "auth.register"
+	AuditOAuthLink            = "auth.oauth_link"
+	AuditOAuthUnlink          = "auth.oauth_unlink"
+	AuditAccountExport        = "auth.account_export"
+	AuditAccountDelete        = "auth.account_delete"
+	AuditLogout               = "auth.logout"
+	AuditTokenReuse           = "auth.refresh_token_reuse"
+	AuditMFAEnable            = "auth.mfa_enable"
+	AuditMFADisable           = "auth.mfa_disable"
+	AuditMFAFailed            = "auth.mfa_failed"
+	AuditMFAPolicy            = "auth.mfa_policy"
+	AuditRecoveryCodes        = "auth.recovery_codes_regenerate"
+	AuditPasskeyAdd           = "auth.passkey_add"
+	AuditPasskeyRemove        = "auth.passkey_remove"
+	AuditAccessTokenCreate    = "auth.access_token_create"
+	AuditAccessTokenRevoke    = "auth.access_token_revoke"
+	AuditConnectionCreate     = "connection.create"
+	AuditConnectionUpdate     = "connection.update"
+	AuditConnectionDelete     = "connection.delete"
+	AuditCredentialAccess     = "connection.credential_access"
+	AuditSSHIdentityCreate    = "ssh_identity.create"
+	AuditSSHIdentityUpdate    = "ssh_identity.update"
+	AuditSSHIdentityDelete    = "ssh_identity.delete"
+	AuditSSHCertIssue         = "ssh_ca.certificate_issue"
+	AuditSSHPrincipals        = "ssh_ca.principals"
+	AuditTerminalStart        = "terminal.start"
+	AuditTerminalJoin         = "terminal.join"
+	AuditTerminalStop         = "terminal.stop"
+)
+
+// Audit target types
+const (
+	AuditTargetUser        = "user"
+	AuditTargetConnection  = "connection"
+	AuditTargetSSHIdentity = "ssh_identity"
+	AuditTargetTeam        = "team"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+var ErrAuditAdminOnly = errors.New("only administrators can read the full audit log")
+
+type AuditService interface {
+	// Record appends an event to the log. Failures are logged rather than
+	// returned so that auditing never breaks the action being audited.
+	Record(event models.AuditEvent)
+	// List and Export return the user's own events, or all events for an
+	// administrator
+	List(userID uint, filter repository.AuditFilter) ([]models.AuditEvent, error)
+	Export(userID uint, filter repository.AuditFilter, w io.Writer) error
+	// Verify walks the whole chain and reports the first broken entry
+	Verify(userID uint) (*AuditVerification, error)
+}
+
+type auditService struct {
+	mu       sync.Mutex // Queues appends in-process instead of contending for the write lock
+	repo     repository.AuditRepository
+	userRepo repository.UserRepository
+	cfg      *config.Config
+}
+
+func NewAuditService(repo repository.AuditRepository, userRepo repository.UserRepository, cfg *config.Config) AuditService {
+	return &auditService{
+		repo:     repo,
+		userRepo: userRepo,
+		cfg:      cfg,
+	}
+}
+
+// AuditVerification is the result of checking the hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt uint   `json:"broken_at,omitempty"` // ID of the first entry that doesn't match
+	Reason   string `json:"reason,omitempty"`
+}
+
+func (s *auditService) Record(event models.AuditEvent) {
+	// Stored timestamps must round-trip exactly for the hash to verify
+	event.Timestamp = time.Now().UTC().Truncate(time.Microsecond)
+	if event.Actor == "" && event.UserID != 0 {
+		if user, err := s.userRepo.FindByID(event.UserID); err == nil {
+			event.Actor = user.Email
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.repo.Append(&event, func(event, prev *models.AuditEvent) error {
+		event.PrevHash = ""
+		if prev != nil {
+			event.PrevHash = prev.Hash
+		}
+		hash, err := auditHash(event)
+		event.Hash = hash
+		return err
+	})
+	if err != nil {
+		log.Printf("AuditService: Failed to record %s: %v", event.Action, err)
+	}
+}
+
+func (s *auditService) List(userID uint, filter repository.AuditFilter) ([]models.AuditEvent, error) {
+	filter, err := s.scope(userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditPageSize
+	}
+	if filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+	return s.repo.List(filter)
+}
+
+// Export writes matching events as JSON Lines, oldest first
+func (s *auditService) Export(userID uint, filter repository.AuditFilter, w io.Writer) error {
+	filter, err := s.scope(userID, filter)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	return s.repo.Each(filter, func(events []models.AuditEvent) error {
+		for i := range events {
+			if err := encoder.Encode(&events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *auditService) Verify(userID uint) (*AuditVerification, error) {
+	if !s.isAdmin(userID) {
+		return nil, ErrAuditAdminOnly
+	}
+
+	result := &AuditVerification{Valid: true}
+	prev := ""
+	// errChainBroken stops the walk at the first bad entry
+	errChainBroken := errors.New("chain broken")
+	err := s.repo.Each(repository.AuditFilter{}, func(events []models.AuditEvent) error {
+		for i := range events {
+			event := &events[i]
+			result.Checked++
+
+			if event.PrevHash != prev {
+				result.Valid, result.BrokenAt = false, event.ID
+				result.Reason = "previous hash does not match the preceding entry"
+				return errChainBroken
+			}
+			hash, err := auditHash(event)
+			if err != nil {
+				return err
+			}
+			if hash != event.Hash {
+				result.Valid, result.BrokenAt = false, event.ID
+				result.Reason = "entry contents do not match its hash"
+				return errChainBroken
+			}
+			prev = event.Hash
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errChainBroken) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scope limits non-administrators to their own events
+func (s *auditService) scope(userID uint, filter repository.AuditFilter) (repository.AuditFilter, error) {
+	if s.isAdmin(userID) {
+		return filter, nil
+	}
+	if filter.UserID != 0 && filter.UserID != userID {
+		return filter, ErrAuditAdminOnly
+	}
+	filter.UserID = userID
+	return filter, nil
+}
+
+func (s *auditService) isAdmin(userID uint) bool {
+	_, err := requireAdmin(s.userRepo, s.cfg, userID)
+	return err == nil
+}
+
+// auditHash hashes everything about an event except its ID and own hash,
+// chained to the previous entry through PrevHash
+func auditHash(event *models.AuditEvent) (string, error) {
+	payload, err := json.Marshal(struct {
+		Timestamp  string                 `json:"timestamp"`
+		UserID     uint                   `json:"user_id"`
+		Actor      string                 `json:"actor"`
+		Action     string                 `json:"action"`
+		TargetType string                 `json:"target_type"`
+		TargetID   uint                   `json:"target_id"`
+		RemoteAddr string                 `json:"remote_addr"`
+		Details    map[string]interface{} `json:"details"`
+		PrevHash   string                 `json:"prev_hash"`
+	}{
+		Timestamp:  event.Timestamp.UTC().Format(time.RFC3339Nano),
+		UserID:     event.UserID,
+		Actor:      event.Actor,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		RemoteAddr: event.RemoteAddr,
+		Details:    event.Details,
+		PrevHash:   event.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+func newTestAuditService(t *testing.T, cfg *config.Config) (AuditService, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	return NewAuditService(repository.NewAuditRepository(db), repository.NewUserRepository(db), cfg), db
+}
+
+// recordTestEvents appends a few events whose details go through a JSON
+// round-trip in the database
+func recordTestEvents(audit AuditService, userID uint) {
+	audit.Record(models.AuditEvent{UserID: userID, Action: AuditLogin, TargetType: AuditTargetUser, TargetID: userID, RemoteAddr: "192.0.2.1"})
+	audit.Record(models.AuditEvent{UserID: 0, Actor: "mallory@example.com", Action: AuditLoginFailed, Details: map[string]interface{}{"reason": "unknown email"}})
+	audit.Record(models.AuditEvent{UserID: userID, Action: AuditConnectionCreate, TargetType: AuditTargetConnection, TargetID: 7, Details: map[string]interface{}{
+		"port":    22,
+		"ratio":   0.5,
+		"changed": []string{"host", "port"},
+		"nested":  map[string]interface{}{"ok": true},
+	}})
+}
+
+func TestAuditChainVerifiesAfterRoundTrip(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.AdminEmails = []string{"admin@example.com"}
+	audit, db := newTestAuditService(t, cfg)
+	admin := createTestUser(t, db, "admin@example.com", "correct horse")
+	recordTestEvents(audit, admin.ID)
+
+	result, err := audit.Verify(admin.ID)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !result.Valid || result.Checked != 3 {
+		t.Errorf("Verify = %+v, want 3 valid entries", result)
+	}
+}
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.AdminEmails = []string{"admin@example.com"}
+
+	tests := []struct {
+		name   string
+		tamper func(db *gorm.DB, events []models.AuditEvent) uint // Returns where the chain breaks
+	}{
+		{"edited details", func(db *gorm.DB, events []models.AuditEvent) uint {
+			db.Exec("UPDATE audit_events SET details = ? WHERE id = ?", `{"reason":"wrong password"}`, events[1].ID)
+			return events[1].ID
+		}},
+		{"edited actor", func(db *gorm.DB, events []models.AuditEvent) uint {
+			db.Model(&events[0]).Update("actor", "someone-else@example.com")
+			return events[0].ID
+		}},
+		{"deleted entry", func(db *gorm.DB, events []models.AuditEvent) uint {
+			db.Delete(&events[1])
+			return events[2].ID
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit, db := newTestAuditService(t, cfg)
+			admin := createTestUser(t, db, "admin@example.com", "correct horse")
+			recordTestEvents(audit, admin.ID)
+
+			var events []models.AuditEvent
+			db.Order("id").Find(&events)
+			brokenAt := tt.tamper(db, events)
+
+			result, err := audit.Verify(admin.ID)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if result.Valid || result.BrokenAt != brokenAt {
+				t.Errorf("Verify = %+v, want broken at %d", result, brokenAt)
+			}
+		})
+	}
+}
+
+func TestAuditVerifyNeedsVerifiedAdmin(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.AdminEmails = []string{"admin@example.com", "unclaimed@example.com"}
+	audit, db := newTestAuditService(t, cfg)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	// Registering an admin address isn't the same as owning it
+	squatter := &models.User{Email: "unclaimed@example.com", Name: "squatter"}
+	if err := db.Create(squatter).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	for _, userID := range []uint{user.ID, squatter.ID} {
+		if _, err := audit.Verify(userID); !errors.Is(err, ErrAuditAdminOnly) {
+			t.Errorf("Verify by user %d: got %v, want ErrAuditAdminOnly", userID, err)
+		}
+		if _, err := audit.List(userID, repository.AuditFilter{UserID: 9999}); !errors.Is(err, ErrAuditAdminOnly) {
+			t.Errorf("List of another user's events by user %d: got %v, want ErrAuditAdminOnly", userID, err)
+		}
+	}
+}
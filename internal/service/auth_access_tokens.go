@@ -0,0 +1,165 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AccessTokenPrefix starts every personal access token, so they can be told
+// apart from session JWTs and spotted by secret scanners
+const AccessTokenPrefix = "sshpat_"
+
+// Scopes a personal access token can be granted
+const (
+	ScopeConnectionsRead  = "connections:read"  // List and view connections and host keys
+	ScopeConnectionsWrite = "connections:write" // Create, change and delete connections
+	ScopeSessionsOpen     = "sessions:open"     // Open terminals and SFTP sessions
+)
+
+var accessTokenScopes = []string{ScopeConnectionsRead, ScopeConnectionsWrite, ScopeSessionsOpen}
+
+// Recording every use would write to the database on each API call
+const accessTokenTouchInterval = time.Minute
+
+var (
+	ErrAccessTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidScope        = errors.New("invalid scope")
+	ErrInvalidExpiry       = errors.New("expiry must be in the future")
+	ErrExpiryTooLong       = errors.New("expiry is later than the longest token lifetime allowed")
+)
+
+// NewAccessToken is returned once, when the token is created; the secret
+// cannot be shown again
+type NewAccessToken struct {
+	*models.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+func (s *authService) CreateAccessToken(userID uint, name string, scopes []string, expiresAt *time.Time, remoteAddr string) (*NewAccessToken, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	now := time.Now()
+	if expiresAt == nil {
+		defaultExpiry := now.Add(s.cfg.PATDefaultTTL)
+		expiresAt = &defaultExpiry
+	}
+	if !expiresAt.After(now) {
+		return nil, ErrInvalidExpiry
+	}
+	if expiresAt.After(now.Add(s.cfg.PATMaxTTL)) {
+		return nil, fmt.Errorf("%w (%s)", ErrExpiryTooLong, s.cfg.PATMaxTTL)
+	}
+
+	secret, err := newToken(32)
+	if err != nil {
+		return nil, err
+	}
+	token := AccessTokenPrefix + secret
+
+	stored := &models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Prefix:    token[:len(AccessTokenPrefix)+8],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.accessTokens.Create(stored); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditAccessTokenCreate,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"token_id": stored.ID, "name": name, "scopes": scopes},
+	})
+	return &NewAccessToken{PersonalAccessToken: stored, Token: token}, nil
+}
+
+func (s *authService) ListAccessTokens(userID uint) ([]models.PersonalAccessToken, error) {
+	return s.accessTokens.ListByUserID(userID)
+}
+
+func (s *authService) RevokeAccessToken(userID, id uint, remoteAddr string) error {
+	err := s.accessTokens.Revoke(id, userID, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAccessTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditAccessTokenRevoke,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"token_id": id},
+	})
+	return nil
+}
+
+// validateAccessTokenSecret authenticates a personal access token
+func (s *authService) validateAccessTokenSecret(token string) (*models.AccessClaims, error) {
+	stored, err := s.accessTokens.GetByHash(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens from before expiry was enforced last the longest lifetime allowed
+	expiresAt := stored.CreatedAt.Add(s.cfg.PATMaxTTL)
+	if stored.ExpiresAt != nil {
+		expiresAt = *stored.ExpiresAt
+	}
+	now := time.Now()
+	if now.After(expiresAt) {
+		return nil, ErrInvalidToken
+	}
+	if err := s.accessTokens.Touch(stored.ID, now, accessTokenTouchInterval); err != nil {
+		return nil, err
+	}
+
+	claims := &models.AccessClaims{
+		UserID:    stored.UserID,
+		TokenID:   fmt.Sprintf("pat-%d", stored.ID),
+		ExpiresAt: expiresAt,
+		Scopes:    stored.Scopes,
+	}
+	if claims.Scopes == nil {
+		// A nil list would read as a session token with every scope
+		claims.Scopes = []string{}
+	}
+	return claims, nil
+}
+
+func isAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+func validScope(scope string) bool {
+	for _, s := range accessTokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
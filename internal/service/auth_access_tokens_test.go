@@ -0,0 +1,65 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenCarriesOnlyItsScopes(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	created, err := auth.CreateAccessToken(user.ID, "ci", []string{ScopeConnectionsRead}, nil, "")
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	claims, err := auth.ValidateAccessToken(created.Token)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if claims.UserID != user.ID || !claims.HasScope(ScopeConnectionsRead) {
+		t.Errorf("claims %+v, want user %d with %s", claims, user.ID, ScopeConnectionsRead)
+	}
+	for _, scope := range []string{ScopeConnectionsWrite, ScopeSessionsOpen, ""} {
+		if claims.HasScope(scope) {
+			t.Errorf("token with only %s also has %q", ScopeConnectionsRead, scope)
+		}
+	}
+
+	if err := auth.RevokeAccessToken(user.ID, created.ID, ""); err != nil {
+		t.Fatalf("RevokeAccessToken: %v", err)
+	}
+	if _, err := auth.ValidateAccessToken(created.Token); err == nil {
+		t.Error("revoked token still validates")
+	}
+}
+
+func TestAccessTokenScopesAndExpiryAreChecked(t *testing.T) {
+	cfg := newTestConfig()
+	auth, db := newTestAuthService(t, cfg)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	for _, scopes := range [][]string{nil, {"admin"}, {ScopeConnectionsRead, "connections:*"}} {
+		if _, err := auth.CreateAccessToken(user.ID, "ci", scopes, nil, ""); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("scopes %q: got %v, want ErrInvalidScope", scopes, err)
+		}
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := auth.CreateAccessToken(user.ID, "ci", []string{ScopeSessionsOpen}, &past, ""); !errors.Is(err, ErrInvalidExpiry) {
+		t.Errorf("expiry in the past: got %v, want ErrInvalidExpiry", err)
+	}
+	tooLate := time.Now().Add(cfg.PATMaxTTL + time.Hour)
+	if _, err := auth.CreateAccessToken(user.ID, "ci", []string{ScopeSessionsOpen}, &tooLate, ""); !errors.Is(err, ErrExpiryTooLong) {
+		t.Errorf("expiry past the maximum: got %v, want ErrExpiryTooLong", err)
+	}
+
+	created, err := auth.CreateAccessToken(user.ID, "ci", []string{ScopeSessionsOpen}, nil, "")
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+	if created.ExpiresAt == nil || created.ExpiresAt.After(time.Now().Add(cfg.PATDefaultTTL)) {
+		t.Errorf("expiry %v, want the default of %s", created.ExpiresAt, cfg.PATDefaultTTL)
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// directoryLogin checks a password with the directory, creating the account
+// on first login and bringing team memberships in line with the user's groups
+func (s *authService) directoryLogin(username, password, remoteAddr string) (*models.User, error) {
+	identity, err := s.directory.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userForIdentity(identity, "directory", remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.syncDirectoryTeams(user.ID, identity.Groups)
+	return user, nil
+}
+
+// LinkDirectory links the user's directory account to the signed in account.
+// The user proves both: the account with its password, and the directory
+// account by signing in to the directory. Wrong directory passwords count
+// towards the lockout of username like a login.
+func (s *authService) LinkDirectory(userID uint, username, directoryPassword, password, remoteAddr string) (*models.UserIdentity, error) {
+	if s.directory == nil {
+		return nil, ErrNoDirectory
+	}
+	if err := s.Reauthenticate(userID, password, remoteAddr); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAttempts(s.loginLimits(username, remoteAddr)...); err != nil {
+		return nil, err
+	}
+	identity, err := s.directory.Authenticate(username, directoryPassword)
+	if err != nil {
+		s.recordLoginFailure(userID, username, remoteAddr, "directory link: "+err.Error())
+		s.countLoginFailure(userID, username, remoteAddr)
+		return nil, err
+	}
+
+	linked, err := s.linkIdentity(userID, identity, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	s.syncDirectoryTeams(userID, identity.Groups)
+	return linked, nil
+}
+
+// syncDirectoryTeams adds, changes and removes the memberships directory
+// groups grant. Memberships added by hand are left alone. Failures are
+// logged rather than blocking the login.
+func (s *authService) syncDirectoryTeams(userID uint, groups []string) {
+	for teamID, role := range s.directory.TeamRoles(groups) {
+		member, err := s.teams.GetMember(teamID, userID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Directory sync: membership of user %d in team %d: %v", userID, teamID, err)
+			continue
+		}
+
+		switch {
+		case member == nil && role != "":
+			if _, err := s.teams.GetByID(teamID); err != nil {
+				log.Printf("Directory sync: team %d: %v", teamID, err)
+				continue
+			}
+			err = s.teams.SaveMember(&models.TeamMember{
+				TeamID: teamID,
+				UserID: userID,
+				Role:   role,
+				Source: models.TeamMemberSourceDirectory,
+			})
+		case member == nil || member.Source != models.TeamMemberSourceDirectory:
+			continue
+		case member.Role == role:
+			continue
+		case member.Role == models.TeamRoleOwner && s.isLastOwner(teamID):
+			log.Printf("Directory sync: keeping user %d as the last owner of team %d", userID, teamID)
+			continue
+		case role == "":
+			err = s.teams.DeleteMember(teamID, userID)
+		default:
+			member.Role = role
+			err = s.teams.SaveMember(member)
+		}
+		if err != nil {
+			log.Printf("Directory sync: user %d in team %d: %v", userID, teamID, err)
+		}
+	}
+}
+
+func (s *authService) isLastOwner(teamID uint) bool {
+	owners, err := s.teams.CountOwners(teamID)
+	return err != nil || owners <= 1
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// fakeDirectory knows one user per username, and grants team roles the way
+// the LDAP authenticator does
+type fakeDirectory struct {
+	users      map[string]fakeDirectoryUser
+	groupTeams []GroupTeam
+}
+
+type fakeDirectoryUser struct {
+	password string
+	identity ExternalIdentity
+}
+
+func (d *fakeDirectory) Authenticate(username, password string) (*ExternalIdentity, error) {
+	user, ok := d.users[username]
+	if !ok || user.password != password {
+		return nil, ErrInvalidCredentials
+	}
+	identity := user.identity
+	identity.Provider = directoryProvider
+	return &identity, nil
+}
+
+func (d *fakeDirectory) TeamRoles(groups []string) map[uint]string {
+	return (&ldapAuthenticator{groupTeams: d.groupTeams}).TeamRoles(groups)
+}
+
+// memberRole returns the user's role in the team, or "" if they aren't a member
+func memberRole(t *testing.T, db *gorm.DB, teamID, userID uint) string {
+	t.Helper()
+	var member models.TeamMember
+	err := db.Where("team_id = ? AND user_id = ?", teamID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ""
+	}
+	if err != nil {
+		t.Fatalf("find member: %v", err)
+	}
+	return member.Role
+}
+
+func TestLDAPTeamRolesPickTheMostPrivileged(t *testing.T) {
+	a := &ldapAuthenticator{groupTeams: []GroupTeam{
+		{Group: "cn=dev,dc=example,dc=com", TeamID: 1, Role: models.TeamRoleViewer},
+		{Group: "cn=ops,dc=example,dc=com", TeamID: 1, Role: models.TeamRoleOperator},
+		{Group: "cn=dba,dc=example,dc=com", TeamID: 2, Role: models.TeamRoleOperator},
+	}}
+
+	got := a.TeamRoles([]string{"CN=Dev,DC=example,DC=com", "cn=ops,dc=example,dc=com"})
+	if len(got) != 2 || got[1] != models.TeamRoleOperator || got[2] != "" {
+		t.Errorf("TeamRoles = %v, want operator in team 1 and nothing in team 2", got)
+	}
+}
+
+func TestDirectoryLoginCreatesAccountAndSyncsTeams(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	admin := createTestUser(t, db, "admin@example.com", "correct horse")
+	ops := createTestTeam(t, auth.teams, "ops", admin.ID, nil)
+	dev := createTestTeam(t, auth.teams, "dev", admin.ID, nil)
+	directory := &fakeDirectory{
+		users: map[string]fakeDirectoryUser{"alice": {"secret", ExternalIdentity{
+			Subject: "uid-1", Email: "alice@example.com", Groups: []string{"cn=ops"},
+		}}},
+		groupTeams: []GroupTeam{
+			{Group: "cn=ops", TeamID: ops.ID, Role: models.TeamRoleOperator},
+			{Group: "cn=dev", TeamID: dev.ID, Role: models.TeamRoleViewer},
+		},
+	}
+	auth.directory = directory
+
+	if _, err := auth.Login("alice", "wrong", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong directory password: got %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := auth.Login("alice", "secret", ""); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	alice, err := auth.repo.FindByEmail("alice@example.com")
+	if err != nil {
+		t.Fatalf("directory login didn't create the account: %v", err)
+	}
+	if alice.Password != "" {
+		t.Error("directory account has a local password")
+	}
+	if role := memberRole(t, db, ops.ID, alice.ID); role != models.TeamRoleOperator {
+		t.Errorf("ops role %q, want operator from cn=ops", role)
+	}
+
+	// A membership added by hand survives the sync; directory ones follow
+	// the groups
+	if err := auth.teams.SaveMember(&models.TeamMember{TeamID: dev.ID, UserID: alice.ID, Role: models.TeamRoleOperator}); err != nil {
+		t.Fatalf("SaveMember: %v", err)
+	}
+	user := directory.users["alice"]
+	user.identity.Groups = []string{"cn=dev"}
+	directory.users["alice"] = user
+	if _, err := auth.Login("alice", "secret", ""); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if role := memberRole(t, db, ops.ID, alice.ID); role != "" {
+		t.Errorf("ops role %q after leaving cn=ops, want none", role)
+	}
+	if role := memberRole(t, db, dev.ID, alice.ID); role != models.TeamRoleOperator {
+		t.Errorf("dev role %q, want the operator role added by hand", role)
+	}
+}
+
+func TestDirectoryAccountLinksToExistingAccount(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	alice := createTestUser(t, db, "alice@example.com", "correct horse")
+	auth.directory = &fakeDirectory{users: map[string]fakeDirectoryUser{"alice": {"secret", ExternalIdentity{
+		Subject: "uid-1", Email: "alice@example.com",
+	}}}}
+
+	// The same address doesn't prove the directory user owns the account
+	if _, err := auth.Login("alice", "secret", ""); !errors.Is(err, ErrDirectoryNotLinked) {
+		t.Fatalf("login before linking: got %v, want ErrDirectoryNotLinked", err)
+	}
+
+	if _, err := auth.LinkDirectory(alice.ID, "alice", "secret", "wrong", ""); err == nil {
+		t.Error("linked without the account's password")
+	}
+	if _, err := auth.LinkDirectory(alice.ID, "alice", "wrong", "correct horse", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong directory password: got %v, want ErrInvalidCredentials", err)
+	}
+	linked, err := auth.LinkDirectory(alice.ID, "alice", "secret", "correct horse", "")
+	if err != nil {
+		t.Fatalf("LinkDirectory: %v", err)
+	}
+	if linked.UserID != alice.ID || linked.Provider != directoryProvider {
+		t.Errorf("linked %+v, want a directory identity of alice", linked)
+	}
+
+	if _, err := auth.Login("alice", "secret", ""); err != nil {
+		t.Errorf("login after linking: %v", err)
+	}
+	var users int64
+	db.Model(&models.User{}).Count(&users)
+	if users != 1 {
+		t.Errorf("%d accounts, want the directory login to use alice's", users)
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// Each address may be sent a few links in quick succession, then has to wait
+const (
+	emailFreeSends   = 3
+	emailFreeSendsIP = 10
+	emailSendBackoff = time.Minute
+	emailSendWindow  = time.Hour
+)
+
+var (
+	ErrInvalidEmailToken         = errors.New("this link is invalid or has expired")
+	ErrEmailAlreadyVerified      = errors.New("email address is already verified")
+	ErrEmailVerificationRequired = errors.New("verify your email address before opening terminal or file sessions")
+	ErrPasswordRequired          = errors.New("password is required")
+	ErrWrongPassword             = errors.New("current password is incorrect")
+	ErrNoLocalPassword           = errors.New("this account has no password, it signs in through an identity provider or directory")
+	ErrRecentSignInRequired      = errors.New("sign in again, or enter a two-factor code, to confirm")
+)
+
+func emailSendLimit(key string, free int) attemptLimit {
+	return attemptLimit{
+		key:    key,
+		free:   free,
+		base:   emailSendBackoff,
+		max:    emailSendWindow,
+		window: emailSendWindow,
+	}
+}
+
+// RequestPasswordReset emails a reset link if the address belongs to an
+// account with a password. It answers the same either way, so it can't be
+// used to find out which addresses have accounts.
+func (s *authService) RequestPasswordReset(email, remoteAddr string) error {
+	limits := []attemptLimit{emailSendLimit("reset:account:"+strings.ToLower(strings.TrimSpace(email)), emailFreeSends)}
+	if remoteAddr != "" {
+		limits = append(limits, emailSendLimit("reset:ip:"+remoteAddr, emailFreeSendsIP))
+	}
+	if err := s.checkAttempts(limits...); err != nil {
+		return err
+	}
+	for _, l := range limits {
+		s.countAttempt(l)
+	}
+
+	user, err := s.repo.FindByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Directory and provider accounts have their passwords managed elsewhere
+	if user.Password == "" {
+		log.Printf("AuthService: Not sending a password reset to user %d, who has no local password", user.ID)
+		return nil
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Actor:      email,
+		Action:     AuditPasswordResetRequest,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+	})
+	return s.sendEmailToken(user, models.EmailTokenPasswordReset, s.cfg.PasswordResetTTL, "/reset-password",
+		"Reset your password",
+		"Someone asked to reset the password of your SSH Terminal account.\n\n"+
+			"Open this link to choose a new one:\n\n%s\n\n"+
+			"The link works once and expires in %s. If it wasn't you, ignore this email.\n")
+}
+
+// ResetPassword sets a new password with a reset link and signs the user out
+// everywhere, in case the old password was known to someone else
+func (s *authService) ResetPassword(token, password, remoteAddr string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	user, err := s.useEmailToken(token, models.EmailTokenPasswordReset)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	// The link reached the inbox, which proves the address as well
+	if user.EmailVerifiedAt == nil {
+		if err := s.claimUnverifiedAccount(user); err != nil {
+			return err
+		}
+	}
+	user.Password = hashedPassword
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	if err := s.RevokeSessions(user.ID, ""); err != nil {
+		return err
+	}
+	s.resetAttempts(s.accountLoginLimit(user.Email))
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Actor:      user.Email,
+		Action:     AuditPasswordReset,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+	})
+	return nil
+}
+
+// SendVerificationEmail emails the user a new verification link
+func (s *authService) SendVerificationEmail(userID uint) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+
+	limit := emailSendLimit(fmt.Sprintf("verify:user:%d", userID), emailFreeSends)
+	if err := s.checkAttempts(limit); err != nil {
+		return err
+	}
+	s.countAttempt(limit)
+
+	return s.sendVerification(user)
+}
+
+func (s *authService) VerifyEmail(token, remoteAddr string) error {
+	user, err := s.useEmailToken(token, models.EmailTokenVerification)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Actor:      user.Email,
+		Action:     AuditEmailVerify,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"email": user.Email},
+	})
+	return nil
+}
+
+func (s *authService) sendVerification(user *models.User) error {
+	return s.sendEmailToken(user, models.EmailTokenVerification, s.cfg.EmailVerificationTTL, "/verify-email",
+		"Confirm your email address",
+		"Welcome to SSH Terminal.\n\n"+
+			"Open this link to confirm your email address:\n\n%s\n\n"+
+			"The link expires in %s. If you didn't create an account, ignore this email.\n")
+}
+
+// sendEmailToken stores a new single-use token and mails a link with it to
+// the user's current address. body is formatted with the link and lifetime.
+// The mail is sent in the background so a slow relay doesn't hold up the
+// request, or reveal by its timing that the account exists.
+func (s *authService) sendEmailToken(user *models.User, purpose string, ttl time.Duration, path, subject, body string) error {
+	token, err := newToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := s.emailTokens.DeleteExpired(now); err != nil {
+		log.Printf("AuthService: Failed to delete expired email tokens: %v", err)
+	}
+	err = s.emailTokens.Create(&models.EmailToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimRight(s.cfg.FrontendURL, "/") + path + "?token=" + url.QueryEscape(token)
+	userID, to := user.ID, user.Email
+	go func() {
+		if err := s.mailer.Send(to, subject, fmt.Sprintf(body, link, ttl)); err != nil {
+			log.Printf("AuthService: Failed to send %s email to user %d: %v", purpose, userID, err)
+		}
+	}()
+	return nil
+}
+
+// useEmailToken consumes a token and returns its user. A token sent to an
+// address the account no longer has is rejected.
+func (s *authService) useEmailToken(token, purpose string) (*models.User, error) {
+	stored, err := s.emailTokens.GetByHash(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidEmailToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.Purpose != purpose || stored.UsedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidEmailToken
+	}
+
+	user, err := s.repo.FindByID(stored.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidEmailToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(user.Email, stored.Email) {
+		return nil, ErrInvalidEmailToken
+	}
+
+	ok, err := s.emailTokens.Use(stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidEmailToken
+	}
+	return user, nil
+}
+
+// claimUnverifiedAccount marks the address verified once its owner has
+// proven they read mail for it. Anyone could have registered an address
+// they can't read, so every credential set up before then is removed.
+func (s *authService) claimUnverifiedAccount(user *models.User) error {
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	user.Password = ""
+	if err := s.clearMFA(user); err != nil {
+		return err
+	}
+
+	passkeys, err := s.passkeys.ListByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	for i := range passkeys {
+		if err := s.passkeys.Delete(&passkeys[i]); err != nil {
+			return err
+		}
+	}
+	accessTokens, err := s.accessTokens.ListByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, token := range accessTokens {
+		if err := s.accessTokens.Revoke(token.ID, user.ID, now); err != nil {
+			return err
+		}
+	}
+	return s.RevokeSessions(user.ID, "")
+}
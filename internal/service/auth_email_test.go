@@ -0,0 +1,193 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+
+	"gorm.io/gorm"
+)
+
+// testMailer passes sent mail to the test, which is sent in the background
+type testMailer struct {
+	sent chan testMail
+}
+
+type testMail struct {
+	to, subject, body string
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.sent <- testMail{to, subject, body}
+	return nil
+}
+
+var emailTokenPattern = regexp.MustCompile(`\?token=(\S+)`)
+
+// next returns the token in the next mail, which must go to to
+func (m *testMailer) next(t *testing.T, to string) string {
+	t.Helper()
+	select {
+	case mail := <-m.sent:
+		if mail.to != to {
+			t.Fatalf("mail sent to %s, want %s", mail.to, to)
+		}
+		match := emailTokenPattern.FindStringSubmatch(mail.body)
+		if match == nil {
+			t.Fatalf("no link in mail %q", mail.body)
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			t.Fatalf("unescape token: %v", err)
+		}
+		return token
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no mail sent to %s", to)
+		return ""
+	}
+}
+
+// none checks that no mail has been sent
+func (m *testMailer) none(t *testing.T) {
+	t.Helper()
+	select {
+	case mail := <-m.sent:
+		t.Errorf("unexpected mail to %s: %q", mail.to, mail.subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// newTestEmailAuthService returns an auth service that requires verified
+// addresses and mails its links to the returned mailer
+func newTestEmailAuthService(t *testing.T) (*authService, *gorm.DB, *testMailer, *config.Config) {
+	t.Helper()
+	cfg := newTestConfig()
+	cfg.FrontendURL = "https://terminal.example.com"
+	cfg.RequireVerifiedEmail = true
+	cfg.EmailVerificationTTL = time.Hour
+	cfg.PasswordResetTTL = time.Hour
+	auth, db := newTestAuthService(t, cfg)
+	mailer := &testMailer{sent: make(chan testMail, 10)}
+	auth.mailer = mailer
+	return auth, db, mailer, cfg
+}
+
+func TestEmailVerificationUnlocksSessions(t *testing.T) {
+	auth, _, mailer, cfg := newTestEmailAuthService(t)
+	user, err := auth.Register("alice@example.com", "correct horse", "Alice", "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	token := mailer.next(t, "alice@example.com")
+
+	if err := checkEmailVerified(auth.repo, cfg, user.ID); !errors.Is(err, ErrEmailVerificationRequired) {
+		t.Errorf("before verifying: got %v, want ErrEmailVerificationRequired", err)
+	}
+	if err := auth.ResetPassword(token, "new password", ""); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("verification link used to reset the password: got %v, want ErrInvalidEmailToken", err)
+	}
+
+	if err := auth.VerifyEmail(token, ""); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if err := checkEmailVerified(auth.repo, cfg, user.ID); err != nil {
+		t.Errorf("after verifying: %v", err)
+	}
+	if err := auth.VerifyEmail(token, ""); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("link used twice: got %v, want ErrInvalidEmailToken", err)
+	}
+	if err := auth.SendVerificationEmail(user.ID); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Errorf("resending once verified: got %v, want ErrEmailAlreadyVerified", err)
+	}
+}
+
+func TestVerificationLinkForOldAddressIsRefused(t *testing.T) {
+	auth, _, mailer, _ := newTestEmailAuthService(t)
+	user, err := auth.Register("alice@example.com", "correct horse", "Alice", "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	token := mailer.next(t, "alice@example.com")
+
+	user.Email = "alice@example.org"
+	if err := auth.repo.Update(user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := auth.VerifyEmail(token, ""); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("link sent to the old address: got %v, want ErrInvalidEmailToken", err)
+	}
+}
+
+func TestPasswordResetSignsOutEverywhere(t *testing.T) {
+	auth, db, mailer, _ := newTestEmailAuthService(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	session, err := auth.IssueTokens(user.ID, "")
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+
+	// Unknown addresses get the same answer, and no mail
+	if err := auth.RequestPasswordReset("nobody@example.com", ""); err != nil {
+		t.Errorf("RequestPasswordReset for an unknown address: %v", err)
+	}
+	mailer.none(t)
+
+	if err := auth.RequestPasswordReset("alice@example.com", ""); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	token := mailer.next(t, "alice@example.com")
+	if err := auth.ResetPassword(token, "", ""); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("empty password: got %v, want ErrPasswordRequired", err)
+	}
+	if err := auth.ResetPassword(token, "battery staple", ""); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if err := auth.ResetPassword(token, "another", ""); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("link used twice: got %v, want ErrInvalidEmailToken", err)
+	}
+
+	if _, err := auth.Refresh(session.RefreshToken, ""); err == nil {
+		t.Error("session from before the reset still refreshes")
+	}
+	if _, err := auth.Login("alice@example.com", "correct horse", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("old password: got %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := auth.Login("alice@example.com", "battery staple", ""); err != nil {
+		t.Errorf("new password: %v", err)
+	}
+}
+
+func TestPasswordResetClaimsUnverifiedAccount(t *testing.T) {
+	auth, _, mailer, _ := newTestEmailAuthService(t)
+	// Someone registered an address they can't read, and set up a token
+	squatter, err := auth.Register("alice@example.com", "squatter's password", "Alice", "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	mailer.next(t, "alice@example.com")
+	if _, err := auth.CreateAccessToken(squatter.ID, "ci", []string{ScopeSessionsOpen}, nil, ""); err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	if err := auth.RequestPasswordReset("alice@example.com", ""); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	if err := auth.ResetPassword(mailer.next(t, "alice@example.com"), "battery staple", ""); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	user, err := auth.repo.FindByID(squatter.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if user.EmailVerifiedAt == nil {
+		t.Error("reset link didn't verify the address")
+	}
+	if tokens, err := auth.accessTokens.ListByUserID(user.ID); err != nil || len(tokens) != 0 {
+		t.Errorf("access tokens %+v (%v) survived the address being claimed", tokens, err)
+	}
+}
@@ -0,0 +1,369 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const providerLoginTTL = 10 * time.Minute
+
+// providerLogin is a sign in sent to an identity provider, kept until the
+// browser comes back to the callback with its state
+type providerLogin struct {
+	provider  string
+	mode      string // "login", "register" or "link"
+	userID    uint   // The account a "link" adds the identity to
+	nonce     string
+	binding   string // Kept in the browser that started a login, so a callback can't be replayed in another
+	expiresAt time.Time
+}
+
+// providerLink is an identity a "link" sign in came back with, held until
+// the signed in user confirms it. Confirming with the session rather than
+// linking in the callback means a callback can't be replayed in someone
+// else's browser to attach an identity to the wrong account.
+type providerLink struct {
+	userID    uint
+	identity  *ExternalIdentity
+	expiresAt time.Time
+}
+
+// ProviderLinkError is a failed sign in made to link an identity, which is
+// reported on the account page rather than the login page
+type ProviderLinkError struct {
+	Err error
+}
+
+func (e *ProviderLinkError) Error() string {
+	return "linking identity: " + e.Err.Error()
+}
+
+func (e *ProviderLinkError) Unwrap() error {
+	return e.Err
+}
+
+func (s *authService) IdentityProviders() []IdentityProviderInfo {
+	infos := make([]IdentityProviderInfo, 0, len(s.providers))
+	for _, p := range s.providers {
+		infos = append(infos, IdentityProviderInfo{Name: p.Name(), DisplayName: p.DisplayName()})
+	}
+	if s.directory != nil {
+		infos = append(infos, IdentityProviderInfo{Name: directoryProvider, DisplayName: "Directory", Directory: true})
+	}
+	return infos
+}
+
+func (s *authService) BeginProviderLogin(ctx context.Context, providerName, mode string) (string, string, error) {
+	binding, err := newToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	url, err := s.beginProviderLogin(ctx, providerLogin{provider: providerName, mode: mode, binding: binding})
+	if err != nil {
+		return "", "", err
+	}
+	return url, binding, nil
+}
+
+// BeginProviderLink needs no browser binding: the identity it comes back
+// with is only linked once the signed in user confirms it
+func (s *authService) BeginProviderLink(ctx context.Context, userID uint, providerName string) (string, error) {
+	return s.beginProviderLogin(ctx, providerLogin{provider: providerName, mode: "link", userID: userID})
+}
+
+func (s *authService) beginProviderLogin(ctx context.Context, login providerLogin) (string, error) {
+	provider, err := s.identityProvider(login.provider)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := newToken(16)
+	if err != nil {
+		return "", err
+	}
+	login.nonce, err = newToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	login.expiresAt = now.Add(providerLoginTTL)
+	s.providerMu.Lock()
+	for key, l := range s.providerLogins {
+		if now.After(l.expiresAt) {
+			delete(s.providerLogins, key)
+		}
+	}
+	s.providerLogins[state] = login
+	s.providerMu.Unlock()
+
+	return provider.AuthCodeURL(ctx, state, login.nonce)
+}
+
+func (s *authService) FinishProviderLogin(ctx context.Context, providerName, state, binding, code, remoteAddr string) (*LoginResult, error) {
+	provider, err := s.identityProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each state works once, and only for the provider it was issued for
+	s.providerMu.Lock()
+	login, ok := s.providerLogins[state]
+	delete(s.providerLogins, state)
+	s.providerMu.Unlock()
+	if !ok || login.provider != providerName || time.Now().After(login.expiresAt) {
+		return nil, ErrProviderLoginGone
+	}
+	if login.mode != "link" && subtle.ConstantTimeCompare([]byte(login.binding), []byte(binding)) != 1 {
+		return nil, ErrProviderLoginGone
+	}
+
+	identity, err := provider.Exchange(ctx, code, login.nonce)
+	if err != nil {
+		if login.mode == "link" {
+			return nil, &ProviderLinkError{Err: err}
+		}
+		s.recordLoginFailure(0, "", remoteAddr, providerName+": "+err.Error())
+		return nil, err
+	}
+
+	if login.mode == "link" {
+		token, err := s.holdProviderLink(login.userID, identity)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{PendingLink: token}, nil
+	}
+
+	user, err := s.userForIdentity(identity, login.mode, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeFirstFactor(user, remoteAddr, providerName, "")
+}
+
+func (s *authService) holdProviderLink(userID uint, identity *ExternalIdentity) (string, error) {
+	token, err := newToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	s.providerMu.Lock()
+	defer s.providerMu.Unlock()
+	for key, l := range s.providerLinks {
+		if now.After(l.expiresAt) {
+			delete(s.providerLinks, key)
+		}
+	}
+	s.providerLinks[token] = providerLink{
+		userID:    userID,
+		identity:  identity,
+		expiresAt: now.Add(providerLoginTTL),
+	}
+	return token, nil
+}
+
+// ConfirmIdentityLink links a held identity once the signed in user enters
+// their password, so a session alone can't attach a new way to sign in
+func (s *authService) ConfirmIdentityLink(userID uint, token, password, remoteAddr string) (*models.UserIdentity, error) {
+	if err := s.Reauthenticate(userID, password, remoteAddr); err != nil {
+		return nil, err
+	}
+
+	s.providerMu.Lock()
+	link, ok := s.providerLinks[token]
+	delete(s.providerLinks, token)
+	s.providerMu.Unlock()
+	if !ok || link.userID != userID || time.Now().After(link.expiresAt) {
+		return nil, ErrProviderLoginGone
+	}
+
+	return s.linkIdentity(userID, link.identity, remoteAddr)
+}
+
+// linkIdentity attaches an identity the user has proven to hold to their
+// account
+func (s *authService) linkIdentity(userID uint, identity *ExternalIdentity, remoteAddr string) (*models.UserIdentity, error) {
+	existing, err := s.identities.FindBySubject(identity.Provider, identity.Subject)
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrIdentityInUse
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	linked := &models.UserIdentity{
+		UserID:   userID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		Groups:   identity.Groups,
+	}
+	if err := s.identities.Create(linked); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditOAuthLink,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"provider": identity.Provider, "email": identity.Email},
+	})
+	return linked, nil
+}
+
+func (s *authService) ListIdentities(userID uint) ([]models.UserIdentity, error) {
+	return s.identities.ListByUserID(userID)
+}
+
+// UnlinkIdentity removes a linked identity, as long as the account keeps a
+// password, a passkey or another identity to sign in with
+func (s *authService) UnlinkIdentity(userID, id uint, remoteAddr string) error {
+	identities, err := s.identities.ListByUserID(userID)
+	if err != nil {
+		return err
+	}
+	var identity *models.UserIdentity
+	for i := range identities {
+		if identities[i].ID == id {
+			identity = &identities[i]
+		}
+	}
+	if identity == nil {
+		return ErrIdentityNotFound
+	}
+
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	passkeys, err := s.passkeys.CountByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Password == "" && passkeys == 0 && len(identities) == 1 {
+		return ErrLastLoginMethod
+	}
+
+	if err := s.identities.Delete(id, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrIdentityNotFound
+		}
+		return err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditOAuthUnlink,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"provider": identity.Provider, "email": identity.Email},
+	})
+	return nil
+}
+
+// userForIdentity finds the account an identity is linked to. An unlinked
+// identity is never attached to an existing account, whatever its address:
+// the owner links it from their account page. When registering, or signing
+// in through the directory, an unknown identity gets a new account. The
+// directory may create one with an unverified address, which is then
+// verified by email like a password registration.
+func (s *authService) userForIdentity(identity *ExternalIdentity, mode, remoteAddr string) (*models.User, error) {
+	linked, err := s.identities.FindBySubject(identity.Provider, identity.Subject)
+	if err == nil {
+		// Keep the provider's view of the account current
+		linked.Email = identity.Email
+		linked.Groups = identity.Groups
+		if err := s.identities.Update(linked); err != nil {
+			return nil, err
+		}
+		return s.repo.FindByID(linked.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || (!identity.EmailVerified && mode != "directory") {
+		s.recordLoginFailure(0, identity.Email, remoteAddr, identity.Provider+": email not verified")
+		return nil, ErrEmailNotVerified
+	}
+
+	user, err := s.repo.FindByEmail(identity.Email)
+	if err == nil {
+		// A matching address alone doesn't prove the identity belongs to
+		// whoever has been using the account
+		s.recordLoginFailure(user.ID, identity.Email, remoteAddr, identity.Provider+" identity not linked")
+		if mode == "directory" {
+			return nil, ErrDirectoryNotLinked
+		}
+		return nil, ErrIdentityNotLinked
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if mode == "login" {
+		s.recordLoginFailure(0, identity.Email, remoteAddr, "no account for "+identity.Provider+" user")
+		return nil, ErrAccountNotFound
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	user = &models.User{Email: identity.Email, Name: name}
+	if identity.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := s.repo.Create(user); err != nil {
+		return nil, err
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Action:     AuditRegister,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"method": identity.Provider},
+	})
+	if user.EmailVerifiedAt == nil {
+		if err := s.sendVerification(user); err != nil {
+			log.Printf("AuthService: Failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}
+
+	err = s.identities.Create(&models.UserIdentity{
+		UserID:   user.ID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		Groups:   identity.Groups,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *authService) identityProvider(name string) (IdentityProvider, error) {
+	for _, p := range s.providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, ErrUnknownProvider
+}
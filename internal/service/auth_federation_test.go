@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// fakeIdentityProvider vouches for one identity, but only when the code is
+// redeemed with the nonce of the last sign in it was sent
+type fakeIdentityProvider struct {
+	name     string
+	identity ExternalIdentity
+	nonce    string
+}
+
+func (p *fakeIdentityProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeIdentityProvider) DisplayName() string {
+	return p.name
+}
+
+func (p *fakeIdentityProvider) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	p.nonce = nonce
+	return "https://idp.example.com/auth?" + url.Values{"state": {state}}.Encode(), nil
+}
+
+func (p *fakeIdentityProvider) Exchange(ctx context.Context, code, nonce string) (*ExternalIdentity, error) {
+	if nonce != p.nonce {
+		return nil, ErrProviderExchange
+	}
+	identity := p.identity
+	identity.Provider = p.name
+	return &identity, nil
+}
+
+// newTestFederatedAuthService returns an auth service that signs in through
+// the given fake providers
+func newTestFederatedAuthService(t *testing.T, providers ...*fakeIdentityProvider) (*authService, *gorm.DB) {
+	t.Helper()
+	auth, db := newTestAuthService(t, newTestConfig())
+	for _, p := range providers {
+		auth.providers = append(auth.providers, p)
+	}
+	return auth, db
+}
+
+// stateOf pulls the state out of a provider's sign in URL
+func stateOf(t *testing.T, raw string) string {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	return u.Query().Get("state")
+}
+
+func TestProviderLoginStateIsBoundAndWorksOnce(t *testing.T) {
+	corp := &fakeIdentityProvider{name: "corp", identity: ExternalIdentity{Subject: "u-1", Email: "alice@example.com", EmailVerified: true}}
+	other := &fakeIdentityProvider{name: "other"}
+	auth, _ := newTestFederatedAuthService(t, corp, other)
+	ctx := context.Background()
+
+	begin := func() (string, string) {
+		t.Helper()
+		raw, binding, err := auth.BeginProviderLogin(ctx, "corp", "register")
+		if err != nil {
+			t.Fatalf("BeginProviderLogin: %v", err)
+		}
+		return stateOf(t, raw), binding
+	}
+
+	// A callback replayed in a browser without the binding, or sent to
+	// another provider, spends the state
+	state, binding := begin()
+	if _, err := auth.FinishProviderLogin(ctx, "corp", state, "", "code", ""); !errors.Is(err, ErrProviderLoginGone) {
+		t.Errorf("without the binding: got %v, want ErrProviderLoginGone", err)
+	}
+	if _, err := auth.FinishProviderLogin(ctx, "corp", state, binding, "code", ""); !errors.Is(err, ErrProviderLoginGone) {
+		t.Errorf("after a failed callback: got %v, want ErrProviderLoginGone", err)
+	}
+	state, binding = begin()
+	if _, err := auth.FinishProviderLogin(ctx, "other", state, binding, "code", ""); !errors.Is(err, ErrProviderLoginGone) {
+		t.Errorf("at another provider: got %v, want ErrProviderLoginGone", err)
+	}
+
+	state, binding = begin()
+	result, err := auth.FinishProviderLogin(ctx, "corp", state, binding, "code", "")
+	if err != nil {
+		t.Fatalf("FinishProviderLogin: %v", err)
+	}
+	if result.TokenPair == nil {
+		t.Errorf("result %+v, want a session", result)
+	}
+	if _, err := auth.FinishProviderLogin(ctx, "corp", state, binding, "code", ""); !errors.Is(err, ErrProviderLoginGone) {
+		t.Errorf("replaying the callback: got %v, want ErrProviderLoginGone", err)
+	}
+}
+
+func TestProviderLoginUsesItsOwnNonce(t *testing.T) {
+	corp := &fakeIdentityProvider{name: "corp", identity: ExternalIdentity{Subject: "u-1", Email: "alice@example.com", EmailVerified: true}}
+	auth, _ := newTestFederatedAuthService(t, corp)
+	ctx := context.Background()
+
+	first, firstBinding, err := auth.BeginProviderLogin(ctx, "corp", "register")
+	if err != nil {
+		t.Fatalf("BeginProviderLogin: %v", err)
+	}
+	firstNonce := corp.nonce
+	if _, _, err := auth.BeginProviderLogin(ctx, "corp", "register"); err != nil {
+		t.Fatalf("BeginProviderLogin: %v", err)
+	}
+	if corp.nonce == firstNonce {
+		t.Fatal("two sign ins were given the same nonce")
+	}
+
+	// The provider now holds the second login's nonce, so finishing the
+	// first must not redeem the code with it
+	if _, err := auth.FinishProviderLogin(ctx, "corp", stateOf(t, first), firstBinding, "code", ""); !errors.Is(err, ErrProviderExchange) {
+		t.Errorf("finishing with a stale nonce: got %v, want ErrProviderExchange", err)
+	}
+}
+
+func TestProviderIdentityIsLinkedOnlyWhenConfirmed(t *testing.T) {
+	corp := &fakeIdentityProvider{name: "corp", identity: ExternalIdentity{Subject: "u-1", Email: "alice@example.com", EmailVerified: true}}
+	auth, db := newTestFederatedAuthService(t, corp)
+	alice := createTestUser(t, db, "alice@example.com", "correct horse")
+	mallory := createTestUser(t, db, "mallory@example.com", "battery staple")
+	ctx := context.Background()
+
+	login := func() (*LoginResult, error) {
+		t.Helper()
+		raw, binding, err := auth.BeginProviderLogin(ctx, "corp", "login")
+		if err != nil {
+			t.Fatalf("BeginProviderLogin: %v", err)
+		}
+		return auth.FinishProviderLogin(ctx, "corp", stateOf(t, raw), binding, "code", "")
+	}
+
+	// A matching address doesn't link the identity to the account
+	if _, err := login(); !errors.Is(err, ErrIdentityNotLinked) {
+		t.Fatalf("login before linking: got %v, want ErrIdentityNotLinked", err)
+	}
+
+	raw, err := auth.BeginProviderLink(ctx, alice.ID, "corp")
+	if err != nil {
+		t.Fatalf("BeginProviderLink: %v", err)
+	}
+	result, err := auth.FinishProviderLogin(ctx, "corp", stateOf(t, raw), "", "code", "")
+	if err != nil {
+		t.Fatalf("FinishProviderLogin: %v", err)
+	}
+	if result.PendingLink == "" || result.TokenPair != nil {
+		t.Fatalf("result %+v, want a pending link and no session", result)
+	}
+	if identities, _ := auth.ListIdentities(alice.ID); len(identities) != 0 {
+		t.Errorf("identity linked before confirming: %+v", identities)
+	}
+
+	// Someone else's session, or the wrong password, can't confirm it
+	if _, err := auth.ConfirmIdentityLink(mallory.ID, result.PendingLink, "battery staple", ""); !errors.Is(err, ErrProviderLoginGone) {
+		t.Errorf("confirming another user's link: got %v, want ErrProviderLoginGone", err)
+	}
+	raw, err = auth.BeginProviderLink(ctx, alice.ID, "corp")
+	if err != nil {
+		t.Fatalf("BeginProviderLink: %v", err)
+	}
+	result, err = auth.FinishProviderLogin(ctx, "corp", stateOf(t, raw), "", "code", "")
+	if err != nil {
+		t.Fatalf("FinishProviderLogin: %v", err)
+	}
+	if _, err := auth.ConfirmIdentityLink(alice.ID, result.PendingLink, "wrong", ""); err == nil {
+		t.Error("confirmed a link with the wrong password")
+	}
+	if _, err := auth.ConfirmIdentityLink(alice.ID, result.PendingLink, "correct horse", ""); err != nil {
+		t.Fatalf("ConfirmIdentityLink: %v", err)
+	}
+
+	result, err = login()
+	if err != nil {
+		t.Fatalf("login after linking: %v", err)
+	}
+	if result.TokenPair == nil {
+		t.Errorf("result %+v, want a session", result)
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+)
+
+const (
+	// An address gets more room than an account, since many users may share
+	// it behind NAT. It is slowed down but never locked out.
+	ipFreeFailures      = 20
+	accountFreeFailures = 3
+	loginBackoffBase    = time.Second
+
+	registerFreeAttempts = 5
+	registerBackoffBase  = time.Minute
+	registerWindow       = time.Hour
+)
+
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// RateLimitError rejects an attempt made too soon after earlier failures
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Locked     bool // The account is locked, not just slowed down
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account temporarily locked, try again in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("%s, try again in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// attemptLimit describes how one kind of key is limited. Once it has free
+// failures each attempt waits twice as long as the one before, from base up
+// to max. lockAfter failures, if set, lock the key for the whole window.
+type attemptLimit struct {
+	key       string
+	free      int
+	base      time.Duration
+	max       time.Duration
+	lockAfter int
+	window    time.Duration // Failures are forgotten after this long without another
+}
+
+// wait returns how long the key must wait before its next attempt
+func (l attemptLimit) wait(record AttemptRecord, now time.Time) (time.Duration, bool) {
+	if l.lockAfter > 0 && record.Failures >= l.lockAfter {
+		return record.LastFailure.Add(l.window).Sub(now), true
+	}
+	if record.Failures < l.free {
+		return 0, false
+	}
+
+	delay := l.max
+	if shift := record.Failures - l.free; shift < 30 {
+		if d := l.base << shift; d < l.max {
+			delay = d
+		}
+	}
+	return record.LastFailure.Add(delay).Sub(now), false
+}
+
+func (s *authService) ipLoginLimit(remoteAddr string) attemptLimit {
+	return attemptLimit{
+		key:    "login:ip:" + remoteAddr,
+		free:   ipFreeFailures,
+		base:   loginBackoffBase,
+		max:    s.cfg.LoginBackoffMax,
+		window: s.cfg.LoginLockout,
+	}
+}
+
+func (s *authService) accountLoginLimit(email string) attemptLimit {
+	return attemptLimit{
+		key:       "login:account:" + strings.ToLower(strings.TrimSpace(email)),
+		free:      accountFreeFailures,
+		base:      loginBackoffBase,
+		max:       s.cfg.LoginBackoffMax,
+		lockAfter: s.cfg.LoginMaxFailures,
+		window:    s.cfg.LoginLockout,
+	}
+}
+
+func registerLimit(remoteAddr string) attemptLimit {
+	return attemptLimit{
+		key:    "register:ip:" + remoteAddr,
+		free:   registerFreeAttempts,
+		base:   registerBackoffBase,
+		max:    registerWindow,
+		window: registerWindow,
+	}
+}
+
+func (s *authService) checkAttempts(limits ...attemptLimit) error {
+	return checkAttempts(s.attempts, limits...)
+}
+
+func (s *authService) countAttempt(l attemptLimit) int {
+	return countAttempt(s.attempts, l)
+}
+
+func (s *authService) resetAttempts(l attemptLimit) {
+	resetAttempts(s.attempts, l)
+}
+
+// checkAttempts returns a RateLimitError if any of the limits is still
+// waiting. A failing store is logged and lets the attempt through, so an
+// outage of a shared store doesn't lock everyone out.
+func checkAttempts(store AttemptStore, limits ...attemptLimit) error {
+	now := time.Now()
+	var limited *RateLimitError
+	for _, l := range limits {
+		record, err := store.Get(l.key, now)
+		if err != nil {
+			log.Printf("Rate limit lookup for %s: %v", l.key, err)
+			continue
+		}
+		wait, locked := l.wait(record, now)
+		if wait <= 0 {
+			continue
+		}
+		if limited == nil || wait > limited.RetryAfter {
+			limited = &RateLimitError{RetryAfter: wait, Locked: locked}
+		}
+	}
+	if limited != nil {
+		return limited
+	}
+	return nil
+}
+
+// countAttempt records a failure against the limit and returns the new count
+func countAttempt(store AttemptStore, l attemptLimit) int {
+	record, err := store.AddFailure(l.key, time.Now(), l.window)
+	if err != nil {
+		log.Printf("Rate limit update for %s: %v", l.key, err)
+		return 0
+	}
+	return record.Failures
+}
+
+func resetAttempts(store AttemptStore, l attemptLimit) {
+	if err := store.Reset(l.key); err != nil {
+		log.Printf("Rate limit reset for %s: %v", l.key, err)
+	}
+}
+
+// loginLimits returns the limits a password login is checked against
+func (s *authService) loginLimits(email, remoteAddr string) []attemptLimit {
+	limits := []attemptLimit{s.accountLoginLimit(email)}
+	if remoteAddr != "" {
+		limits = append(limits, s.ipLoginLimit(remoteAddr))
+	}
+	return limits
+}
+
+// countLoginFailure counts a wrong password against the account and the
+// address, locking the account once it reaches the limit
+func (s *authService) countLoginFailure(userID uint, email, remoteAddr string) {
+	for _, l := range s.loginLimits(email, remoteAddr) {
+		failures := s.countAttempt(l)
+		if l.lockAfter == 0 || failures != l.lockAfter {
+			continue
+		}
+		s.audit.Record(models.AuditEvent{
+			UserID:     userID,
+			Actor:      email,
+			Action:     AuditAccountLocked,
+			TargetType: AuditTargetUser,
+			TargetID:   userID,
+			RemoteAddr: remoteAddr,
+			Details:    map[string]interface{}{"failures": failures, "duration": l.window.String()},
+		})
+	}
+}
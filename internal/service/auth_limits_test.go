@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+)
+
+func TestAttemptLimitWait(t *testing.T) {
+	limit := attemptLimit{free: 3, base: time.Second, max: time.Minute, lockAfter: 10, window: 15 * time.Minute}
+	last := time.Now()
+
+	tests := []struct {
+		failures int
+		wait     time.Duration
+		locked   bool
+	}{
+		{0, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{6, 8 * time.Second, false},
+		{9, time.Minute, false}, // 64s is capped
+		{10, 15 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		wait, locked := limit.wait(AttemptRecord{Failures: tt.failures, LastFailure: last}, last)
+		if tt.wait == 0 {
+			if wait > 0 || locked {
+				t.Errorf("%d failures: wait %v, locked %v, want none", tt.failures, wait, locked)
+			}
+			continue
+		}
+		if wait != tt.wait || locked != tt.locked {
+			t.Errorf("%d failures: wait %v, locked %v, want %v, %v", tt.failures, wait, locked, tt.wait, tt.locked)
+		}
+	}
+
+	// The wait runs from the last failure
+	if wait, _ := limit.wait(AttemptRecord{Failures: 3, LastFailure: last}, last.Add(2*time.Second)); wait > 0 {
+		t.Errorf("wait %v after the backoff has passed, want none", wait)
+	}
+}
+
+func TestLoginBacksOffAfterFreeFailures(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	createTestUser(t, db, "alice@example.com", "correct horse")
+
+	for i := 0; i < accountFreeFailures; i++ {
+		if _, err := auth.Login("alice@example.com", "wrong", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failure %d: got %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+
+	// Even the right password waits out the backoff
+	_, err := auth.Login("Alice@Example.com ", "correct horse", "192.0.2.1")
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || limited.Locked {
+		t.Fatalf("after %d failures: got %v, want a backoff", accountFreeFailures, err)
+	}
+	if limited.RetryAfter <= 0 || limited.RetryAfter > loginBackoffBase {
+		t.Errorf("RetryAfter %v, want up to %v", limited.RetryAfter, loginBackoffBase)
+	}
+}
+
+func TestLoginLocksAccount(t *testing.T) {
+	cfg := newTestConfig()
+	auth, db := newTestAuthService(t, cfg)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	for i := 0; i < cfg.LoginMaxFailures; i++ {
+		auth.countLoginFailure(user.ID, user.Email, "192.0.2.1")
+	}
+
+	_, err := auth.Login(user.Email, "correct horse", "198.51.100.7")
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || !limited.Locked {
+		t.Fatalf("after %d failures: got %v, want the account locked", cfg.LoginMaxFailures, err)
+	}
+	if limited.RetryAfter <= cfg.LoginLockout-time.Minute {
+		t.Errorf("locked for %v, want about %v", limited.RetryAfter, cfg.LoginLockout)
+	}
+
+	var locked int64
+	db.Model(&models.AuditEvent{}).Where("action = ? AND user_id = ?", AuditAccountLocked, user.ID).Count(&locked)
+	if locked != 1 {
+		t.Errorf("recorded %d lockouts, want 1", locked)
+	}
+}
+
+func TestLoginForgivesFailuresOnSuccess(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	createTestUser(t, db, "alice@example.com", "correct horse")
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < accountFreeFailures-1; i++ {
+			auth.Login("alice@example.com", "wrong", "")
+		}
+		if _, err := auth.Login("alice@example.com", "correct horse", ""); err != nil {
+			t.Fatalf("round %d: %v", round+1, err)
+		}
+	}
+}
+
+// unavailableDirectory fails every sign in as if the server were down
+type unavailableDirectory struct{}
+
+func (unavailableDirectory) Authenticate(username, password string) (*ExternalIdentity, error) {
+	return nil, ErrDirectoryUnavailable
+}
+
+func (unavailableDirectory) TeamRoles(groups []string) map[uint]string {
+	return nil
+}
+
+func TestLoginCountsFailuresWhileDirectoryIsDown(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	auth.directory = unavailableDirectory{}
+	createTestUser(t, db, "alice@example.com", "correct horse")
+
+	for i := 0; i < accountFreeFailures; i++ {
+		if _, err := auth.Login("alice@example.com", "wrong", ""); !errors.Is(err, ErrDirectoryUnavailable) {
+			t.Fatalf("failure %d: got %v, want ErrDirectoryUnavailable", i+1, err)
+		}
+	}
+	if _, err := auth.Login("alice@example.com", "wrong", ""); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("guessing while the directory is down: got %v, want ErrTooManyAttempts", err)
+	}
+}
@@ -0,0 +1,709 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+
+	// Purposes of the pending token handed out between the first and second
+	// factor steps
+	mfaPurposeVerify = "verify"
+	mfaPurposeSetup  = "setup"
+)
+
+var (
+	ErrUserNotFound            = errors.New("user not found")
+	ErrMFARequired             = errors.New("two-factor authentication is required for this account")
+	ErrMFAAlreadyEnabled       = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled           = errors.New("two-factor authentication is not enabled")
+	ErrMFAEnrollmentNotStarted = errors.New("two-factor enrollment has not been started")
+	ErrInvalidMFACode          = errors.New("invalid authentication code")
+	ErrInvalidMFAToken         = errors.New("invalid or expired MFA token")
+)
+
+// Second factors a pending login can be completed with
+const (
+	MFAMethodTOTP     = "totp"
+	MFAMethodWebAuthn = "webauthn"
+)
+
+// LoginResult is a full session, or a pending MFA token when a second factor
+// is still due. MFAMethods lists the factors the account can use; with
+// MFASetupRequired it has none yet and has to enroll first.
+type LoginResult struct {
+	*TokenPair
+	MFAToken         string   `json:"mfa_token,omitempty"`
+	MFAMethods       []string `json:"mfa_methods,omitempty"`
+	MFASetupRequired bool     `json:"mfa_setup_required,omitempty"`
+	// PendingLink is set instead of a session when the sign in linked an
+	// identity to an existing account. It is passed to ConfirmIdentityLink.
+	PendingLink string `json:"-"`
+}
+
+// MFAEnrollment is shown to the user once so they can add the secret to an
+// authenticator app
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type MFAStatus struct {
+	Enabled                bool  `json:"enabled"` // TOTP is set up
+	Required               bool  `json:"required"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+	Passkeys               int64 `json:"passkeys"`
+	PasskeyMFA             bool  `json:"passkey_mfa"` // Passkeys are asked for after the password
+}
+
+// MFASetupResult completes a login that was held back for enrollment
+type MFASetupResult struct {
+	*TokenPair
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// completeFirstFactor decides what a successful password or Google login
+// gets: a session, or a pending token when the account has or needs a second
+// factor. method names the first factor for the audit log. login is the name
+// a password was checked under, empty for providers; its failures are only
+// forgiven once the session is issued.
+func (s *authService) completeFirstFactor(user *models.User, remoteAddr, method, login string) (*LoginResult, error) {
+	methods, err := s.mfaMethods(user)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) > 0 {
+		token, err := s.generateMFAToken(user.ID, mfaPurposeVerify, method, login)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: token, MFAMethods: methods}, nil
+	}
+	if s.mfaRequired(user) {
+		token, err := s.generateMFAToken(user.ID, mfaPurposeSetup, method, login)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: token, MFASetupRequired: true}, nil
+	}
+
+	s.forgiveLoginFailures(login)
+	s.recordLogin(user, remoteAddr, method)
+	tokens, err := s.IssueTokens(user.ID, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
+
+// mfaMethods lists the second factors the user has turned on
+func (s *authService) mfaMethods(user *models.User) ([]string, error) {
+	var methods []string
+	if user.MFAEnabled {
+		methods = append(methods, MFAMethodTOTP)
+	}
+	passkeyMFA, err := s.passkeyMFA(user)
+	if err != nil {
+		return nil, err
+	}
+	if passkeyMFA {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+	return methods, nil
+}
+
+// passkeyMFA reports whether the user's passkeys count as a second factor
+func (s *authService) passkeyMFA(user *models.User) (bool, error) {
+	if !user.PasskeyMFA {
+		return false, nil
+	}
+	passkeys, err := s.passkeys.CountByUserID(user.ID)
+	return passkeys > 0, err
+}
+
+// VerifyMFA completes a pending login with a TOTP or recovery code
+func (s *authService) VerifyMFA(mfaToken, code, remoteAddr string) (*TokenPair, error) {
+	claims, err := s.parseMFAToken(mfaToken, mfaPurposeVerify)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.findUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		return nil, ErrInvalidMFAToken
+	}
+	if err := s.checkAttempts(s.mfaLimit(user.ID)); err != nil {
+		return nil, err
+	}
+
+	method, err := s.checkSecondFactor(user, code)
+	if err != nil {
+		s.recordMFAFailure(user, claims, remoteAddr, err)
+		return nil, err
+	}
+
+	s.consumeMFAToken(claims)
+	s.resetAttempts(s.mfaLimit(user.ID))
+	s.forgiveLoginFailures(claims.Login)
+	s.recordLogin(user, remoteAddr, claims.Method+"+"+method)
+	return s.IssueTokens(user.ID, remoteAddr)
+}
+
+// BeginMFASetup starts enrollment for a login held back by the MFA policy
+func (s *authService) BeginMFASetup(mfaToken string) (*MFAEnrollment, error) {
+	claims, err := s.parseMFAToken(mfaToken, mfaPurposeSetup)
+	if err != nil {
+		return nil, err
+	}
+	return s.BeginMFAEnrollment(claims.UserID)
+}
+
+// CompleteMFASetup confirms the enrollment started by BeginMFASetup and
+// finishes the login
+func (s *authService) CompleteMFASetup(mfaToken, code, remoteAddr string) (*MFASetupResult, error) {
+	claims, err := s.parseMFAToken(mfaToken, mfaPurposeSetup)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.findUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAttempts(s.mfaLimit(user.ID)); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.ConfirmMFAEnrollment(user.ID, code, remoteAddr)
+	if errors.Is(err, ErrInvalidMFACode) {
+		s.recordMFAFailure(user, claims, remoteAddr, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.consumeMFAToken(claims)
+	s.resetAttempts(s.mfaLimit(user.ID))
+	s.forgiveLoginFailures(claims.Login)
+	s.recordLogin(user, remoteAddr, claims.Method+"+"+MFAMethodTOTP)
+	tokens, err := s.IssueTokens(user.ID, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &MFASetupResult{TokenPair: tokens, RecoveryCodes: codes}, nil
+}
+
+// BeginMFAEnrollment generates a new TOTP secret. It only takes effect once
+// confirmed with a code, so restarting enrollment is harmless.
+func (s *authService) BeginMFAEnrollment(userID uint) (*MFAEnrollment, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.keyring.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = encrypted
+	user.TOTPLastStep = 0
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(s.cfg.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFAEnrollment turns MFA on once the user proves their authenticator
+// produces valid codes, and returns a fresh set of recovery codes
+func (s *authService) ConfirmMFAEnrollment(userID uint, code, remoteAddr string) ([]string, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFAEnrollmentNotStarted
+	}
+
+	secret, err := s.keyring.Decrypt(user.TOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := s.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.MFAEnabled = true
+	user.TOTPLastStep = step
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Action:     AuditMFAEnable,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"method": "totp"},
+	})
+	return codes, nil
+}
+
+// DisableMFA turns MFA off after re-checking a current code. Accounts covered
+// by the MFA policy cannot opt out.
+func (s *authService) DisableMFA(userID uint, code, remoteAddr string) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if s.mfaRequired(user) {
+		// Fine as long as passkeys still cover the requirement
+		passkeyMFA, err := s.passkeyMFA(user)
+		if err != nil {
+			return err
+		}
+		if !passkeyMFA {
+			return ErrMFARequired
+		}
+	}
+	if err := s.confirmSecondFactor(user, code, remoteAddr, "wrong code when disabling two-factor authentication"); err != nil {
+		return err
+	}
+
+	if err := s.clearMFA(user); err != nil {
+		return err
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Action:     AuditMFADisable,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+	})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not
+func (s *authService) RegenerateRecoveryCodes(userID uint, code, remoteAddr string) ([]string, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.confirmSecondFactor(user, code, remoteAddr, "wrong code when regenerating recovery codes"); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Action:     AuditRecoveryCodes,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+	})
+	return codes, nil
+}
+
+func (s *authService) GetMFAStatus(userID uint) (*MFAStatus, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MFAStatus{
+		Enabled:    user.MFAEnabled,
+		Required:   s.mfaRequired(user),
+		PasskeyMFA: user.PasskeyMFA,
+	}
+	status.Passkeys, err = s.passkeys.CountByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		status.RecoveryCodesRemaining, err = s.recoveryCodes.CountUnused(user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// SetMFARequired lets an admin require MFA for one account on top of the
+// deployment-wide MFA_REQUIRED setting
+func (s *authService) SetMFARequired(adminID, userID uint, required bool, remoteAddr string) error {
+	admin, err := requireAdmin(s.repo, s.cfg, adminID)
+	if err != nil {
+		return err
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	newlyRequired := required && !s.mfaRequired(user)
+	user.MFARequired = required
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	if newlyRequired {
+		// Sessions started with a password alone end here; the next login
+		// has to enroll
+		if err := s.RevokeSessions(user.ID, ""); err != nil {
+			return err
+		}
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     admin.ID,
+		Actor:      admin.Email,
+		Action:     AuditMFAPolicy,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"required": required},
+	})
+	return nil
+}
+
+// ResetMFA lets an admin remove the second factors of a user who lost both
+// their authenticator and recovery codes. Their passkeys go too, since one
+// of them may be what was lost. Their sessions are revoked, and if MFA is
+// required they enroll again at their next login.
+func (s *authService) ResetMFA(adminID, userID uint, remoteAddr string) error {
+	admin, err := requireAdmin(s.repo, s.cfg, adminID)
+	if err != nil {
+		return err
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	passkeys, err := s.passkeys.CountByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled && passkeys == 0 {
+		return ErrMFANotEnabled
+	}
+
+	user.PasskeyMFA = false
+	if err := s.clearMFA(user); err != nil {
+		return err
+	}
+	if err := s.passkeys.DeleteByUserID(user.ID); err != nil {
+		return err
+	}
+	// Whoever prompted the reset may not be the one holding these sessions
+	if err := s.RevokeSessions(user.ID, ""); err != nil {
+		return err
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     admin.ID,
+		Actor:      admin.Email,
+		Action:     AuditMFADisable,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"reset_by_admin": true},
+	})
+	return nil
+}
+
+// SetPasskeyMFA turns passkeys on or off as a second factor for password
+// and provider logins. Accounts covered by the MFA policy cannot turn them
+// off unless TOTP is enabled.
+func (s *authService) SetPasskeyMFA(userID uint, enabled bool, remoteAddr string) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.PasskeyMFA == enabled {
+		return nil
+	}
+	if enabled {
+		passkeys, err := s.passkeys.CountByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+		if passkeys == 0 {
+			return ErrWebAuthnNotAvailable
+		}
+	} else if s.mfaRequired(user) && !user.MFAEnabled {
+		return ErrMFARequired
+	}
+
+	user.PasskeyMFA = enabled
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	action := AuditMFADisable
+	if enabled {
+		action = AuditMFAEnable
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Action:     action,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"method": MFAMethodWebAuthn},
+	})
+	return nil
+}
+
+func (s *authService) mfaRequired(user *models.User) bool {
+	return s.cfg.MFARequired || user.MFARequired
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code
+// and returns which one it was
+func (s *authService) checkSecondFactor(user *models.User, code string) (string, error) {
+	secret, err := s.keyring.Decrypt(user.TOTPSecret)
+	if err != nil {
+		return "", err
+	}
+	if step, ok := utils.ValidateTOTP(secret, code, time.Now()); ok {
+		// A code stays valid for its whole window; only the first use counts
+		advanced, err := s.repo.AdvanceTOTPStep(user.ID, step)
+		if err != nil {
+			return "", err
+		}
+		if !advanced {
+			return "", ErrInvalidMFACode
+		}
+		return MFAMethodTOTP, nil
+	}
+
+	used, err := s.recoveryCodes.Use(user.ID, hashToken(normalizeRecoveryCode(code)), time.Now())
+	if err != nil {
+		return "", err
+	}
+	if used {
+		return "recovery_code", nil
+	}
+	return "", ErrInvalidMFACode
+}
+
+// confirmSecondFactor re-checks the second factor of a signed in user. Wrong
+// codes count toward the same limit as those of a pending login, so a stolen
+// session can't be used to guess them.
+func (s *authService) confirmSecondFactor(user *models.User, code, remoteAddr, reason string) error {
+	limit := s.mfaLimit(user.ID)
+	if err := s.checkAttempts(limit); err != nil {
+		return err
+	}
+	if _, err := s.checkSecondFactor(user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.recordLoginFailure(user.ID, user.Email, remoteAddr, reason)
+			s.countAttempt(limit)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *authService) newRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw, err := newToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+
+	if err := s.recoveryCodes.Replace(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *authService) clearMFA(user *models.User) error {
+	user.MFAEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	return s.recoveryCodes.DeleteByUserID(user.ID)
+}
+
+// mfaClaims are the verified contents of a pending MFA token
+type mfaClaims struct {
+	UserID    uint
+	TokenID   string
+	Method    string // First factor that was already checked
+	Login     string // Name the password was checked under, if any
+	ExpiresAt time.Time
+}
+
+// generateMFAToken issues the pending token. Its type and "mfa" claim keep
+// ValidateAccessToken from accepting it as an access token.
+func (s *authService) generateMFAToken(userID uint, purpose, method, login string) (string, error) {
+	jti, err := newToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"jti":     jti,
+		"mfa":     purpose,
+		"amr":     method,
+		"iat":     now.Unix(),
+		"exp":     now.Add(mfaTokenTTL).Unix(),
+	}
+	if login != "" {
+		claims["login"] = login
+	}
+	return s.signer.Sign(tokenTypeMFA, claims)
+}
+
+func (s *authService) parseMFAToken(tokenString, purpose string) (*mfaClaims, error) {
+	claims, err := s.signer.Parse(tokenTypeMFA, tokenString)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	if tokenPurpose, _ := claims["mfa"].(string); tokenPurpose != purpose {
+		return nil, ErrInvalidMFAToken
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, ErrInvalidMFAToken
+	}
+	jti, _ := claims["jti"].(string)
+	method, _ := claims["amr"].(string)
+	login, _ := claims["login"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" {
+		return nil, ErrInvalidMFAToken
+	}
+
+	revoked, err := s.tokens.IsRevoked(jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidMFAToken
+	}
+
+	return &mfaClaims{
+		UserID:    uint(userID),
+		TokenID:   jti,
+		Method:    method,
+		Login:     login,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// consumeMFAToken makes a pending token single-use
+func (s *authService) consumeMFAToken(claims *mfaClaims) {
+	if err := s.tokens.Revoke(claims.TokenID, claims.ExpiresAt); err != nil {
+		log.Printf("AuthService: Failed to revoke MFA token: %v", err)
+	}
+}
+
+// mfaLimit counts wrong second factors against the user rather than the
+// pending token, so logging in again with the password buys no new guesses
+func (s *authService) mfaLimit(userID uint) attemptLimit {
+	return attemptLimit{
+		key:       fmt.Sprintf("mfa:user:%d", userID),
+		free:      accountFreeFailures,
+		base:      loginBackoffBase,
+		max:       s.cfg.LoginBackoffMax,
+		lockAfter: s.cfg.LoginMaxFailures,
+		window:    s.cfg.LoginLockout,
+	}
+}
+
+// recordMFAFailure audits a wrong code and counts it against the user. Once
+// they reach maxMFAAttempts the pending token is burnt as well, so the
+// password has to be entered again.
+func (s *authService) recordMFAFailure(user *models.User, claims *mfaClaims, remoteAddr string, cause error) {
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Actor:      user.Email,
+		Action:     AuditMFAFailed,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"reason": cause.Error()},
+	})
+
+	limit := s.mfaLimit(user.ID)
+	failures := s.countAttempt(limit)
+	if failures >= maxMFAAttempts {
+		s.consumeMFAToken(claims)
+	}
+	if limit.lockAfter > 0 && failures == limit.lockAfter {
+		s.audit.Record(models.AuditEvent{
+			UserID:     user.ID,
+			Actor:      user.Email,
+			Action:     AuditAccountLocked,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			RemoteAddr: remoteAddr,
+			Details:    map[string]interface{}{"failures": failures, "duration": limit.window.String(), "factor": "mfa"},
+		})
+	}
+}
+
+func (s *authService) findUser(userID uint) (*models.User, error) {
+	user, err := s.repo.FindByID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// normalizeRecoveryCode ignores case, spaces and the dash that is shown to
+// users
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/utils"
+)
+
+// enrollTestTOTP turns on TOTP for user with a code for the current time
+// step, returning the secret and recovery codes
+func enrollTestTOTP(t *testing.T, auth *authService, user *models.User) (string, []string) {
+	t.Helper()
+	enrollment, err := auth.BeginMFAEnrollment(user.ID)
+	if err != nil {
+		t.Fatalf("BeginMFAEnrollment: %v", err)
+	}
+	codes, err := auth.ConfirmMFAEnrollment(user.ID, totpAt(t, enrollment.Secret, time.Now()), "")
+	if err != nil {
+		t.Fatalf("ConfirmMFAEnrollment: %v", err)
+	}
+	return enrollment.Secret, codes
+}
+
+func totpAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, at)
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+// loginForMFA signs in with a password and returns the pending MFA token
+func loginForMFA(t *testing.T, auth *authService, email, password string) string {
+	t.Helper()
+	result, err := auth.Login(email, password, "")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if result.MFAToken == "" || result.TokenPair != nil {
+		t.Fatalf("Login = %+v, want a pending MFA token", result)
+	}
+	return result.MFAToken
+}
+
+func TestVerifyMFARejectsReplayedCode(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	secret, _ := enrollTestTOTP(t, auth, user)
+	enrolled, err := auth.findUser(user.ID)
+	if err != nil {
+		t.Fatalf("findUser: %v", err)
+	}
+	stepStart := func(step int64) time.Time { return time.Unix(step*30, 0) }
+
+	// The enrollment code was already used for its time step
+	used := totpAt(t, secret, stepStart(enrolled.TOTPLastStep))
+	if _, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), used, ""); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("code used for enrollment: got %v, want ErrInvalidMFACode", err)
+	}
+
+	// The next step's code is accepted once for clock drift, then never again
+	next := totpAt(t, secret, stepStart(enrolled.TOTPLastStep+1))
+	if _, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), next, ""); err != nil {
+		t.Fatalf("VerifyMFA with a fresh code: %v", err)
+	}
+	if _, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), next, ""); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("replayed code: got %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestVerifyMFATokenWorksOnce(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	_, codes := enrollTestTOTP(t, auth, user)
+
+	token := loginForMFA(t, auth, user.Email, "correct horse")
+	if _, err := auth.VerifyMFA(token, codes[0], ""); err != nil {
+		t.Fatalf("VerifyMFA: %v", err)
+	}
+	if _, err := auth.VerifyMFA(token, codes[1], ""); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Errorf("reusing the pending token: got %v, want ErrInvalidMFAToken", err)
+	}
+}
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	_, codes := enrollTestTOTP(t, auth, user)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	// Typed the way it was shown, or sloppily
+	pair, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), " "+strings.ToUpper(codes[0])+" ", "")
+	if err != nil {
+		t.Fatalf("VerifyMFA with a recovery code: %v", err)
+	}
+	if pair.AccessToken == "" {
+		t.Error("VerifyMFA returned no access token")
+	}
+
+	if _, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), codes[0], ""); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("used recovery code: got %v, want ErrInvalidMFACode", err)
+	}
+	if _, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), codes[1], ""); err != nil {
+		t.Errorf("unused recovery code: %v", err)
+	}
+}
+
+func TestRegenerateRecoveryCodesReplacesOldOnes(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	secret, old := enrollTestTOTP(t, auth, user)
+
+	fresh, err := auth.RegenerateRecoveryCodes(user.ID, totpAt(t, secret, time.Now().Add(30*time.Second)), "")
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes: %v", err)
+	}
+
+	if _, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), old[0], ""); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("replaced recovery code: got %v, want ErrInvalidMFACode", err)
+	}
+	if _, err := auth.VerifyMFA(loginForMFA(t, auth, user.Email, "correct horse"), fresh[0], ""); err != nil {
+		t.Errorf("new recovery code: %v", err)
+	}
+}
+
+func TestSignedInMFAChangesLockAfterWrongCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(auth *authService, userID uint, code string) error
+	}{
+		{"DisableMFA", func(auth *authService, userID uint, code string) error {
+			return auth.DisableMFA(userID, code, "192.0.2.1")
+		}},
+		{"RegenerateRecoveryCodes", func(auth *authService, userID uint, code string) error {
+			_, err := auth.RegenerateRecoveryCodes(userID, code, "192.0.2.1")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			// Only the lockout is under test, not the backoff before it
+			cfg.LoginBackoffMax = time.Nanosecond
+			auth, db := newTestAuthService(t, cfg)
+			user := createTestUser(t, db, "alice@example.com", "correct horse")
+			_, codes := enrollTestTOTP(t, auth, user)
+
+			for i := 0; i < cfg.LoginMaxFailures; i++ {
+				if err := tt.call(auth, user.ID, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+					t.Fatalf("wrong code %d: got %v, want ErrInvalidMFACode", i+1, err)
+				}
+			}
+
+			// Even a valid recovery code is refused and left unused
+			err := tt.call(auth, user.ID, codes[0])
+			var limited *RateLimitError
+			if !errors.As(err, &limited) || !limited.Locked {
+				t.Fatalf("after %d wrong codes: got %v, want the account locked", cfg.LoginMaxFailures, err)
+			}
+			status, err := auth.GetMFAStatus(user.ID)
+			if err != nil {
+				t.Fatalf("GetMFAStatus: %v", err)
+			}
+			if !status.Enabled || status.RecoveryCodesRemaining != recoveryCodeCount {
+				t.Errorf("status %+v, want MFA on with all %d recovery codes", status, recoveryCodeCount)
+			}
+		})
+	}
+}
@@ -2,8 +2,10 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"io"
+	"log"
+	"sync"
 	"time"
 
 	"ssh-terminal-app/internal/config"
@@ -11,35 +13,159 @@ import (
 	"ssh-terminal-app/internal/repository"
 	"ssh-terminal-app/internal/utils"
 
-	"github.com/golang-jwt/jwt"
-	"golang.org/x/oauth2"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
+// recentSignInWindow is how long after signing in a user without a password
+// can confirm a sensitive change without entering a two-factor code
+const recentSignInWindow = 5 * time.Minute
+
 type AuthService interface {
-	Register(email, password, name string) (*models.User, error)
-	Login(email, password string) (string, error)
-	GoogleLogin(state string) string
-	GoogleCallback(ctx context.Context, code, mode string) (string, error)
-	GenerateToken(userID uint) (string, error)
+	// remoteAddr is the client address, recorded in the audit log. Register
+	// and Login return a *RateLimitError after too many attempts.
+	Register(email, password, name, remoteAddr string) (*models.User, error)
+	// Login returns a pending MFA token instead of a session when the account
+	// has, or is required to set up, a second factor
+	Login(email, password, remoteAddr string) (*LoginResult, error)
+
+	// Identity providers sign users in through an external service. mode is
+	// "register" to create an account for an unknown identity.
+	// BeginProviderLogin returns the provider's URL and a binding the browser
+	// has to keep and hand back to FinishProviderLogin.
+	IdentityProviders() []IdentityProviderInfo
+	BeginProviderLogin(ctx context.Context, provider, mode string) (string, string, error)
+	FinishProviderLogin(ctx context.Context, provider, state, binding, code, remoteAddr string) (*LoginResult, error)
+
+	// Linking an identity to a signed in account goes through the provider's
+	// sign in like a login, then waits for ConfirmIdentityLink with the
+	// LoginResult's PendingLink and the user's password
+	BeginProviderLink(ctx context.Context, userID uint, provider string) (string, error)
+	ConfirmIdentityLink(userID uint, token, password, remoteAddr string) (*models.UserIdentity, error)
+	// LinkDirectory links a directory account, signed in to with username and
+	// directoryPassword, after checking the user's own password
+	LinkDirectory(userID uint, username, directoryPassword, password, remoteAddr string) (*models.UserIdentity, error)
+	ListIdentities(userID uint) ([]models.UserIdentity, error)
+	UnlinkIdentity(userID, id uint, remoteAddr string) error
+
 	GetProfile(userID uint) (*models.User, error)
+	// Reauthenticate checks the password of a signed in user before a
+	// sensitive change. Failures count towards the login lockout.
+	Reauthenticate(userID uint, password, remoteAddr string) error
+	// ConfirmRecentSignIn stands in for Reauthenticate on accounts without a
+	// password: it takes a current second factor code, or a session that
+	// signed in within the last few minutes when code is empty.
+	ConfirmRecentSignIn(claims *models.AccessClaims, code, remoteAddr string) error
+	// ChangePassword signs out every other session of the user
+	ChangePassword(claims *models.AccessClaims, current, password, remoteAddr string) error
+
+	// Password reset and verification links are emailed and work once
+	RequestPasswordReset(email, remoteAddr string) error
+	ResetPassword(token, password, remoteAddr string) error
+	SendVerificationEmail(userID uint) error
+	VerifyEmail(token, remoteAddr string) error
+
+	// IssueTokens starts a new session with a short-lived access token and a
+	// rotating refresh token
+	IssueTokens(userID uint, remoteAddr string) (*TokenPair, error)
+	Refresh(refreshToken, remoteAddr string) (*TokenPair, error)
+	Logout(claims *models.AccessClaims, remoteAddr string) error
+	// RevokeSessions ends every session of the user but the refresh family
+	// keepFamilyID, which may be empty
+	RevokeSessions(userID uint, keepFamilyID string) error
+	ValidateAccessToken(token string) (*models.AccessClaims, error)
+
+	// Personal access tokens authenticate scripts, limited to their scopes.
+	// A nil expiresAt gets PAT_DEFAULT_TTL; none may outlive PAT_MAX_TTL.
+	CreateAccessToken(userID uint, name string, scopes []string, expiresAt *time.Time, remoteAddr string) (*NewAccessToken, error)
+	ListAccessTokens(userID uint) ([]models.PersonalAccessToken, error)
+	RevokeAccessToken(userID, id uint, remoteAddr string) error
+
+	VerifyMFA(mfaToken, code, remoteAddr string) (*TokenPair, error)
+	BeginMFASetup(mfaToken string) (*MFAEnrollment, error)
+	CompleteMFASetup(mfaToken, code, remoteAddr string) (*MFASetupResult, error)
+	BeginMFAEnrollment(userID uint) (*MFAEnrollment, error)
+	ConfirmMFAEnrollment(userID uint, code, remoteAddr string) ([]string, error)
+	DisableMFA(userID uint, code, remoteAddr string) error
+	RegenerateRecoveryCodes(userID uint, code, remoteAddr string) ([]string, error)
+	GetMFAStatus(userID uint) (*MFAStatus, error)
+	SetMFARequired(adminID, userID uint, required bool, remoteAddr string) error
+	ResetMFA(adminID, userID uint, remoteAddr string) error
+	SetPasskeyMFA(userID uint, enabled bool, remoteAddr string) error
+
+	// Passkeys sign in on their own or complete a pending login as the
+	// second factor. response is the JSON the browser's authenticator returned.
+	BeginPasskeyRegistration(userID uint) (*WebAuthnChallenge, error)
+	FinishPasskeyRegistration(userID uint, sessionID, name string, response io.Reader, remoteAddr string) (*models.WebAuthnCredential, error)
+	ListPasskeys(userID uint) ([]models.WebAuthnCredential, error)
+	DeletePasskey(userID, id uint, remoteAddr string) error
+	BeginPasskeyLogin() (*WebAuthnChallenge, error)
+	FinishPasskeyLogin(sessionID string, response io.Reader, remoteAddr string) (*TokenPair, error)
+	BeginPasskeyMFA(mfaToken string) (*WebAuthnChallenge, error)
+	FinishPasskeyMFA(mfaToken, sessionID string, response io.Reader, remoteAddr string) (*TokenPair, error)
 }
 
 type authService struct {
-	repo        repository.UserRepository
-	cfg         *config.Config
-	googleOAuth *oauth2.Config
+	repo          repository.UserRepository
+	tokens        repository.TokenRepository
+	signer        TokenSigner
+	accessTokens  repository.AccessTokenRepository
+	recoveryCodes repository.RecoveryCodeRepository
+	emailTokens   repository.EmailTokenRepository
+	passkeys      repository.WebAuthnRepository
+	identities    repository.IdentityRepository
+	teams         repository.TeamRepository
+	attempts      AttemptStore
+	audit         AuditService
+	mailer        Mailer
+	keyring       *utils.Keyring
+	cfg           *config.Config
+	providers     []IdentityProvider
+	directory     DirectoryAuthenticator // nil unless LDAP is configured
+	webAuthn      *webauthn.WebAuthn
+
+	ceremonyMu sync.Mutex
+	ceremonies map[string]webAuthnCeremony
+
+	providerMu     sync.Mutex
+	providerLogins map[string]providerLogin // Keyed by OAuth state
+	providerLinks  map[string]providerLink  // Keyed by the token handed to the frontend
 }
 
-func NewAuthService(repo repository.UserRepository, cfg *config.Config, googleOAuth *oauth2.Config) AuthService {
+func NewAuthService(repo repository.UserRepository, tokens repository.TokenRepository, signer TokenSigner, accessTokens repository.AccessTokenRepository, recoveryCodes repository.RecoveryCodeRepository, emailTokens repository.EmailTokenRepository, passkeys repository.WebAuthnRepository, identities repository.IdentityRepository, teams repository.TeamRepository, attempts AttemptStore, audit AuditService, mailer Mailer, keyring *utils.Keyring, cfg *config.Config, providers []IdentityProvider, directory DirectoryAuthenticator, webAuthn *webauthn.WebAuthn) AuthService {
 	return &authService{
-		repo:        repo,
-		cfg:         cfg,
-		googleOAuth: googleOAuth,
+		repo:           repo,
+		tokens:         tokens,
+		signer:         signer,
+		accessTokens:   accessTokens,
+		recoveryCodes:  recoveryCodes,
+		emailTokens:    emailTokens,
+		passkeys:       passkeys,
+		identities:     identities,
+		teams:          teams,
+		attempts:       attempts,
+		audit:          audit,
+		mailer:         mailer,
+		keyring:        keyring,
+		cfg:            cfg,
+		providers:      providers,
+		directory:      directory,
+		webAuthn:       webAuthn,
+		ceremonies:     make(map[string]webAuthnCeremony),
+		providerLogins: make(map[string]providerLogin),
+		providerLinks:  make(map[string]providerLink),
 	}
 }
 
-func (s *authService) Register(email, password, name string) (*models.User, error) {
-	// ... (same as before)
+func (s *authService) Register(email, password, name, remoteAddr string) (*models.User, error) {
+	if remoteAddr != "" {
+		limit := registerLimit(remoteAddr)
+		if err := s.checkAttempts(limit); err != nil {
+			return nil, err
+		}
+		// Every registration counts, so one address can't create accounts in bulk
+		s.countAttempt(limit)
+	}
+
 	existingUser, _ := s.repo.FindByEmail(email)
 	if existingUser != nil {
 		return nil, errors.New("user already exists")
@@ -60,90 +186,170 @@ func (s *authService) Register(email, password, name string) (*models.User, erro
 		return nil, err
 	}
 
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Action:     AuditRegister,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"method": "password"},
+	})
+
+	// The account works without it unless verification is required
+	if err := s.sendVerification(user); err != nil {
+		log.Printf("AuthService: Failed to send verification email to user %d: %v", user.ID, err)
+	}
 	return user, nil
 }
 
-func (s *authService) Login(email, password string) (string, error) {
-	// ... (same as before)
+func (s *authService) Login(email, password, remoteAddr string) (*LoginResult, error) {
+	if err := s.checkAttempts(s.loginLimits(email, remoteAddr)...); err != nil {
+		return nil, err
+	}
+
 	user, err := s.repo.FindByEmail(email)
-	if err != nil {
-		return "", errors.New("invalid credentials")
+	if err == nil && utils.CheckPassword(password, user.Password) {
+		return s.completeFirstFactor(user, remoteAddr, "password", email)
+	}
+	var userID uint
+	if err == nil {
+		userID = user.ID
 	}
 
-	if !utils.CheckPassword(password, user.Password) {
-		return "", errors.New("invalid credentials")
+	// Directory users have no local password; email may be their username
+	if s.directory != nil {
+		dirUser, dirErr := s.directoryLogin(email, password, remoteAddr)
+		if dirErr == nil {
+			return s.completeFirstFactor(dirUser, remoteAddr, "ldap", email)
+		}
+		if !errors.Is(dirErr, ErrInvalidCredentials) {
+			// Counted all the same, or an unreachable directory would lift
+			// the limits on guessing local passwords
+			s.recordLoginFailure(userID, email, remoteAddr, dirErr.Error())
+			s.countLoginFailure(userID, email, remoteAddr)
+			return nil, dirErr
+		}
 	}
 
-	return s.GenerateToken(user.ID)
+	if err != nil {
+		s.recordLoginFailure(0, email, remoteAddr, "unknown email")
+	} else {
+		s.recordLoginFailure(userID, email, remoteAddr, "wrong password")
+	}
+	s.countLoginFailure(userID, email, remoteAddr)
+	return nil, ErrInvalidCredentials
 }
 
-func (s *authService) GoogleLogin(state string) string {
-	return s.googleOAuth.AuthCodeURL(state, oauth2.AccessTypeOffline)
+func (s *authService) recordLogin(user *models.User, remoteAddr, method string) {
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Actor:      user.Email,
+		Action:     AuditLogin,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"method": method},
+	})
 }
 
-func (s *authService) GoogleCallback(ctx context.Context, code, mode string) (string, error) {
-	token, err := s.googleOAuth.Exchange(ctx, code)
-	if err != nil {
-		return "", errors.New("failed to exchange token")
+// forgiveLoginFailures clears the password failures counted under login,
+// once the whole login including any second factor has succeeded
+func (s *authService) forgiveLoginFailures(login string) {
+	if login != "" {
+		s.resetAttempts(s.accountLoginLimit(login))
 	}
+}
+
+// recordLoginFailure logs a failed attempt; userID is 0 when no account matched
+func (s *authService) recordLoginFailure(userID uint, email, remoteAddr, reason string) {
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Actor:      email,
+		Action:     AuditLoginFailed,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"reason": reason},
+	})
+}
 
-	client := s.googleOAuth.Client(ctx, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
+func (s *authService) GetProfile(userID uint) (*models.User, error) {
+	return s.repo.FindByID(userID)
+}
+
+func (s *authService) Reauthenticate(userID uint, password, remoteAddr string) error {
+	user, err := s.repo.FindByID(userID)
 	if err != nil {
-		return "", errors.New("failed to get user info")
+		return err
+	}
+	if user.Password == "" {
+		return ErrNoLocalPassword
 	}
-	defer resp.Body.Close()
 
-	var googleUser struct {
-		ID    string `json:"id"`
-		Email string `json:"email"`
-		Name  string `json:"name"`
+	// Checked like a login, so a stolen session can't be used to guess the password
+	limit := s.accountLoginLimit(user.Email)
+	if err := s.checkAttempts(limit); err != nil {
+		return err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&googleUser); err != nil {
-		return "", errors.New("failed to decode user info")
+	if !utils.CheckPassword(password, user.Password) {
+		s.recordLoginFailure(user.ID, user.Email, remoteAddr, "wrong password on re-authentication")
+		s.countLoginFailure(user.ID, user.Email, remoteAddr)
+		return ErrWrongPassword
 	}
+	return nil
+}
 
-	user, err := s.repo.FindByGoogleID(googleUser.ID)
+func (s *authService) ConfirmRecentSignIn(claims *models.AccessClaims, code, remoteAddr string) error {
+	user, err := s.findUser(claims.UserID)
 	if err != nil {
-		// User not found by Google ID
-		user, err = s.repo.FindByEmail(googleUser.Email)
-		if err != nil {
-			// User does not exist
-			if mode == "login" {
-				return "", errors.New("User not found. Please register first.")
-			}
-
-			// Register mode
-			newUser := &models.User{
-				Email:    googleUser.Email,
-				Name:     googleUser.Name,
-				GoogleID: googleUser.ID,
-			}
-			if err := s.repo.Create(newUser); err != nil {
-				return "", err
-			}
-			user = newUser
-		} else {
-			// Link account
-			user.GoogleID = googleUser.ID
-			if err := s.repo.Update(user); err != nil {
-				return "", err
-			}
+		return err
+	}
+
+	if code != "" {
+		if !user.MFAEnabled {
+			return ErrMFANotEnabled
 		}
+		return s.confirmSecondFactor(user, code, remoteAddr, "wrong code on re-authentication")
 	}
 
-	return s.GenerateToken(user.ID)
+	// Signing in again through the user's provider starts a new family
+	if claims.AuthTime.IsZero() || time.Since(claims.AuthTime) > recentSignInWindow {
+		return ErrRecentSignInRequired
+	}
+	return nil
 }
 
-func (s *authService) GenerateToken(userID uint) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+func (s *authService) ChangePassword(claims *models.AccessClaims, current, password, remoteAddr string) error {
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if err := s.Reauthenticate(claims.UserID, current, remoteAddr); err != nil {
+		return err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.cfg.JWTSecret))
-}
 
-func (s *authService) GetProfile(userID uint) (*models.User, error) {
-	return s.repo.FindByID(userID)
+	user, err := s.repo.FindByID(claims.UserID)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	if err := s.RevokeSessions(user.ID, claims.FamilyID); err != nil {
+		return err
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     user.ID,
+		Actor:      user.Email,
+		Action:     AuditPasswordChange,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		RemoteAddr: remoteAddr,
+	})
+	return nil
 }
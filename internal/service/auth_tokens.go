@@ -0,0 +1,245 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+)
+
+// TokenPair is returned on login and refresh. The access token keeps the
+// "token" key it has always been sent under.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// IssueTokens starts a new refresh family for the user
+func (s *authService) IssueTokens(userID uint, remoteAddr string) (*TokenPair, error) {
+	familyID, err := newToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	// Opportunistic cleanup keeps both tables small without a background job
+	if err := s.tokens.DeleteExpired(time.Now()); err != nil {
+		log.Printf("AuthService: Failed to delete expired tokens: %v", err)
+	}
+
+	return s.issueTokens(userID, familyID, time.Now(), remoteAddr)
+}
+
+// Refresh rotates a refresh token. Presenting one that was already used
+// means it has leaked, so its whole family is revoked.
+func (s *authService) Refresh(refreshToken, remoteAddr string) (*TokenPair, error) {
+	stored, err := s.tokens.GetRefreshTokenByHash(hashToken(refreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if stored.UsedAt != nil {
+		s.revokeFamily(stored, remoteAddr, "refresh token reuse detected")
+		return nil, ErrInvalidRefreshToken
+	}
+	if now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	ok, err := s.tokens.MarkUsed(stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// Lost a race against another request presenting the same token
+		s.revokeFamily(stored, remoteAddr, "refresh token reuse detected")
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.issueTokens(stored.UserID, stored.FamilyID, stored.AuthTime, remoteAddr)
+}
+
+// Logout revokes the access token in use and its whole refresh family
+func (s *authService) Logout(claims *models.AccessClaims, remoteAddr string) error {
+	if err := s.tokens.Revoke(claims.TokenID, claims.ExpiresAt); err != nil {
+		return err
+	}
+	if claims.FamilyID != "" {
+		if err := s.tokens.RevokeFamily(claims.FamilyID, time.Now()); err != nil {
+			return err
+		}
+		if err := s.tokens.Revoke(claims.FamilyID, time.Now().Add(s.cfg.AccessTokenTTL)); err != nil {
+			return err
+		}
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     claims.UserID,
+		Action:     AuditLogout,
+		TargetType: AuditTargetUser,
+		TargetID:   claims.UserID,
+		RemoteAddr: remoteAddr,
+	})
+	return nil
+}
+
+// RevokeSessions revokes the families' access tokens along with their
+// refresh tokens, since those already issued stay valid until they expire
+func (s *authService) RevokeSessions(userID uint, keepFamilyID string) error {
+	now := time.Now()
+	families, err := s.tokens.RevokeUserFamilies(userID, keepFamilyID, now)
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if err := s.tokens.Revoke(family, now.Add(s.cfg.AccessTokenTTL)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateAccessToken verifies the signature, expiry and revocation status
+// of an access token, or looks up a personal access token
+func (s *authService) ValidateAccessToken(tokenString string) (*models.AccessClaims, error) {
+	if isAccessToken(tokenString) {
+		return s.validateAccessTokenSecret(tokenString)
+	}
+
+	claims, err := s.signer.Parse(TokenTypeAccess, tokenString)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	exp, _ := claims["exp"].(float64)
+	jti, _ := claims["jti"].(string)
+	fid, _ := claims["fid"].(string)
+	if jti == "" {
+		return nil, ErrInvalidToken
+	}
+	// Pending MFA tokens have their own type, but grant nothing here either way
+	if _, pending := claims["mfa"]; pending {
+		return nil, ErrInvalidToken
+	}
+
+	ids := []string{jti}
+	if fid != "" {
+		ids = append(ids, fid)
+	}
+	revoked, err := s.tokens.IsRevoked(ids...)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+
+	result := &models.AccessClaims{
+		UserID:    uint(userID),
+		TokenID:   jti,
+		FamilyID:  fid,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		result.AuthTime = time.Unix(int64(authTime), 0)
+	}
+	return result, nil
+}
+
+func (s *authService) issueTokens(userID uint, familyID string, authTime time.Time, remoteAddr string) (*TokenPair, error) {
+	accessToken, err := s.generateAccessToken(userID, familyID, authTime)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newToken(32)
+	if err != nil {
+		return nil, err
+	}
+	err = s.tokens.CreateRefreshToken(&models.RefreshToken{
+		UserID:     userID,
+		FamilyID:   familyID,
+		AuthTime:   authTime,
+		TokenHash:  hashToken(refreshToken),
+		ExpiresAt:  time.Now().Add(s.cfg.RefreshTokenTTL),
+		RemoteAddr: remoteAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.cfg.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *authService) generateAccessToken(userID uint, familyID string, authTime time.Time) (string, error) {
+	jti, err := newToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":     strconv.FormatUint(uint64(userID), 10),
+		"user_id": userID,
+		"jti":     jti,
+		"fid":     familyID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(s.cfg.AccessTokenTTL).Unix(),
+	}
+	if !authTime.IsZero() {
+		claims["auth_time"] = authTime.Unix()
+	}
+	return s.signer.Sign(TokenTypeAccess, claims)
+}
+
+func (s *authService) revokeFamily(stored *models.RefreshToken, remoteAddr, reason string) {
+	now := time.Now()
+	if err := s.tokens.RevokeFamily(stored.FamilyID, now); err != nil {
+		log.Printf("AuthService: Failed to revoke token family: %v", err)
+	}
+	// Access tokens already issued in the family die with it
+	if err := s.tokens.Revoke(stored.FamilyID, now.Add(s.cfg.AccessTokenTTL)); err != nil {
+		log.Printf("AuthService: Failed to revoke token family: %v", err)
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     stored.UserID,
+		Action:     AuditTokenReuse,
+		TargetType: AuditTargetUser,
+		TargetID:   stored.UserID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"reason": reason},
+	})
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+)
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	first, err := auth.IssueTokens(user.ID, "")
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	second, err := auth.Refresh(first.RefreshToken, "")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("Refresh returned the same refresh token")
+	}
+
+	claims, err := auth.ValidateAccessToken(second.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if claims.UserID != user.ID {
+		t.Errorf("access token is for user %d, want %d", claims.UserID, user.ID)
+	}
+	if _, err := auth.Refresh(second.RefreshToken, ""); err != nil {
+		t.Errorf("Refresh with the rotated token: %v", err)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	first, err := auth.IssueTokens(user.ID, "")
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	second, err := auth.Refresh(first.RefreshToken, "")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	// Whoever stole the first token tries it after the user has moved on
+	if _, err := auth.Refresh(first.RefreshToken, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reusing a refresh token: got %v, want ErrInvalidRefreshToken", err)
+	}
+
+	if _, err := auth.Refresh(second.RefreshToken, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refreshing after reuse: got %v, want ErrInvalidRefreshToken", err)
+	}
+	for name, token := range map[string]string{"first": first.AccessToken, "second": second.AccessToken} {
+		if _, err := auth.ValidateAccessToken(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s access token after reuse: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	var reuse int64
+	db.Model(&models.AuditEvent{}).Where("action = ?", AuditTokenReuse).Count(&reuse)
+	if reuse != 1 {
+		t.Errorf("recorded %d reuse events, want 1", reuse)
+	}
+}
+
+func TestRefreshReuseLeavesOtherFamilies(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	laptop, err := auth.IssueTokens(user.ID, "")
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	phone, err := auth.IssueTokens(user.ID, "")
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+
+	if _, err := auth.Refresh(laptop.RefreshToken, ""); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	auth.Refresh(laptop.RefreshToken, "")
+
+	if _, err := auth.ValidateAccessToken(phone.AccessToken); err != nil {
+		t.Errorf("another session's access token: %v", err)
+	}
+	if _, err := auth.Refresh(phone.RefreshToken, ""); err != nil {
+		t.Errorf("another session's refresh token: %v", err)
+	}
+}
+
+func TestLogoutRevokesFamily(t *testing.T) {
+	auth, db := newTestAuthService(t, newTestConfig())
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	pair, err := auth.IssueTokens(user.ID, "")
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	claims, err := auth.ValidateAccessToken(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if err := auth.Logout(claims, ""); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+
+	if _, err := auth.ValidateAccessToken(pair.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("access token after logout: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := auth.Refresh(pair.RefreshToken, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("refresh token after logout: got %v, want ErrInvalidRefreshToken", err)
+	}
+}
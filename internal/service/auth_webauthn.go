@@ -0,0 +1,418 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+const webAuthnCeremonyTTL = 5 * time.Minute
+
+// Kinds of WebAuthn ceremony, so a challenge issued for one cannot finish
+// another
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+	ceremonyMFA      = "mfa"
+)
+
+var (
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrInvalidPasskey       = errors.New("passkey verification failed")
+	ErrWebAuthnSessionGone  = errors.New("invalid or expired WebAuthn session")
+	ErrWebAuthnNotAvailable = errors.New("no passkeys registered for this account")
+)
+
+// WebAuthnChallenge starts a ceremony in the browser. Options is passed to
+// navigator.credentials.create() or get(); SessionID comes back with the
+// authenticator's response.
+type WebAuthnChallenge struct {
+	SessionID string      `json:"session_id"`
+	Options   interface{} `json:"options"`
+}
+
+// webAuthnCeremony is the server half of a challenge, kept until the
+// browser answers or it expires
+type webAuthnCeremony struct {
+	kind      string
+	userID    uint // Zero for passwordless logins, where the user is not yet known
+	session   webauthn.SessionData
+	expiresAt time.Time
+}
+
+// webAuthnUser adapts a user and their passkeys to the webauthn library
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return webAuthnUserHandle(u.user.ID)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for j, t := range c.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+		credentials[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				UserVerified:   c.UserVerified,
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+	return credentials
+}
+
+// BeginPasskeyRegistration asks the browser to create a new credential. It
+// prefers a discoverable one so it can also be used for passwordless login.
+func (s *authService) BeginPasskeyRegistration(userID uint) (*WebAuthnChallenge, error) {
+	user, err := s.loadWebAuthnUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, c := range user.WebAuthnCredentials() {
+		exclude = append(exclude, c.Descriptor())
+	}
+
+	options, session, err := s.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclude),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.startCeremony(ceremonyRegister, userID, session, options)
+}
+
+// FinishPasskeyRegistration verifies the attestation and stores the new
+// credential under name
+func (s *authService) FinishPasskeyRegistration(userID uint, sessionID, name string, response io.Reader, remoteAddr string) (*models.WebAuthnCredential, error) {
+	ceremony, err := s.takeCeremony(sessionID, ceremonyRegister)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.userID != userID {
+		return nil, ErrWebAuthnSessionGone
+	}
+	user, err := s.loadWebAuthnUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(response)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+	credential, err := s.webAuthn.CreateCredential(user, ceremony.session, parsed)
+	if err != nil {
+		log.Printf("AuthService: Passkey registration failed for user %d: %v", userID, err)
+		return nil, ErrInvalidPasskey
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+	stored := &models.WebAuthnCredential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		UserVerified:    credential.Flags.UserVerified,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	if err := s.passkeys.Create(stored); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditPasskeyAdd,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"name": name, "passkey_id": stored.ID},
+	})
+	return stored, nil
+}
+
+func (s *authService) ListPasskeys(userID uint) ([]models.WebAuthnCredential, error) {
+	return s.passkeys.ListByUserID(userID)
+}
+
+// DeletePasskey removes a passkey unless it is the last factor standing
+// between the account and the MFA policy
+func (s *authService) DeletePasskey(userID, id uint, remoteAddr string) error {
+	credential, err := s.passkeys.GetByID(id, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrPasskeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if s.mfaRequired(user) && !user.MFAEnabled && user.PasskeyMFA {
+		count, err := s.passkeys.CountByUserID(userID)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrMFARequired
+		}
+	}
+
+	if err := s.passkeys.Delete(credential); err != nil {
+		return err
+	}
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditPasskeyRemove,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		RemoteAddr: remoteAddr,
+		Details:    map[string]interface{}{"name": credential.Name, "passkey_id": credential.ID},
+	})
+	return nil
+}
+
+// BeginPasskeyLogin starts a passwordless login. The browser picks the
+// account from the discoverable credentials it holds.
+func (s *authService) BeginPasskeyLogin() (*WebAuthnChallenge, error) {
+	options, session, err := s.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.startCeremony(ceremonyLogin, 0, session, options)
+}
+
+// FinishPasskeyLogin signs the user in. A user-verified passkey is already
+// two factors, so no further MFA step follows.
+func (s *authService) FinishPasskeyLogin(sessionID string, response io.Reader, remoteAddr string) (*TokenPair, error) {
+	ceremony, err := s.takeCeremony(sessionID, ceremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	var user *webAuthnUser
+	credential, err := s.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, ErrPasskeyNotFound
+		}
+		user, err = s.loadWebAuthnUser(uint(binary.BigEndian.Uint64(userHandle)))
+		return user, err
+	}, ceremony.session, parsed)
+	if err != nil {
+		var userID uint
+		if user != nil {
+			userID = user.user.ID
+		}
+		s.recordLoginFailure(userID, "", remoteAddr, "passkey verification failed")
+		return nil, ErrInvalidPasskey
+	}
+	if err := s.usePasskey(user, credential); err != nil {
+		s.recordLoginFailure(user.user.ID, user.user.Email, remoteAddr, err.Error())
+		return nil, err
+	}
+
+	s.recordLogin(user.user, remoteAddr, MFAMethodWebAuthn)
+	return s.IssueTokens(user.user.ID, remoteAddr)
+}
+
+// BeginPasskeyMFA challenges the user's passkeys as the second factor of a
+// pending login
+func (s *authService) BeginPasskeyMFA(mfaToken string) (*WebAuthnChallenge, error) {
+	claims, err := s.parseMFAToken(mfaToken, mfaPurposeVerify)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.loadWebAuthnUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.credentials) == 0 {
+		return nil, ErrWebAuthnNotAvailable
+	}
+
+	options, session, err := s.webAuthn.BeginLogin(user)
+	if err != nil {
+		return nil, err
+	}
+	return s.startCeremony(ceremonyMFA, claims.UserID, session, options)
+}
+
+// FinishPasskeyMFA completes a pending login with a passkey assertion
+func (s *authService) FinishPasskeyMFA(mfaToken, sessionID string, response io.Reader, remoteAddr string) (*TokenPair, error) {
+	claims, err := s.parseMFAToken(mfaToken, mfaPurposeVerify)
+	if err != nil {
+		return nil, err
+	}
+	ceremony, err := s.takeCeremony(sessionID, ceremonyMFA)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.userID != claims.UserID {
+		return nil, ErrWebAuthnSessionGone
+	}
+	user, err := s.loadWebAuthnUser(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAttempts(s.mfaLimit(claims.UserID)); err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		s.recordMFAFailure(user.user, claims, remoteAddr, ErrInvalidPasskey)
+		return nil, ErrInvalidPasskey
+	}
+	credential, err := s.webAuthn.ValidateLogin(user, ceremony.session, parsed)
+	if err != nil {
+		s.recordMFAFailure(user.user, claims, remoteAddr, ErrInvalidPasskey)
+		return nil, ErrInvalidPasskey
+	}
+	if err := s.usePasskey(user, credential); err != nil {
+		s.recordMFAFailure(user.user, claims, remoteAddr, err)
+		return nil, err
+	}
+
+	s.consumeMFAToken(claims)
+	s.resetAttempts(s.mfaLimit(claims.UserID))
+	s.forgiveLoginFailures(claims.Login)
+	s.recordLogin(user.user, remoteAddr, claims.Method+"+"+MFAMethodWebAuthn)
+	return s.IssueTokens(user.user.ID, remoteAddr)
+}
+
+// usePasskey records a successful assertion. A sign count that went
+// backwards means the authenticator may have been cloned, so it is refused.
+func (s *authService) usePasskey(user *webAuthnUser, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return ErrInvalidPasskey
+	}
+
+	for i := range user.credentials {
+		stored := &user.credentials[i]
+		if string(stored.CredentialID) != string(credential.ID) {
+			continue
+		}
+		now := time.Now()
+		stored.SignCount = credential.Authenticator.SignCount
+		stored.BackupState = credential.Flags.BackupState
+		stored.LastUsedAt = &now
+		return s.passkeys.Update(stored)
+	}
+	return ErrPasskeyNotFound
+}
+
+func (s *authService) loadWebAuthnUser(userID uint) (*webAuthnUser, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := s.passkeys.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+func (s *authService) startCeremony(kind string, userID uint, session *webauthn.SessionData, options interface{}) (*WebAuthnChallenge, error) {
+	id, err := newToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.ceremonyMu.Lock()
+	for key, c := range s.ceremonies {
+		if now.After(c.expiresAt) {
+			delete(s.ceremonies, key)
+		}
+	}
+	s.ceremonies[id] = webAuthnCeremony{
+		kind:      kind,
+		userID:    userID,
+		session:   *session,
+		expiresAt: now.Add(webAuthnCeremonyTTL),
+	}
+	s.ceremonyMu.Unlock()
+
+	return &WebAuthnChallenge{SessionID: id, Options: options}, nil
+}
+
+// takeCeremony removes and returns a pending ceremony, so every challenge
+// can be answered only once
+func (s *authService) takeCeremony(id, kind string) (*webAuthnCeremony, error) {
+	s.ceremonyMu.Lock()
+	ceremony, ok := s.ceremonies[id]
+	delete(s.ceremonies, id)
+	s.ceremonyMu.Unlock()
+
+	if !ok || ceremony.kind != kind || time.Now().After(ceremony.expiresAt) {
+		return nil, ErrWebAuthnSessionGone
+	}
+	return &ceremony, nil
+}
+
+// webAuthnUserHandle is the opaque user ID stored in discoverable credentials
+func webAuthnUserHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// testAuthenticator is a software passkey that answers WebAuthn challenges
+// the way a browser would pass them on
+type testAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{key: key, id: id}
+}
+
+// newTestWebAuthnService is a test auth service that accepts passkeys for
+// testRPID
+func newTestWebAuthnService(t *testing.T) (*authService, *gorm.DB) {
+	t.Helper()
+	auth, db := newTestAuthService(t, newTestConfig())
+	w, err := webauthn.New(&webauthn.Config{RPID: testRPID, RPDisplayName: "SSH Terminal", RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatalf("webauthn.New: %v", err)
+	}
+	auth.webAuthn = w
+	return auth, db
+}
+
+// authData builds authenticator data with the user present and verified
+func (a *testAuthenticator) authData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested != nil {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, kind string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"type": kind, "challenge": challenge.String(), "origin": testOrigin})
+	if err != nil {
+		t.Fatalf("marshal client data: %v", err)
+	}
+	return data
+}
+
+// create answers a registration challenge with a "none" attestation
+func (a *testAuthenticator) create(t *testing.T, challenge *WebAuthnChallenge) *bytes.Reader {
+	t.Helper()
+	options := challenge.Options.(*protocol.CredentialCreation)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	attested := make([]byte, 16) // Zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(append(attested, a.id...), publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(attested),
+	})
+	if err != nil {
+		t.Fatalf("marshal attestation: %v", err)
+	}
+	return a.response(t, map[string]interface{}{
+		"clientDataJSON":    protocol.URLEncodedBase64(clientData(t, "webauthn.create", options.Response.Challenge)),
+		"attestationObject": protocol.URLEncodedBase64(attestation),
+	})
+}
+
+// get answers a login challenge after moving the sign count to signCount
+func (a *testAuthenticator) get(t *testing.T, challenge *WebAuthnChallenge, signCount uint32) *bytes.Reader {
+	t.Helper()
+	options := challenge.Options.(*protocol.CredentialAssertion)
+	a.signCount = signCount
+
+	authData := a.authData(nil)
+	data := clientData(t, "webauthn.get", options.Response.Challenge)
+	dataHash := sha256.Sum256(data)
+	digest := sha256.Sum256(append(authData, dataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("SignASN1: %v", err)
+	}
+	return a.response(t, map[string]interface{}{
+		"clientDataJSON":    protocol.URLEncodedBase64(data),
+		"authenticatorData": protocol.URLEncodedBase64(authData),
+		"signature":         protocol.URLEncodedBase64(signature),
+		"userHandle":        protocol.URLEncodedBase64(a.userHandle),
+	})
+}
+
+func (a *testAuthenticator) response(t *testing.T, response map[string]interface{}) *bytes.Reader {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId":    protocol.URLEncodedBase64(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("marshal credential: %v", err)
+	}
+	return bytes.NewReader(body)
+}
+
+// registerTestPasskey adds a passkey from a new authenticator to user
+func registerTestPasskey(t *testing.T, auth *authService, user *models.User) *testAuthenticator {
+	t.Helper()
+	authenticator := newTestAuthenticator(t)
+	authenticator.userHandle = webAuthnUserHandle(user.ID)
+	challenge, err := auth.BeginPasskeyRegistration(user.ID)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if _, err := auth.FinishPasskeyRegistration(user.ID, challenge.SessionID, "laptop", authenticator.create(t, challenge), ""); err != nil {
+		t.Fatalf("FinishPasskeyRegistration: %v", err)
+	}
+	return authenticator
+}
+
+// passkeyLogin runs a passwordless login with the given sign count
+func passkeyLogin(t *testing.T, auth *authService, authenticator *testAuthenticator, signCount uint32) (*TokenPair, error) {
+	t.Helper()
+	challenge, err := auth.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	return auth.FinishPasskeyLogin(challenge.SessionID, authenticator.get(t, challenge, signCount), "")
+}
+
+func storedSignCount(t *testing.T, auth *authService, userID uint) uint32 {
+	t.Helper()
+	passkeys, err := auth.ListPasskeys(userID)
+	if err != nil || len(passkeys) != 1 {
+		t.Fatalf("ListPasskeys = %v, %v; want one passkey", passkeys, err)
+	}
+	return passkeys[0].SignCount
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	auth, db := newTestWebAuthnService(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	// Another user's registration challenge cannot be answered
+	challenge, err := auth.BeginPasskeyRegistration(user.ID)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if _, err := auth.FinishPasskeyRegistration(user.ID+1, challenge.SessionID, "", newTestAuthenticator(t).create(t, challenge), ""); !errors.Is(err, ErrWebAuthnSessionGone) {
+		t.Errorf("finishing another user's registration: got %v, want ErrWebAuthnSessionGone", err)
+	}
+
+	authenticator := registerTestPasskey(t, auth, user)
+	tokens, err := passkeyLogin(t, auth, authenticator, 7)
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+	if tokens.AccessToken == "" {
+		t.Error("no access token after passkey login")
+	}
+	if got := storedSignCount(t, auth, user.ID); got != 7 {
+		t.Errorf("stored sign count %d, want 7", got)
+	}
+}
+
+func TestPasskeyChallengeWorksOnce(t *testing.T) {
+	auth, db := newTestWebAuthnService(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	authenticator := registerTestPasskey(t, auth, user)
+
+	challenge, err := auth.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	if _, err := auth.FinishPasskeyLogin(challenge.SessionID, authenticator.get(t, challenge, 1), ""); err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+	if _, err := auth.FinishPasskeyLogin(challenge.SessionID, authenticator.get(t, challenge, 2), ""); !errors.Is(err, ErrWebAuthnSessionGone) {
+		t.Errorf("answering the challenge again: got %v, want ErrWebAuthnSessionGone", err)
+	}
+
+	// A login challenge cannot finish a registration
+	challenge, err = auth.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	if _, err := auth.FinishPasskeyRegistration(user.ID, challenge.SessionID, "", bytes.NewReader(nil), ""); !errors.Is(err, ErrWebAuthnSessionGone) {
+		t.Errorf("registering with a login challenge: got %v, want ErrWebAuthnSessionGone", err)
+	}
+}
+
+func TestPasskeyLoginRefusesSignCountGoingBack(t *testing.T) {
+	auth, db := newTestWebAuthnService(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	authenticator := registerTestPasskey(t, auth, user)
+
+	if _, err := passkeyLogin(t, auth, authenticator, 5); err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+	// A copy of the key that has signed less often than the original
+	for _, count := range []uint32{5, 3} {
+		if _, err := passkeyLogin(t, auth, authenticator, count); !errors.Is(err, ErrInvalidPasskey) {
+			t.Errorf("sign count %d after 5: got %v, want ErrInvalidPasskey", count, err)
+		}
+	}
+	if got := storedSignCount(t, auth, user.ID); got != 5 {
+		t.Errorf("stored sign count %d, want 5", got)
+	}
+}
+
+func TestPasskeyAsSecondFactor(t *testing.T) {
+	auth, db := newTestWebAuthnService(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	if err := auth.SetPasskeyMFA(user.ID, true, ""); !errors.Is(err, ErrWebAuthnNotAvailable) {
+		t.Errorf("SetPasskeyMFA without passkeys: got %v, want ErrWebAuthnNotAvailable", err)
+	}
+	authenticator := registerTestPasskey(t, auth, user)
+	if err := auth.SetPasskeyMFA(user.ID, true, ""); err != nil {
+		t.Fatalf("SetPasskeyMFA: %v", err)
+	}
+
+	mfaToken := loginForMFA(t, auth, "alice@example.com", "correct horse")
+	challenge, err := auth.BeginPasskeyMFA(mfaToken)
+	if err != nil {
+		t.Fatalf("BeginPasskeyMFA: %v", err)
+	}
+	if _, err := auth.FinishPasskeyMFA(mfaToken, challenge.SessionID, authenticator.get(t, challenge, 1), ""); err != nil {
+		t.Fatalf("FinishPasskeyMFA: %v", err)
+	}
+
+	// The pending login is spent
+	if _, err := auth.BeginPasskeyMFA(mfaToken); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Errorf("reusing the pending token: got %v, want ErrInvalidMFAToken", err)
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// isAdmin reports whether user is one of the configured admins. The address
+// only counts once it is verified, so registering an admin's unclaimed email
+// grants nothing.
+func isAdmin(cfg *config.Config, user *models.User) bool {
+	return user.EmailVerifiedAt != nil && cfg.IsAdminEmail(user.Email)
+}
+
+// requireAdmin loads userID and refuses anyone who isn't an admin
+func requireAdmin(users repository.UserRepository, cfg *config.Config, userID uint) (*models.User, error) {
+	user, err := users.FindByID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isAdmin(cfg, user) {
+		return nil, ErrPermissionDenied
+	}
+	return user, nil
+}
+
+// Permission is an action a user may take on a connection or team
+type Permission string
+
+const (
+	// Connection permissions
+	PermView          Permission = "view"           // See the connection and its host key
+	PermConnect       Permission = "connect"        // Open terminals and SFTP sessions
+	PermEdit          Permission = "edit"           // Change settings, credentials and host keys
+	PermDelete        Permission = "delete"         // Delete the connection
+	PermManageSharing Permission = "manage-sharing" // Move it between teams, share live sessions
+
+	// Team permissions
+	PermManageMembers Permission = "manage-members" // Add, remove and change members
+	PermManageTeam    Permission = "manage-team"    // Rename or delete the team
+)
+
+// rolePermissions lists what each team role grants on the team and on the
+// connections shared with it
+var rolePermissions = map[string][]Permission{
+	models.TeamRoleOwner:    {PermView, PermConnect, PermEdit, PermDelete, PermManageSharing, PermManageMembers, PermManageTeam},
+	models.TeamRoleAdmin:    {PermView, PermConnect, PermEdit, PermDelete, PermManageSharing, PermManageMembers},
+	models.TeamRoleOperator: {PermView, PermConnect},
+	models.TeamRoleViewer:   {PermView},
+}
+
+// roleRank orders team roles from least to most privileged
+var roleRank = map[string]int{
+	models.TeamRoleViewer:   1,
+	models.TeamRoleOperator: 2,
+	models.TeamRoleAdmin:    3,
+	models.TeamRoleOwner:    4,
+}
+
+// ownerPermissions apply to a user's personal connections
+var ownerPermissions = []Permission{PermView, PermConnect, PermEdit, PermDelete, PermManageSharing}
+
+// Authorizer decides what a user may do with connections and teams. Denials
+// for things the user cannot see at all are reported as not found.
+type Authorizer interface {
+	AuthorizeConnection(connID, userID uint, perm Permission) (*models.SSHConnection, error)
+	AuthorizeTeam(teamID, userID uint, perm Permission) (*models.TeamMember, error)
+	ConnectionPermissions(conn *models.SSHConnection, userID uint) []Permission
+}
+
+type authorizer struct {
+	sshRepo  repository.SSHRepository
+	teamRepo repository.TeamRepository
+}
+
+func NewAuthorizer(sshRepo repository.SSHRepository, teamRepo repository.TeamRepository) Authorizer {
+	return &authorizer{
+		sshRepo:  sshRepo,
+		teamRepo: teamRepo,
+	}
+}
+
+func (a *authorizer) AuthorizeConnection(connID, userID uint, perm Permission) (*models.SSHConnection, error) {
+	conn, err := a.sshRepo.GetByID(connID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrConnectionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasPermission(a.ConnectionPermissions(conn, userID), perm) {
+		return nil, fmt.Errorf("%w: %s on connection %q", ErrPermissionDenied, perm, conn.Name)
+	}
+	return conn, nil
+}
+
+func (a *authorizer) AuthorizeTeam(teamID, userID uint, perm Permission) (*models.TeamMember, error) {
+	member, err := a.teamRepo.GetMember(teamID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTeamNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasPermission(rolePermissions[member.Role], perm) {
+		return nil, fmt.Errorf("%w: %s on team %d", ErrPermissionDenied, perm, teamID)
+	}
+	return member, nil
+}
+
+func (a *authorizer) ConnectionPermissions(conn *models.SSHConnection, userID uint) []Permission {
+	if conn.TeamID == nil {
+		if conn.UserID == userID {
+			return ownerPermissions
+		}
+		return nil
+	}
+
+	member, err := a.teamRepo.GetMember(*conn.TeamID, userID)
+	if err != nil {
+		return nil
+	}
+
+	// Team-level permissions don't apply to a single connection
+	var perms []Permission
+	for _, perm := range rolePermissions[member.Role] {
+		if hasPermission(ownerPermissions, perm) {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
+
+func hasPermission(perms []Permission, perm Permission) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
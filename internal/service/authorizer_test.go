@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+)
+
+func TestAuthorizeConnection(t *testing.T) {
+	db := newTestDB(t)
+	teams := repository.NewTeamRepository(db)
+	authz := NewAuthorizer(repository.NewSSHRepository(db), teams)
+
+	users := map[string]*models.User{}
+	for _, name := range []string{"owner", "admin", "operator", "viewer", "outsider"} {
+		users[name] = createTestUser(t, db, name+"@example.com", "correct horse")
+	}
+	team := &models.Team{Name: "ops"}
+	if err := teams.Create(team, users["owner"].ID); err != nil {
+		t.Fatalf("create team: %v", err)
+	}
+	for _, role := range []string{models.TeamRoleAdmin, models.TeamRoleOperator, models.TeamRoleViewer} {
+		if err := teams.SaveMember(&models.TeamMember{TeamID: team.ID, UserID: users[role].ID, Role: role}); err != nil {
+			t.Fatalf("add %s: %v", role, err)
+		}
+	}
+
+	personal := &models.SSHConnection{UserID: users["operator"].ID, Name: "laptop", Host: "10.0.0.1", Username: "me", AuthType: "password"}
+	shared := &models.SSHConnection{UserID: users["admin"].ID, TeamID: &team.ID, Name: "db", Host: "10.0.0.2", Username: "ops", AuthType: "password"}
+	for _, conn := range []*models.SSHConnection{personal, shared} {
+		if err := db.Create(conn).Error; err != nil {
+			t.Fatalf("create connection: %v", err)
+		}
+	}
+
+	all := []Permission{PermView, PermConnect, PermEdit, PermDelete, PermManageSharing}
+	tests := []struct {
+		user    string
+		conn    *models.SSHConnection
+		allowed []Permission // nil when the connection isn't visible at all
+	}{
+		{"operator", personal, all},
+		{"owner", personal, nil},
+		{"owner", shared, all},
+		{"admin", shared, all},
+		{"operator", shared, []Permission{PermView, PermConnect}},
+		{"viewer", shared, []Permission{PermView}},
+		{"outsider", shared, nil},
+	}
+	for _, tt := range tests {
+		for _, perm := range all {
+			_, err := authz.AuthorizeConnection(tt.conn.ID, users[tt.user].ID, perm)
+			switch {
+			case tt.allowed == nil:
+				if !errors.Is(err, ErrConnectionNotFound) {
+					t.Errorf("%s %s on %s: got %v, want ErrConnectionNotFound", tt.user, perm, tt.conn.Name, err)
+				}
+			case hasPermission(tt.allowed, perm):
+				if err != nil {
+					t.Errorf("%s %s on %s: %v", tt.user, perm, tt.conn.Name, err)
+				}
+			default:
+				if !errors.Is(err, ErrPermissionDenied) {
+					t.Errorf("%s %s on %s: got %v, want ErrPermissionDenied", tt.user, perm, tt.conn.Name, err)
+				}
+			}
+		}
+	}
+
+	// Leaving the team takes its connections away, even the ones created
+	if err := teams.DeleteMember(team.ID, users["admin"].ID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+	if _, err := authz.AuthorizeConnection(shared.ID, users["admin"].ID, PermView); !errors.Is(err, ErrConnectionNotFound) {
+		t.Errorf("former member viewing the team's connection: got %v, want ErrConnectionNotFound", err)
+	}
+}
+
+func TestAuthorizeTeam(t *testing.T) {
+	db := newTestDB(t)
+	teams := repository.NewTeamRepository(db)
+	authz := NewAuthorizer(repository.NewSSHRepository(db), teams)
+
+	owner := createTestUser(t, db, "owner@example.com", "correct horse")
+	outsider := createTestUser(t, db, "outsider@example.com", "correct horse")
+	team := &models.Team{Name: "ops"}
+	if err := teams.Create(team, owner.ID); err != nil {
+		t.Fatalf("create team: %v", err)
+	}
+
+	tests := []struct {
+		role    string
+		allowed []Permission
+	}{
+		{models.TeamRoleOwner, []Permission{PermManageMembers, PermManageTeam}},
+		{models.TeamRoleAdmin, []Permission{PermManageMembers}},
+		{models.TeamRoleOperator, nil},
+		{models.TeamRoleViewer, nil},
+	}
+	for _, tt := range tests {
+		user := owner
+		if tt.role != models.TeamRoleOwner {
+			user = createTestUser(t, db, tt.role+"@example.com", "correct horse")
+			if err := teams.SaveMember(&models.TeamMember{TeamID: team.ID, UserID: user.ID, Role: tt.role}); err != nil {
+				t.Fatalf("add %s: %v", tt.role, err)
+			}
+		}
+		for _, perm := range []Permission{PermManageMembers, PermManageTeam} {
+			_, err := authz.AuthorizeTeam(team.ID, user.ID, perm)
+			if hasPermission(tt.allowed, perm) {
+				if err != nil {
+					t.Errorf("%s %s: %v", tt.role, perm, err)
+				}
+			} else if !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("%s %s: got %v, want ErrPermissionDenied", tt.role, perm, err)
+			}
+		}
+	}
+
+	if _, err := authz.AuthorizeTeam(team.ID, outsider.ID, PermManageTeam); !errors.Is(err, ErrTeamNotFound) {
+		t.Errorf("outsider: got %v, want ErrTeamNotFound", err)
+	}
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/repository"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrInvalidUpload = errors.New("invalid upload")
+)
+
+// UploadSource yields the files of an upload in turn. Next returns io.EOF
+// after the last one; other errors should wrap ErrInvalidUpload or
+// ErrInvalidPath when the request itself is at fault.
+type UploadSource interface {
+	Next() (name string, r io.Reader, err error)
+}
+
+// FileInfo describes a remote file or directory
+type FileInfo struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Mode      string    `json:"mode"`
+	Perm      string    `json:"perm"` // Octal permission bits, e.g. "0644"
+	IsDir     bool      `json:"is_dir"`
+	IsSymlink bool      `json:"is_symlink"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+type FileService interface {
+	List(connID, userID uint, dir string) (string, []FileInfo, error)
+	Stat(connID, userID uint, p string) (*FileInfo, error)
+	// Open streams a remote file; closing the reader ends the SFTP session
+	Open(connID, userID uint, p string) (io.ReadCloser, *FileInfo, error)
+	// Upload writes every file of src into dir over a single SFTP session
+	Upload(connID, userID uint, dir string, src UploadSource) ([]FileInfo, error)
+	Rename(connID, userID uint, from, to string) error
+	Chmod(connID, userID uint, p string, mode os.FileMode) error
+	Mkdir(connID, userID uint, p string) error
+	Delete(connID, userID uint, p string, recursive bool) error
+}
+
+type fileService struct {
+	dialer   *sshDialer
+	userRepo repository.UserRepository
+	cfg      *config.Config
+}
+
+func NewFileService(sshService SSHService, hostKeys HostKeyService, ca SSHCAService, userRepo repository.UserRepository, cfg *config.Config) FileService {
+	return &fileService{
+		dialer:   newSSHDialer(sshService, hostKeys, ca, nil),
+		userRepo: userRepo,
+		cfg:      cfg,
+	}
+}
+
+// sftpSession bundles an SFTP client with the SSH client it runs over
+type sftpSession struct {
+	*sftp.Client
+	ssh *ssh.Client
+}
+
+func (s *sftpSession) Close() error {
+	err := s.Client.Close()
+	if sshErr := s.ssh.Close(); err == nil {
+		err = sshErr
+	}
+	return err
+}
+
+func (s *fileService) open(connID, userID uint) (*sftpSession, error) {
+	if err := checkEmailVerified(s.userRepo, s.cfg, userID); err != nil {
+		return nil, err
+	}
+
+	sshClient, _, err := s.dialer.Dial(connID, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %v", err)
+	}
+
+	return &sftpSession{Client: client, ssh: sshClient}, nil
+}
+
+func (s *fileService) List(connID, userID uint, dir string) (string, []FileInfo, error) {
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	defer session.Close()
+
+	// Default to the remote user's home directory
+	if dir == "" {
+		dir = "."
+	}
+	dir, err = session.RealPath(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entries, err := session.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	files := make([]FileInfo, len(entries))
+	for i, entry := range entries {
+		files[i] = newFileInfo(path.Join(dir, entry.Name()), entry)
+	}
+
+	// Directories first, then by name
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		return files[i].Name < files[j].Name
+	})
+
+	return dir, files, nil
+}
+
+func (s *fileService) Stat(connID, userID uint, p string) (*FileInfo, error) {
+	if p == "" {
+		return nil, ErrInvalidPath
+	}
+
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	info, err := session.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo := newFileInfo(p, info)
+	return &fileInfo, nil
+}
+
+// remoteFile closes the SFTP session along with the file
+type remoteFile struct {
+	*sftp.File
+	session *sftpSession
+}
+
+func (f *remoteFile) Close() error {
+	err := f.File.Close()
+	if sessionErr := f.session.Close(); err == nil {
+		err = sessionErr
+	}
+	return err
+}
+
+func (s *fileService) Open(connID, userID uint, p string) (io.ReadCloser, *FileInfo, error) {
+	if p == "" {
+		return nil, nil, ErrInvalidPath
+	}
+
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := session.Open(p)
+	if err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		session.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		session.Close()
+		return nil, nil, fmt.Errorf("%w: %s is a directory", ErrInvalidPath, p)
+	}
+
+	fileInfo := newFileInfo(p, info)
+	return &remoteFile{File: file, session: session}, &fileInfo, nil
+}
+
+func (s *fileService) Upload(connID, userID uint, dir string, src UploadSource) ([]FileInfo, error) {
+	if dir == "" {
+		return nil, ErrInvalidPath
+	}
+
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	uploaded := []FileInfo{}
+	for {
+		name, r, err := src.Next()
+		if err == io.EOF {
+			return uploaded, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := uploadFile(session, path.Join(dir, name), r)
+		if err != nil {
+			return nil, err
+		}
+		uploaded = append(uploaded, *info)
+	}
+}
+
+// uploadFile writes r to p. Closing the file flushes the last writes, so its
+// error counts as much as the copy's.
+func uploadFile(session *sftpSession, p string, r io.Reader) (*FileInfo, error) {
+	file, err := session.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	fileInfo := newFileInfo(p, info)
+	return &fileInfo, nil
+}
+
+func (s *fileService) Rename(connID, userID uint, from, to string) error {
+	if from == "" || to == "" {
+		return ErrInvalidPath
+	}
+
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return session.Rename(from, to)
+}
+
+func (s *fileService) Chmod(connID, userID uint, p string, mode os.FileMode) error {
+	if p == "" {
+		return ErrInvalidPath
+	}
+
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return session.Chmod(p, mode)
+}
+
+func (s *fileService) Mkdir(connID, userID uint, p string) error {
+	if p == "" {
+		return ErrInvalidPath
+	}
+
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return session.MkdirAll(p)
+}
+
+func (s *fileService) Delete(connID, userID uint, p string, recursive bool) error {
+	if p == "" || path.Clean(p) == "/" {
+		return ErrInvalidPath
+	}
+
+	session, err := s.open(connID, userID)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	info, err := session.Lstat(p)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() && recursive {
+		return removeAll(session.Client, p)
+	}
+	return session.Remove(p)
+}
+
+// removeAll deletes a remote directory tree depth-first
+func removeAll(client *sftp.Client, dir string) error {
+	entries, err := client.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		p := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			err = removeAll(client, p)
+		} else {
+			err = client.Remove(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.RemoveDirectory(dir)
+}
+
+func newFileInfo(p string, info os.FileInfo) FileInfo {
+	return FileInfo{
+		Name:      info.Name(),
+		Path:      p,
+		Size:      info.Size(),
+		Mode:      info.Mode().String(),
+		Perm:      fmt.Sprintf("%04o", info.Mode().Perm()),
+		IsDir:     info.IsDir(),
+		IsSymlink: info.Mode()&os.ModeSymlink != 0,
+		ModTime:   info.ModTime(),
+	}
+}
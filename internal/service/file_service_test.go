@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"ssh-terminal-app/internal/repository"
+)
+
+// testUpload is an upload of named files with string contents
+type testUpload []struct{ name, data string }
+
+func (u *testUpload) Next() (string, io.Reader, error) {
+	if len(*u) == 0 {
+		return "", nil, io.EOF
+	}
+	next := (*u)[0]
+	*u = (*u)[1:]
+	return next.name, strings.NewReader(next.data), nil
+}
+
+// newTestFileService returns a file service and a user with a connection to
+// a new test SSH server
+func newTestFileService(t *testing.T) (*fileService, *testSSHServer, uint, uint) {
+	t.Helper()
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	server := newTestSSHServer(t)
+	conn := createTestServerConnection(t, ts, user.ID, "files", server)
+
+	s := &fileService{dialer: newTestDialer(ts, nil), userRepo: repository.NewUserRepository(db), cfg: newTestConfig()}
+	return s, server, conn.ID, user.ID
+}
+
+func fileNames(files []FileInfo) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	return names
+}
+
+func TestFileServiceManagesRemoteFiles(t *testing.T) {
+	s, server, connID, userID := newTestFileService(t)
+	root := server.root
+
+	upload := &testUpload{{"notes.txt", "hello"}, {"b.txt", "bee"}}
+	uploaded, err := s.Upload(connID, userID, root, upload)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(uploaded) != 2 || uploaded[0].Size != 5 {
+		t.Errorf("Upload = %+v, want two files with notes.txt 5 bytes", uploaded)
+	}
+	if err := s.Mkdir(connID, userID, path.Join(root, "sub", "deep")); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	dir, files, err := s.List(connID, userID, root)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if dir != root || strings.Join(fileNames(files), ",") != "sub,b.txt,notes.txt" {
+		t.Errorf("List = %s %q, want %s with directories first", dir, fileNames(files), root)
+	}
+
+	r, info, err := s.Open(connID, userID, path.Join(root, "notes.txt"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "hello" || info.Size != 5 {
+		t.Errorf("Open read %q, %v (size %d), want hello", data, err, info.Size)
+	}
+
+	moved := path.Join(root, "sub", "moved.txt")
+	if err := s.Rename(connID, userID, path.Join(root, "notes.txt"), moved); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := s.Chmod(connID, userID, moved, 0600); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if info, err := s.Stat(connID, userID, moved); err != nil || info.Perm != "0600" {
+		t.Errorf("Stat = %+v, %v; want perm 0600", info, err)
+	}
+
+	if err := s.Delete(connID, userID, path.Join(root, "sub"), false); err == nil {
+		t.Error("deleted a non-empty directory without recursive")
+	}
+	if err := s.Delete(connID, userID, path.Join(root, "sub"), true); err != nil {
+		t.Fatalf("Delete recursive: %v", err)
+	}
+	if _, err := os.Stat(path.Join(root, "sub")); !os.IsNotExist(err) {
+		t.Errorf("sub still exists after Delete: %v", err)
+	}
+}
+
+func TestFileServiceRefusesBadPathsAndOtherUsers(t *testing.T) {
+	s, server, connID, userID := newTestFileService(t)
+
+	checks := map[string]error{
+		"delete root":        s.Delete(connID, userID, "/", true),
+		"delete empty":       s.Delete(connID, userID, "", false),
+		"rename empty":       s.Rename(connID, userID, "", "/tmp/x"),
+		"chmod empty":        s.Chmod(connID, userID, "", 0600),
+		"mkdir empty":        s.Mkdir(connID, userID, ""),
+		"upload without dir": func() error { _, err := s.Upload(connID, userID, "", &testUpload{}); return err }(),
+	}
+	for name, err := range checks {
+		if !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("%s: got %v, want ErrInvalidPath", name, err)
+		}
+	}
+
+	if _, _, err := s.List(connID, userID+1, server.root); !errors.Is(err, ErrConnectionNotFound) {
+		t.Errorf("another user's connection: got %v, want ErrConnectionNotFound", err)
+	}
+}
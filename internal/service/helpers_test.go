@@ -0,0 +1,382 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/database"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+	"ssh-terminal-app/internal/utils"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens an empty database with the app's schema. Queries aren't
+// logged.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	logger.Default = logger.Discard
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("DB: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		JWTAlgorithm:     "EdDSA",
+		JWTIssuer:        "ssh-terminal-test",
+		JWTKeyRotation:   30 * 24 * time.Hour,
+		AccessTokenTTL:   15 * time.Minute,
+		RefreshTokenTTL:  24 * time.Hour,
+		PATDefaultTTL:    24 * time.Hour,
+		PATMaxTTL:        48 * time.Hour,
+		MFAIssuer:        "SSH Terminal",
+		LoginMaxFailures: 5,
+		LoginLockout:     15 * time.Minute,
+		LoginBackoffMax:  time.Minute,
+	}
+}
+
+func newTestKeyring(t *testing.T) *utils.Keyring {
+	t.Helper()
+	keyring, err := utils.NewKeyring("test", "a master key only the tests use", nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return keyring
+}
+
+// newTestAuthService wires an authService to a fresh database, without
+// identity providers, a directory or passkeys
+func newTestAuthService(t *testing.T, cfg *config.Config) (*authService, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	keyring := newTestKeyring(t)
+	users := repository.NewUserRepository(db)
+
+	signer, err := NewTokenSigner(repository.NewSigningKeyRepository(db), keyring, cfg)
+	if err != nil {
+		t.Fatalf("NewTokenSigner: %v", err)
+	}
+	mailer, err := NewMailer(config.MailConfig{Driver: "none", From: "noreply@example.com"})
+	if err != nil {
+		t.Fatalf("NewMailer: %v", err)
+	}
+	audit := NewAuditService(repository.NewAuditRepository(db), users, cfg)
+
+	auth := NewAuthService(users, repository.NewTokenRepository(db), signer,
+		repository.NewAccessTokenRepository(db), repository.NewRecoveryCodeRepository(db),
+		repository.NewEmailTokenRepository(db), repository.NewWebAuthnRepository(db),
+		repository.NewIdentityRepository(db), repository.NewTeamRepository(db),
+		NewMemoryAttemptStore(), audit, mailer, keyring, cfg, nil, nil, nil)
+	return auth.(*authService), db
+}
+
+// createTestUser stores a verified user with a password
+func createTestUser(t *testing.T, db *gorm.DB, email, password string) *models.User {
+	t.Helper()
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	now := time.Now()
+	user := &models.User{Email: email, Name: email, Password: hashed, EmailVerifiedAt: &now}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// newTestWebSocket connects a client to a new test server and returns both
+// ends of the connection
+func newTestWebSocket(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- ws
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server = <-conns
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+// createTestConnection stores a personal password connection
+func createTestConnection(t *testing.T, db *gorm.DB, userID uint, name, host string) *models.SSHConnection {
+	t.Helper()
+	conn := &models.SSHConnection{UserID: userID, Name: name, Host: host, Port: 22, Username: "me", AuthType: "password"}
+	if err := db.Create(conn).Error; err != nil {
+		t.Fatalf("create connection: %v", err)
+	}
+	return conn
+}
+
+// testSSHServices are the connection and identity services over one
+// database, sharing a secret store sealed with a test keyring
+type testSSHServices struct {
+	db         *gorm.DB
+	ssh        *sshService
+	identities SSHIdentityService
+	teams      repository.TeamRepository
+	secrets    SecretStore
+}
+
+func newTestSSHServices(t *testing.T, db *gorm.DB) *testSSHServices {
+	t.Helper()
+	cfg := newTestConfig()
+	sshRepo := repository.NewSSHRepository(db)
+	identityRepo := repository.NewSSHIdentityRepository(db)
+	teams := repository.NewTeamRepository(db)
+	audit := NewAuditService(repository.NewAuditRepository(db), repository.NewUserRepository(db), cfg)
+	secrets := &localSecretStore{keyring: newTestKeyring(t)}
+
+	return &testSSHServices{
+		db:         db,
+		ssh:        NewSSHService(sshRepo, identityRepo, NewAuthorizer(sshRepo, teams), audit, secrets, cfg).(*sshService),
+		identities: NewSSHIdentityService(identityRepo, audit, secrets),
+		teams:      teams,
+		secrets:    secrets,
+	}
+}
+
+// createTestTeam makes a team owned by owner with the other users in the
+// given roles
+func createTestTeam(t *testing.T, teams repository.TeamRepository, name string, owner uint, members map[uint]string) *models.Team {
+	t.Helper()
+	team := &models.Team{Name: name}
+	if err := teams.Create(team, owner); err != nil {
+		t.Fatalf("create team: %v", err)
+	}
+	for userID, role := range members {
+		if err := teams.SaveMember(&models.TeamMember{TeamID: team.ID, UserID: userID, Role: role}); err != nil {
+			t.Fatalf("add team member: %v", err)
+		}
+	}
+	return team
+}
+
+const testSSHPassword = "open sesame"
+
+// testSSHServer is an SSH server on localhost for user "me", who logs in
+// with testSSHPassword or an authorized key. It serves SFTP from a temporary
+// directory and forwards direct-tcpip channels, so it can be a jump host.
+type testSSHServer struct {
+	port    int
+	root    string
+	hostKey ssh.Signer
+
+	mu         sync.Mutex
+	authorized []ssh.PublicKey
+	forwarded  []string // Addresses direct-tcpip channels were opened to
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server := &testSSHServer{port: listener.Addr().(*net.TCPAddr).Port, root: t.TempDir(), hostKey: hostKey}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "me" && string(password) == testSSHPassword {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			for _, authorized := range server.authorized {
+				if c.User() == "me" && string(authorized.Marshal()) == string(key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("key not authorized")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		listener.Close()
+		wg.Wait()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				server.serve(conn, config)
+			}()
+		}
+	}()
+	return server
+}
+
+// authorize lets key log in as "me"
+func (s *testSSHServer) authorize(key ssh.PublicKey) {
+	s.mu.Lock()
+	s.authorized = append(s.authorized, key)
+	s.mu.Unlock()
+}
+
+func (s *testSSHServer) forwardedTo() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.forwarded...)
+}
+
+func (s *testSSHServer) serve(netConn net.Conn, config *ssh.ServerConfig) {
+	defer netConn.Close()
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.serveSession(newChannel)
+		case "direct-tcpip":
+			go s.forward(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+// serveSession only offers the sftp subsystem
+func (s *testSSHServer) serveSession(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	for req := range reqs {
+		var subsystem struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(s.root))
+		if err != nil {
+			return
+		}
+		server.Serve()
+		server.Close()
+		return
+	}
+}
+
+func (s *testSSHServer) forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "bad request")
+		return
+	}
+	addr := net.JoinHostPort(target.Host, fmt.Sprint(target.Port))
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, addr)
+	s.mu.Unlock()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+// createTestServerConnection saves a password connection to server for
+// userID
+func createTestServerConnection(t *testing.T, ts *testSSHServices, userID uint, name string, server *testSSHServer) *models.SSHConnection {
+	t.Helper()
+	conn, err := ts.ssh.Create(userID, SSHConnectionRequest{
+		Name:     name,
+		Host:     "127.0.0.1",
+		Port:     server.port,
+		Username: "me",
+		Password: testSSHPassword,
+		AuthType: "password",
+	})
+	if err != nil {
+		t.Fatalf("create connection: %v", err)
+	}
+	return conn
+}
+
+// newTestDialer dials with ts's connections, learning host keys on first use
+func newTestDialer(ts *testSSHServices, attempts AttemptStore) *sshDialer {
+	hostKeys := NewHostKeyService(repository.NewKnownHostRepository(ts.db), repository.NewSSHRepository(ts.db))
+	return newSSHDialer(ts.ssh, hostKeys, nil, attempts)
+}
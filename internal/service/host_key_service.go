@@ -0,0 +1,203 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoHostKey        = errors.New("no host key recorded for this connection")
+	ErrNoPendingHostKey = errors.New("no changed host key to accept")
+	ErrInvalidHostKey   = errors.New("invalid public key")
+)
+
+// HostKeyMismatchError is returned when a server presents a key that differs
+// from the one recorded for the connection.
+type HostKeyMismatchError struct {
+	ConnectionID         uint
+	Host                 string
+	PreviousHost         string // Set when the connection was re-pointed since the key was recorded
+	ExpectedFingerprint  string
+	PresentedFingerprint string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	if e.PreviousHost != "" {
+		return fmt.Sprintf("connection moved from %s to %s, whose host key %s has not been accepted", e.PreviousHost, e.Host, e.PresentedFingerprint)
+	}
+	return fmt.Sprintf("host key for %s has changed (expected %s, got %s)", e.Host, e.ExpectedFingerprint, e.PresentedFingerprint)
+}
+
+type HostKeyService interface {
+	Get(connID, userID uint) (*models.KnownHost, error)
+	Pin(connID, userID uint, publicKey string) (*models.KnownHost, error)
+	AcceptChanged(connID, userID uint) (*models.KnownHost, error)
+	Clear(connID, userID uint) error
+	// Callback returns a trust-on-first-use host key check bound to the connection
+	Callback(conn *models.SSHConnection) ssh.HostKeyCallback
+}
+
+type hostKeyService struct {
+	repo    repository.KnownHostRepository
+	sshRepo repository.SSHRepository
+}
+
+func NewHostKeyService(repo repository.KnownHostRepository, sshRepo repository.SSHRepository) HostKeyService {
+	return &hostKeyService{
+		repo:    repo,
+		sshRepo: sshRepo,
+	}
+}
+
+func (s *hostKeyService) Get(connID, userID uint) (*models.KnownHost, error) {
+	if _, err := s.getConnection(connID, userID); err != nil {
+		return nil, err
+	}
+
+	known, err := s.repo.GetByConnectionID(connID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoHostKey
+	}
+	return known, err
+}
+
+func (s *hostKeyService) Pin(connID, userID uint, publicKey string) (*models.KnownHost, error) {
+	conn, err := s.getConnection(connID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHostKey, err)
+	}
+
+	known, err := s.repo.GetByConnectionID(connID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		known = &models.KnownHost{ConnectionID: connID}
+	}
+
+	setHostKey(known, conn, key)
+	known.Pinned = true
+
+	if err := s.repo.Save(known); err != nil {
+		return nil, err
+	}
+	return known, nil
+}
+
+func (s *hostKeyService) AcceptChanged(connID, userID uint) (*models.KnownHost, error) {
+	conn, err := s.getConnection(connID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	known, err := s.repo.GetByConnectionID(connID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoHostKey
+		}
+		return nil, err
+	}
+	if known.PendingPublicKey == "" {
+		return nil, ErrNoPendingHostKey
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(known.PendingPublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	setHostKey(known, conn, key)
+
+	if err := s.repo.Save(known); err != nil {
+		return nil, err
+	}
+	return known, nil
+}
+
+func (s *hostKeyService) Clear(connID, userID uint) error {
+	if _, err := s.getConnection(connID, userID); err != nil {
+		return err
+	}
+	return s.repo.DeleteByConnectionID(connID)
+}
+
+func (s *hostKeyService) Callback(conn *models.SSHConnection) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		known, err := s.repo.GetByConnectionID(conn.ID)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			// First connection: trust and remember the presented key
+			known = &models.KnownHost{ConnectionID: conn.ID}
+			setHostKey(known, conn, key)
+			return s.repo.Save(known)
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if known.Fingerprint == fingerprint {
+			return nil
+		}
+
+		// Anyone who can edit the connection can re-point it, so the new
+		// endpoint's key is held for the user to accept like a changed one
+		previousHost := ""
+		if known.Host != conn.Host || known.Port != conn.Port {
+			previousHost = fmt.Sprintf("%s:%d", known.Host, known.Port)
+		}
+
+		now := time.Now()
+		known.PendingPublicKey = marshalHostKey(key)
+		known.PendingFingerprint = fingerprint
+		known.PendingSeenAt = &now
+		if err := s.repo.Save(known); err != nil {
+			return err
+		}
+
+		return &HostKeyMismatchError{
+			ConnectionID:         conn.ID,
+			Host:                 fmt.Sprintf("%s:%d", conn.Host, conn.Port),
+			PreviousHost:         previousHost,
+			ExpectedFingerprint:  known.Fingerprint,
+			PresentedFingerprint: fingerprint,
+		}
+	}
+}
+
+func (s *hostKeyService) getConnection(connID, userID uint) (*models.SSHConnection, error) {
+	conn, err := s.sshRepo.GetByID(connID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrConnectionNotFound
+	}
+	return conn, err
+}
+
+// setHostKey records key as the trusted key and drops any pending change
+func setHostKey(known *models.KnownHost, conn *models.SSHConnection, key ssh.PublicKey) {
+	known.Host = conn.Host
+	known.Port = conn.Port
+	known.KeyType = key.Type()
+	known.PublicKey = marshalHostKey(key)
+	known.Fingerprint = ssh.FingerprintSHA256(key)
+	known.PendingPublicKey = ""
+	known.PendingFingerprint = ""
+	known.PendingSeenAt = nil
+}
+
+func marshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	return key
+}
+
+func newTestHostKeyService(t *testing.T) (HostKeyService, *gorm.DB, *models.SSHConnection) {
+	t.Helper()
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	conn := createTestConnection(t, db, user.ID, "web", "192.0.2.10")
+	return NewHostKeyService(repository.NewKnownHostRepository(db), repository.NewSSHRepository(db)), db, conn
+}
+
+// presentHostKey runs the connection's host key check as a handshake would
+func presentHostKey(s HostKeyService, conn *models.SSHConnection, key ssh.PublicKey) error {
+	return s.Callback(conn)(conn.Host, &net.TCPAddr{IP: net.ParseIP(conn.Host), Port: conn.Port}, key)
+}
+
+func expectHostKeyMismatch(t *testing.T, err error, expected, presented ssh.PublicKey) *HostKeyMismatchError {
+	t.Helper()
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("got %v, want a HostKeyMismatchError", err)
+	}
+	if mismatch.ExpectedFingerprint != ssh.FingerprintSHA256(expected) || mismatch.PresentedFingerprint != ssh.FingerprintSHA256(presented) {
+		t.Errorf("mismatch %+v, want %s replaced by %s", mismatch, ssh.FingerprintSHA256(expected), ssh.FingerprintSHA256(presented))
+	}
+	return mismatch
+}
+
+func TestHostKeyTrustedOnFirstUse(t *testing.T) {
+	s, _, conn := newTestHostKeyService(t)
+	key := newTestHostKey(t)
+
+	if _, err := s.Get(conn.ID, conn.UserID); !errors.Is(err, ErrNoHostKey) {
+		t.Fatalf("Get before connecting: got %v, want ErrNoHostKey", err)
+	}
+	if err := presentHostKey(s, conn, key); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	known, err := s.Get(conn.ID, conn.UserID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if known.Fingerprint != ssh.FingerprintSHA256(key) || known.Host != conn.Host || known.Port != conn.Port || known.Pinned {
+		t.Errorf("recorded %+v, want the presented key for %s:%d, unpinned", known, conn.Host, conn.Port)
+	}
+	if err := presentHostKey(s, conn, key); err != nil {
+		t.Errorf("same key again: %v", err)
+	}
+}
+
+func TestHostKeyChangeNeedsAccepting(t *testing.T) {
+	s, _, conn := newTestHostKeyService(t)
+	original, changed := newTestHostKey(t), newTestHostKey(t)
+	if err := presentHostKey(s, conn, original); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+
+	if _, err := s.AcceptChanged(conn.ID, conn.UserID); !errors.Is(err, ErrNoPendingHostKey) {
+		t.Errorf("AcceptChanged with nothing pending: got %v, want ErrNoPendingHostKey", err)
+	}
+
+	expectHostKeyMismatch(t, presentHostKey(s, conn, changed), original, changed)
+	known, err := s.Get(conn.ID, conn.UserID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if known.Fingerprint != ssh.FingerprintSHA256(original) || known.PendingFingerprint != ssh.FingerprintSHA256(changed) {
+		t.Errorf("after the mismatch %+v, want the original key trusted and the new one pending", known)
+	}
+
+	if _, err := s.AcceptChanged(conn.ID, conn.UserID); err != nil {
+		t.Fatalf("AcceptChanged: %v", err)
+	}
+	if err := presentHostKey(s, conn, changed); err != nil {
+		t.Errorf("accepted key: %v", err)
+	}
+	expectHostKeyMismatch(t, presentHostKey(s, conn, original), changed, original)
+}
+
+func TestPinnedHostKey(t *testing.T) {
+	s, _, conn := newTestHostKeyService(t)
+	pinned, other := newTestHostKey(t), newTestHostKey(t)
+
+	if _, err := s.Pin(conn.ID, conn.UserID, "not a key"); !errors.Is(err, ErrInvalidHostKey) {
+		t.Errorf("Pin garbage: got %v, want ErrInvalidHostKey", err)
+	}
+	if _, err := s.Pin(conn.ID, conn.UserID+1, marshalHostKey(pinned)); !errors.Is(err, ErrConnectionNotFound) {
+		t.Errorf("Pin on someone else's connection: got %v, want ErrConnectionNotFound", err)
+	}
+
+	known, err := s.Pin(conn.ID, conn.UserID, marshalHostKey(pinned))
+	if err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	if !known.Pinned || known.Fingerprint != ssh.FingerprintSHA256(pinned) {
+		t.Errorf("Pin recorded %+v", known)
+	}
+
+	// A pinned key is checked from the very first connection
+	expectHostKeyMismatch(t, presentHostKey(s, conn, other), pinned, other)
+	if err := presentHostKey(s, conn, pinned); err != nil {
+		t.Errorf("pinned key: %v", err)
+	}
+
+	// Pinning again replaces the key and drops what was pending
+	known, err = s.Pin(conn.ID, conn.UserID, marshalHostKey(other))
+	if err != nil {
+		t.Fatalf("Pin again: %v", err)
+	}
+	if known.Fingerprint != ssh.FingerprintSHA256(other) || known.PendingFingerprint != "" {
+		t.Errorf("second Pin recorded %+v", known)
+	}
+	if err := presentHostKey(s, conn, other); err != nil {
+		t.Errorf("newly pinned key: %v", err)
+	}
+}
+
+func TestHostKeyOfNewEndpointNeedsAccepting(t *testing.T) {
+	s, db, conn := newTestHostKeyService(t)
+	original, moved := newTestHostKey(t), newTestHostKey(t)
+	if err := presentHostKey(s, conn, original); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+
+	// Re-pointing the connection doesn't make the new server's key trusted
+	conn.Host, conn.Port = "198.51.100.20", 2222
+	if err := db.Save(conn).Error; err != nil {
+		t.Fatalf("save connection: %v", err)
+	}
+	mismatch := expectHostKeyMismatch(t, presentHostKey(s, conn, moved), original, moved)
+	if mismatch.PreviousHost != "192.0.2.10:22" || mismatch.Host != "198.51.100.20:2222" {
+		t.Errorf("mismatch reports a move from %q to %q", mismatch.PreviousHost, mismatch.Host)
+	}
+	if err := presentHostKey(s, conn, moved); err == nil {
+		t.Fatal("new endpoint's key was trusted on the second try")
+	}
+
+	known, err := s.AcceptChanged(conn.ID, conn.UserID)
+	if err != nil {
+		t.Fatalf("AcceptChanged: %v", err)
+	}
+	if known.Host != conn.Host || known.Port != conn.Port {
+		t.Errorf("accepted key recorded for %s:%d, want %s:%d", known.Host, known.Port, conn.Host, conn.Port)
+	}
+	if err := presentHostKey(s, conn, moved); err != nil {
+		t.Errorf("accepted key of the new endpoint: %v", err)
+	}
+}
+
+func TestClearHostKeyTrustsNextKey(t *testing.T) {
+	s, _, conn := newTestHostKeyService(t)
+	if err := presentHostKey(s, conn, newTestHostKey(t)); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	if err := s.Clear(conn.ID, conn.UserID); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if err := presentHostKey(s, conn, newTestHostKey(t)); err != nil {
+		t.Errorf("new key after Clear: %v", err)
+	}
+}
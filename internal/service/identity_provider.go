@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnknownProvider    = errors.New("unknown identity provider")
+	ErrProviderLoginGone  = errors.New("sign in expired or was already used, please try again")
+	ErrProviderExchange   = errors.New("identity provider did not confirm the sign in")
+	ErrAccountNotFound    = errors.New("User not found. Please register first.")
+	ErrIdentityNotAllowed = errors.New("your account is not allowed to sign in here")
+	ErrEmailNotVerified   = errors.New("the identity provider has not verified your email address")
+	ErrIdentityNotLinked  = errors.New("an account with this email already exists, sign in and link the provider from your account page")
+	ErrDirectoryNotLinked = errors.New("an account with this email already exists, sign in with its own password and link your directory account from your account page")
+	ErrIdentityInUse      = errors.New("this identity is already linked to another account")
+	ErrIdentityNotFound   = errors.New("linked identity not found")
+	ErrLastLoginMethod    = errors.New("this is the only way left to sign in to the account")
+)
+
+// IdentityProvider federates sign in to an external service
+type IdentityProvider interface {
+	Name() string
+	DisplayName() string
+	// AuthCodeURL is where the browser is sent to sign in. nonce is echoed
+	// back in the ID token so it can't be replayed into another login.
+	AuthCodeURL(ctx context.Context, state, nonce string) (string, error)
+	// Exchange redeems the code from the callback for the verified identity
+	Exchange(ctx context.Context, code, nonce string) (*ExternalIdentity, error)
+}
+
+// ExternalIdentity is the account a provider vouched for, with its claims
+// mapped to the fields used here
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+// IdentityProviderInfo is what the login page shows for a provider
+type IdentityProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	// Directory marks the LDAP directory, which is signed in to with the
+	// login form and linked with LinkDirectory rather than by redirect
+	Directory bool `json:"directory,omitempty"`
+}
+
+// oidcProvider signs in with any OpenID Connect issuer
+type oidcProvider struct {
+	cfg config.OIDCProviderConfig
+
+	// Discovery runs on first use so the app starts while an issuer is down
+	mu       sync.Mutex
+	provider *oidc.Provider
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDCProvider(cfg config.OIDCProviderConfig) IdentityProvider {
+	return &oidcProvider{cfg: cfg}
+}
+
+func (p *oidcProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *oidcProvider) DisplayName() string {
+	return p.cfg.DisplayName
+}
+
+func (p *oidcProvider) discover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return nil
+	}
+
+	// The context is kept for refreshing the issuer's signing keys later, so
+	// it must outlive the request that triggered discovery
+	ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: 10 * time.Second})
+	provider, err := oidc.NewProvider(ctx, p.cfg.IssuerURL)
+	if err != nil {
+		return fmt.Errorf("discover %s: %w", p.cfg.IssuerURL, err)
+	}
+
+	p.provider = provider
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, p.cfg.Scopes...),
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	return nil
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	if err := p.discover(); err != nil {
+		return "", err
+	}
+	return p.oauth.AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code, nonce string) (*ExternalIdentity, error) {
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	token, err := p.oauth.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderExchange, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrProviderExchange)
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderExchange, err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrProviderExchange)
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderExchange, err)
+	}
+
+	// Some issuers leave profile claims out of the ID token; userinfo has them
+	if claims[p.cfg.EmailClaim] == nil {
+		userInfo, err := p.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err == nil && userInfo.Subject == idToken.Subject {
+			extra := map[string]interface{}{}
+			if userInfo.Claims(&extra) == nil {
+				for key, value := range extra {
+					if _, ok := claims[key]; !ok {
+						claims[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	identity := &ExternalIdentity{
+		Provider:      p.cfg.Name,
+		Subject:       idToken.Subject,
+		Email:         stringClaim(claims, p.cfg.EmailClaim),
+		EmailVerified: verifiedClaim(claims["email_verified"]),
+		Name:          stringClaim(claims, p.cfg.NameClaim),
+		Groups:        listClaim(claims, p.cfg.GroupsClaim),
+	}
+
+	if len(p.cfg.AllowedGroups) > 0 && !inAnyGroup(identity.Groups, p.cfg.AllowedGroups) {
+		return nil, ErrIdentityNotAllowed
+	}
+	return identity, nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// verifiedClaim only accepts an explicit email_verified claim; an issuer
+// that leaves it out has not vouched for the address
+func verifiedClaim(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// listClaim reads a claim sent either as an array or a single string
+func listClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func inAnyGroup(groups, allowed []string) bool {
+	for _, group := range groups {
+		for _, a := range allowed {
+			if group == a {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIssuer is an OpenID Connect issuer that answers every code with an ID
+// token carrying its current claims
+type testIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	issuer := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/auth",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := jwt.MapClaims{
+			"iss": issuer.URL,
+			"aud": "ssh-terminal",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		for name, value := range issuer.claims {
+			claims[name] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (i *testIssuer) provider(allowedGroups ...string) IdentityProvider {
+	return NewOIDCProvider(config.OIDCProviderConfig{
+		Name:          "corp",
+		IssuerURL:     i.URL,
+		ClientID:      "ssh-terminal",
+		ClientSecret:  "secret",
+		RedirectURL:   "https://terminal.example.com/api/auth/oauth/corp/callback",
+		EmailClaim:    "email",
+		NameClaim:     "name",
+		GroupsClaim:   "groups",
+		AllowedGroups: allowedGroups,
+	})
+}
+
+func TestOIDCAuthCodeURLCarriesStateAndNonce(t *testing.T) {
+	issuer := newTestIssuer(t)
+	raw, err := issuer.provider().AuthCodeURL(context.Background(), "the-state", "the-nonce")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	query := u.Query()
+	if query.Get("state") != "the-state" || query.Get("nonce") != "the-nonce" || query.Get("client_id") != "ssh-terminal" {
+		t.Errorf("AuthCodeURL %q lacks the state, nonce or client", raw)
+	}
+}
+
+func TestOIDCExchangeChecksNonce(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.claims = jwt.MapClaims{"sub": "u-1", "nonce": "issued", "email": "alice@example.com", "email_verified": true, "name": "Alice", "groups": "ops"}
+	p := issuer.provider()
+
+	identity, err := p.Exchange(context.Background(), "code", "issued")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := ExternalIdentity{Provider: "corp", Subject: "u-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice", Groups: []string{"ops"}}
+	if !reflect.DeepEqual(*identity, want) {
+		t.Errorf("identity %+v, want %+v", *identity, want)
+	}
+
+	// An ID token minted for another login is refused
+	if _, err := p.Exchange(context.Background(), "code", "another"); !errors.Is(err, ErrProviderExchange) {
+		t.Errorf("nonce mismatch: got %v, want ErrProviderExchange", err)
+	}
+	delete(issuer.claims, "nonce")
+	if _, err := p.Exchange(context.Background(), "code", "issued"); !errors.Is(err, ErrProviderExchange) {
+		t.Errorf("no nonce: got %v, want ErrProviderExchange", err)
+	}
+}
+
+func TestOIDCExchangeNeedsExplicitVerificationAndGroup(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.claims = jwt.MapClaims{"sub": "u-1", "nonce": "n", "email": "alice@example.com", "groups": []string{"dev"}}
+
+	identity, err := issuer.provider().Exchange(context.Background(), "code", "n")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if identity.EmailVerified {
+		t.Error("email counted as verified without an email_verified claim")
+	}
+	if _, err := issuer.provider("ops").Exchange(context.Background(), "code", "n"); !errors.Is(err, ErrIdentityNotAllowed) {
+		t.Errorf("outside the allowed groups: got %v, want ErrIdentityNotAllowed", err)
+	}
+}
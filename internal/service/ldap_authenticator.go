@@ -0,0 +1,246 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ssh-terminal-app/internal/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const ldapTimeout = 10 * time.Second
+
+// directoryProvider is the provider name of directory identities
+const directoryProvider = "ldap"
+
+var (
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrDirectoryUnavailable = errors.New("directory unavailable")
+	ErrNoDirectory          = errors.New("no directory is configured")
+)
+
+// DirectoryAuthenticator checks passwords against an external user directory
+type DirectoryAuthenticator interface {
+	// Authenticate returns ErrInvalidCredentials for an unknown user or wrong
+	// password and ErrDirectoryUnavailable when the directory can't be asked
+	Authenticate(username, password string) (*ExternalIdentity, error)
+	// TeamRoles returns, for every team the directory manages, the role the
+	// groups grant, or "" when they grant none
+	TeamRoles(groups []string) map[uint]string
+}
+
+// GroupTeam grants members of a directory group a role in a team
+type GroupTeam struct {
+	Group  string `json:"group"`
+	TeamID uint   `json:"team_id"`
+	Role   string `json:"role"`
+}
+
+// ldapAuthenticator binds as the user to check their password. It connects
+// for each login, which is rare enough not to need a pool.
+type ldapAuthenticator struct {
+	cfg        config.LDAPConfig
+	tlsConfig  *tls.Config
+	groupTeams []GroupTeam
+}
+
+func NewLDAPAuthenticator(cfg config.LDAPConfig) (DirectoryAuthenticator, error) {
+	if cfg.BaseDN == "" {
+		return nil, errors.New("LDAP_BASE_DN is required")
+	}
+	if !strings.Contains(cfg.UserFilter, "{username}") {
+		return nil, errors.New("LDAP_USER_FILTER must contain {username}")
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_URL: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CACertFile)
+		}
+	}
+
+	var groupTeams []GroupTeam
+	if cfg.GroupTeams != "" {
+		if err := json.Unmarshal([]byte(cfg.GroupTeams), &groupTeams); err != nil {
+			return nil, fmt.Errorf("invalid LDAP_GROUP_TEAMS: %w", err)
+		}
+	}
+	for _, gt := range groupTeams {
+		if gt.Group == "" || gt.TeamID == 0 || !validTeamRole(gt.Role) {
+			return nil, fmt.Errorf("invalid LDAP_GROUP_TEAMS entry %+v", gt)
+		}
+	}
+
+	return &ldapAuthenticator{cfg: cfg, tlsConfig: tlsConfig, groupTeams: groupTeams}, nil
+}
+
+func (a *ldapAuthenticator) Authenticate(username, password string) (*ExternalIdentity, error) {
+	// An empty password is an unauthenticated bind, which servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.connect()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+	}
+	defer conn.Close()
+
+	attributes := []string{a.cfg.EmailAttribute, a.cfg.NameAttribute, a.cfg.GroupAttribute}
+	if a.cfg.IDAttribute != "" {
+		attributes = append(attributes, a.cfg.IDAttribute)
+	}
+	filter := strings.ReplaceAll(a.cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout.Seconds()), false, filter, attributes, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("%w: search: %v", ErrDirectoryUnavailable, err)
+	}
+	// More than one match means the filter can't tell users apart
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	// Look groups up while still bound as the service account, which may
+	// see more of the directory than the user can
+	groups := entry.GetAttributeValues(a.cfg.GroupAttribute)
+	if a.cfg.GroupFilter != "" {
+		found, err := a.searchGroups(conn, entry.DN)
+		if err != nil {
+			return nil, fmt.Errorf("%w: group search: %v", ErrDirectoryUnavailable, err)
+		}
+		groups = append(groups, found...)
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("%w: bind: %v", ErrDirectoryUnavailable, err)
+	}
+
+	// Only the directory's own attribute is used; what the user typed to
+	// sign in proves nothing about the address
+	email := entry.GetAttributeValue(a.cfg.EmailAttribute)
+	if email == "" {
+		log.Printf("LDAP entry %s has no %s attribute; cannot create an account for it", entry.DN, a.cfg.EmailAttribute)
+		return nil, ErrInvalidCredentials
+	}
+
+	subject := entry.DN
+	if a.cfg.IDAttribute != "" {
+		// Binary IDs such as objectGUID are stored as hex
+		raw := entry.GetRawAttributeValue(a.cfg.IDAttribute)
+		if len(raw) == 0 {
+			return nil, fmt.Errorf("%w: entry %s has no %s", ErrDirectoryUnavailable, entry.DN, a.cfg.IDAttribute)
+		}
+		subject = string(raw)
+		if !utf8.Valid(raw) {
+			subject = hex.EncodeToString(raw)
+		}
+	}
+
+	return &ExternalIdentity{
+		Provider:      directoryProvider,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: a.cfg.EmailVerified,
+		Name:          entry.GetAttributeValue(a.cfg.NameAttribute),
+		Groups:        groups,
+	}, nil
+}
+
+func (a *ldapAuthenticator) TeamRoles(groups []string) map[uint]string {
+	roles := make(map[uint]string, len(a.groupTeams))
+	for _, gt := range a.groupTeams {
+		if _, ok := roles[gt.TeamID]; !ok {
+			roles[gt.TeamID] = ""
+		}
+		if !inGroup(groups, gt.Group) {
+			continue
+		}
+		// Several groups may map to one team; the most privileged role wins
+		if roleRank[gt.Role] > roleRank[roles[gt.TeamID]] {
+			roles[gt.TeamID] = gt.Role
+		}
+	}
+	return roles
+}
+
+func (a *ldapAuthenticator) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(a.tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(a.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (a *ldapAuthenticator) searchGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	filter := strings.ReplaceAll(a.cfg.GroupFilter, "{dn}", ldap.EscapeFilter(userDN))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(ldapTimeout.Seconds()), false, filter, []string{"dn"}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// inGroup compares group DNs, which are case-insensitive
+func inGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+)
+
+const smtpTimeout = 30 * time.Second
+
+var ErrMailNotConfigured = errors.New("mail delivery is not configured, set MAIL_DRIVER")
+
+// Mailer delivers account emails such as password reset links
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer returns the mailer selected by MAIL_DRIVER
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp mail driver")
+		}
+		switch cfg.SMTPTLS {
+		case "starttls", "tls", "none":
+		default:
+			return nil, fmt.Errorf("invalid SMTP_TLS %q", cfg.SMTPTLS)
+		}
+		return &smtpMailer{cfg: cfg}, nil
+	case "file":
+		if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+			return nil, err
+		}
+		return &fileMailer{from: cfg.From, dir: cfg.Dir}, nil
+	case "log":
+		log.Printf("Mail: MAIL_DRIVER=log writes password reset and verification links to the server log; use it for development only")
+		return &logMailer{from: cfg.From}, nil
+	case "none":
+		log.Printf("Mail: MAIL_DRIVER is not set; password resets and email verification are unavailable")
+		return disabledMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", cfg.Driver)
+	}
+}
+
+// smtpMailer sends through a relay, opening a connection per message
+type smtpMailer struct {
+	cfg config.MailConfig
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	msg, recipient, err := composeMail(m.cfg.From, to, subject, body)
+	if err != nil {
+		return err
+	}
+	sender, _ := mail.ParseAddress(m.cfg.From)
+
+	addr := net.JoinHostPort(m.cfg.SMTPHost, strconv.Itoa(m.cfg.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: m.cfg.SMTPHost}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	if m.cfg.SMTPTLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, m.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.cfg.SMTPTLS == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if m.cfg.SMTPUsername != "" {
+		// PlainAuth refuses to send the password over an unencrypted
+		// connection to anything but localhost
+		auth := smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(recipient); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// fileMailer writes each message to an .eml file, for local testing
+type fileMailer struct {
+	from string
+	dir  string
+}
+
+func (m *fileMailer) Send(to, subject, body string) error {
+	msg, _, err := composeMail(m.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+	suffix, err := newToken(4)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), suffix)
+	return os.WriteFile(filepath.Join(m.dir, name), msg, 0600)
+}
+
+// logMailer prints messages to the server log. The links in them grant
+// access to accounts, so it is only for development.
+type logMailer struct {
+	from string
+}
+
+func (m *logMailer) Send(to, subject, body string) error {
+	if _, _, err := composeMail(m.from, to, subject, body); err != nil {
+		return err
+	}
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// disabledMailer refuses every message, so account links are never written
+// anywhere they could be read when no driver was chosen
+type disabledMailer struct{}
+
+func (disabledMailer) Send(to, subject, body string) error {
+	return ErrMailNotConfigured
+}
+
+// composeMail builds a plain text message and returns it with the bare
+// recipient address. Parsing the addresses also keeps line breaks out of
+// the headers.
+func composeMail(from, to, subject, body string) ([]byte, string, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid sender: %w", err)
+	}
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", sender.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", recipient.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return nil, "", err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), recipient.Address, nil
+}
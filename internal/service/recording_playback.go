@@ -0,0 +1,466 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"ssh-terminal-app/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	maxPlaybackSpeed = 16
+	maxSearchResults = 200
+	// Recordings are scanned newest first until this much has been read
+	maxSearchBytes = 64 << 20
+)
+
+var (
+	ErrInvalidPlayback = errors.New("invalid playback parameters")
+	ErrEmptySearch     = errors.New("search query is required")
+)
+
+// castEvent is a single timed entry of an asciicast v2 file
+type castEvent struct {
+	Time float64
+	Kind string
+	Data string
+}
+
+// SearchMatch is a line of recorded output that contains the search query
+type SearchMatch struct {
+	RecordingID  uint      `json:"recording_id"`
+	ConnectionID uint      `json:"connection_id"`
+	Host         string    `json:"host"`
+	Offset       float64   `json:"offset"` // Seconds from the start of the recording
+	Timestamp    time.Time `json:"timestamp"`
+	Line         string    `json:"line"`
+}
+
+// playbackControl is sent by the client to steer a running playback
+type playbackControl struct {
+	Type     string  `json:"type"` // "pause", "resume", "speed" or "seek"
+	Speed    float64 `json:"speed"`
+	Position float64 `json:"position"`
+}
+
+func readCastFile(path string) (*asciicastHeader, []castEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	return readCast(file)
+}
+
+func readCast(r io.Reader) (*asciicastHeader, []castEvent, error) {
+	var events []castEvent
+	header, err := scanCast(r, func(event castEvent) {
+		events = append(events, event)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, events, nil
+}
+
+// scanCast reads the header and passes each event to fn as it is read
+func scanCast(r io.Reader, fn func(castEvent)) (*asciicastHeader, error) {
+	reader := bufio.NewReader(r)
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, err
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var raw []interface{}
+			// A session that is still running may end in a partial line
+			if json.Unmarshal(line, &raw) == nil && len(raw) == 3 {
+				t, _ := raw[0].(float64)
+				kind, _ := raw[1].(string)
+				data, _ := raw[2].(string)
+				fn(castEvent{Time: t, Kind: kind, Data: data})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &header, nil
+}
+
+// Play streams a recording to ws using the same binary output frames as a
+// live terminal. Resize events and the end of the recording are reported as
+// JSON text frames.
+func (s *recordingService) Play(ws *websocket.Conn, id, userID uint, speed, seek float64) error {
+	// Written so that NaN, which ParseFloat accepts, fails the checks
+	if !(speed > 0 && speed <= maxPlaybackSpeed) || !(seek >= 0) || math.IsInf(seek, 1) {
+		return ErrInvalidPlayback
+	}
+
+	rec, err := s.Get(id, userID)
+	if err != nil {
+		return err
+	}
+
+	header, events, err := readCastFile(rec.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read recording %d: %v", id, err)
+	}
+
+	// The control reader lives only as long as the playback: once it ends,
+	// the pending read is cut short and the reader is waited for
+	ctx, cancel := context.WithCancel(context.Background())
+	controls := make(chan playbackControl)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(controls)
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			var ctl playbackControl
+			if err := json.Unmarshal(msg, &ctl); err != nil {
+				continue
+			}
+			select {
+			case controls <- ctl:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		ws.SetReadDeadline(time.Now())
+		<-done
+	}()
+
+	player := &castPlayer{ws: ws, events: events, speed: speed}
+	if err := player.sendJSON(map[string]interface{}{"type": "resize", "cols": header.Width, "rows": header.Height}); err != nil {
+		return err
+	}
+	if seek > 0 {
+		if err := player.seek(seek); err != nil {
+			return err
+		}
+	}
+
+	return player.run(controls)
+}
+
+type castPlayer struct {
+	ws       *websocket.Conn
+	events   []castEvent
+	next     int     // Index of the next event to send
+	position float64 // Current playback position in recording seconds
+	speed    float64
+	paused   bool
+}
+
+func (p *castPlayer) run(controls <-chan playbackControl) error {
+	for {
+		// Paused or finished: wait until the client does something
+		if p.paused || p.next >= len(p.events) {
+			ctl, ok := <-controls
+			if !ok {
+				return nil
+			}
+			if err := p.apply(ctl); err != nil {
+				return err
+			}
+			continue
+		}
+
+		event := p.events[p.next]
+		wait := time.Duration((event.Time - p.position) / p.speed * float64(time.Second))
+		timer := time.NewTimer(wait)
+		started := time.Now()
+
+		select {
+		case <-timer.C:
+			p.position = event.Time
+			p.next++
+			if err := p.send(event); err != nil {
+				return err
+			}
+			if p.next >= len(p.events) {
+				if err := p.sendJSON(map[string]interface{}{"type": "end", "position": p.position}); err != nil {
+					return err
+				}
+			}
+		case ctl, ok := <-controls:
+			timer.Stop()
+			if !ok {
+				return nil
+			}
+			p.position += time.Since(started).Seconds() * p.speed
+			if p.position > event.Time {
+				p.position = event.Time
+			}
+			if err := p.apply(ctl); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p *castPlayer) apply(ctl playbackControl) error {
+	switch ctl.Type {
+	case "pause":
+		p.paused = true
+	case "resume":
+		p.paused = false
+	case "speed":
+		if ctl.Speed > 0 && ctl.Speed <= maxPlaybackSpeed {
+			p.speed = ctl.Speed
+		}
+	case "seek":
+		if ctl.Position >= 0 {
+			return p.seek(ctl.Position)
+		}
+	}
+	return nil
+}
+
+// seek resets the client terminal and replays all output up to position at
+// once. A position past the last event ends the playback there.
+func (p *castPlayer) seek(position float64) error {
+	if n := len(p.events); n == 0 {
+		position = 0
+	} else if last := p.events[n-1].Time; position > last {
+		position = last
+	}
+
+	var output strings.Builder
+	output.WriteString("\x1bc")
+
+	var resize *castEvent
+	p.next = 0
+	for p.next < len(p.events) && p.events[p.next].Time <= position {
+		event := p.events[p.next]
+		switch event.Kind {
+		case "o":
+			output.WriteString(event.Data)
+		case "r":
+			resize = &p.events[p.next]
+		}
+		p.next++
+	}
+	p.position = position
+
+	if resize != nil {
+		if err := p.send(*resize); err != nil {
+			return err
+		}
+	}
+	if err := p.ws.WriteMessage(websocket.BinaryMessage, []byte(output.String())); err != nil {
+		return err
+	}
+	if err := p.sendJSON(map[string]interface{}{"type": "position", "position": p.position}); err != nil {
+		return err
+	}
+	if p.next >= len(p.events) {
+		return p.sendJSON(map[string]interface{}{"type": "end", "position": p.position})
+	}
+	return nil
+}
+
+func (p *castPlayer) send(event castEvent) error {
+	switch event.Kind {
+	case "o":
+		return p.ws.WriteMessage(websocket.BinaryMessage, []byte(event.Data))
+	case "r":
+		var cols, rows int
+		if _, err := fmt.Sscanf(event.Data, "%dx%d", &cols, &rows); err != nil {
+			return nil
+		}
+		return p.sendJSON(map[string]interface{}{"type": "resize", "cols": cols, "rows": rows})
+	}
+	// Input events are not replayed; their echo is already part of the output
+	return nil
+}
+
+func (p *castPlayer) sendJSON(v interface{}) error {
+	return p.ws.WriteJSON(v)
+}
+
+// Search scans the output of the user's recordings for query, ignoring case
+// and terminal escape sequences. connID 0 searches all connections. The
+// newest recordings are searched first; truncated reports that the search
+// stopped at maxSearchResults matches or after reading maxSearchBytes.
+func (s *recordingService) Search(userID, connID uint, query string) ([]SearchMatch, bool, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, false, ErrEmptySearch
+	}
+
+	recordings, err := s.repo.ListByUserID(userID, connID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	matches := []SearchMatch{}
+	budget := int64(maxSearchBytes)
+	for _, rec := range recordings {
+		if budget <= 0 || len(matches) >= maxSearchResults {
+			return matches, true, nil
+		}
+
+		file, err := os.Open(rec.FilePath)
+		if err != nil {
+			// Skip recordings whose file is missing or unreadable
+			continue
+		}
+		// Only the start of a file larger than what is left is read
+		limit := budget
+		if info, err := file.Stat(); err == nil {
+			budget -= info.Size()
+		} else {
+			budget = 0
+		}
+
+		stripper := &ansiStripper{}
+		add := func(line strippedLine) {
+			if len(matches) < maxSearchResults && strings.Contains(strings.ToLower(line.Text), query) {
+				matches = append(matches, newSearchMatch(rec, line))
+			}
+		}
+		_, err = scanCast(io.LimitReader(file, limit), func(event castEvent) {
+			if event.Kind != "o" {
+				return
+			}
+			for _, line := range stripper.Write(event.Time, event.Data) {
+				add(line)
+			}
+		})
+		file.Close()
+		if err != nil {
+			continue
+		}
+		if line, ok := stripper.Flush(); ok {
+			add(line)
+		}
+	}
+
+	return matches, len(matches) >= maxSearchResults || budget < 0, nil
+}
+
+func newSearchMatch(rec models.SessionRecording, line strippedLine) SearchMatch {
+	return SearchMatch{
+		RecordingID:  rec.ID,
+		ConnectionID: rec.ConnectionID,
+		Host:         rec.Host,
+		Offset:       line.Time,
+		Timestamp:    rec.StartedAt.Add(time.Duration(line.Time * float64(time.Second))),
+		Line:         line.Text,
+	}
+}
+
+// strippedLine is a line of plain text and the time it started in the recording
+type strippedLine struct {
+	Time float64
+	Text string
+}
+
+// ansiStripper removes terminal escape sequences from a stream of output
+// chunks and splits it into lines. Its state carries over between chunks, so
+// sequences split across events are still removed.
+type ansiStripper struct {
+	state    int
+	line     strings.Builder
+	lineTime float64
+}
+
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+	ansiCharset
+)
+
+func (a *ansiStripper) Write(t float64, data string) []strippedLine {
+	var lines []strippedLine
+	for _, r := range data {
+		switch a.state {
+		case ansiText:
+			switch {
+			case r == '\x1b':
+				a.state = ansiEscape
+			case r == '\n':
+				if line, ok := a.Flush(); ok {
+					lines = append(lines, line)
+				}
+			case r == '\t' || !unicode.IsControl(r):
+				if a.line.Len() == 0 {
+					a.lineTime = t
+				}
+				a.line.WriteRune(r)
+			}
+		case ansiEscape:
+			switch r {
+			case '[':
+				a.state = ansiCSI
+			case ']':
+				a.state = ansiOSC
+			case '(', ')', '*', '+':
+				a.state = ansiCharset
+			default:
+				a.state = ansiText
+			}
+		case ansiCSI:
+			// Parameters and intermediates run until a final byte in @..~
+			if r >= '@' && r <= '~' {
+				a.state = ansiText
+			}
+		case ansiOSC:
+			switch r {
+			case '\x07':
+				a.state = ansiText
+			case '\x1b':
+				a.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			a.state = ansiText
+		case ansiCharset:
+			a.state = ansiText
+		}
+	}
+	return lines
+}
+
+// Flush returns the pending partial line, if it has any visible text
+func (a *ansiStripper) Flush() (strippedLine, bool) {
+	text := strings.TrimSpace(a.line.String())
+	a.line.Reset()
+	if text == "" {
+		return strippedLine{}, false
+	}
+	return strippedLine{Time: a.lineTime, Text: text}, true
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"github.com/gorilla/websocket"
+)
+
+const testCast = `{"version":2,"width":80,"height":24,"timestamp":1700000000}
+[0.5,"o","$ ls\r\n"]
+[1.0,"r","120x40"]
+[1.5,"o","file.txt\r\n"]
+[2.0,"i","exit\r"]
+[2.5,"o","logout\r\n"]
+`
+
+// newTestPlayback stores testCast as a recording of a new user
+func newTestPlayback(t *testing.T) (*recordingService, uint, uint) {
+	t.Helper()
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	path := filepath.Join(t.TempDir(), "session.cast")
+	if err := os.WriteFile(path, []byte(testCast), 0600); err != nil {
+		t.Fatalf("write cast: %v", err)
+	}
+	rec := &models.SessionRecording{UserID: user.ID, ConnectionID: 1, Host: "example.com", Username: "alice", FilePath: path, StartedAt: time.Now()}
+	if err := db.Create(rec).Error; err != nil {
+		t.Fatalf("create recording: %v", err)
+	}
+
+	cfg := newTestConfig()
+	cfg.RecordingsPath = t.TempDir()
+	return NewRecordingService(repository.NewRecordingRepository(db), cfg).(*recordingService), rec.ID, user.ID
+}
+
+// playTestRecording runs Play on the server end of a WebSocket and returns
+// the client end along with Play's result
+func playTestRecording(t *testing.T, s *recordingService, id, userID uint, speed, seek float64) (*websocket.Conn, <-chan error) {
+	t.Helper()
+	result := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			result <- err
+			return
+		}
+		defer ws.Close()
+		result <- s.Play(ws, id, userID, speed, seek)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, result
+}
+
+// readFrames reads until the "end" message, rendering binary frames as
+// "out:<data>" and JSON ones as "<type>"
+func readFrames(t *testing.T, ws *websocket.Conn) []string {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frames []string
+	for {
+		kind, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage after %q: %v", frames, err)
+		}
+		if kind == websocket.BinaryMessage {
+			frames = append(frames, "out:"+string(msg))
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(msg, &m); err != nil {
+			t.Fatalf("text frame %q: %v", msg, err)
+		}
+		frame, _ := m["type"].(string)
+		switch frame {
+		case "resize":
+			frame += ":" + jsonNumber(m["cols"]) + "x" + jsonNumber(m["rows"])
+		case "position", "end":
+			frame += ":" + jsonNumber(m["position"])
+		}
+		frames = append(frames, frame)
+		if strings.HasPrefix(frame, "end") {
+			return frames
+		}
+	}
+}
+
+func jsonNumber(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func TestPlayStreamsRecording(t *testing.T) {
+	s, id, userID := newTestPlayback(t)
+	client, result := playTestRecording(t, s, id, userID, maxPlaybackSpeed, 0)
+
+	want := []string{"resize:80x24", "out:$ ls\r\n", "resize:120x40", "out:file.txt\r\n", "out:logout\r\n", "end:2.5"}
+	if got := readFrames(t, client); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("frames %q, want %q", got, want)
+	}
+
+	client.Close()
+	if err := <-result; err != nil {
+		t.Errorf("Play: %v", err)
+	}
+}
+
+func TestPlaySeeksBeforeStreaming(t *testing.T) {
+	s, id, userID := newTestPlayback(t)
+	client, _ := playTestRecording(t, s, id, userID, maxPlaybackSpeed, 1.7)
+
+	// Everything up to the position arrives at once after a terminal reset,
+	// with the size in effect at that point
+	want := []string{"resize:80x24", "resize:120x40", "out:\x1bc$ ls\r\nfile.txt\r\n", "position:1.7", "out:logout\r\n", "end:2.5"}
+	if got := readFrames(t, client); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("frames %q, want %q", got, want)
+	}
+}
+
+func TestPlaySeekControlRewinds(t *testing.T) {
+	s, id, userID := newTestPlayback(t)
+	client, _ := playTestRecording(t, s, id, userID, maxPlaybackSpeed, 0)
+	readFrames(t, client)
+
+	if err := client.WriteJSON(playbackControl{Type: "seek", Position: 0.5}); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	want := []string{"out:\x1bc$ ls\r\n", "position:0.5", "resize:120x40", "out:file.txt\r\n", "out:logout\r\n", "end:2.5"}
+	if got := readFrames(t, client); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("frames after seeking %q, want %q", got, want)
+	}
+}
+
+func TestPlayRejectsInvalidParameters(t *testing.T) {
+	s, id, userID := newTestPlayback(t)
+	tests := []struct {
+		name        string
+		speed, seek float64
+	}{
+		{"zero speed", 0, 0},
+		{"negative speed", -1, 0},
+		{"too fast", maxPlaybackSpeed + 1, 0},
+		{"NaN speed", math.NaN(), 0},
+		{"infinite speed", math.Inf(1), 0},
+		{"negative seek", 1, -1},
+		{"NaN seek", 1, math.NaN()},
+		{"infinite seek", 1, math.Inf(1)},
+	}
+	for _, tt := range tests {
+		// Checked before the socket is used
+		if err := s.Play(nil, id, userID, tt.speed, tt.seek); !errors.Is(err, ErrInvalidPlayback) {
+			t.Errorf("%s: got %v, want ErrInvalidPlayback", tt.name, err)
+		}
+	}
+}
+
+func TestPlaySeekPastLastEventEnds(t *testing.T) {
+	s, id, userID := newTestPlayback(t)
+	client, result := playTestRecording(t, s, id, userID, maxPlaybackSpeed, 10)
+
+	// The position is clamped to the recording and nothing is left to stream
+	want := []string{"resize:80x24", "resize:120x40", "out:\x1bc$ ls\r\nfile.txt\r\nlogout\r\n", "position:2.5", "end:2.5"}
+	if got := readFrames(t, client); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("frames %q, want %q", got, want)
+	}
+
+	client.Close()
+	if err := <-result; err != nil {
+		t.Errorf("Play: %v", err)
+	}
+}
+
+func TestSearchFindsRecordedOutput(t *testing.T) {
+	s, _, userID := newTestPlayback(t)
+
+	matches, truncated, err := s.Search(userID, 0, "FILE")
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if truncated || len(matches) != 1 || matches[0].Line != "file.txt" {
+		t.Errorf("got %+v (truncated %v), want the file.txt line", matches, truncated)
+	}
+	if _, _, err := s.Search(userID, 0, " "); !errors.Is(err, ErrEmptySearch) {
+		t.Errorf("blank query: got %v, want ErrEmptySearch", err)
+	}
+}
+
+func TestSearchStopsAtResultLimit(t *testing.T) {
+	s, id, userID := newTestPlayback(t)
+	rec, err := s.repo.GetByID(id, userID)
+	if err != nil {
+		t.Fatalf("load recording: %v", err)
+	}
+	var cast strings.Builder
+	cast.WriteString(`{"version":2,"width":80,"height":24}` + "\n")
+	for i := 0; i < maxSearchResults+50; i++ {
+		cast.WriteString(`[0.1,"o","match\r\n"]` + "\n")
+	}
+	if err := os.WriteFile(rec.FilePath, []byte(cast.String()), 0600); err != nil {
+		t.Fatalf("write cast: %v", err)
+	}
+
+	matches, truncated, err := s.Search(userID, 0, "match")
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(matches) != maxSearchResults || !truncated {
+		t.Errorf("got %d matches (truncated %v), want %d and truncated", len(matches), truncated, maxSearchResults)
+	}
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+var ErrRecordingNotFound = errors.New("recording not found")
+
+type RecordingService interface {
+	// Start begins recording a session; it returns a nil Recorder when
+	// recording is disabled for the connection
+	Start(userID uint, conn *models.SSHConnection, cols, rows int) (*Recorder, error)
+	List(userID, connID uint) ([]models.SessionRecording, error)
+	Get(id, userID uint) (*models.SessionRecording, error)
+	Open(id, userID uint) (*os.File, *models.SessionRecording, error)
+	// Play replays a recording over ws at speed, starting at seek seconds
+	Play(ws *websocket.Conn, id, userID uint, speed, seek float64) error
+	// Search reports whether it stopped before searching everything
+	Search(userID, connID uint, query string) ([]SearchMatch, bool, error)
+}
+
+type recordingService struct {
+	repo repository.RecordingRepository
+	cfg  *config.Config
+}
+
+func NewRecordingService(repo repository.RecordingRepository, cfg *config.Config) RecordingService {
+	return &recordingService{
+		repo: repo,
+		cfg:  cfg,
+	}
+}
+
+// asciicastHeader is the first line of an asciicast v2 file
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+func (s *recordingService) Start(userID uint, conn *models.SSHConnection, cols, rows int) (*Recorder, error) {
+	if !s.cfg.RecordAllSessions && !conn.RecordSessions {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(s.cfg.RecordingsPath, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %v", err)
+	}
+
+	// The file is complete with its header before the recording is listed,
+	// so a failure leaves no row behind pointing at nothing
+	file, err := os.CreateTemp(s.cfg.RecordingsPath, "session-*.cast")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording file: %v", err)
+	}
+	discard := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	now := time.Now()
+	header := asciicastHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: now.Unix(),
+		Title:     fmt.Sprintf("%s@%s", conn.Username, conn.Host),
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	}
+	if err := json.NewEncoder(file).Encode(header); err != nil {
+		discard()
+		return nil, err
+	}
+
+	rec := &models.SessionRecording{
+		UserID:       userID,
+		ConnectionID: conn.ID,
+		Host:         conn.Host,
+		Username:     conn.Username,
+		FilePath:     file.Name(),
+		StartedAt:    now,
+	}
+	if err := s.repo.Create(rec); err != nil {
+		discard()
+		return nil, err
+	}
+
+	return &Recorder{
+		repo:   s.repo,
+		record: rec,
+		file:   file,
+		start:  now,
+	}, nil
+}
+
+func (s *recordingService) List(userID, connID uint) ([]models.SessionRecording, error) {
+	return s.repo.ListByUserID(userID, connID)
+}
+
+func (s *recordingService) Get(id, userID uint) (*models.SessionRecording, error) {
+	rec, err := s.repo.GetByID(id, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordingNotFound
+	}
+	return rec, err
+}
+
+func (s *recordingService) Open(id, userID uint) (*os.File, *models.SessionRecording, error) {
+	rec, err := s.Get(id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(rec.FilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, ErrRecordingNotFound
+		}
+		return nil, nil, err
+	}
+
+	return file, rec, nil
+}
+
+// Recorder appends terminal events to an asciicast v2 file. All methods are
+// safe for concurrent use and a nil Recorder discards everything, so callers
+// need not check whether recording is enabled.
+type Recorder struct {
+	mu      sync.Mutex
+	repo    repository.RecordingRepository
+	record  *models.SessionRecording
+	file    *os.File
+	start   time.Time
+	pending []byte // Incomplete UTF-8 sequence held back from the last output chunk
+	closed  bool
+}
+
+// Output records data written by the remote side
+func (r *Recorder) Output(data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.BytesOut += int64(len(data))
+
+	// Output arrives in arbitrary chunks; don't split multi-byte characters
+	data = append(r.pending, data...)
+	cut := incompleteUTF8Suffix(data)
+	r.pending = append([]byte(nil), data[len(data)-cut:]...)
+	if len(data) > cut {
+		r.writeEvent("o", string(data[:len(data)-cut]))
+	}
+}
+
+// Input records keystrokes sent by the user
+func (r *Recorder) Input(data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.record.BytesIn += int64(len(data))
+	r.writeEvent("i", string(data))
+}
+
+// Resize records a terminal size change
+func (r *Recorder) Resize(cols, rows int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writeEvent("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close finalizes the file and stores the session totals; exitStatus is nil
+// when the shell did not report one
+func (r *Recorder) Close(exitStatus *int) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if len(r.pending) > 0 {
+		r.writeEvent("o", string(r.pending))
+		r.pending = nil
+	}
+	r.file.Close()
+
+	now := time.Now()
+	r.record.EndedAt = &now
+	r.record.ExitStatus = exitStatus
+	return r.repo.Update(r.record)
+}
+
+// writeEvent must be called with mu held
+func (r *Recorder) writeEvent(kind, data string) {
+	if r.closed {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, kind, data})
+	if err != nil {
+		return
+	}
+	r.file.Write(append(line, '\n'))
+}
+
+// incompleteUTF8Suffix returns the length of a truncated multi-byte sequence
+// at the end of data, or 0 if data ends on a character boundary
+func incompleteUTF8Suffix(data []byte) int {
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if utf8.RuneStart(b) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+)
+
+// failingRecordingRepository can't store new recordings
+type failingRecordingRepository struct {
+	repository.RecordingRepository
+}
+
+func (failingRecordingRepository) Create(rec *models.SessionRecording) error {
+	return errors.New("database is read-only")
+}
+
+func newTestRecordingService(t *testing.T, repo repository.RecordingRepository) *recordingService {
+	cfg := newTestConfig()
+	cfg.RecordingsPath = filepath.Join(t.TempDir(), "recordings")
+	return NewRecordingService(repo, cfg).(*recordingService)
+}
+
+func TestRecorderWritesAsciicast(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	conn := createTestConnection(t, db, user.ID, "web", "192.0.2.10")
+	s := newTestRecordingService(t, repository.NewRecordingRepository(db))
+
+	if rec, err := s.Start(user.ID, conn, 80, 24); rec != nil || err != nil {
+		t.Fatalf("Start on a connection that isn't recorded = %v, %v", rec, err)
+	}
+
+	conn.RecordSessions = true
+	rec, err := s.Start(user.ID, conn, 80, 24)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	rec.Output([]byte("caf\xc3")) // "é" split across chunks
+	rec.Output([]byte("\xa9\r\n"))
+	rec.Input([]byte("ls\r"))
+	rec.Resize(120, 40)
+	status := 3
+	if err := rec.Close(&status); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	recordings, err := s.List(user.ID, conn.ID)
+	if err != nil || len(recordings) != 1 {
+		t.Fatalf("List = %d recordings, %v; want 1", len(recordings), err)
+	}
+	stored := recordings[0]
+	if stored.EndedAt == nil || stored.ExitStatus == nil || *stored.ExitStatus != 3 || stored.BytesOut != 7 || stored.BytesIn != 3 {
+		t.Errorf("stored recording %+v", stored)
+	}
+
+	header, events, err := readCastFile(stored.FilePath)
+	if err != nil {
+		t.Fatalf("readCastFile: %v", err)
+	}
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 {
+		t.Errorf("header %+v", header)
+	}
+	// The split character is written whole with the chunk that completes it
+	want := []castEvent{{Kind: "o", Data: "caf"}, {Kind: "o", Data: "é\r\n"}, {Kind: "i", Data: "ls\r"}, {Kind: "r", Data: "120x40"}}
+	if len(events) != len(want) {
+		t.Fatalf("events %+v, want %+v", events, want)
+	}
+	for i, event := range events {
+		if event.Kind != want[i].Kind || event.Data != want[i].Data {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+	}
+}
+
+func TestFailedRecordingStartLeavesNothingBehind(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	conn := createTestConnection(t, db, user.ID, "web", "192.0.2.10")
+	conn.RecordSessions = true
+	repo := repository.NewRecordingRepository(db)
+
+	// The row can't be stored: the file goes again
+	s := newTestRecordingService(t, failingRecordingRepository{repo})
+	if _, err := s.Start(user.ID, conn, 80, 24); err == nil {
+		t.Fatal("Start succeeded without storing the recording")
+	}
+	files, err := os.ReadDir(s.cfg.RecordingsPath)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("left %d file(s) behind", len(files))
+	}
+
+	// The file can't be created: no row is stored
+	s = newTestRecordingService(t, repo)
+	if err := os.WriteFile(s.cfg.RecordingsPath, nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := s.Start(user.ID, conn, 80, 24); err == nil {
+		t.Fatal("Start succeeded without a recordings directory")
+	}
+	if recordings, err := s.List(user.ID, 0); err != nil || len(recordings) != 0 {
+		t.Errorf("List = %d recordings, %v; want none", len(recordings), err)
+	}
+}
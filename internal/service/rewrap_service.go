@@ -0,0 +1,242 @@
+package service
+
+import (
+	"fmt"
+
+	"ssh-terminal-app/internal/repository"
+	"ssh-terminal-app/internal/utils"
+)
+
+const rewrapBatchSize = 100
+
+// RewrapService moves stored secrets to the current master key
+type RewrapService interface {
+	// Rewrap re-wraps every connection and identity credential, TOTP secret,
+	// signing key and SSH CA key sealed with another master key, or from before envelope
+	// encryption. Credentials kept in another secret store are left to it.
+	// It works through small batches and skips values changed while it runs,
+	// so the server can keep serving; running it again picks up anything
+	// left over.
+	Rewrap() (*RewrapResult, error)
+}
+
+// RewrapResult counts the values Rewrap looked at
+type RewrapResult struct {
+	Rewrapped int // Moved to the current master key
+	Current   int // Already sealed with it
+	Skipped   int // Changed or deleted while being re-wrapped
+}
+
+func (r *RewrapResult) count(changed, replaced bool) {
+	switch {
+	case !changed:
+		r.Current++
+	case replaced:
+		r.Rewrapped++
+	default:
+		r.Skipped++
+	}
+}
+
+type rewrapService struct {
+	connections repository.SSHRepository
+	identities  repository.SSHIdentityRepository
+	users       repository.UserRepository
+	signingKeys repository.SigningKeyRepository
+	caKeys      repository.SSHCARepository
+	keyring     *utils.Keyring
+}
+
+func NewRewrapService(connections repository.SSHRepository, identities repository.SSHIdentityRepository, users repository.UserRepository, signingKeys repository.SigningKeyRepository, caKeys repository.SSHCARepository, keyring *utils.Keyring) RewrapService {
+	return &rewrapService{
+		connections: connections,
+		identities:  identities,
+		users:       users,
+		signingKeys: signingKeys,
+		caKeys:      caKeys,
+		keyring:     keyring,
+	}
+}
+
+func (s *rewrapService) Rewrap() (*RewrapResult, error) {
+	result := &RewrapResult{}
+	if err := s.rewrapConnections(result); err != nil {
+		return result, err
+	}
+	if err := s.rewrapIdentities(result); err != nil {
+		return result, err
+	}
+	if err := s.rewrapTOTPSecrets(result); err != nil {
+		return result, err
+	}
+	if err := s.rewrapSigningKeys(result); err != nil {
+		return result, err
+	}
+	return result, s.rewrapCAKeys(result)
+}
+
+func (s *rewrapService) rewrapConnections(result *RewrapResult) error {
+	var afterID uint
+	for {
+		connections, err := s.connections.ListSecretsAfter(afterID, rewrapBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(connections) == 0 {
+			return nil
+		}
+
+		for _, conn := range connections {
+			afterID = conn.ID
+			password, passwordChanged, err := s.rewrapCredential(conn.Password)
+			if err != nil {
+				return fmt.Errorf("connection %d password: %w", conn.ID, err)
+			}
+			privateKey, keyChanged, err := s.rewrapCredential(conn.PrivateKey)
+			if err != nil {
+				return fmt.Errorf("connection %d private key: %w", conn.ID, err)
+			}
+			passphrase, passphraseChanged, err := s.rewrapCredential(conn.Passphrase)
+			if err != nil {
+				return fmt.Errorf("connection %d passphrase: %w", conn.ID, err)
+			}
+
+			changed := passwordChanged || keyChanged || passphraseChanged
+			replaced := false
+			if changed {
+				replaced, err = s.connections.ReplaceSecrets(conn.ID,
+					conn.Password, conn.PrivateKey, conn.Passphrase,
+					password, privateKey, passphrase)
+				if err != nil {
+					return err
+				}
+			}
+			result.count(changed, replaced)
+		}
+	}
+}
+
+func (s *rewrapService) rewrapIdentities(result *RewrapResult) error {
+	var afterID uint
+	for {
+		identities, err := s.identities.ListSecretsAfter(afterID, rewrapBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(identities) == 0 {
+			return nil
+		}
+
+		for _, identity := range identities {
+			afterID = identity.ID
+			password, passwordChanged, err := s.rewrapCredential(identity.Password)
+			if err != nil {
+				return fmt.Errorf("SSH identity %d password: %w", identity.ID, err)
+			}
+			privateKey, keyChanged, err := s.rewrapCredential(identity.PrivateKey)
+			if err != nil {
+				return fmt.Errorf("SSH identity %d private key: %w", identity.ID, err)
+			}
+			passphrase, passphraseChanged, err := s.rewrapCredential(identity.Passphrase)
+			if err != nil {
+				return fmt.Errorf("SSH identity %d passphrase: %w", identity.ID, err)
+			}
+
+			changed := passwordChanged || keyChanged || passphraseChanged
+			replaced := false
+			if changed {
+				replaced, err = s.identities.ReplaceSecrets(identity.ID,
+					identity.Password, identity.PrivateKey, identity.Passphrase,
+					password, privateKey, passphrase)
+				if err != nil {
+					return err
+				}
+			}
+			result.count(changed, replaced)
+		}
+	}
+}
+
+// rewrapCredential leaves references to secrets kept outside the database
+// to their store
+func (s *rewrapService) rewrapCredential(value string) (string, bool, error) {
+	if IsSecretRef(value) {
+		return value, false, nil
+	}
+	return s.keyring.Rewrap(value)
+}
+
+func (s *rewrapService) rewrapTOTPSecrets(result *RewrapResult) error {
+	var afterID uint
+	for {
+		users, err := s.users.ListTOTPSecretsAfter(afterID, rewrapBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		for _, user := range users {
+			afterID = user.ID
+			secret, changed, err := s.keyring.Rewrap(user.TOTPSecret)
+			if err != nil {
+				return fmt.Errorf("user %d TOTP secret: %w", user.ID, err)
+			}
+
+			replaced := false
+			if changed {
+				if replaced, err = s.users.ReplaceTOTPSecret(user.ID, user.TOTPSecret, secret); err != nil {
+					return err
+				}
+			}
+			result.count(changed, replaced)
+		}
+	}
+}
+
+func (s *rewrapService) rewrapSigningKeys(result *RewrapResult) error {
+	keys, err := s.signingKeys.List()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		privateKey, changed, err := s.keyring.Rewrap(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.KID, err)
+		}
+
+		replaced := false
+		if changed {
+			if replaced, err = s.signingKeys.ReplacePrivateKey(key.ID, key.PrivateKey, privateKey); err != nil {
+				return err
+			}
+		}
+		result.count(changed, replaced)
+	}
+	return nil
+}
+
+func (s *rewrapService) rewrapCAKeys(result *RewrapResult) error {
+	keys, err := s.caKeys.List()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		privateKey, changed, err := s.keyring.Rewrap(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("SSH CA key %d: %w", key.ID, err)
+		}
+
+		replaced := false
+		if changed {
+			if replaced, err = s.caKeys.ReplacePrivateKey(key.ID, key.PrivateKey, privateKey); err != nil {
+				return err
+			}
+		}
+		result.count(changed, replaced)
+	}
+	return nil
+}
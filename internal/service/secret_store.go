@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/utils"
+)
+
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretStore keeps connection passwords and private keys. The database
+// holds only the reference Put returns: the sealed secret itself for the
+// local store, or "<backend>:<id>" for one that keeps secrets elsewhere.
+type SecretStore interface {
+	// Put saves secret and returns the reference to store in its place
+	Put(secret string) (string, error)
+	// Get returns the secret a reference points to
+	Get(ref string) (string, error)
+	// Delete removes the secret a reference points to. Deleting one that is
+	// already gone is not an error.
+	Delete(ref string) error
+}
+
+// NewSecretStore returns a store that saves new secrets in the backend
+// selected by SECRET_STORE and reads references from any configured one,
+// so switching backends doesn't strand secrets saved before
+func NewSecretStore(cfg config.SecretStoreConfig, keyring *utils.Keyring) (SecretStore, error) {
+	store := &secretStoreRouter{
+		local:    &localSecretStore{keyring: keyring},
+		backends: make(map[string]SecretStore),
+	}
+
+	if cfg.AgeIdentityFile != "" {
+		age, err := newAgeSecretStore(cfg.AgeFile, cfg.AgeIdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("age secret store: %w", err)
+		}
+		store.backends[ageSecretScheme] = age
+	}
+	if cfg.VaultAddr != "" {
+		vault, err := newVaultSecretStore(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("Vault secret store: %w", err)
+		}
+		store.backends[vaultSecretScheme] = vault
+	}
+
+	switch cfg.Backend {
+	case "local":
+		store.write = store.local
+	case ageSecretScheme, vaultSecretScheme:
+		store.write = store.backends[cfg.Backend]
+		if store.write == nil {
+			return nil, fmt.Errorf("SECRET_STORE=%s is not configured; see SECRET_STORE_AGE_IDENTITY or VAULT_ADDR", cfg.Backend)
+		}
+	default:
+		return nil, fmt.Errorf("unknown SECRET_STORE %q", cfg.Backend)
+	}
+	return store, nil
+}
+
+// IsSecretRef reports whether a stored value references a secret kept
+// outside the database, rather than being one sealed with the keyring
+func IsSecretRef(value string) bool {
+	scheme, _, ok := strings.Cut(value, ":")
+	return ok && (scheme == ageSecretScheme || scheme == vaultSecretScheme)
+}
+
+type secretStoreRouter struct {
+	write    SecretStore
+	local    SecretStore
+	backends map[string]SecretStore // By reference scheme
+}
+
+func (s *secretStoreRouter) Put(secret string) (string, error) {
+	return s.write.Put(secret)
+}
+
+func (s *secretStoreRouter) Get(ref string) (string, error) {
+	store, err := s.storeFor(ref)
+	if err != nil {
+		return "", err
+	}
+	return store.Get(ref)
+}
+
+func (s *secretStoreRouter) Delete(ref string) error {
+	store, err := s.storeFor(ref)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ref)
+}
+
+func (s *secretStoreRouter) storeFor(ref string) (SecretStore, error) {
+	if !IsSecretRef(ref) {
+		return s.local, nil
+	}
+	scheme, _, _ := strings.Cut(ref, ":")
+	store, ok := s.backends[scheme]
+	if !ok {
+		return nil, fmt.Errorf("secret is kept in the %s store, which is not configured", scheme)
+	}
+	return store, nil
+}
+
+// localSecretStore seals secrets with the keyring and keeps them in the
+// database row itself
+type localSecretStore struct {
+	keyring *utils.Keyring
+}
+
+func (s *localSecretStore) Put(secret string) (string, error) {
+	return s.keyring.Encrypt(secret)
+}
+
+func (s *localSecretStore) Get(ref string) (string, error) {
+	return s.keyring.Decrypt(ref)
+}
+
+func (s *localSecretStore) Delete(ref string) error {
+	return nil // Goes with the row
+}
+
+// putSecret saves a password or key, returning the reference to store. An
+// empty secret stays empty.
+func putSecret(store SecretStore, secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+	return store.Put(secret)
+}
+
+// deleteSecrets removes secrets nothing refers to anymore. A failure only
+// leaves an orphaned secret behind, so it is logged.
+func deleteSecrets(store SecretStore, refs ...string) {
+	for _, ref := range refs {
+		if ref == "" {
+			continue
+		}
+		if err := store.Delete(ref); err != nil {
+			log.Printf("SecretStore: Failed to delete secret: %v", err)
+		}
+	}
+}
+
+// splitSecretRef returns the ID of a "<scheme>:<id>" reference
+func splitSecretRef(scheme, ref string) (string, error) {
+	id, ok := strings.CutPrefix(ref, scheme+":")
+	if !ok || id == "" {
+		return "", fmt.Errorf("not a %s secret reference", scheme)
+	}
+	return id, nil
+}
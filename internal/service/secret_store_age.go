@@ -0,0 +1,166 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"filippo.io/age"
+)
+
+const ageSecretScheme = "age"
+
+// ageSecretStore keeps secrets in a single file, a JSON object of ID to
+// secret encrypted with age to the recipient of an identity kept apart from
+// the database. The file is rewritten whole on every change.
+type ageSecretStore struct {
+	path      string
+	identity  *age.X25519Identity
+	recipient age.Recipient
+
+	mu sync.Mutex
+}
+
+func newAgeSecretStore(path, identityFile string) (*ageSecretStore, error) {
+	file, err := os.Open(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, err
+	}
+	identity, ok := identities[0].(*age.X25519Identity)
+	if len(identities) != 1 || !ok {
+		return nil, fmt.Errorf("%s must hold exactly one X25519 identity", identityFile)
+	}
+
+	s := &ageSecretStore{path: path, identity: identity, recipient: identity.Recipient()}
+	// Fail at startup rather than on first use if the file can't be opened
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ageSecretStore) Put(secret string) (string, error) {
+	id, err := newToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	secrets[id] = secret
+	if err := s.save(secrets); err != nil {
+		return "", err
+	}
+	return ageSecretScheme + ":" + id, nil
+}
+
+func (s *ageSecretStore) Get(ref string) (string, error) {
+	id, err := splitSecretRef(ageSecretScheme, ref)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[id]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *ageSecretStore) Delete(ref string) error {
+	id, err := splitSecretRef(ageSecretScheme, ref)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[id]; !ok {
+		return nil
+	}
+	delete(secrets, id)
+	return s.save(secrets)
+}
+
+// load decrypts the vault file. A missing file is an empty vault.
+func (s *ageSecretStore) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := age.Decrypt(bytes.NewReader(data), s.identity)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", s.path, err)
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.path, err)
+	}
+	return secrets, nil
+}
+
+// save encrypts the secrets to a temporary file and renames it over the
+// vault, so a crash never leaves a half-written file behind
+func (s *ageSecretStore) save(secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer, err := age.Encrypt(tmp, s.recipient)
+	if err == nil {
+		_, err = writer.Write(plaintext)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
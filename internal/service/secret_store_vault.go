@@ -0,0 +1,273 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+)
+
+const (
+	vaultSecretScheme = "vault"
+	vaultTimeout      = 10 * time.Second
+
+	// A failed renewal is retried this often until the token expires
+	vaultRenewRetry = time.Minute
+)
+
+// vaultSecretStore keeps each secret at its own path in a HashiCorp Vault
+// KV version 2 secrets engine, under the "value" key.
+//
+// A token read from VAULT_TOKEN_FILE is read again whenever the file
+// changes, so an agent such as Vault Agent can keep it fresh. A renewable
+// VAULT_TOKEN is renewed in the background before its TTL runs out.
+type vaultSecretStore struct {
+	addr      string
+	namespace string
+	mount     string
+	prefix    string
+	client    *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenFile   string
+	tokenLoaded time.Time // Modification time of tokenFile when token was read
+}
+
+func newVaultSecretStore(cfg config.SecretStoreConfig) (*vaultSecretStore, error) {
+	if cfg.VaultToken == "" && cfg.VaultTokenFile == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN or VAULT_TOKEN_FILE is required")
+	}
+	if _, err := url.Parse(cfg.VaultAddr); err != nil {
+		return nil, fmt.Errorf("invalid VAULT_ADDR: %w", err)
+	}
+	store := &vaultSecretStore{
+		addr:      strings.TrimSuffix(cfg.VaultAddr, "/"),
+		namespace: cfg.VaultNamespace,
+		mount:     strings.Trim(cfg.VaultMount, "/"),
+		prefix:    strings.Trim(cfg.VaultPrefix, "/"),
+		client:    &http.Client{Timeout: vaultTimeout},
+		token:     cfg.VaultToken,
+		tokenFile: cfg.VaultTokenFile,
+	}
+
+	if store.tokenFile != "" {
+		if _, err := store.currentToken(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	// Whoever keeps the token file fresh also renews its token
+	ttl, renewable, err := store.lookupToken()
+	if err != nil {
+		return nil, fmt.Errorf("looking up VAULT_TOKEN: %w", err)
+	}
+	if renewable && ttl > 0 {
+		go store.renewLoop(ttl)
+	}
+	return store, nil
+}
+
+// currentToken returns the token to send, reading the token file again if
+// it has changed since it was last read
+func (s *vaultSecretStore) currentToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tokenFile == "" {
+		return s.token, nil
+	}
+	info, err := os.Stat(s.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("reading VAULT_TOKEN_FILE: %w", err)
+	}
+	if s.token != "" && info.ModTime().Equal(s.tokenLoaded) {
+		return s.token, nil
+	}
+	data, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("reading VAULT_TOKEN_FILE: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN_FILE %s is empty", s.tokenFile)
+	}
+	s.token = token
+	s.tokenLoaded = info.ModTime()
+	return token, nil
+}
+
+// vaultTokenInfo is the part of a token lookup or renewal response used here
+type vaultTokenInfo struct {
+	Data struct {
+		TTL       int64 `json:"ttl"`
+		Renewable bool  `json:"renewable"`
+	} `json:"data"`
+	Auth struct {
+		LeaseDuration int64 `json:"lease_duration"`
+		Renewable     bool  `json:"renewable"`
+	} `json:"auth"`
+}
+
+// lookupToken returns the token's remaining TTL and whether it can be renewed
+func (s *vaultSecretStore) lookupToken() (time.Duration, bool, error) {
+	var info vaultTokenInfo
+	if err := s.call(http.MethodGet, "v1/auth/token/lookup-self", nil, &info); err != nil {
+		return 0, false, err
+	}
+	return time.Duration(info.Data.TTL) * time.Second, info.Data.Renewable, nil
+}
+
+// renewToken extends the token's lease and returns its new TTL
+func (s *vaultSecretStore) renewToken() (time.Duration, bool, error) {
+	var info vaultTokenInfo
+	if err := s.call(http.MethodPost, "v1/auth/token/renew-self", map[string]interface{}{}, &info); err != nil {
+		return 0, false, err
+	}
+	return time.Duration(info.Auth.LeaseDuration) * time.Second, info.Auth.Renewable, nil
+}
+
+// renewLoop renews the token when half its TTL has passed, for as long as
+// Vault allows. Once it stops, requests fail when the token expires and the
+// server must be restarted with a new one.
+func (s *vaultSecretStore) renewLoop(ttl time.Duration) {
+	expires := time.Now().Add(ttl)
+	wait := ttl / 2
+	for {
+		time.Sleep(wait)
+
+		renewed, renewable, err := s.renewToken()
+		switch {
+		case err != nil:
+			if time.Until(expires) <= 0 {
+				log.Printf("Vault token expired, renewal failed: %v", err)
+				return
+			}
+			log.Printf("Vault token renewal failed, retrying: %v", err)
+			wait = min(vaultRenewRetry, time.Until(expires)/2)
+		case !renewable || renewed <= 0:
+			log.Printf("Vault token can no longer be renewed; it expires in %s", renewed)
+			return
+		default:
+			expires = time.Now().Add(renewed)
+			wait = renewed / 2
+		}
+	}
+}
+
+type vaultSecretData struct {
+	Value string `json:"value"`
+}
+
+func (s *vaultSecretStore) Put(secret string) (string, error) {
+	id, err := newToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	body := map[string]interface{}{"data": vaultSecretData{Value: secret}}
+	if err := s.do(http.MethodPost, "data", id, body, nil); err != nil {
+		return "", err
+	}
+	return vaultSecretScheme + ":" + id, nil
+}
+
+func (s *vaultSecretStore) Get(ref string) (string, error) {
+	id, err := splitSecretRef(vaultSecretScheme, ref)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data struct {
+			Data vaultSecretData `json:"data"`
+		} `json:"data"`
+	}
+	if err := s.do(http.MethodGet, "data", id, nil, &response); err != nil {
+		return "", err
+	}
+	return response.Data.Data.Value, nil
+}
+
+// Delete removes every version of the secret, not just the latest
+func (s *vaultSecretStore) Delete(ref string) error {
+	id, err := splitSecretRef(vaultSecretScheme, ref)
+	if err != nil {
+		return err
+	}
+
+	err = s.do(http.MethodDelete, "metadata", id, nil, nil)
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil
+	}
+	return err
+}
+
+// do calls the KV engine's endpoint for a secret, e.g. data or metadata
+func (s *vaultSecretStore) do(method, endpoint, id string, body, result interface{}) error {
+	segments := []string{"v1", s.mount, endpoint}
+	if s.prefix != "" {
+		segments = append(segments, s.prefix)
+	}
+	return s.call(method, strings.Join(append(segments, id), "/"), body, result)
+}
+
+// call sends a request to a Vault API path, e.g. v1/auth/token/lookup-self
+func (s *vaultSecretStore) call(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	token, err := s.currentToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, s.addr+"/"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if s.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrSecretNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&vaultErr)
+		return fmt.Errorf("Vault returned %s: %s", resp.Status, strings.Join(vaultErr.Errors, "; "))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
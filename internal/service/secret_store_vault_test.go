@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+)
+
+// fakeVault is a stand-in for a Vault server with a KV version 2 engine
+// mounted at "secret". It accepts any token in tokens.
+type fakeVault struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	tokens  map[string]bool
+	secrets map[string]string // By path under the mount
+	renewed int
+	fail    bool // Answer every KV request with a 500
+}
+
+func newFakeVault(t *testing.T, tokens ...string) (*fakeVault, *httptest.Server) {
+	vault := &fakeVault{t: t, tokens: map[string]bool{}, secrets: map[string]string{}}
+	for _, token := range tokens {
+		vault.tokens[token] = true
+	}
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+	return vault, server
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		writeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/token/lookup-self":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"ttl": 3600, "renewable": true},
+		})
+		return
+	case "/v1/auth/token/renew-self":
+		v.renewed++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"lease_duration": 3600, "renewable": true},
+		})
+		return
+	}
+
+	if v.fail {
+		writeVaultError(w, http.StatusInternalServerError, "storage unavailable")
+		return
+	}
+
+	if p, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/"); ok {
+		switch r.Method {
+		case http.MethodPost:
+			var body struct {
+				Data vaultSecretData `json:"data"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeVaultError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			v.secrets[p] = body.Data.Value
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"data":{"version":1}}`))
+		case http.MethodGet:
+			value, ok := v.secrets[p]
+			if !ok {
+				writeVaultError(w, http.StatusNotFound, "")
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"data": vaultSecretData{Value: value}},
+			})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if p, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/metadata/"); ok && r.Method == http.MethodDelete {
+		if _, ok := v.secrets[p]; !ok {
+			writeVaultError(w, http.StatusNotFound, "")
+			return
+		}
+		delete(v.secrets, p)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	v.t.Errorf("unexpected Vault request %s %s", r.Method, r.URL.Path)
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func writeVaultError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	errs := []string{}
+	if message != "" {
+		errs = append(errs, message)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
+
+func vaultConfig(addr string) config.SecretStoreConfig {
+	return config.SecretStoreConfig{
+		VaultAddr:   addr,
+		VaultToken:  "root",
+		VaultMount:  "secret",
+		VaultPrefix: "ssh-terminal",
+	}
+}
+
+func TestVaultSecretStoreRoundTrip(t *testing.T) {
+	vault, server := newFakeVault(t, "root")
+	store, err := newVaultSecretStore(vaultConfig(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := store.Put("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ref, "vault:") {
+		t.Fatalf("reference %q has no vault scheme", ref)
+	}
+	id := strings.TrimPrefix(ref, "vault:")
+	vault.mu.Lock()
+	stored := vault.secrets["ssh-terminal/"+id]
+	vault.mu.Unlock()
+	if stored != "hunter2" {
+		t.Fatalf("secret was not stored under the prefix %q", "ssh-terminal/")
+	}
+
+	got, err := store.Get(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hunter2" {
+		t.Fatalf("Get = %q, want %q", got, "hunter2")
+	}
+
+	if err := store.Delete(ref); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ref); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrSecretNotFound", err)
+	}
+	// Deleting again is not an error
+	if err := store.Delete(ref); err != nil {
+		t.Fatalf("second Delete = %v", err)
+	}
+}
+
+func TestVaultSecretStoreErrors(t *testing.T) {
+	vault, server := newFakeVault(t, "root")
+	store, err := newVaultSecretStore(vaultConfig(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get("age:abc"); err == nil {
+		t.Fatal("Get accepted a reference for another backend")
+	}
+
+	vault.mu.Lock()
+	vault.fail = true
+	vault.mu.Unlock()
+	_, err = store.Put("hunter2")
+	if err == nil || !strings.Contains(err.Error(), "storage unavailable") {
+		t.Fatalf("Put on a failing server = %v, want Vault's error", err)
+	}
+	if err := store.Delete("vault:abc"); err == nil {
+		t.Fatal("Delete on a failing server succeeded")
+	}
+
+	if _, err := newVaultSecretStore(config.SecretStoreConfig{VaultAddr: server.URL}); err == nil {
+		t.Fatal("store created without a token")
+	}
+	cfg := vaultConfig(server.URL)
+	cfg.VaultToken = "wrong"
+	if _, err := newVaultSecretStore(cfg); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("store with a wrong token = %v, want permission denied", err)
+	}
+}
+
+func TestVaultSecretStoreTokenFile(t *testing.T) {
+	vault, server := newFakeVault(t, "first")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := vaultConfig(server.URL)
+	cfg.VaultToken = ""
+	cfg.VaultTokenFile = tokenFile
+	store, err := newVaultSecretStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := store.Put("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The agent swaps the token and the old one stops working
+	vault.mu.Lock()
+	vault.tokens = map[string]bool{"second": true}
+	vault.mu.Unlock()
+	if err := os.WriteFile(tokenFile, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := store.Get(ref); err != nil || got != "hunter2" {
+		t.Fatalf("Get after token change = %q, %v", got, err)
+	}
+}
+
+func TestVaultSecretStoreRenewToken(t *testing.T) {
+	vault, server := newFakeVault(t, "root")
+	store, err := newVaultSecretStore(vaultConfig(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttl, renewable, err := store.renewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != time.Hour || !renewable {
+		t.Fatalf("renewToken = %s, %v; want 1h, true", ttl, renewable)
+	}
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	if vault.renewed != 1 {
+		t.Fatalf("renew-self called %d times, want 1", vault.renewed)
+	}
+}
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+	"ssh-terminal-app/internal/utils"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+const (
+	// Certificates are valid from a little before they are issued, in case
+	// the server's clock is behind
+	sshCertClockSkew = time.Minute
+	maxSSHPrincipals = 32
+)
+
+var (
+	ErrNoSSHPrincipals   = errors.New("no SSH principals are configured for you; ask an administrator")
+	ErrInvalidPrincipals = errors.New("invalid SSH principals")
+)
+
+// TeamSSHPrincipals are the principals a team adds to its members'
+// certificates, and the member roles that get them
+type TeamSSHPrincipals struct {
+	Principals []string `json:"principals"`
+	Roles      []string `json:"roles"`
+}
+
+// SSHCAService is the app's SSH certificate authority. Connections using
+// the "certificate" auth type log in with a fresh key and a certificate that
+// is only valid for a few minutes, instead of a stored credential.
+type SSHCAService interface {
+	// PublicKey returns the CA keys in authorized_keys format, one per line,
+	// for sshd's TrustedUserCAKeys. After a rotation it lists the previous
+	// key too, so servers can trust the new one before it signs.
+	PublicKey() (string, error)
+	// Signer makes an ephemeral key and certifies it for userID to log into
+	// conn, with the user's principals. Those of conn's team are added when
+	// the user's role in the team is one the admin granted them to.
+	Signer(userID uint, conn *models.SSHConnection) (ssh.Signer, error)
+
+	// Principal mappings are managed by admins only, as they decide which
+	// logins certificates are accepted for
+	UserPrincipals(adminID, userID uint) ([]string, error)
+	SetUserPrincipals(adminID, userID uint, principals []string, remoteAddr string) ([]string, error)
+	TeamPrincipals(adminID, teamID uint) (*TeamSSHPrincipals, error)
+	SetTeamPrincipals(adminID, teamID uint, req TeamSSHPrincipals, remoteAddr string) (*TeamSSHPrincipals, error)
+}
+
+type sshCAService struct {
+	repo     repository.SSHCARepository
+	userRepo repository.UserRepository
+	teamRepo repository.TeamRepository
+	audit    AuditService
+	cfg      *config.Config
+	signer   ssh.Signer
+}
+
+// NewSSHCAService loads the CA key, creating it on a new install
+func NewSSHCAService(repo repository.SSHCARepository, userRepo repository.UserRepository, teamRepo repository.TeamRepository, audit AuditService, keyring *utils.Keyring, cfg *config.Config) (SSHCAService, error) {
+	if cfg.SSHCertTTL <= 0 {
+		return nil, fmt.Errorf("SSH_CERT_TTL must be positive")
+	}
+
+	record, err := repo.Current()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		record, err = createSSHCAKey(repo, keyring)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pemKey, err := keyring.Decrypt(record.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(pemKey))
+	if err != nil {
+		return nil, fmt.Errorf("SSH CA key %d: %w", record.ID, err)
+	}
+
+	return &sshCAService{
+		repo:     repo,
+		userRepo: userRepo,
+		teamRepo: teamRepo,
+		audit:    audit,
+		cfg:      cfg,
+		signer:   signer,
+	}, nil
+}
+
+// RotateSSHCAKey adds a new CA key and forgets all but the one it replaces.
+// The new key signs once the server restarts; servers should trust it
+// before then.
+func RotateSSHCAKey(repo repository.SSHCARepository, keyring *utils.Keyring) (*models.SSHCAKey, error) {
+	previous, err := repo.Current()
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	record, err := createSSHCAKey(repo, keyring)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if err := repo.DeleteBefore(previous.ID); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func createSSHCAKey(repo repository.SSHCARepository, keyring *utils.Keyring) (*models.SSHCAKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(private, "ssh-terminal CA")
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := keyring.Encrypt(string(pem.EncodeToMemory(block)))
+	if err != nil {
+		return nil, err
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.SSHCAKey{
+		PrivateKey: encrypted,
+		PublicKey:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic))),
+	}
+	if err := repo.Create(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *sshCAService) PublicKey() (string, error) {
+	keys, err := s.repo.List()
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key.PublicKey
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (s *sshCAService) Signer(userID uint, conn *models.SSHConnection) (ssh.Signer, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	principals := append([]string(nil), user.SSHPrincipals...)
+	if conn.TeamID != nil {
+		teamPrincipals, err := s.teamPrincipalsFor(*conn.TeamID, userID)
+		if err != nil {
+			return nil, err
+		}
+		principals = mergePrincipals(principals, teamPrincipals)
+	}
+	if len(principals) == 0 {
+		return nil, ErrNoSSHPrincipals
+	}
+
+	// The key lives only as long as this login
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             sshPublic,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("%s connection %d", user.Email, conn.ID),
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-sshCertClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(s.cfg.SSHCertTTL).Unix()),
+		Permissions: ssh.Permissions{
+			// Jump hosts forward the connection to the next hop
+			Extensions: map[string]string{
+				"permit-pty":             "",
+				"permit-port-forwarding": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, s.signer); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditSSHCertIssue,
+		TargetType: AuditTargetConnection,
+		TargetID:   conn.ID,
+		Details: map[string]interface{}{
+			"serial":       cert.Serial,
+			"principals":   principals,
+			"valid_before": time.Unix(int64(cert.ValidBefore), 0).UTC(),
+		},
+	})
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// teamPrincipalsFor returns the team's principals if the user's role in it
+// is one they are granted to, and none otherwise
+func (s *sshCAService) teamPrincipalsFor(teamID, userID uint) ([]string, error) {
+	team, err := s.teamRepo.GetByID(teamID)
+	if err != nil {
+		return nil, err
+	}
+	if len(team.SSHPrincipals) == 0 {
+		return nil, nil
+	}
+	member, err := s.teamRepo.GetMember(teamID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range team.SSHPrincipalRoles {
+		if role == member.Role {
+			return team.SSHPrincipals, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *sshCAService) UserPrincipals(adminID, userID uint) ([]string, error) {
+	if _, err := requireAdmin(s.userRepo, s.cfg, adminID); err != nil {
+		return nil, err
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return nonNilPrincipals(user.SSHPrincipals), nil
+}
+
+func (s *sshCAService) SetUserPrincipals(adminID, userID uint, principals []string, remoteAddr string) ([]string, error) {
+	admin, err := requireAdmin(s.userRepo, s.cfg, adminID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if principals, err = cleanPrincipals(principals); err != nil {
+		return nil, err
+	}
+
+	user.SSHPrincipals = principals
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+	s.recordPrincipals(admin, AuditTargetUser, user.ID, map[string]interface{}{"principals": principals}, remoteAddr)
+	return principals, nil
+}
+
+func (s *sshCAService) TeamPrincipals(adminID, teamID uint) (*TeamSSHPrincipals, error) {
+	if _, err := requireAdmin(s.userRepo, s.cfg, adminID); err != nil {
+		return nil, err
+	}
+	team, err := s.findTeam(teamID)
+	if err != nil {
+		return nil, err
+	}
+	return &TeamSSHPrincipals{
+		Principals: nonNilPrincipals(team.SSHPrincipals),
+		Roles:      nonNilPrincipals(team.SSHPrincipalRoles),
+	}, nil
+}
+
+func (s *sshCAService) SetTeamPrincipals(adminID, teamID uint, req TeamSSHPrincipals, remoteAddr string) (*TeamSSHPrincipals, error) {
+	admin, err := requireAdmin(s.userRepo, s.cfg, adminID)
+	if err != nil {
+		return nil, err
+	}
+	team, err := s.findTeam(teamID)
+	if err != nil {
+		return nil, err
+	}
+	principals, err := cleanPrincipals(req.Principals)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := cleanPrincipalRoles(req.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if len(principals) > 0 && len(roles) == 0 {
+		return nil, fmt.Errorf("%w: choose the team roles that get them", ErrInvalidPrincipals)
+	}
+
+	team.SSHPrincipals = principals
+	team.SSHPrincipalRoles = roles
+	if err := s.teamRepo.Update(team); err != nil {
+		return nil, err
+	}
+	s.recordPrincipals(admin, AuditTargetTeam, team.ID, map[string]interface{}{"principals": principals, "roles": roles}, remoteAddr)
+	return &TeamSSHPrincipals{Principals: principals, Roles: roles}, nil
+}
+
+func (s *sshCAService) recordPrincipals(admin *models.User, targetType string, targetID uint, details map[string]interface{}, remoteAddr string) {
+	s.audit.Record(models.AuditEvent{
+		UserID:     admin.ID,
+		Actor:      admin.Email,
+		Action:     AuditSSHPrincipals,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RemoteAddr: remoteAddr,
+		Details:    details,
+	})
+}
+
+func (s *sshCAService) findUser(userID uint) (*models.User, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (s *sshCAService) findTeam(teamID uint) (*models.Team, error) {
+	team, err := s.teamRepo.GetByID(teamID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTeamNotFound
+	}
+	return team, err
+}
+
+// cleanPrincipals trims and deduplicates principals, refusing ones sshd
+// couldn't match against a login or AuthorizedPrincipalsFile line
+func cleanPrincipals(principals []string) ([]string, error) {
+	cleaned := []string{}
+	for _, principal := range principals {
+		principal = strings.TrimSpace(principal)
+		if principal == "" {
+			continue
+		}
+		if strings.ContainsAny(principal, " \t\r\n,") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPrincipals, principal)
+		}
+		cleaned = mergePrincipals(cleaned, []string{principal})
+	}
+	if len(cleaned) > maxSSHPrincipals {
+		return nil, fmt.Errorf("%w: at most %d are allowed", ErrInvalidPrincipals, maxSSHPrincipals)
+	}
+	return cleaned, nil
+}
+
+// cleanPrincipalRoles deduplicates the team roles that get a team's
+// principals. Viewers can't connect, so they can't be given any.
+func cleanPrincipalRoles(roles []string) ([]string, error) {
+	cleaned := []string{}
+	for _, role := range roles {
+		switch role {
+		case models.TeamRoleOwner, models.TeamRoleAdmin, models.TeamRoleOperator:
+			cleaned = mergePrincipals(cleaned, []string{role})
+		default:
+			return nil, fmt.Errorf("%w: role %q can't be given principals", ErrInvalidPrincipals, role)
+		}
+	}
+	return cleaned, nil
+}
+
+// mergePrincipals appends the principals in more that aren't already listed
+func mergePrincipals(principals, more []string) []string {
+	for _, principal := range more {
+		seen := false
+		for _, existing := range principals {
+			if existing == principal {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			principals = append(principals, principal)
+		}
+	}
+	return principals
+}
+
+func nonNilPrincipals(principals []string) []string {
+	if principals == nil {
+		return []string{}
+	}
+	return principals
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+	"ssh-terminal-app/internal/utils"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// newTestSSHCA returns a CA whose admin is admin@example.com
+func newTestSSHCA(t *testing.T) (*sshCAService, *gorm.DB, *utils.Keyring) {
+	t.Helper()
+	db := newTestDB(t)
+	cfg := newTestConfig()
+	cfg.SSHCertTTL = 5 * time.Minute
+	cfg.AdminEmails = []string{"admin@example.com"}
+	keyring := newTestKeyring(t)
+	users := repository.NewUserRepository(db)
+	audit := NewAuditService(repository.NewAuditRepository(db), users, cfg)
+
+	ca, err := NewSSHCAService(repository.NewSSHCARepository(db), users, repository.NewTeamRepository(db), audit, keyring, cfg)
+	if err != nil {
+		t.Fatalf("NewSSHCAService: %v", err)
+	}
+	return ca.(*sshCAService), db, keyring
+}
+
+// issueTestCertificate returns the certificate Signer made for conn
+func issueTestCertificate(t *testing.T, ca *sshCAService, userID uint, conn *models.SSHConnection) *ssh.Certificate {
+	t.Helper()
+	signer, err := ca.Signer(userID, conn)
+	if err != nil {
+		t.Fatalf("Signer: %v", err)
+	}
+	cert, ok := signer.PublicKey().(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("Signer key is a %T, want a certificate", signer.PublicKey())
+	}
+	return cert
+}
+
+func TestSSHCertificateMergesGrantedTeamPrincipals(t *testing.T) {
+	ca, db, _ := newTestSSHCA(t)
+	admin := createTestUser(t, db, "admin@example.com", "correct horse")
+	alice := createTestUser(t, db, "alice@example.com", "correct horse")
+	bob := createTestUser(t, db, "bob@example.com", "correct horse")
+	team := createTestTeam(t, ca.teamRepo, "ops", admin.ID, map[uint]string{
+		alice.ID: models.TeamRoleOperator,
+		bob.ID:   models.TeamRoleViewer,
+	})
+
+	for _, user := range []*models.User{alice, bob} {
+		if _, err := ca.SetUserPrincipals(admin.ID, user.ID, []string{strings.TrimSuffix(user.Email, "@example.com"), "deploy"}, ""); err != nil {
+			t.Fatalf("SetUserPrincipals: %v", err)
+		}
+	}
+	grant := TeamSSHPrincipals{Principals: []string{"deploy", "ops"}, Roles: []string{models.TeamRoleOperator}}
+	if _, err := ca.SetTeamPrincipals(admin.ID, team.ID, grant, ""); err != nil {
+		t.Fatalf("SetTeamPrincipals: %v", err)
+	}
+
+	personal := &models.SSHConnection{ID: 1, UserID: alice.ID}
+	shared := &models.SSHConnection{ID: 2, UserID: admin.ID, TeamID: &team.ID}
+	tests := []struct {
+		user *models.User
+		conn *models.SSHConnection
+		want []string
+	}{
+		{alice, personal, []string{"alice", "deploy"}},
+		{alice, shared, []string{"alice", "deploy", "ops"}},
+		{bob, shared, []string{"bob", "deploy"}}, // Viewers aren't granted the team's
+	}
+	for _, tt := range tests {
+		cert := issueTestCertificate(t, ca, tt.user.ID, tt.conn)
+		if !reflect.DeepEqual(cert.ValidPrincipals, tt.want) {
+			t.Errorf("%s on connection %d: principals %q, want %q", tt.user.Email, tt.conn.ID, cert.ValidPrincipals, tt.want)
+		}
+	}
+
+	// Without principals of their own, team principals are all a user gets
+	if _, err := ca.SetUserPrincipals(admin.ID, alice.ID, nil, ""); err != nil {
+		t.Fatalf("SetUserPrincipals: %v", err)
+	}
+	if cert := issueTestCertificate(t, ca, alice.ID, shared); !reflect.DeepEqual(cert.ValidPrincipals, []string{"deploy", "ops"}) {
+		t.Errorf("principals %q, want the team's", cert.ValidPrincipals)
+	}
+	if _, err := ca.Signer(alice.ID, personal); !errors.Is(err, ErrNoSSHPrincipals) {
+		t.Errorf("no principals: got %v, want ErrNoSSHPrincipals", err)
+	}
+}
+
+func TestSSHCertificateValidityWindow(t *testing.T) {
+	ca, db, _ := newTestSSHCA(t)
+	admin := createTestUser(t, db, "admin@example.com", "correct horse")
+	if _, err := ca.SetUserPrincipals(admin.ID, admin.ID, []string{"root"}, ""); err != nil {
+		t.Fatalf("SetUserPrincipals: %v", err)
+	}
+
+	before := time.Now()
+	cert := issueTestCertificate(t, ca, admin.ID, &models.SSHConnection{ID: 1, UserID: admin.ID})
+	after := time.Now()
+
+	validAfter := time.Unix(int64(cert.ValidAfter), 0)
+	validBefore := time.Unix(int64(cert.ValidBefore), 0)
+	if validAfter.Before(before.Add(-sshCertClockSkew-time.Second)) || validAfter.After(after.Add(-sshCertClockSkew)) {
+		t.Errorf("valid after %v, want %s before issuing", validAfter, sshCertClockSkew)
+	}
+	if validBefore.Before(before.Add(ca.cfg.SSHCertTTL-time.Second)) || validBefore.After(after.Add(ca.cfg.SSHCertTTL)) {
+		t.Errorf("valid before %v, want %s after issuing", validBefore, ca.cfg.SSHCertTTL)
+	}
+
+	// What sshd checks with TrustedUserCAKeys
+	caKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(mustPublicKey(t, ca)))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey: %v", err)
+	}
+	if !bytes.Equal(cert.SignatureKey.Marshal(), caKey.Marshal()) {
+		t.Fatal("certificate isn't signed by the published CA key")
+	}
+	checkAt := func(at time.Time) error {
+		checker := &ssh.CertChecker{Clock: func() time.Time { return at }}
+		return checker.CheckCert("root", cert)
+	}
+	if err := checkAt(time.Now()); err != nil {
+		t.Errorf("CheckCert now: %v", err)
+	}
+	if err := checkAt(before.Add(-sshCertClockSkew - time.Minute)); err == nil {
+		t.Error("certificate valid before it was issued")
+	}
+	if err := checkAt(after.Add(ca.cfg.SSHCertTTL + time.Minute)); err == nil {
+		t.Error("certificate valid after its TTL")
+	}
+}
+
+func mustPublicKey(t *testing.T, ca SSHCAService) string {
+	t.Helper()
+	key, err := ca.PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	return key
+}
+
+func TestSSHPrincipalsAreAdminOnlyAndValidated(t *testing.T) {
+	ca, db, _ := newTestSSHCA(t)
+	admin := createTestUser(t, db, "admin@example.com", "correct horse")
+	alice := createTestUser(t, db, "alice@example.com", "correct horse")
+	team := createTestTeam(t, ca.teamRepo, "ops", alice.ID, nil)
+
+	if _, err := ca.SetUserPrincipals(alice.ID, alice.ID, []string{"root"}, ""); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("non-admin granting principals: got %v, want ErrPermissionDenied", err)
+	}
+	if _, err := ca.SetUserPrincipals(admin.ID, alice.ID, []string{"root,admin"}, ""); !errors.Is(err, ErrInvalidPrincipals) {
+		t.Errorf("principal with a comma: got %v, want ErrInvalidPrincipals", err)
+	}
+	got, err := ca.SetUserPrincipals(admin.ID, alice.ID, []string{" alice ", "", "alice", "deploy"}, "")
+	if err != nil || !reflect.DeepEqual(got, []string{"alice", "deploy"}) {
+		t.Errorf("SetUserPrincipals = %q, %v; want trimmed and deduplicated", got, err)
+	}
+
+	for _, req := range []TeamSSHPrincipals{
+		{Principals: []string{"ops"}},
+		{Principals: []string{"ops"}, Roles: []string{models.TeamRoleViewer}},
+	} {
+		if _, err := ca.SetTeamPrincipals(admin.ID, team.ID, req, ""); !errors.Is(err, ErrInvalidPrincipals) {
+			t.Errorf("team principals %+v: got %v, want ErrInvalidPrincipals", req, err)
+		}
+	}
+}
+
+func TestRotatedSSHCAKeysArePublished(t *testing.T) {
+	ca, db, keyring := newTestSSHCA(t)
+	repo := repository.NewSSHCARepository(db)
+	original := mustPublicKey(t, ca)
+
+	rotated, err := RotateSSHCAKey(repo, keyring)
+	if err != nil {
+		t.Fatalf("RotateSSHCAKey: %v", err)
+	}
+	if got := mustPublicKey(t, ca); got != original+"\n"+rotated.PublicKey {
+		t.Errorf("after one rotation PublicKey = %q, want both keys", got)
+	}
+
+	// Only the key being replaced is kept
+	latest, err := RotateSSHCAKey(repo, keyring)
+	if err != nil {
+		t.Fatalf("RotateSSHCAKey: %v", err)
+	}
+	if got := mustPublicKey(t, ca); got != rotated.PublicKey+"\n"+latest.PublicKey {
+		t.Errorf("after two rotations PublicKey = %q, want the last two keys", got)
+	}
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxPassphraseAttempts limits how many wrong passphrases a user may give
+	// for one key, across sessions, before it is locked for passphraseLockout
+	maxPassphraseAttempts = 3
+	passphraseLockout     = 15 * time.Minute
+)
+
+var (
+	ErrPassphraseRequired  = errors.New("private key is passphrase protected and no passphrase was given")
+	ErrIncorrectPassphrase = errors.New("incorrect private key passphrase")
+)
+
+// PassphrasePrompt asks the user for the passphrase of a connection's
+// private key when none is stored; retry is set after a wrong one
+type PassphrasePrompt func(conn *models.SSHConnection, retry bool) (string, error)
+
+// JumpHostError reports which hop of a jump host chain failed
+type JumpHostError struct {
+	Hop  int // 1-based position in the chain
+	Name string
+	Host string
+	Err  error
+}
+
+func (e *JumpHostError) Error() string {
+	return fmt.Sprintf("jump host %d %q (%s): %v", e.Hop, e.Name, e.Host, e.Err)
+}
+
+func (e *JumpHostError) Unwrap() error {
+	return e.Err
+}
+
+// sshDialer opens SSH clients for saved connections using their decrypted
+// credentials, or certificates from the CA, and recorded host keys,
+// tunnelling through any jump hosts.
+type sshDialer struct {
+	sshService SSHService
+	hostKeys   HostKeyService
+	ca         SSHCAService
+	attempts   AttemptStore // Counts wrong passphrases; only needed to prompt
+}
+
+func newSSHDialer(sshService SSHService, hostKeys HostKeyService, ca SSHCAService, attempts AttemptStore) *sshDialer {
+	return &sshDialer{
+		sshService: sshService,
+		hostKeys:   hostKeys,
+		ca:         ca,
+		attempts:   attempts,
+	}
+}
+
+// Dial connects to the connection through its jump hosts. prompt is asked
+// for the passphrases of protected keys that have none stored; without it
+// such keys fail with ErrPassphraseRequired.
+func (d *sshDialer) Dial(connID, userID uint, prompt PassphrasePrompt) (*ssh.Client, *models.SSHConnection, error) {
+	conn, err := d.getConnection(connID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Each hop is dialed through the client of the one before it
+	var hops []*ssh.Client
+	closeHops := func() {
+		for i := len(hops) - 1; i >= 0; i-- {
+			hops[i].Close()
+		}
+	}
+
+	var via *ssh.Client
+	for i, jumpID := range conn.JumpHostIDs {
+		jump, err := d.getConnection(jumpID, userID)
+		if err != nil {
+			closeHops()
+			return nil, nil, &JumpHostError{Hop: i + 1, Name: fmt.Sprintf("#%d", jumpID), Err: err}
+		}
+
+		client, err := d.dialConnection(jump, userID, via, prompt)
+		if err != nil {
+			closeHops()
+			return nil, nil, &JumpHostError{
+				Hop:  i + 1,
+				Name: jump.Name,
+				Host: fmt.Sprintf("%s:%d", jump.Host, jump.Port),
+				Err:  err,
+			}
+		}
+		hops = append(hops, client)
+		via = client
+	}
+
+	client, err := d.dialConnection(conn, userID, via, prompt)
+	if err != nil {
+		closeHops()
+		return nil, nil, err
+	}
+
+	// Tear the chain down once the target connection goes away
+	if len(hops) > 0 {
+		go func() {
+			client.Wait()
+			closeHops()
+		}()
+	}
+
+	return client, conn, nil
+}
+
+func (d *sshDialer) getConnection(connID, userID uint) (*models.SSHConnection, error) {
+	conn, err := d.sshService.Get(connID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrConnectionNotFound
+	}
+	return conn, err
+}
+
+// dialConnection connects to conn directly, or through via when it is set
+func (d *sshDialer) dialConnection(conn *models.SSHConnection, userID uint, via *ssh.Client, prompt PassphrasePrompt) (*ssh.Client, error) {
+	// Get credentials and connection info
+	creds, _, err := d.sshService.GetDecryptedCredentials(conn.ID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection credentials: %w", err)
+	}
+
+	// Verify the server against its recorded host key, keeping the typed
+	// mismatch error since ssh.Dial only reports it as text
+	var mismatch *HostKeyMismatchError
+	verifyHostKey := d.hostKeys.Callback(conn)
+
+	// Create SSH client config
+	sshConfig := &ssh.ClientConfig{
+		User: creds.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := verifyHostKey(hostname, remote, key)
+			errors.As(err, &mismatch)
+			return err
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	if creds.AuthType == AuthTypeCertificate {
+		signer, err := d.ca.Signer(userID, conn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue SSH certificate: %w", err)
+		}
+		sshConfig.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	} else if creds.AuthType == "key" && creds.PrivateKey != "" {
+		signer, err := d.unlockKey(conn, userID, creds, prompt)
+		if err != nil {
+			return nil, err
+		}
+		sshConfig.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	} else if creds.Password != "" {
+		sshConfig.Auth = []ssh.AuthMethod{ssh.Password(creds.Password)}
+	} else {
+		return nil, fmt.Errorf("no authentication credentials provided")
+	}
+
+	// Connect to SSH server
+	addr := fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+	log.Printf("SSHDialer: Connecting to %s as %s", addr, creds.Username)
+
+	client, err := dialSSH(addr, sshConfig, via)
+	if err != nil {
+		if mismatch != nil {
+			return nil, mismatch
+		}
+		return nil, fmt.Errorf("ssh connection failed: %v", err)
+	}
+
+	return client, nil
+}
+
+// unlockKey builds the signer for creds, asking prompt for the passphrase
+// when the key is protected and none is stored. Wrong passphrases count
+// against the user and key, so reconnecting doesn't allow more guesses.
+func (d *sshDialer) unlockKey(conn *models.SSHConnection, userID uint, creds *Credentials, prompt PassphrasePrompt) (ssh.Signer, error) {
+	signer, err := newKeySigner(creds.PrivateKey, creds.Passphrase, creds.Certificate)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+	if prompt == nil || d.attempts == nil {
+		return nil, ErrPassphraseRequired
+	}
+
+	limit := passphraseLimit(userID, conn, missing.PublicKey)
+	for retry := false; ; retry = true {
+		var limited *RateLimitError
+		if err := checkAttempts(d.attempts, limit); errors.As(err, &limited) {
+			return nil, fmt.Errorf("%w: too many tries, try again in %s", ErrIncorrectPassphrase, limited.RetryAfter.Round(time.Second))
+		}
+
+		passphrase, err := prompt(conn, retry)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := newKeySigner(creds.PrivateKey, passphrase, creds.Certificate)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			countAttempt(d.attempts, limit)
+			continue
+		}
+		if err == nil {
+			resetAttempts(d.attempts, limit)
+		}
+		return signer, err
+	}
+}
+
+// passphraseLimit identifies the key by its fingerprint. Legacy PEM keys
+// don't reveal their public half while locked, so the connection stands in.
+func passphraseLimit(userID uint, conn *models.SSHConnection, key ssh.PublicKey) attemptLimit {
+	keyID := fmt.Sprintf("connection:%d", conn.ID)
+	if key != nil {
+		keyID = ssh.FingerprintSHA256(key)
+	}
+	return attemptLimit{
+		key:       fmt.Sprintf("passphrase:%d:%s", userID, keyID),
+		free:      maxPassphraseAttempts,
+		base:      time.Second,
+		max:       passphraseLockout,
+		lockAfter: maxPassphraseAttempts,
+		window:    passphraseLockout,
+	}
+}
+
+// newKeySigner parses a private key, unlocking it with passphrase and
+// presenting certificate with it when they are set
+func newKeySigner(privateKey, passphrase, certificate string) (ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(privateKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	if certificate == "" {
+		return signer, nil
+	}
+
+	cert, err := parseCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate does not match the private key: %v", err)
+	}
+	return certSigner, nil
+}
+
+// keyPublicKey checks a private key before it is stored and returns its
+// public half. A protected key given without its passphrase is accepted,
+// since the passphrase can be asked for at connect time; its public half is
+// nil when the key format doesn't carry it unencrypted.
+func keyPublicKey(privateKey, passphrase string) (ssh.PublicKey, error) {
+	signer, err := newKeySigner(privateKey, passphrase, "")
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case err == nil:
+		return signer.PublicKey(), nil
+	case errors.As(err, &missing):
+		return missing.PublicKey, nil
+	case errors.Is(err, x509.IncorrectPasswordError):
+		return nil, errors.New("passphrase does not unlock the private key")
+	default:
+		return nil, err
+	}
+}
+
+// parseCertificate reads an OpenSSH certificate in authorized_keys format
+func parseCertificate(certificate string) (*ssh.Certificate, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("certificate is a plain public key, not an OpenSSH certificate")
+	}
+	return cert, nil
+}
+
+// checkCertificate makes sure certificate was issued for key. A nil key
+// can't be compared and is let through to fail at connect time instead.
+func checkCertificate(certificate string, key ssh.PublicKey) error {
+	cert, err := parseCertificate(certificate)
+	if err != nil {
+		return err
+	}
+	if key != nil && !bytes.Equal(cert.Key.Marshal(), key.Marshal()) {
+		return errors.New("certificate does not match the private key")
+	}
+	return nil
+}
+
+// dialSSH connects to addr directly or through via. Neither the TCP connect
+// nor a jump host's channel open nor the handshake may take longer than
+// config.Timeout, so an unresponsive hop can't hold the request forever.
+func dialSSH(addr string, config *ssh.ClientConfig, via *ssh.Client) (*ssh.Client, error) {
+	var netConn net.Conn
+	var err error
+	if via == nil {
+		netConn, err = net.DialTimeout("tcp", addr, config.Timeout)
+	} else {
+		netConn, err = dialVia(via, addr, config.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Tunnelled channels don't support deadlines; closing the connection
+	// when time is up ends the handshake all the same
+	var timer *time.Timer
+	if netConn.SetDeadline(time.Now().Add(config.Timeout)) != nil {
+		timer = time.AfterFunc(config.Timeout, func() { netConn.Close() })
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if timer != nil && !timer.Stop() && err == nil {
+		c.Close()
+		err = errors.New("ssh handshake timed out")
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialVia opens a channel to addr through a jump host, giving up after
+// timeout. A channel that opens too late is closed once it does.
+func dialVia(via *ssh.Client, addr string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		conn, err := via.Dial("tcp", addr)
+		opened <- result{conn, err}
+	}()
+
+	select {
+	case r := <-opened:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-opened; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("dial %s through jump host: timed out", addr)
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newProtectedTestKey returns an OpenSSH private key locked with passphrase,
+// and its public half
+func newProtectedTestKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(private, "test", []byte(passphrase))
+	if err != nil {
+		t.Fatalf("MarshalPrivateKeyWithPassphrase: %v", err)
+	}
+	public, err := ssh.NewPublicKey(private.Public())
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	return string(pem.EncodeToMemory(block)), public
+}
+
+// testPrompt answers passphrase prompts from a list, recording the retry
+// flag of each
+type testPrompt struct {
+	answers []string
+	retries []bool
+}
+
+func (p *testPrompt) ask(conn *models.SSHConnection, retry bool) (string, error) {
+	p.retries = append(p.retries, retry)
+	if len(p.answers) == 0 {
+		return "", errors.New("no more answers")
+	}
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+	return answer, nil
+}
+
+// newTestKeyConnection saves a connection that logs into server with key,
+// which server accepts
+func newTestKeyConnection(t *testing.T, ts *testSSHServices, userID uint, server *testSSHServer, key string, public ssh.PublicKey, passphrase *string) *models.SSHConnection {
+	t.Helper()
+	server.authorize(public)
+	conn, err := ts.ssh.Create(userID, SSHConnectionRequest{
+		Name: "key", Host: "127.0.0.1", Port: server.port, Username: "me", AuthType: "key",
+		PrivateKey: key, Passphrase: passphrase,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return conn
+}
+
+func TestProtectedKeyAsksForPassphrase(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	server := newTestSSHServer(t)
+	key, public := newProtectedTestKey(t, "hunter2")
+	conn := newTestKeyConnection(t, ts, user.ID, server, key, public, nil)
+	dialer := newTestDialer(ts, NewMemoryAttemptStore())
+
+	if _, _, err := dialer.Dial(conn.ID, user.ID, nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("Dial without a prompt: got %v, want ErrPassphraseRequired", err)
+	}
+
+	prompt := &testPrompt{answers: []string{"wrong", "hunter2"}}
+	client, _, err := dialer.Dial(conn.ID, user.ID, prompt.ask)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client.Close()
+	if len(prompt.retries) != 2 || prompt.retries[0] || !prompt.retries[1] {
+		t.Errorf("prompted with retry flags %v, want [false true]", prompt.retries)
+	}
+}
+
+func TestWrongPassphrasesLockTheKey(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	server := newTestSSHServer(t)
+	key, public := newProtectedTestKey(t, "hunter2")
+	conn := newTestKeyConnection(t, ts, user.ID, server, key, public, nil)
+	dialer := newTestDialer(ts, NewMemoryAttemptStore())
+
+	prompt := &testPrompt{answers: []string{"a", "b", "c", "d"}}
+	if _, _, err := dialer.Dial(conn.ID, user.ID, prompt.ask); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Fatalf("Dial with wrong passphrases: got %v, want ErrIncorrectPassphrase", err)
+	}
+	if len(prompt.retries) != maxPassphraseAttempts {
+		t.Errorf("prompted %d times, want %d", len(prompt.retries), maxPassphraseAttempts)
+	}
+
+	// Reconnecting doesn't allow more guesses, even the right one
+	prompt = &testPrompt{answers: []string{"hunter2"}}
+	if _, _, err := dialer.Dial(conn.ID, user.ID, prompt.ask); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Errorf("Dial while locked: got %v, want ErrIncorrectPassphrase", err)
+	}
+	if len(prompt.retries) != 0 {
+		t.Error("prompted for a passphrase while the key was locked")
+	}
+}
+
+func TestStoredPassphraseUnlocksKey(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	server := newTestSSHServer(t)
+	key, public := newProtectedTestKey(t, "hunter2")
+
+	_, err := ts.ssh.Create(user.ID, SSHConnectionRequest{
+		Name: "key", Host: "127.0.0.1", Port: server.port, Username: "me", AuthType: "key",
+		PrivateKey: key, Passphrase: strPtr("wrong"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "passphrase") {
+		t.Errorf("saving a key with the wrong passphrase: got %v", err)
+	}
+
+	conn := newTestKeyConnection(t, ts, user.ID, server, key, public, strPtr("hunter2"))
+	client, _, err := newTestDialer(ts, nil).Dial(conn.ID, user.ID, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client.Close()
+}
+
+func TestGenerateSSHIdentityKeys(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	tests := []struct {
+		req      GenerateKeyRequest
+		wantType string // "" when the request is refused
+	}{
+		{GenerateKeyRequest{Name: "default"}, ssh.KeyAlgoED25519},
+		{GenerateKeyRequest{Name: "rsa", Type: "rsa", Bits: 2048}, ssh.KeyAlgoRSA},
+		{GenerateKeyRequest{Name: "ecdsa", Type: "ecdsa", Bits: 384}, ssh.KeyAlgoECDSA384},
+		{GenerateKeyRequest{Name: "weak rsa", Type: "rsa", Bits: 1024}, ""},
+		{GenerateKeyRequest{Name: "odd curve", Type: "ecdsa", Bits: 300}, ""},
+		{GenerateKeyRequest{Name: "dsa", Type: "dsa"}, ""},
+		{GenerateKeyRequest{Type: "ed25519"}, ""},
+	}
+	for _, tt := range tests {
+		identity, err := ts.identities.Generate(user.ID, tt.req)
+		if tt.wantType == "" {
+			if !errors.Is(err, ErrInvalidSSHIdentity) {
+				t.Errorf("%s: got %v, want ErrInvalidSSHIdentity", tt.req.Name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.req.Name, err)
+			continue
+		}
+
+		public, _, _, _, err := ssh.ParseAuthorizedKey([]byte(identity.PublicKey))
+		if err != nil || public.Type() != tt.wantType {
+			t.Errorf("%s: public key %q (%v), want a %s key", tt.req.Name, identity.PublicKey, err, tt.wantType)
+			continue
+		}
+		// The stored private half matches the public key handed out
+		private, err := ts.secrets.Get(identity.PrivateKey)
+		if err != nil {
+			t.Fatalf("%s: Get private key: %v", tt.req.Name, err)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(private))
+		if err != nil || string(signer.PublicKey().Marshal()) != string(public.Marshal()) {
+			t.Errorf("%s: stored private key doesn't match the public key (%v)", tt.req.Name, err)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSSHIdentityNotFound = errors.New("SSH identity not found")
+	ErrSSHIdentityInUse    = errors.New("SSH identity is still used by connections")
+	ErrInvalidSSHIdentity  = errors.New("invalid SSH identity")
+	// ErrSSHIdentityRevoked is returned when a connection uses the identity
+	// of someone who can no longer see it, such as a former team member
+	ErrSSHIdentityRevoked = errors.New("the connection's SSH identity belongs to someone who no longer has access to it")
+)
+
+// SSHIdentityService manages the logins users save once and use on many
+// connections. Identities are personal; a team connection may use its
+// owner's identity like it would carry the owner's own credentials.
+type SSHIdentityService interface {
+	Create(userID uint, req SSHIdentityRequest) (*models.SSHIdentity, error)
+	List(userID uint) ([]models.SSHIdentity, error)
+	Get(id, userID uint) (*models.SSHIdentity, error)
+	// Update renames the identity or changes or clears its username. Giving
+	// any credential replaces the password, key, passphrase and certificate
+	// together, so every connection using the identity switches at once.
+	Update(id, userID uint, req SSHIdentityRequest) (*models.SSHIdentity, error)
+	// Delete refuses while connections still use the identity
+	Delete(id, userID uint) error
+	// Connections lists the connections the user can still see that log in
+	// with the identity
+	Connections(id, userID uint) ([]models.SSHConnection, error)
+	// Generate creates an identity around a keypair made on the server, so
+	// that the private key is never handled by the user
+	Generate(userID uint, req GenerateKeyRequest) (*models.SSHIdentity, error)
+}
+
+type sshIdentityService struct {
+	repo    repository.SSHIdentityRepository
+	audit   AuditService
+	secrets SecretStore
+}
+
+func NewSSHIdentityService(repo repository.SSHIdentityRepository, audit AuditService, secrets SecretStore) SSHIdentityService {
+	return &sshIdentityService{
+		repo:    repo,
+		audit:   audit,
+		secrets: secrets,
+	}
+}
+
+// SSHIdentityRequest DTO. An identity logs in with either a password or a
+// private key, never both.
+type SSHIdentityRequest struct {
+	Name string `json:"name"`
+	// Username overrides the username of the connections using the
+	// identity; on update, "" clears it and leaving it out keeps it
+	Username    *string `json:"username"`
+	Password    string  `json:"password"`
+	PrivateKey  string  `json:"private_key"`
+	Passphrase  string  `json:"passphrase"`
+	Certificate string  `json:"certificate"`
+}
+
+// GenerateKeyRequest DTO. Type is "ed25519" (the default), "rsa" or
+// "ecdsa"; Bits picks the RSA modulus or ECDSA curve size.
+type GenerateKeyRequest struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Type     string `json:"type"`
+	Bits     int    `json:"bits"`
+}
+
+func (r *SSHIdentityRequest) hasCredentials() bool {
+	return r.Password != "" || r.PrivateKey != "" || r.Passphrase != "" || r.Certificate != ""
+}
+
+func (s *sshIdentityService) Create(userID uint, req SSHIdentityRequest) (*models.SSHIdentity, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidSSHIdentity)
+	}
+
+	identity := &models.SSHIdentity{
+		UserID: userID,
+		Name:   req.Name,
+	}
+	if req.Username != nil {
+		identity.Username = *req.Username
+	}
+	if err := s.setCredentials(identity, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(identity); err != nil {
+		deleteSecrets(s.secrets, identity.Password, identity.PrivateKey, identity.Passphrase)
+		return nil, err
+	}
+
+	s.recordIdentityEvent(AuditSSHIdentityCreate, userID, identity, map[string]interface{}{
+		"name":       identity.Name,
+		"public_key": identity.PublicKey,
+	})
+	return identity, nil
+}
+
+func (s *sshIdentityService) List(userID uint) ([]models.SSHIdentity, error) {
+	return s.repo.ListByUserID(userID)
+}
+
+func (s *sshIdentityService) Get(id, userID uint) (*models.SSHIdentity, error) {
+	identity, err := s.repo.GetByID(id, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSSHIdentityNotFound
+	}
+	return identity, err
+}
+
+func (s *sshIdentityService) Update(id, userID uint, req SSHIdentityRequest) (*models.SSHIdentity, error) {
+	identity, err := s.Get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the names of changed fields are audited, never their values
+	changed := []string{}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		identity.Name = name
+		changed = append(changed, "name")
+	}
+	if req.Username != nil && *req.Username != identity.Username {
+		// Connections without a username of their own log in with this one
+		if *req.Username == "" {
+			if err := s.checkUsernameNotNeeded(id); err != nil {
+				return nil, err
+			}
+		}
+		identity.Username = *req.Username
+		changed = append(changed, "username")
+	}
+
+	// The whole set is swapped in a single row, which every connection
+	// reads at dial time
+	replaced := []string{identity.Password, identity.PrivateKey, identity.Passphrase}
+	if req.hasCredentials() {
+		if err := s.setCredentials(identity, req); err != nil {
+			return nil, err
+		}
+		changed = append(changed, "credentials")
+	} else {
+		replaced = nil
+	}
+
+	if err := s.repo.Update(identity); err != nil {
+		if replaced != nil {
+			deleteSecrets(s.secrets, identity.Password, identity.PrivateKey, identity.Passphrase)
+		}
+		return nil, err
+	}
+	deleteSecrets(s.secrets, replaced...)
+
+	details := map[string]interface{}{"changed": changed}
+	if replaced != nil {
+		connections, err := s.repo.ListConnections(id)
+		if err != nil {
+			return nil, err
+		}
+		details["connections"] = len(connections)
+		details["public_key"] = identity.PublicKey
+	}
+	s.recordIdentityEvent(AuditSSHIdentityUpdate, userID, identity, details)
+	return identity, nil
+}
+
+func (s *sshIdentityService) Delete(id, userID uint) error {
+	identity, err := s.Get(id, userID)
+	if err != nil {
+		return err
+	}
+
+	connections, err := s.repo.ListConnections(id)
+	if err != nil {
+		return err
+	}
+	if len(connections) > 0 {
+		return fmt.Errorf("%w: %q and %d other(s)", ErrSSHIdentityInUse, connections[0].Name, len(connections)-1)
+	}
+
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+	deleteSecrets(s.secrets, identity.Password, identity.PrivateKey, identity.Passphrase)
+
+	s.recordIdentityEvent(AuditSSHIdentityDelete, userID, identity, map[string]interface{}{
+		"name": identity.Name,
+	})
+	return nil
+}
+
+// checkUsernameNotNeeded refuses to clear the username of an identity that
+// a connection without a username of its own logs in with
+func (s *sshIdentityService) checkUsernameNotNeeded(id uint) error {
+	connections, err := s.repo.ListConnections(id)
+	if err != nil {
+		return err
+	}
+	for _, conn := range connections {
+		if conn.Username == "" {
+			return fmt.Errorf("%w: connection %q has no username of its own", ErrSSHIdentityInUse, conn.Name)
+		}
+	}
+	return nil
+}
+
+func (s *sshIdentityService) Connections(id, userID uint) ([]models.SSHConnection, error) {
+	if _, err := s.Get(id, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListVisibleConnections(id, userID)
+}
+
+func (s *sshIdentityService) Generate(userID uint, req GenerateKeyRequest) (*models.SSHIdentity, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidSSHIdentity)
+	}
+	if req.Type == "" {
+		req.Type = "ed25519"
+	}
+
+	privateKey, err := generateKey(req.Type, req.Bits, req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSSHIdentity, err)
+	}
+
+	identity := &models.SSHIdentity{
+		UserID:   userID,
+		Name:     req.Name,
+		Username: req.Username,
+	}
+	if err := s.setCredentials(identity, SSHIdentityRequest{PrivateKey: privateKey}); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(identity); err != nil {
+		deleteSecrets(s.secrets, identity.PrivateKey)
+		return nil, err
+	}
+
+	s.recordIdentityEvent(AuditSSHIdentityCreate, userID, identity, map[string]interface{}{
+		"name":       identity.Name,
+		"public_key": identity.PublicKey,
+		"generated":  req.Type,
+	})
+	return identity, nil
+}
+
+// generateKey makes a new private key in the OpenSSH format. Stored keys are
+// already sealed by the secret store, so it isn't passphrase protected.
+func generateKey(keyType string, bits int, comment string) (string, error) {
+	var key crypto.PrivateKey
+	var err error
+	switch keyType {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		if bits == 0 {
+			bits = 4096
+		}
+		if bits < 2048 || bits > 8192 {
+			return "", errors.New("RSA keys must be 2048 to 8192 bits")
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return "", errors.New("ECDSA keys must be 256, 384 or 521 bits")
+		}
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", keyType)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, comment)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// setCredentials checks the credentials in req and stores them on the
+// identity in place of its current ones
+func (s *sshIdentityService) setCredentials(identity *models.SSHIdentity, req SSHIdentityRequest) error {
+	if req.Password == "" && req.PrivateKey == "" {
+		return fmt.Errorf("%w: a password or private key is required", ErrInvalidSSHIdentity)
+	}
+	// A key would always win over the password, which would sit unused
+	if req.Password != "" && req.PrivateKey != "" {
+		return fmt.Errorf("%w: give a password or a private key, not both", ErrInvalidSSHIdentity)
+	}
+	if req.PrivateKey == "" && (req.Passphrase != "" || req.Certificate != "") {
+		return fmt.Errorf("%w: a passphrase or certificate needs a private key", ErrInvalidSSHIdentity)
+	}
+
+	// A protected key may be saved without its passphrase, which is then
+	// asked for whenever a connection uses the identity
+	publicKey := ""
+	if req.PrivateKey != "" {
+		key, err := keyPublicKey(req.PrivateKey, req.Passphrase)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSSHIdentity, err)
+		}
+		if req.Certificate != "" {
+			if err := checkCertificate(req.Certificate, key); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidSSHIdentity, err)
+			}
+		}
+		if key != nil {
+			publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		}
+	}
+
+	var added []string
+	refs := make([]string, 3)
+	for i, secret := range []string{req.Password, req.PrivateKey, req.Passphrase} {
+		ref, err := putSecret(s.secrets, secret)
+		if err != nil {
+			deleteSecrets(s.secrets, added...)
+			return err
+		}
+		refs[i] = ref
+		added = append(added, ref)
+	}
+
+	identity.Password, identity.PrivateKey, identity.Passphrase = refs[0], refs[1], refs[2]
+	identity.Certificate = strings.TrimSpace(req.Certificate)
+	identity.PublicKey = publicKey
+	return nil
+}
+
+func (s *sshIdentityService) recordIdentityEvent(action string, userID uint, identity *models.SSHIdentity, details map[string]interface{}) {
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     action,
+		TargetType: AuditTargetSSHIdentity,
+		TargetID:   identity.ID,
+		Details:    details,
+	})
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+)
+
+func strPtr(s string) *string { return &s }
+func uintPtr(v uint) *uint    { return &v }
+
+func TestCreateSSHIdentityValidatesCredentials(t *testing.T) {
+	svc := newTestSSHServices(t, newTestDB(t))
+	user := createTestUser(t, svc.db, "alice@example.com", "correct horse")
+	key, err := generateKey("ed25519", 0, "test")
+	if err != nil {
+		t.Fatalf("generateKey: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  SSHIdentityRequest
+		ok   bool
+	}{
+		{"password", SSHIdentityRequest{Name: "pw", Password: "secret"}, true},
+		{"key", SSHIdentityRequest{Name: "key", PrivateKey: key}, true},
+		{"no name", SSHIdentityRequest{Password: "secret"}, false},
+		{"no credentials", SSHIdentityRequest{Name: "empty"}, false},
+		{"password and key", SSHIdentityRequest{Name: "both", Password: "secret", PrivateKey: key}, false},
+		{"passphrase without key", SSHIdentityRequest{Name: "pp", Password: "secret", Passphrase: "x"}, false},
+		{"broken key", SSHIdentityRequest{Name: "bad", PrivateKey: "not a key"}, false},
+	}
+	for _, tt := range tests {
+		identity, err := svc.identities.Create(user.ID, tt.req)
+		if !tt.ok {
+			if !errors.Is(err, ErrInvalidSSHIdentity) {
+				t.Errorf("%s: got %v, want ErrInvalidSSHIdentity", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if (tt.req.PrivateKey != "") != (identity.PublicKey != "") {
+			t.Errorf("%s: public key %q", tt.name, identity.PublicKey)
+		}
+	}
+}
+
+func TestSSHIdentityUpdateReachesEveryConnection(t *testing.T) {
+	svc := newTestSSHServices(t, newTestDB(t))
+	user := createTestUser(t, svc.db, "alice@example.com", "correct horse")
+	identity, err := svc.identities.Create(user.ID, SSHIdentityRequest{Name: "deploy", Username: strPtr("deploy"), Password: "old"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var conns []*models.SSHConnection
+	for _, host := range []string{"192.0.2.1", "192.0.2.2"} {
+		conn, err := svc.ssh.Create(user.ID, SSHConnectionRequest{Name: host, Host: host, IdentityID: &identity.ID})
+		if err != nil {
+			t.Fatalf("Create connection: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	if _, err := svc.identities.Update(identity.ID, user.ID, SSHIdentityRequest{Password: "new"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	for _, conn := range conns {
+		creds, _, err := svc.ssh.GetDecryptedCredentials(conn.ID, user.ID)
+		if err != nil {
+			t.Fatalf("GetDecryptedCredentials: %v", err)
+		}
+		if creds.Password != "new" || creds.Username != "deploy" {
+			t.Errorf("%s logs in as %q with %q, want deploy with the new password", conn.Name, creds.Username, creds.Password)
+		}
+	}
+
+	// The connections have no username of their own to fall back on
+	if _, err := svc.identities.Update(identity.ID, user.ID, SSHIdentityRequest{Username: strPtr("")}); !errors.Is(err, ErrSSHIdentityInUse) {
+		t.Errorf("clearing the username: got %v, want ErrSSHIdentityInUse", err)
+	}
+	if err := svc.identities.Delete(identity.ID, user.ID); !errors.Is(err, ErrSSHIdentityInUse) {
+		t.Errorf("Delete while in use: got %v, want ErrSSHIdentityInUse", err)
+	}
+	for _, conn := range conns {
+		if err := svc.ssh.Delete(conn.ID, user.ID); err != nil {
+			t.Fatalf("Delete connection: %v", err)
+		}
+	}
+	if err := svc.identities.Delete(identity.ID, user.ID); err != nil {
+		t.Errorf("Delete once unused: %v", err)
+	}
+}
+
+func TestSSHIdentityOfFormerTeamMember(t *testing.T) {
+	svc := newTestSSHServices(t, newTestDB(t))
+	owner := createTestUser(t, svc.db, "owner@example.com", "correct horse")
+	member := createTestUser(t, svc.db, "member@example.com", "correct horse")
+	team := createTestTeam(t, svc.teams, "ops", owner.ID, map[uint]string{member.ID: models.TeamRoleAdmin})
+
+	identity, err := svc.identities.Create(member.ID, SSHIdentityRequest{Name: "mine", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Create identity: %v", err)
+	}
+	shared, err := svc.ssh.Create(member.ID, SSHConnectionRequest{Name: "db", Host: "192.0.2.5", Username: "ops", TeamID: &team.ID, IdentityID: &identity.ID})
+	if err != nil {
+		t.Fatalf("Create team connection: %v", err)
+	}
+	if creds, _, err := svc.ssh.GetDecryptedCredentials(shared.ID, owner.ID); err != nil || creds.Password != "secret" {
+		t.Fatalf("teammate connecting with the member's identity: %v", err)
+	}
+
+	if err := svc.teams.DeleteMember(team.ID, member.ID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+
+	// The member no longer sees the team connection, and it no longer logs
+	// in with their identity
+	connections, err := svc.identities.Connections(identity.ID, member.ID)
+	if err != nil {
+		t.Fatalf("Connections: %v", err)
+	}
+	if len(connections) != 0 {
+		t.Errorf("Connections after leaving the team = %d, want none", len(connections))
+	}
+	if _, _, err := svc.ssh.GetDecryptedCredentials(shared.ID, owner.ID); !errors.Is(err, ErrSSHIdentityRevoked) {
+		t.Errorf("connecting after the member left: got %v, want ErrSSHIdentityRevoked", err)
+	}
+
+	// Only the owner of an identity can attach it to a connection
+	if _, err := svc.ssh.Update(shared.ID, owner.ID, SSHConnectionRequest{IdentityID: uintPtr(identity.ID)}); err != nil {
+		t.Fatalf("Update keeping the identity: %v", err)
+	}
+	other, err := svc.ssh.Create(owner.ID, SSHConnectionRequest{Name: "web", Host: "192.0.2.6", Username: "web", Password: "pw"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := svc.ssh.Update(other.ID, owner.ID, SSHConnectionRequest{IdentityID: uintPtr(identity.ID)}); !errors.Is(err, ErrSSHIdentityNotFound) {
+		t.Errorf("attaching someone else's identity: got %v, want ErrSSHIdentityNotFound", err)
+	}
+}
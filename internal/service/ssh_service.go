@@ -2,11 +2,25 @@ package service
 
 import (
 	"errors"
+	"fmt"
 
 	"ssh-terminal-app/internal/config"
 	"ssh-terminal-app/internal/models"
 	"ssh-terminal-app/internal/repository"
-	"ssh-terminal-app/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// maxJumpHosts limits how many hops a connection may tunnel through
+const maxJumpHosts = 5
+
+// AuthTypeCertificate connections log in with a short-lived certificate from
+// the app's SSH CA and store no credentials
+const AuthTypeCertificate = "certificate"
+
+var (
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrConnectionInUse    = errors.New("connection is used as a jump host")
 )
 
 type SSHService interface {
@@ -15,35 +29,76 @@ type SSHService interface {
 	Get(id, userID uint) (*models.SSHConnection, error)
 	Update(id, userID uint, req SSHConnectionRequest) (*models.SSHConnection, error)
 	Delete(id, userID uint) error
-	// DecryptCredentials helps retrieving raw password/key for connection
-	GetDecryptedCredentials(id, userID uint) (string, string, *models.SSHConnection, error)
+	// DecryptCredentials helps retrieving raw password/key for connection,
+	// from the identity it uses if it has one. Only used server side to dial,
+	// so it requires the connect permission; secrets are never returned to
+	// clients.
+	GetDecryptedCredentials(id, userID uint) (*Credentials, *models.SSHConnection, error)
+}
+
+// Credentials are what a connection logs in with
+type Credentials struct {
+	Username    string
+	AuthType    string // "password", "key" or "certificate"
+	Password    string
+	PrivateKey  string
+	Passphrase  string // Unlocks PrivateKey
+	Certificate string // OpenSSH certificate for PrivateKey
 }
 
 type sshService struct {
-	repo repository.SSHRepository
-	cfg  *config.Config
+	repo       repository.SSHRepository
+	identities repository.SSHIdentityRepository
+	authz      Authorizer
+	audit      AuditService
+	secrets    SecretStore
+	cfg        *config.Config
 }
 
-func NewSSHService(repo repository.SSHRepository, cfg *config.Config) SSHService {
+func NewSSHService(repo repository.SSHRepository, identities repository.SSHIdentityRepository, authz Authorizer, audit AuditService, secrets SecretStore, cfg *config.Config) SSHService {
 	return &sshService{
-		repo: repo,
-		cfg:  cfg,
+		repo:       repo,
+		identities: identities,
+		authz:      authz,
+		audit:      audit,
+		secrets:    secrets,
+		cfg:        cfg,
 	}
 }
 
 // SSHConnectionRequest DTO
 type SSHConnectionRequest struct {
-	Name       string
-	Host       string
-	Port       int
-	Username   string
-	Password   string
-	PrivateKey string
-	AuthType   string
+	Name           string  `json:"name"`
+	Host           string  `json:"host"`
+	Port           int     `json:"port"`
+	Username       string  `json:"username"`
+	Password       string  `json:"password"`
+	PrivateKey     string  `json:"private_key"`
+	AuthType       string  `json:"auth_type"`
+	RecordSessions *bool   `json:"record_sessions"`
+	JumpHostIDs    *[]uint `json:"jump_host_ids"`
+	// Passphrase unlocks PrivateKey; leaving it out or "" keeps the stored
+	// one. A new key without one forgets the old key's.
+	Passphrase *string `json:"passphrase"`
+	// ClearPassphrase forgets the stored passphrase, so that it is asked for
+	// at connect time
+	ClearPassphrase bool `json:"clear_passphrase"`
+	// TeamID shares the connection with a team; 0 makes it personal again
+	TeamID *uint `json:"team_id"`
+	// IdentityID logs in with one of the user's saved identities instead of
+	// the connection's own credentials; 0 stops using it
+	IdentityID *uint `json:"identity_id"`
 }
 
 func (s *sshService) Create(userID uint, req SSHConnectionRequest) (*models.SSHConnection, error) {
-	if req.Name == "" || req.Host == "" || req.Username == "" {
+	var identity *models.SSHIdentity
+	if req.IdentityID != nil && *req.IdentityID != 0 {
+		var err error
+		if identity, err = s.getIdentity(*req.IdentityID, userID); err != nil {
+			return nil, err
+		}
+	}
+	if req.Name == "" || req.Host == "" || (req.Username == "" && (identity == nil || identity.Username == "")) {
 		return nil, errors.New("name, host, and username are required")
 	}
 
@@ -54,38 +109,65 @@ func (s *sshService) Create(userID uint, req SSHConnectionRequest) (*models.SSHC
 		req.AuthType = "password"
 	}
 
-	var encryptedPassword, encryptedKey string
-	var err error
-
-	if req.Password != "" {
-		encryptedPassword, err = utils.Encrypt(req.Password, s.cfg.EncryptionKey)
-		if err != nil {
+	conn := &models.SSHConnection{
+		UserID:   userID,
+		Name:     req.Name,
+		Host:     req.Host,
+		Port:     req.Port,
+		Username: req.Username,
+		AuthType: req.AuthType,
+	}
+	if identity != nil {
+		conn.IdentityID = &identity.ID
+	}
+	if req.RecordSessions != nil {
+		conn.RecordSessions = *req.RecordSessions
+	}
+	if req.TeamID != nil && *req.TeamID != 0 {
+		if _, err := s.authz.AuthorizeTeam(*req.TeamID, userID, PermManageSharing); err != nil {
 			return nil, err
 		}
+		conn.TeamID = req.TeamID
 	}
-
-	if req.PrivateKey != "" {
-		encryptedKey, err = utils.Encrypt(req.PrivateKey, s.cfg.EncryptionKey)
-		if err != nil {
+	if req.JumpHostIDs != nil {
+		if err := s.validateJumpHosts(0, userID, conn.TeamID, *req.JumpHostIDs); err != nil {
 			return nil, err
 		}
+		conn.JumpHostIDs = *req.JumpHostIDs
 	}
 
-	conn := &models.SSHConnection{
-		UserID:     userID,
-		Name:       req.Name,
-		Host:       req.Host,
-		Port:       req.Port,
-		Username:   req.Username,
-		Password:   encryptedPassword,
-		PrivateKey: encryptedKey,
-		AuthType:   req.AuthType,
+	passphrase := ""
+	if req.Passphrase != nil {
+		passphrase = *req.Passphrase
+	}
+	if err := checkConnectionKey(req.PrivateKey, passphrase); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if conn.Password, err = putSecret(s.secrets, req.Password); err != nil {
+		return nil, err
+	}
+	if conn.PrivateKey, err = putSecret(s.secrets, req.PrivateKey); err != nil {
+		deleteSecrets(s.secrets, conn.Password)
+		return nil, err
+	}
+	if conn.Passphrase, err = putSecret(s.secrets, passphrase); err != nil {
+		deleteSecrets(s.secrets, conn.Password, conn.PrivateKey)
+		return nil, err
 	}
 
 	if err := s.repo.Create(conn); err != nil {
+		deleteSecrets(s.secrets, conn.Password, conn.PrivateKey, conn.Passphrase)
 		return nil, err
 	}
 
+	s.recordConnectionEvent(AuditConnectionCreate, userID, conn, map[string]interface{}{
+		"name":    conn.Name,
+		"host":    conn.Host,
+		"port":    conn.Port,
+		"team_id": conn.TeamID,
+	})
 	return conn, nil
 }
 
@@ -98,75 +180,357 @@ func (s *sshService) Get(id, userID uint) (*models.SSHConnection, error) {
 }
 
 func (s *sshService) Update(id, userID uint, req SSHConnectionRequest) (*models.SSHConnection, error) {
-	conn, err := s.repo.GetByID(id, userID)
+	conn, err := s.authz.AuthorizeConnection(id, userID, PermEdit)
 	if err != nil {
 		return nil, err
 	}
 
+	// Only the names of changed fields are audited, never their values
+	changed := []string{}
 	if req.Name != "" {
 		conn.Name = req.Name
+		changed = append(changed, "name")
 	}
+	// The recorded host key stays bound to the old endpoint, so the first
+	// connect to a new one asks for its key to be accepted
 	if req.Host != "" {
 		conn.Host = req.Host
+		changed = append(changed, "host")
 	}
 	if req.Port != 0 {
 		conn.Port = req.Port
+		changed = append(changed, "port")
 	}
 	if req.Username != "" {
 		conn.Username = req.Username
+		changed = append(changed, "username")
 	}
 	if req.AuthType != "" {
 		conn.AuthType = req.AuthType
+		changed = append(changed, "auth_type")
+	}
+	if req.RecordSessions != nil {
+		conn.RecordSessions = *req.RecordSessions
+		changed = append(changed, "record_sessions")
 	}
 
+	teamChanged := false
+	if req.TeamID != nil && !sameID(conn.TeamID, *req.TeamID) {
+		if _, err := s.authz.AuthorizeConnection(id, userID, PermManageSharing); err != nil {
+			return nil, err
+		}
+		if *req.TeamID == 0 {
+			conn.TeamID = nil
+			conn.UserID = userID
+		} else {
+			if _, err := s.authz.AuthorizeTeam(*req.TeamID, userID, PermManageSharing); err != nil {
+				return nil, err
+			}
+			conn.TeamID = req.TeamID
+		}
+		teamChanged = true
+		changed = append(changed, "team_id")
+	}
+	if req.JumpHostIDs != nil {
+		conn.JumpHostIDs = *req.JumpHostIDs
+		changed = append(changed, "jump_host_ids")
+	}
+	// Only the identity's owner may start using it; others editing a team
+	// connection keep whatever it already uses
+	if req.IdentityID != nil && !sameID(conn.IdentityID, *req.IdentityID) {
+		if *req.IdentityID == 0 {
+			conn.IdentityID = nil
+		} else {
+			if _, err := s.getIdentity(*req.IdentityID, userID); err != nil {
+				return nil, err
+			}
+			conn.IdentityID = req.IdentityID
+		}
+		changed = append(changed, "identity_id")
+	}
+	// Hops must stay reachable by everyone who can use the connection
+	if req.JumpHostIDs != nil || teamChanged {
+		if err := s.validateJumpHosts(id, userID, conn.TeamID, conn.JumpHostIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	newPassphrase := req.Passphrase != nil && *req.Passphrase != ""
+	if newPassphrase && req.ClearPassphrase {
+		return nil, errors.New("give a new passphrase or clear it, not both")
+	}
+
+	// A new key or passphrase is checked against whichever of the two stays
+	keyChanged := req.PrivateKey != "" || newPassphrase || req.ClearPassphrase
+	if keyChanged {
+		privateKey, passphrase, err := s.updatedKey(conn, req)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkConnectionKey(privateKey, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replaced secrets are deleted once the connection no longer points at
+	// them; new ones are deleted if it never does
+	var replaced, added []string
 	if req.Password != "" {
-		encrypted, err := utils.Encrypt(req.Password, s.cfg.EncryptionKey)
+		ref, err := putSecret(s.secrets, req.Password)
 		if err != nil {
 			return nil, err
 		}
-		conn.Password = encrypted
+		replaced, added = append(replaced, conn.Password), append(added, ref)
+		conn.Password = ref
+		changed = append(changed, "password")
 	}
 
 	if req.PrivateKey != "" {
-		encrypted, err := utils.Encrypt(req.PrivateKey, s.cfg.EncryptionKey)
+		ref, err := putSecret(s.secrets, req.PrivateKey)
 		if err != nil {
+			deleteSecrets(s.secrets, added...)
 			return nil, err
 		}
-		conn.PrivateKey = encrypted
+		replaced, added = append(replaced, conn.PrivateKey), append(added, ref)
+		conn.PrivateKey = ref
+		changed = append(changed, "private_key")
+	}
+
+	if keyChanged {
+		ref := ""
+		if newPassphrase {
+			if ref, err = putSecret(s.secrets, *req.Passphrase); err != nil {
+				deleteSecrets(s.secrets, added...)
+				return nil, err
+			}
+		}
+		replaced, added = append(replaced, conn.Passphrase), append(added, ref)
+		conn.Passphrase = ref
+		changed = append(changed, "passphrase")
 	}
 
 	if err := s.repo.Update(conn); err != nil {
+		deleteSecrets(s.secrets, added...)
 		return nil, err
 	}
+	deleteSecrets(s.secrets, replaced...)
 
+	s.recordConnectionEvent(AuditConnectionUpdate, userID, conn, map[string]interface{}{"changed": changed})
 	return conn, nil
 }
 
 func (s *sshService) Delete(id, userID uint) error {
-	return s.repo.Delete(id, userID)
-}
+	conn, err := s.authz.AuthorizeConnection(id, userID, PermDelete)
+	if err != nil {
+		return err
+	}
 
-func (s *sshService) GetDecryptedCredentials(id, userID uint) (string, string, *models.SSHConnection, error) {
-	conn, err := s.repo.GetByID(id, userID)
+	// Refuse to break the chains of connections that tunnel through this
+	// one, including teammates' personal ones
+	dependents, err := s.repo.ListJumpingThrough(id)
 	if err != nil {
-		return "", "", nil, err
+		return err
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("%w by %d connection(s), including %s", ErrConnectionInUse, len(dependents), s.describeDependent(&dependents[0], userID))
+	}
+
+	if err := s.repo.Delete(id); err != nil {
+		return err
 	}
+	deleteSecrets(s.secrets, conn.Password, conn.PrivateKey, conn.Passphrase)
 
-	var password, privateKey string
+	s.recordConnectionEvent(AuditConnectionDelete, userID, conn, map[string]interface{}{
+		"name": conn.Name,
+		"host": conn.Host,
+	})
+	return nil
+}
 
-	if conn.Password != "" {
-		password, err = utils.Decrypt(conn.Password, s.cfg.EncryptionKey)
+// describeDependent names a connection the user can see; others belong to
+// someone else and only their existence is told
+func (s *sshService) describeDependent(conn *models.SSHConnection, userID uint) string {
+	if _, err := s.repo.GetByID(conn.ID, userID); err != nil {
+		return "one you can't see"
+	}
+	return fmt.Sprintf("%q", conn.Name)
+}
+
+func (s *sshService) recordConnectionEvent(action string, userID uint, conn *models.SSHConnection, details map[string]interface{}) {
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     action,
+		TargetType: AuditTargetConnection,
+		TargetID:   conn.ID,
+		Details:    details,
+	})
+}
+
+// sameID reports whether id (0 for none) is the team or identity current
+// refers to
+func sameID(current *uint, id uint) bool {
+	if current == nil {
+		return id == 0
+	}
+	return *current == id
+}
+
+// validateJumpHosts checks that every hop is another connection the user can
+// reach; id is 0 for a connection that doesn't exist yet. A team connection
+// may only tunnel through connections of the same team, so that every member
+// can dial the whole chain.
+func (s *sshService) validateJumpHosts(id, userID uint, teamID *uint, jumpIDs []uint) error {
+	if len(jumpIDs) > maxJumpHosts {
+		return fmt.Errorf("at most %d jump hosts are allowed", maxJumpHosts)
+	}
+
+	seen := make(map[uint]bool)
+	for _, jumpID := range jumpIDs {
+		if jumpID == id {
+			return errors.New("a connection cannot be its own jump host")
+		}
+		if seen[jumpID] {
+			return fmt.Errorf("jump host %d is listed more than once", jumpID)
+		}
+		seen[jumpID] = true
+
+		jump, err := s.repo.GetByID(jumpID, userID)
 		if err != nil {
-			return "", "", nil, err
+			return fmt.Errorf("jump host %d not found", jumpID)
+		}
+		if teamID != nil && (jump.TeamID == nil || *jump.TeamID != *teamID) {
+			return fmt.Errorf("jump host %q is not shared with the same team", jump.Name)
 		}
 	}
+	return nil
+}
 
-	if conn.PrivateKey != "" {
-		privateKey, err = utils.Decrypt(conn.PrivateKey, s.cfg.EncryptionKey)
-		if err != nil {
-			return "", "", nil, err
+func (s *sshService) GetDecryptedCredentials(id, userID uint) (*Credentials, *models.SSHConnection, error) {
+	conn, err := s.authz.AuthorizeConnection(id, userID, PermConnect)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var creds *Credentials
+	details := map[string]interface{}{
+		"host": fmt.Sprintf("%s:%d", conn.Host, conn.Port),
+	}
+	if conn.IdentityID != nil {
+		creds, err = s.identityCredentials(conn)
+		details["identity_id"] = *conn.IdentityID
+	} else {
+		creds, err = s.connectionCredentials(conn)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.recordConnectionEvent(AuditCredentialAccess, userID, conn, details)
+	return creds, conn, nil
+}
+
+func (s *sshService) connectionCredentials(conn *models.SSHConnection) (*Credentials, error) {
+	creds := &Credentials{Username: conn.Username, AuthType: conn.AuthType}
+
+	var err error
+	if creds.Password, err = s.getSecret(conn.Password); err != nil {
+		return nil, err
+	}
+	if creds.PrivateKey, err = s.getSecret(conn.PrivateKey); err != nil {
+		return nil, err
+	}
+	if creds.Passphrase, err = s.getSecret(conn.Passphrase); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// updatedKey returns the private key and passphrase conn will have once req
+// is applied, reading the stored ones that req leaves in place
+func (s *sshService) updatedKey(conn *models.SSHConnection, req SSHConnectionRequest) (string, string, error) {
+	privateKey := req.PrivateKey
+	if privateKey == "" {
+		var err error
+		if privateKey, err = s.getSecret(conn.PrivateKey); err != nil {
+			return "", "", err
+		}
+	}
+	passphrase := ""
+	if req.Passphrase != nil {
+		passphrase = *req.Passphrase
+	}
+	return privateKey, passphrase, nil
+}
+
+// checkConnectionKey makes sure a passphrase is only saved with a key it
+// unlocks. A protected key saved without one is accepted and unlocked at
+// connect time.
+func checkConnectionKey(privateKey, passphrase string) error {
+	if privateKey == "" {
+		if passphrase != "" {
+			return errors.New("a passphrase needs a private key")
+		}
+		return nil
+	}
+	_, err := keyPublicKey(privateKey, passphrase)
+	return err
+}
+
+// identityCredentials reads the identity afresh on every dial, so replacing
+// its credentials takes effect for all of its connections at once. An owner
+// who has left the connection's team no longer lends it their identity.
+func (s *sshService) identityCredentials(conn *models.SSHConnection) (*Credentials, error) {
+	identity, err := s.identities.FindByID(*conn.IdentityID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSSHIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.GetByID(conn.ID, identity.UserID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSSHIdentityRevoked
 		}
+		return nil, err
 	}
 
-	return password, privateKey, conn, nil
+	creds := &Credentials{
+		Username:    conn.Username,
+		AuthType:    "password",
+		Certificate: identity.Certificate,
+	}
+	if identity.Username != "" {
+		creds.Username = identity.Username
+	}
+	if identity.PrivateKey != "" {
+		creds.AuthType = "key"
+	}
+
+	if creds.Password, err = s.getSecret(identity.Password); err != nil {
+		return nil, err
+	}
+	if creds.PrivateKey, err = s.getSecret(identity.PrivateKey); err != nil {
+		return nil, err
+	}
+	if creds.Passphrase, err = s.getSecret(identity.Passphrase); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// getSecret reads a stored password or key. An empty reference stays empty.
+func (s *sshService) getSecret(ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	return s.secrets.Get(ref)
+}
+
+// getIdentity finds one of the user's identities to use on a connection
+func (s *sshService) getIdentity(id, userID uint) (*models.SSHIdentity, error) {
+	identity, err := s.identities.GetByID(id, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSSHIdentityNotFound
+	}
+	return identity, err
 }
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestJumpHostsMustBeReachable(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	alice := createTestUser(t, db, "alice@example.com", "correct horse")
+	bob := createTestUser(t, db, "bob@example.com", "correct horse")
+	team := createTestTeam(t, ts.teams, "ops", alice.ID, nil)
+
+	var hops []uint
+	for i := 0; i <= maxJumpHosts; i++ {
+		hops = append(hops, createTestConnection(t, db, alice.ID, fmt.Sprintf("hop%d", i), "192.0.2.1").ID)
+	}
+	bobs := createTestConnection(t, db, bob.ID, "bob's", "192.0.2.2")
+	target := createTestConnection(t, db, alice.ID, "target", "192.0.2.3")
+
+	tests := []struct {
+		name   string
+		teamID *uint
+		jumps  []uint
+		want   string
+	}{
+		{"itself", nil, []uint{target.ID}, "own jump host"},
+		{"twice", nil, []uint{hops[0], hops[0]}, "more than once"},
+		{"someone else's", nil, []uint{bobs.ID}, "not found"},
+		{"too many", nil, hops, "at most"},
+		{"personal hop for a team connection", &team.ID, hops[:1], "same team"},
+	}
+	for _, tt := range tests {
+		err := ts.ssh.validateJumpHosts(target.ID, alice.ID, tt.teamID, tt.jumps)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error about %q", tt.name, err, tt.want)
+		}
+	}
+	if err := ts.ssh.validateJumpHosts(target.ID, alice.ID, nil, hops[:maxJumpHosts]); err != nil {
+		t.Errorf("valid chain: %v", err)
+	}
+}
+
+func TestJumpHostInUseCannotBeDeleted(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+
+	bastion, err := ts.ssh.Create(user.ID, SSHConnectionRequest{Name: "bastion", Host: "192.0.2.1", Username: "me", Password: "pw"})
+	if err != nil {
+		t.Fatalf("Create bastion: %v", err)
+	}
+	target, err := ts.ssh.Create(user.ID, SSHConnectionRequest{Name: "db", Host: "10.0.0.5", Username: "me", Password: "pw", JumpHostIDs: &[]uint{bastion.ID}})
+	if err != nil {
+		t.Fatalf("Create target: %v", err)
+	}
+
+	err = ts.ssh.Delete(bastion.ID, user.ID)
+	if !errors.Is(err, ErrConnectionInUse) || !strings.Contains(err.Error(), `"db"`) {
+		t.Errorf("deleting a jump host in use: got %v, want ErrConnectionInUse naming db", err)
+	}
+	if err := ts.ssh.Delete(target.ID, user.ID); err != nil {
+		t.Fatalf("Delete target: %v", err)
+	}
+	if err := ts.ssh.Delete(bastion.ID, user.ID); err != nil {
+		t.Errorf("deleting an unused jump host: %v", err)
+	}
+}
+
+func TestDialTunnelsThroughJumpHosts(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	bastion, inner, target := newTestSSHServer(t), newTestSSHServer(t), newTestSSHServer(t)
+
+	hop1 := createTestServerConnection(t, ts, user.ID, "bastion", bastion)
+	hop2 := createTestServerConnection(t, ts, user.ID, "inner", inner)
+	conn, err := ts.ssh.Create(user.ID, SSHConnectionRequest{
+		Name: "db", Host: "127.0.0.1", Port: target.port, Username: "me", Password: testSSHPassword, AuthType: "password",
+		JumpHostIDs: &[]uint{hop1.ID, hop2.ID},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	client, _, err := newTestDialer(ts, nil).Dial(conn.ID, user.ID, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		t.Errorf("RequestSubsystem: %v", err)
+	}
+	session.Close()
+
+	// Each hop opened the channel to the next
+	if got := bastion.forwardedTo(); len(got) != 1 || got[0] != fmt.Sprintf("127.0.0.1:%d", inner.port) {
+		t.Errorf("bastion forwarded to %q, want the inner hop", got)
+	}
+	if got := inner.forwardedTo(); len(got) != 1 || got[0] != fmt.Sprintf("127.0.0.1:%d", target.port) {
+		t.Errorf("inner hop forwarded to %q, want the target", got)
+	}
+}
+
+func TestDialReportsFailingHop(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSSHServices(t, db)
+	user := createTestUser(t, db, "alice@example.com", "correct horse")
+	bastion, inner, target := newTestSSHServer(t), newTestSSHServer(t), newTestSSHServer(t)
+
+	hop1 := createTestServerConnection(t, ts, user.ID, "bastion", bastion)
+	hop2, err := ts.ssh.Create(user.ID, SSHConnectionRequest{Name: "inner", Host: "127.0.0.1", Port: inner.port, Username: "me", Password: "wrong", AuthType: "password"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	conn, err := ts.ssh.Create(user.ID, SSHConnectionRequest{
+		Name: "db", Host: "127.0.0.1", Port: target.port, Username: "me", Password: testSSHPassword, AuthType: "password",
+		JumpHostIDs: &[]uint{hop1.ID, hop2.ID},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	_, _, err = newTestDialer(ts, nil).Dial(conn.ID, user.ID, nil)
+	var hopErr *JumpHostError
+	if !errors.As(err, &hopErr) || hopErr.Hop != 2 || hopErr.Name != "inner" {
+		t.Fatalf("Dial: got %v, want a JumpHostError for hop 2 (inner)", err)
+	}
+	if len(target.forwardedTo()) != 0 || len(inner.forwardedTo()) != 0 {
+		t.Error("the chain went on past the failing hop")
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// errOwnersOnly guards granting, changing or removing the owner role
+var errOwnersOnly = fmt.Errorf("%w: only owners can manage owners", ErrPermissionDenied)
+
+var (
+	ErrTeamNotFound   = errors.New("team not found")
+	ErrLastTeamOwner  = errors.New("a team must keep at least one owner")
+	ErrTeamNotEmpty   = errors.New("team still has shared connections")
+	ErrMemberNotFound = errors.New("team member not found")
+	ErrMemberExists   = errors.New("user is already a team member")
+	ErrInvalidRole    = errors.New("role must be owner, admin, operator or viewer")
+)
+
+type TeamService interface {
+	Create(userID uint, name string) (*models.Team, error)
+	List(userID uint) ([]models.Team, error)
+	Get(id, userID uint) (*models.Team, error)
+	Rename(id, userID uint, name string) (*models.Team, error)
+	Delete(id, userID uint) error
+	ListMembers(id, userID uint) ([]TeamMemberInfo, error)
+	// AddMember adds the registered user with the given email to the team
+	AddMember(id, userID uint, email, role string) (*TeamMemberInfo, error)
+	UpdateMember(id, userID, memberID uint, role string) (*TeamMemberInfo, error)
+	// RemoveMember removes memberID from the team; members may remove themselves
+	RemoveMember(id, userID, memberID uint) error
+}
+
+type teamService struct {
+	repo     repository.TeamRepository
+	userRepo repository.UserRepository
+	authz    Authorizer
+}
+
+func NewTeamService(repo repository.TeamRepository, userRepo repository.UserRepository, authz Authorizer) TeamService {
+	return &teamService{
+		repo:     repo,
+		userRepo: userRepo,
+		authz:    authz,
+	}
+}
+
+// TeamMemberInfo describes a member of a team
+type TeamMemberInfo struct {
+	UserID   uint      `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func (s *teamService) Create(userID uint, name string) (*models.Team, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	team := &models.Team{Name: name}
+	if err := s.repo.Create(team, userID); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+func (s *teamService) List(userID uint) ([]models.Team, error) {
+	return s.repo.ListByUserID(userID)
+}
+
+func (s *teamService) Get(id, userID uint) (*models.Team, error) {
+	if _, err := s.authz.AuthorizeTeam(id, userID, PermView); err != nil {
+		return nil, err
+	}
+	return s.getTeam(id)
+}
+
+func (s *teamService) Rename(id, userID uint, name string) (*models.Team, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if _, err := s.authz.AuthorizeTeam(id, userID, PermManageTeam); err != nil {
+		return nil, err
+	}
+
+	team, err := s.getTeam(id)
+	if err != nil {
+		return nil, err
+	}
+	team.Name = name
+	if err := s.repo.Update(team); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+func (s *teamService) Delete(id, userID uint) error {
+	if _, err := s.authz.AuthorizeTeam(id, userID, PermManageTeam); err != nil {
+		return err
+	}
+
+	// Connections would otherwise be left without anyone able to reach them
+	count, err := s.repo.CountConnections(id)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTeamNotEmpty
+	}
+
+	return s.repo.Delete(id)
+}
+
+func (s *teamService) ListMembers(id, userID uint) ([]TeamMemberInfo, error) {
+	if _, err := s.authz.AuthorizeTeam(id, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	members, err := s.repo.ListMembers(id)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]TeamMemberInfo, 0, len(members))
+	for _, member := range members {
+		infos = append(infos, s.memberInfo(member))
+	}
+	return infos, nil
+}
+
+func (s *teamService) AddMember(id, userID uint, email, role string) (*TeamMemberInfo, error) {
+	if role == "" {
+		role = models.TeamRoleOperator
+	}
+	if !validTeamRole(role) {
+		return nil, ErrInvalidRole
+	}
+	actor, err := s.authz.AuthorizeTeam(id, userID, PermManageMembers)
+	if err != nil {
+		return nil, err
+	}
+	if role == models.TeamRoleOwner && actor.Role != models.TeamRoleOwner {
+		return nil, errOwnersOnly
+	}
+
+	user, err := s.userRepo.FindByEmail(strings.TrimSpace(email))
+	if err != nil {
+		return nil, errors.New("no user is registered with that email")
+	}
+
+	if _, err := s.repo.GetMember(id, user.ID); err == nil {
+		return nil, ErrMemberExists
+	}
+
+	member := &models.TeamMember{
+		TeamID: id,
+		UserID: user.ID,
+		Role:   role,
+	}
+	if err := s.repo.SaveMember(member); err != nil {
+		return nil, err
+	}
+
+	info := s.memberInfo(*member)
+	return &info, nil
+}
+
+func (s *teamService) UpdateMember(id, userID, memberID uint, role string) (*TeamMemberInfo, error) {
+	if !validTeamRole(role) {
+		return nil, ErrInvalidRole
+	}
+	actor, err := s.authz.AuthorizeTeam(id, userID, PermManageMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.repo.GetMember(id, memberID)
+	if err != nil {
+		return nil, ErrMemberNotFound
+	}
+	if (member.Role == models.TeamRoleOwner || role == models.TeamRoleOwner) && actor.Role != models.TeamRoleOwner {
+		return nil, errOwnersOnly
+	}
+
+	if member.Role == models.TeamRoleOwner && role != models.TeamRoleOwner {
+		if err := s.keepOwner(id); err != nil {
+			return nil, err
+		}
+	}
+
+	member.Role = role
+	if err := s.repo.SaveMember(member); err != nil {
+		return nil, err
+	}
+
+	info := s.memberInfo(*member)
+	return &info, nil
+}
+
+func (s *teamService) RemoveMember(id, userID, memberID uint) error {
+	actor, err := s.authz.AuthorizeTeam(id, userID, PermView)
+	if err != nil {
+		return err
+	}
+
+	member, err := s.repo.GetMember(id, memberID)
+	if err != nil {
+		return ErrMemberNotFound
+	}
+
+	// Anyone may leave; removing others needs member management, and only
+	// owners may remove owners
+	if memberID != userID {
+		if _, err := s.authz.AuthorizeTeam(id, userID, PermManageMembers); err != nil {
+			return err
+		}
+		if member.Role == models.TeamRoleOwner && actor.Role != models.TeamRoleOwner {
+			return errOwnersOnly
+		}
+	}
+
+	if member.Role == models.TeamRoleOwner {
+		if err := s.keepOwner(id); err != nil {
+			return err
+		}
+	}
+
+	return s.repo.DeleteMember(id, memberID)
+}
+
+// keepOwner fails if removing one owner would leave the team without any
+func (s *teamService) keepOwner(id uint) error {
+	owners, err := s.repo.CountOwners(id)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastTeamOwner
+	}
+	return nil
+}
+
+func (s *teamService) getTeam(id uint) (*models.Team, error) {
+	team, err := s.repo.GetByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTeamNotFound
+	}
+	return team, err
+}
+
+func (s *teamService) memberInfo(member models.TeamMember) TeamMemberInfo {
+	info := TeamMemberInfo{
+		UserID:   member.UserID,
+		Role:     member.Role,
+		JoinedAt: member.CreatedAt,
+	}
+	if user, err := s.userRepo.FindByID(member.UserID); err == nil {
+		info.Name = user.Name
+		info.Email = user.Email
+	}
+	return info
+}
+
+func validTeamRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// newTestTeamService returns a team service and a team whose members are
+// named after their roles, plus an outsider
+func newTestTeamService(t *testing.T) (TeamService, *gorm.DB, *models.Team, map[string]*models.User) {
+	t.Helper()
+	db := newTestDB(t)
+	teams := repository.NewTeamRepository(db)
+	s := NewTeamService(teams, repository.NewUserRepository(db), NewAuthorizer(repository.NewSSHRepository(db), teams))
+
+	users := map[string]*models.User{}
+	for _, name := range []string{"owner", "admin", "operator", "viewer", "outsider"} {
+		users[name] = createTestUser(t, db, name+"@example.com", "correct horse")
+	}
+	team := createTestTeam(t, teams, "ops", users["owner"].ID, map[uint]string{
+		users["admin"].ID:    models.TeamRoleAdmin,
+		users["operator"].ID: models.TeamRoleOperator,
+		users["viewer"].ID:   models.TeamRoleViewer,
+	})
+	return s, db, team, users
+}
+
+func TestTeamMembershipNeedsRole(t *testing.T) {
+	s, _, team, users := newTestTeamService(t)
+
+	if _, err := s.Get(team.ID, users["outsider"].ID); !errors.Is(err, ErrTeamNotFound) {
+		t.Errorf("outsider Get: got %v, want ErrTeamNotFound", err)
+	}
+	if _, err := s.Get(team.ID, users["viewer"].ID); err != nil {
+		t.Errorf("viewer Get: %v", err)
+	}
+	for _, name := range []string{"operator", "viewer"} {
+		if _, err := s.AddMember(team.ID, users[name].ID, "outsider@example.com", models.TeamRoleViewer); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s AddMember: got %v, want ErrPermissionDenied", name, err)
+		}
+		if err := s.RemoveMember(team.ID, users[name].ID, users["admin"].ID); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s RemoveMember: got %v, want ErrPermissionDenied", name, err)
+		}
+	}
+	if _, err := s.Rename(team.ID, users["admin"].ID, "platform"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin Rename: got %v, want ErrPermissionDenied", err)
+	}
+	if err := s.Delete(team.ID, users["admin"].ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin Delete: got %v, want ErrPermissionDenied", err)
+	}
+
+	info, err := s.AddMember(team.ID, users["admin"].ID, "outsider@example.com", "")
+	if err != nil {
+		t.Fatalf("admin AddMember: %v", err)
+	}
+	if info.Role != models.TeamRoleOperator {
+		t.Errorf("default role %q, want operator", info.Role)
+	}
+	if _, err := s.AddMember(team.ID, users["admin"].ID, "outsider@example.com", models.TeamRoleViewer); !errors.Is(err, ErrMemberExists) {
+		t.Errorf("adding twice: got %v, want ErrMemberExists", err)
+	}
+	if _, err := s.UpdateMember(team.ID, users["admin"].ID, users["outsider"].ID, "superuser"); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("unknown role: got %v, want ErrInvalidRole", err)
+	}
+}
+
+func TestOnlyOwnersManageOwners(t *testing.T) {
+	s, _, team, users := newTestTeamService(t)
+	admin := users["admin"].ID
+
+	if _, err := s.AddMember(team.ID, admin, "outsider@example.com", models.TeamRoleOwner); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin adding an owner: got %v, want ErrPermissionDenied", err)
+	}
+	if _, err := s.UpdateMember(team.ID, admin, admin, models.TeamRoleOwner); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin promoting themselves: got %v, want ErrPermissionDenied", err)
+	}
+	if _, err := s.UpdateMember(team.ID, admin, users["owner"].ID, models.TeamRoleViewer); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin demoting the owner: got %v, want ErrPermissionDenied", err)
+	}
+	if err := s.RemoveMember(team.ID, admin, users["owner"].ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin removing the owner: got %v, want ErrPermissionDenied", err)
+	}
+
+	if _, err := s.UpdateMember(team.ID, users["owner"].ID, admin, models.TeamRoleOwner); err != nil {
+		t.Fatalf("owner promoting admin: %v", err)
+	}
+	if _, err := s.UpdateMember(team.ID, admin, users["owner"].ID, models.TeamRoleAdmin); err != nil {
+		t.Errorf("new owner demoting the old one: %v", err)
+	}
+}
+
+func TestTeamKeepsLastOwner(t *testing.T) {
+	s, _, team, users := newTestTeamService(t)
+	owner := users["owner"].ID
+
+	if _, err := s.UpdateMember(team.ID, owner, owner, models.TeamRoleAdmin); !errors.Is(err, ErrLastTeamOwner) {
+		t.Errorf("demoting the last owner: got %v, want ErrLastTeamOwner", err)
+	}
+	if err := s.RemoveMember(team.ID, owner, owner); !errors.Is(err, ErrLastTeamOwner) {
+		t.Errorf("last owner leaving: got %v, want ErrLastTeamOwner", err)
+	}
+
+	// Anyone else may leave without member management rights
+	if err := s.RemoveMember(team.ID, users["viewer"].ID, users["viewer"].ID); err != nil {
+		t.Errorf("viewer leaving: %v", err)
+	}
+	if _, err := s.Get(team.ID, users["viewer"].ID); !errors.Is(err, ErrTeamNotFound) {
+		t.Errorf("Get after leaving: got %v, want ErrTeamNotFound", err)
+	}
+}
+
+func TestTeamWithConnectionsCannotBeDeleted(t *testing.T) {
+	s, db, team, users := newTestTeamService(t)
+	owner := users["owner"].ID
+
+	conn := &models.SSHConnection{UserID: owner, TeamID: &team.ID, Name: "db", Host: "10.0.0.2", Port: 22, Username: "ops", AuthType: "password"}
+	if err := db.Create(conn).Error; err != nil {
+		t.Fatalf("create connection: %v", err)
+	}
+	if err := s.Delete(team.ID, owner); !errors.Is(err, ErrTeamNotEmpty) {
+		t.Errorf("Delete with a connection: got %v, want ErrTeamNotEmpty", err)
+	}
+}
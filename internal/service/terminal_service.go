@@ -2,71 +2,321 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"time"
 
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+
 	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/ssh"
 )
 
+const (
+	defaultShareTTL = time.Hour
+	maxShareTTL     = 24 * time.Hour
+
+	// passphrasePromptTimeout is how long a key passphrase prompt waits for
+	// the user
+	passphrasePromptTimeout = 2 * time.Minute
+	// maxPendingInput caps what a client may type while a prompt waits
+	maxPendingInput = 64 << 10
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrShareNotFound   = errors.New("share link not found or expired")
+	ErrViewerNotFound  = errors.New("viewer not found")
+)
+
 type TerminalService interface {
-	StartSession(ws *websocket.Conn, connID uint, userID uint) error
+	StartSession(ws *websocket.Conn, connID uint, userID uint, remoteAddr string) error
+	// AttachSession reconnects ws to a session that is still running
+	AttachSession(ws *websocket.Conn, sessionID string, userID uint) error
+	// JoinSharedSession attaches another user through a share invite
+	JoinSharedSession(ws *websocket.Conn, shareToken string, userID uint, remoteAddr string) error
+	ListSessions(userID uint) []SessionInfo
+	TerminateSession(sessionID string, userID uint) error
+
+	ShareSession(sessionID string, userID uint, readOnly bool, ttl time.Duration) (*ShareInvite, error)
+	ListShares(sessionID string, userID uint) ([]ShareInvite, error)
+	RevokeShare(sessionID string, userID uint, shareToken string) error
+	ListViewers(sessionID string, userID uint) ([]ViewerInfo, error)
+	KickViewer(sessionID string, userID uint, viewerID string) error
 }
 
 type terminalService struct {
-	sshService SSHService
+	dialer     *sshDialer
+	authz      Authorizer
+	audit      AuditService
+	recordings RecordingService
+	userRepo   repository.UserRepository
+	sessions   *sessionRegistry
+	cfg        *config.Config
 }
 
-func NewTerminalService(sshService SSHService) TerminalService {
+func NewTerminalService(sshService SSHService, hostKeys HostKeyService, ca SSHCAService, authz Authorizer, audit AuditService, recordings RecordingService, userRepo repository.UserRepository, attempts AttemptStore, cfg *config.Config) TerminalService {
 	return &terminalService{
-		sshService: sshService,
+		dialer:     newSSHDialer(sshService, hostKeys, ca, attempts),
+		authz:      authz,
+		audit:      audit,
+		recordings: recordings,
+		userRepo:   userRepo,
+		sessions:   newSessionRegistry(),
+		cfg:        cfg,
+	}
+}
+
+func (s *terminalService) StartSession(ws *websocket.Conn, connID uint, userID uint, remoteAddr string) error {
+	if err := s.checkVerified(userID); err != nil {
+		return err
+	}
+
+	prompt := &passphrasePrompt{ws: ws}
+	session, err := s.openSession(connID, userID, remoteAddr, prompt.ask)
+	if err != nil {
+		return err
+	}
+
+	if !session.attach(ws, s.viewerInfo(userID, true, false)) {
+		return ErrSessionNotFound
+	}
+	session.serve(ws, false, prompt.pending)
+	return nil
+}
+
+func (s *terminalService) AttachSession(ws *websocket.Conn, sessionID string, userID uint) error {
+	session, ok := s.sessions.get(sessionID, userID)
+	if !ok || !session.attach(ws, s.viewerInfo(userID, true, false)) {
+		return ErrSessionNotFound
+	}
+	session.serve(ws, false, nil)
+	return nil
+}
+
+func (s *terminalService) JoinSharedSession(ws *websocket.Conn, shareToken string, userID uint, remoteAddr string) error {
+	if err := s.checkVerified(userID); err != nil {
+		return err
+	}
+
+	session, invite, ok := s.sessions.findByShareToken(shareToken)
+	if !ok {
+		return ErrShareNotFound
+	}
+
+	// The owner following their own link gets full control
+	owner := session.userID == userID
+	readOnly := invite.ReadOnly && !owner
+
+	if !session.attach(ws, s.viewerInfo(userID, owner, readOnly)) {
+		return ErrShareNotFound
+	}
+	log.Printf("TerminalService: User %d joined session %s (read-only: %t)", userID, session.id, readOnly)
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditTerminalJoin,
+		TargetType: AuditTargetConnection,
+		TargetID:   session.connectionID,
+		RemoteAddr: remoteAddr,
+		Details: map[string]interface{}{
+			"session_id": session.id,
+			"owner_id":   session.userID,
+			"read_only":  readOnly,
+		},
+	})
+	session.serve(ws, readOnly, nil)
+	return nil
+}
+
+func (s *terminalService) ListSessions(userID uint) []SessionInfo {
+	sessions := s.sessions.list(userID)
+	infos := make([]SessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[i] = session.info()
+	}
+	return infos
+}
+
+func (s *terminalService) TerminateSession(sessionID string, userID uint) error {
+	session, ok := s.sessions.get(sessionID, userID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.close()
+	return nil
+}
+
+func (s *terminalService) ShareSession(sessionID string, userID uint, readOnly bool, ttl time.Duration) (*ShareInvite, error) {
+	session, ok := s.sessions.get(sessionID, userID)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	// Invitees act on the connection without holding any role on it
+	if _, err := s.authz.AuthorizeConnection(session.connectionID, userID, PermManageSharing); err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = defaultShareTTL
+	}
+	if ttl > maxShareTTL {
+		ttl = maxShareTTL
+	}
+	return session.share(readOnly, ttl)
+}
+
+func (s *terminalService) ListShares(sessionID string, userID uint) ([]ShareInvite, error) {
+	session, ok := s.sessions.get(sessionID, userID)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session.shareList(), nil
+}
+
+func (s *terminalService) RevokeShare(sessionID string, userID uint, shareToken string) error {
+	session, ok := s.sessions.get(sessionID, userID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if !session.revokeShare(shareToken) {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func (s *terminalService) ListViewers(sessionID string, userID uint) ([]ViewerInfo, error) {
+	session, ok := s.sessions.get(sessionID, userID)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session.viewerList(), nil
+}
+
+func (s *terminalService) KickViewer(sessionID string, userID uint, viewerID string) error {
+	session, ok := s.sessions.get(sessionID, userID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if !session.kick(viewerID) {
+		return ErrViewerNotFound
 	}
+	return nil
 }
 
-func (s *terminalService) StartSession(ws *websocket.Conn, connID uint, userID uint) error {
-	// Get credentials and connection info
-	password, privateKey, conn, err := s.sshService.GetDecryptedCredentials(connID, userID)
+func (s *terminalService) viewerInfo(userID uint, owner, readOnly bool) ViewerInfo {
+	info := ViewerInfo{
+		UserID:   userID,
+		Owner:    owner,
+		ReadOnly: readOnly,
+	}
+	if user, err := s.userRepo.FindByID(userID); err == nil {
+		info.Name = user.Name
+		info.Email = user.Email
+	}
+	return info
+}
+
+func (s *terminalService) checkVerified(userID uint) error {
+	return checkEmailVerified(s.userRepo, s.cfg, userID)
+}
+
+// checkEmailVerified keeps users with unconfirmed addresses out of terminals
+// and file browsing when the server requires verification
+func checkEmailVerified(userRepo repository.UserRepository, cfg *config.Config, userID uint) error {
+	if !cfg.RequireVerifiedEmail {
+		return nil
+	}
+	user, err := userRepo.FindByID(userID)
 	if err != nil {
-		return fmt.Errorf("failed to get connection credentials: %v", err)
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		return ErrEmailVerificationRequired
 	}
+	return nil
+}
 
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User:            conn.Username,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, verify host key
-		Timeout:         10 * time.Second,
+// passphrasePrompt asks over ws for the passphrases of keys that have none
+// stored. It runs before the session is attached, so nothing else reads
+// from ws; other messages sent meanwhile, such as early keystrokes or a
+// resize, are kept in pending for the session to replay. The client answers
+// with {"type": "passphrase", "data": "..."}, and an empty one cancels.
+type passphrasePrompt struct {
+	ws           *websocket.Conn
+	pending      [][]byte
+	pendingBytes int
+}
+
+func (p *passphrasePrompt) ask(conn *models.SSHConnection, retry bool) (string, error) {
+	err := writeJSONFrame(p.ws, map[string]interface{}{
+		"type":          "passphrase_required",
+		"connection_id": conn.ID,
+		"name":          conn.Name,
+		"host":          fmt.Sprintf("%s:%d", conn.Host, conn.Port),
+		"retry":         retry,
+	})
+	if err != nil {
+		return "", err
 	}
 
-	if conn.AuthType == "key" && privateKey != "" {
-		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	p.ws.SetReadDeadline(time.Now().Add(passphrasePromptTimeout))
+	defer p.ws.SetReadDeadline(time.Time{})
+	for {
+		_, msg, err := p.ws.ReadMessage()
 		if err != nil {
-			return fmt.Errorf("failed to parse private key: %v", err)
+			return "", ErrPassphraseRequired
+		}
+		var reply struct {
+			Type string `json:"type"`
+			Data string `json:"data"`
+		}
+		if json.Unmarshal(msg, &reply) != nil || reply.Type != "passphrase" {
+			p.keep(msg)
+			continue
 		}
-		sshConfig.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
-	} else if password != "" {
-		sshConfig.Auth = []ssh.AuthMethod{ssh.Password(password)}
-	} else {
-		return fmt.Errorf("no authentication credentials provided")
+		if reply.Data == "" {
+			return "", ErrPassphraseRequired
+		}
+		return reply.Data, nil
 	}
+}
 
-	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%d", conn.Host, conn.Port)
-	log.Printf("TerminalService: Connecting to %s as %s", addr, conn.Username)
+// keep holds on to a message for the session, dropping any past
+// maxPendingInput
+func (p *passphrasePrompt) keep(msg []byte) {
+	if p.pendingBytes+len(msg) > maxPendingInput {
+		return
+	}
+	p.pending = append(p.pending, msg)
+	p.pendingBytes += len(msg)
+}
 
-	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
+// openSession dials the connection, starts a shell and registers the session
+func (s *terminalService) openSession(connID uint, userID uint, remoteAddr string, prompt PassphrasePrompt) (*terminalSession, error) {
+	sshClient, conn, err := s.dialer.Dial(connID, userID, prompt)
 	if err != nil {
-		return fmt.Errorf("ssh connection failed: %v", err)
+		return nil, err
 	}
-	defer sshClient.Close()
 
 	// Create session
 	session, err := sshClient.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create ssh session: %v", err)
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to create ssh session: %v", err)
 	}
-	defer session.Close()
+
+	// Anything failing past this point must release the client and session
+	ok := false
+	defer func() {
+		if !ok {
+			session.Close()
+			sshClient.Close()
+		}
+	}()
 
 	// Request PTY
 	modes := ssh.TerminalModes{
@@ -76,105 +326,78 @@ func (s *terminalService) StartSession(ws *websocket.Conn, connID uint, userID u
 	}
 
 	if err := session.RequestPty("xterm-256color", 40, 80, modes); err != nil {
-		return fmt.Errorf("request for pty failed: %v", err)
+		return nil, fmt.Errorf("request for pty failed: %v", err)
 	}
 
 	// Pipes
 	stdin, err := session.StdinPipe()
 	if err != nil {
-		return fmt.Errorf("unable to setup stdin: %v", err)
+		return nil, fmt.Errorf("unable to setup stdin: %v", err)
 	}
 	stdout, err := session.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("unable to setup stdout: %v", err)
+		return nil, fmt.Errorf("unable to setup stdout: %v", err)
 	}
 	stderr, err := session.StderrPipe()
 	if err != nil {
-		return fmt.Errorf("unable to setup stderr: %v", err)
+		return nil, fmt.Errorf("unable to setup stderr: %v", err)
+	}
+
+	id, err := newToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start recording before the shell produces any output
+	recorder, err := s.recordings.Start(userID, conn, 80, 40)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session recording: %v", err)
 	}
 
 	// Start shell
 	if err := session.Shell(); err != nil {
-		return fmt.Errorf("failed to start shell: %v", err)
-	}
-
-	// Handle I/O
-	errorChan := make(chan error, 3)
-
-	// Custom Writer for WebSocket
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stdout.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					errorChan <- err
-				}
-				break
-			}
-			if err := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
-				errorChan <- err
-				break
-			}
-		}
-	}()
+		recorder.Close(nil)
+		return nil, fmt.Errorf("failed to start shell: %v", err)
+	}
+	ok = true
 
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					errorChan <- err
-				}
-				break
-			}
-			if err := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
-				errorChan <- err
-				break
-			}
-		}
-	}()
+	ts := &terminalSession{
+		id:           id,
+		userID:       userID,
+		connectionID: conn.ID,
+		host:         conn.Host,
+		username:     conn.Username,
+		startedAt:    time.Now(),
+		client:       sshClient,
+		session:      session,
+		stdin:        stdin,
+		recorder:     recorder,
+		registry:     s.sessions,
+		audit:        s.audit,
+		grace:        s.cfg.SessionGracePeriod,
+		viewers:      make(map[*websocket.Conn]*viewer),
+		invites:      make(map[string]*ShareInvite),
+		kicked:       make(map[uint]bool),
+		output:       newRingBuffer(s.cfg.SessionBufferSize),
+	}
+	s.sessions.add(ts)
 
-	go func() {
-		for {
-			_, msg, err := ws.ReadMessage()
-			if err != nil {
-				errorChan <- err
-				break
-			}
-
-			// Try to parse as JSON
-			var wsMsg struct {
-				Type string `json:"type"`
-				Data string `json:"data"`
-				Cols int    `json:"cols"`
-				Rows int    `json:"rows"`
-			}
-			if err := json.Unmarshal(msg, &wsMsg); err == nil {
-				switch wsMsg.Type {
-				case "input":
-					if _, err := stdin.Write([]byte(wsMsg.Data)); err != nil {
-						errorChan <- err
-						return
-					}
-				case "resize":
-					session.WindowChange(wsMsg.Rows, wsMsg.Cols)
-				}
-			} else {
-				// Raw message, write directly
-				if _, err := stdin.Write(msg); err != nil {
-					errorChan <- err
-					break
-				}
-			}
-		}
-	}()
+	go ts.pump(stdout)
+	go ts.pump(stderr)
+	go ts.wait()
 
-	// Wait for errors or completion
-	select {
-	case err := <-errorChan:
-		log.Printf("TerminalService: Connection closed with error: %v", err)
-		return err // Or nil if just closed
-	}
+	log.Printf("TerminalService: Started session %s on %s for user %d", id, conn.Host, userID)
+	s.audit.Record(models.AuditEvent{
+		UserID:     userID,
+		Action:     AuditTerminalStart,
+		TargetType: AuditTargetConnection,
+		TargetID:   conn.ID,
+		RemoteAddr: remoteAddr,
+		Details: map[string]interface{}{
+			"session_id": id,
+			"host":       fmt.Sprintf("%s:%d", conn.Host, conn.Port),
+			"username":   conn.Username,
+		},
+	})
+	return ts, nil
 }
@@ -0,0 +1,595 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"ssh-terminal-app/internal/models"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	// A viewer this many frames behind is disconnected rather than slowing
+	// down the session
+	viewerQueueSize = 256
+)
+
+// SessionInfo describes a live terminal session
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	ConnectionID uint      `json:"connection_id"`
+	Host         string    `json:"host"`
+	Username     string    `json:"username"`
+	StartedAt    time.Time `json:"started_at"`
+	Attached     bool      `json:"attached"`
+	Viewers      int       `json:"viewers"`
+}
+
+// ViewerInfo describes a client attached to a live terminal session
+type ViewerInfo struct {
+	ID       string    `json:"id"`
+	UserID   uint      `json:"user_id"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Owner    bool      `json:"owner"`
+	ReadOnly bool      `json:"read_only"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ShareInvite lets other users join a live session until it expires
+type ShareInvite struct {
+	Token     string    `json:"token"`
+	ReadOnly  bool      `json:"read_only"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// viewer is a WebSocket attached to a session. Frames for it are queued and
+// written by its own goroutine, so a slow client only holds up itself.
+type viewer struct {
+	ViewerInfo
+	ws   *websocket.Conn
+	send chan viewerFrame
+}
+
+type viewerFrame struct {
+	kind int
+	data []byte
+}
+
+func newViewer(ws *websocket.Conn, info ViewerInfo) *viewer {
+	v := &viewer{ViewerInfo: info, ws: ws, send: make(chan viewerFrame, viewerQueueSize)}
+	go v.writeLoop()
+	return v
+}
+
+// queue hands a frame to the writer without waiting. It returns false when
+// the viewer's queue is full.
+func (v *viewer) queue(kind int, data []byte) bool {
+	select {
+	case v.send <- viewerFrame{kind: kind, data: data}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (v *viewer) queueJSON(msg interface{}) bool {
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	return v.queue(websocket.TextMessage, frame)
+}
+
+// writeLoop writes queued frames until the queue is closed, then closes the
+// socket. A failed write closes it right away, which also ends serve.
+func (v *viewer) writeLoop() {
+	defer v.ws.Close()
+	for frame := range v.send {
+		v.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := v.ws.WriteMessage(frame.kind, frame.data); err != nil {
+			return
+		}
+	}
+}
+
+// terminalSession is a running SSH shell that outlives the WebSocket that
+// started it. Output fans out to every attached viewer; while nobody is
+// attached it keeps accumulating in a ring buffer until the grace period
+// runs out.
+type terminalSession struct {
+	id           string
+	userID       uint
+	connectionID uint
+	host         string
+	username     string
+	startedAt    time.Time
+
+	client   *ssh.Client
+	session  *ssh.Session
+	stdin    io.Writer
+	recorder *Recorder
+	registry *sessionRegistry
+	audit    AuditService
+	grace    time.Duration
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	mu          sync.Mutex // Guards the fields below
+	viewers     map[*websocket.Conn]*viewer
+	invites     map[string]*ShareInvite
+	kicked      map[uint]bool // Users removed by the owner, who may not rejoin
+	output      *ringBuffer
+	detachTimer *time.Timer
+	exitStatus  *int
+	closed      bool
+}
+
+// pump copies remote output to the buffer, the recorder and all viewers
+func (s *terminalSession) pump(r io.Reader) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.write(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *terminalSession) write(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.output.Write(data)
+	s.recorder.Output(data)
+	s.bytesOut.Add(int64(len(data)))
+	if len(s.viewers) == 0 {
+		return
+	}
+
+	// The frame outlives the call, but pump reuses data
+	frame := append([]byte(nil), data...)
+	for ws, v := range s.viewers {
+		if !v.queue(websocket.BinaryMessage, frame) {
+			log.Printf("TerminalService: Viewer %s of session %s fell behind, disconnecting it", v.ID, s.id)
+			s.dropLocked(ws)
+		}
+	}
+}
+
+// wait blocks until the remote shell exits and then ends the session
+func (s *terminalSession) wait() {
+	err := s.session.Wait()
+
+	s.mu.Lock()
+	var exitErr *ssh.ExitError
+	if err == nil {
+		status := 0
+		s.exitStatus = &status
+	} else if errors.As(err, &exitErr) {
+		status := exitErr.ExitStatus()
+		s.exitStatus = &status
+	} else {
+		log.Printf("TerminalService: Session %s closed with error: %v", s.id, err)
+	}
+	s.mu.Unlock()
+
+	s.close()
+}
+
+// attach adds ws as a viewer, replaying buffered output first
+func (s *terminalSession) attach(ws *websocket.Conn, info ViewerInfo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.kicked[info.UserID] {
+		return false
+	}
+
+	if s.detachTimer != nil {
+		s.detachTimer.Stop()
+		s.detachTimer = nil
+	}
+
+	id, err := newToken(8)
+	if err != nil {
+		return false
+	}
+	info.ID = id
+	info.JoinedAt = time.Now()
+	v := newViewer(ws, info)
+	s.viewers[ws] = v
+
+	v.queueJSON(map[string]interface{}{"type": "session", "session_id": s.id, "viewer_id": info.ID, "read_only": info.ReadOnly})
+	if missed := s.output.Bytes(); len(missed) > 0 {
+		v.queue(websocket.BinaryMessage, missed)
+	}
+	return true
+}
+
+// serve handles client messages until ws disconnects or the session ends.
+// Read-only viewers can watch but their input and resizes are ignored.
+func (s *terminalSession) serve(ws *websocket.Conn, readOnly bool, pending [][]byte) {
+	if !readOnly {
+		for _, msg := range pending {
+			s.handle(msg)
+		}
+	}
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			s.detach(ws)
+			return
+		}
+		if readOnly {
+			continue
+		}
+		s.handle(msg)
+	}
+}
+
+// handle applies a message from a viewer: input or a resize in JSON, or
+// raw input
+func (s *terminalSession) handle(msg []byte) {
+	var wsMsg struct {
+		Type string `json:"type"`
+		Data string `json:"data"`
+		Cols int    `json:"cols"`
+		Rows int    `json:"rows"`
+	}
+	if err := json.Unmarshal(msg, &wsMsg); err == nil {
+		switch wsMsg.Type {
+		case "input":
+			s.input([]byte(wsMsg.Data))
+		case "resize":
+			s.recorder.Resize(wsMsg.Cols, wsMsg.Rows)
+			s.session.WindowChange(wsMsg.Rows, wsMsg.Cols)
+		}
+	} else {
+		// Raw message, write directly
+		s.input(msg)
+	}
+}
+
+func (s *terminalSession) input(data []byte) {
+	s.recorder.Input(data)
+	s.bytesIn.Add(int64(len(data)))
+	if _, err := s.stdin.Write(data); err != nil {
+		log.Printf("TerminalService: Failed to write to session %s: %v", s.id, err)
+	}
+}
+
+func (s *terminalSession) detach(ws *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detachLocked(ws)
+}
+
+// dropLocked disconnects ws at once, without waiting for frames still
+// queued for it; it must be called with mu held
+func (s *terminalSession) dropLocked(ws *websocket.Conn) {
+	ws.Close()
+	s.detachLocked(ws)
+}
+
+// detachLocked drops ws if it is still attached and starts the grace period
+// once the last viewer is gone; it must be called with mu held. Its socket
+// is closed once the frames already queued for it are written.
+func (s *terminalSession) detachLocked(ws *websocket.Conn) {
+	v, ok := s.viewers[ws]
+	if !ok || s.closed {
+		return
+	}
+	delete(s.viewers, ws)
+	close(v.send)
+
+	if len(s.viewers) > 0 {
+		return
+	}
+
+	log.Printf("TerminalService: Session %s detached, keeping it for %s", s.id, s.grace)
+	s.detachTimer = time.AfterFunc(s.grace, func() {
+		log.Printf("TerminalService: Session %s grace period expired", s.id)
+		s.close()
+	})
+}
+
+func (s *terminalSession) viewerList() []ViewerInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	viewers := make([]ViewerInfo, 0, len(s.viewers))
+	for _, v := range s.viewers {
+		viewers = append(viewers, v.ViewerInfo)
+	}
+	return viewers
+}
+
+// kick disconnects a viewer and keeps that user from rejoining
+func (s *terminalSession) kick(viewerID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ws, v := range s.viewers {
+		if v.ID != viewerID || v.Owner {
+			continue
+		}
+		s.kicked[v.UserID] = true
+		if !v.queueJSON(map[string]interface{}{"type": "error", "data": "You were removed from this session by its owner"}) {
+			s.dropLocked(ws)
+			return true
+		}
+		s.detachLocked(ws)
+		return true
+	}
+	return false
+}
+
+func (s *terminalSession) share(readOnly bool, ttl time.Duration) (*ShareInvite, error) {
+	token, err := newToken(24)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invite := &ShareInvite{
+		Token:     token,
+		ReadOnly:  readOnly,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invites[token] = invite
+	return invite, nil
+}
+
+func (s *terminalSession) revokeShare(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.invites[token]; !ok {
+		return false
+	}
+	delete(s.invites, token)
+	return true
+}
+
+// invite returns the unexpired invite for token
+func (s *terminalSession) invite(token string) (*ShareInvite, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invite, ok := s.invites[token]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		delete(s.invites, token)
+		return nil, false
+	}
+	return invite, true
+}
+
+func (s *terminalSession) shareList() []ShareInvite {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	invites := []ShareInvite{}
+	for token, invite := range s.invites {
+		if now.After(invite.ExpiresAt) {
+			delete(s.invites, token)
+			continue
+		}
+		invites = append(invites, *invite)
+	}
+	return invites
+}
+
+// close ends the SSH session and releases everything it holds
+func (s *terminalSession) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.detachTimer != nil {
+		s.detachTimer.Stop()
+	}
+	for ws, v := range s.viewers {
+		if !v.queueJSON(map[string]interface{}{"type": "exit", "exit_status": s.exitStatus}) {
+			ws.Close()
+		}
+		close(v.send)
+	}
+	s.viewers = nil
+	exitStatus := s.exitStatus
+	s.mu.Unlock()
+
+	s.session.Close()
+	s.client.Close()
+	if err := s.recorder.Close(exitStatus); err != nil {
+		log.Printf("TerminalService: Failed to finalize recording: %v", err)
+	}
+	s.registry.remove(s.id)
+
+	s.audit.Record(models.AuditEvent{
+		UserID:     s.userID,
+		Action:     AuditTerminalStop,
+		TargetType: AuditTargetConnection,
+		TargetID:   s.connectionID,
+		Details: map[string]interface{}{
+			"session_id":  s.id,
+			"host":        s.host,
+			"duration":    time.Since(s.startedAt).Round(time.Second).String(),
+			"bytes_in":    s.bytesIn.Load(),
+			"bytes_out":   s.bytesOut.Load(),
+			"exit_status": exitStatus,
+		},
+	})
+}
+
+func (s *terminalSession) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SessionInfo{
+		ID:           s.id,
+		ConnectionID: s.connectionID,
+		Host:         s.host,
+		Username:     s.username,
+		StartedAt:    s.startedAt,
+		Attached:     len(s.viewers) > 0,
+		Viewers:      len(s.viewers),
+	}
+}
+
+func writeJSONFrame(ws *websocket.Conn, v interface{}) error {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(websocket.TextMessage, frame)
+}
+
+// sessionRegistry tracks live sessions by ID
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*terminalSession
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[string]*terminalSession)}
+}
+
+func (r *sessionRegistry) add(s *terminalSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.id] = s
+}
+
+func (r *sessionRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+// get returns the session only if it belongs to userID
+func (r *sessionRegistry) get(id string, userID uint) (*terminalSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[id]
+	if !ok || s.userID != userID {
+		return nil, false
+	}
+	return s, true
+}
+
+// findByShareToken returns the session the invite token belongs to
+func (r *sessionRegistry) findByShareToken(token string) (*terminalSession, *ShareInvite, bool) {
+	r.mu.Lock()
+	sessions := make([]*terminalSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.mu.Unlock()
+
+	for _, s := range sessions {
+		if invite, ok := s.invite(token); ok {
+			return s, invite, true
+		}
+	}
+	return nil, nil, false
+}
+
+func (r *sessionRegistry) list(userID uint) []*terminalSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sessions []*terminalSession
+	for _, s := range r.sessions {
+		if s.userID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// newToken returns n random bytes as hex, used for session, viewer and invite IDs
+func newToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ringBuffer keeps the most recent bytes written to it
+type ringBuffer struct {
+	data []byte
+	size int
+	full bool
+	next int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	if size < 0 {
+		size = 0
+	}
+	return &ringBuffer{data: make([]byte, size), size: size}
+}
+
+func (b *ringBuffer) Write(p []byte) {
+	if b.size == 0 {
+		return
+	}
+	if len(p) >= b.size {
+		copy(b.data, p[len(p)-b.size:])
+		b.next = 0
+		b.full = true
+		return
+	}
+	n := copy(b.data[b.next:], p)
+	if n < len(p) {
+		copy(b.data, p[n:])
+		b.full = true
+	}
+	b.next = (b.next + len(p)) % b.size
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Bytes returns the buffered output, skipping a partial character at the start
+func (b *ringBuffer) Bytes() []byte {
+	var out []byte
+	if b.full {
+		out = append(out, b.data[b.next:]...)
+	}
+	out = append(out, b.data[:b.next]...)
+
+	for i := 0; i < utf8.UTFMax && i < len(out); i++ {
+		if utf8.RuneStart(out[i]) {
+			return out[i:]
+		}
+	}
+	return out
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRingBufferKeepsRecentOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"empty", 8, nil, ""},
+		{"partly filled", 8, []string{"abc", "de"}, "abcde"},
+		{"exactly full", 8, []string{"abcd", "efgh"}, "abcdefgh"},
+		{"wrapped", 8, []string{"abcdef", "ghij"}, "cdefghij"},
+		{"wrapped twice", 4, []string{"abc", "def", "ghi"}, "fghi"},
+		{"write larger than the buffer", 4, []string{"ab", "cdefghij"}, "ghij"},
+		{"write of exactly the size", 4, []string{"ab", "cdef"}, "cdef"},
+		{"wrap ends at the start", 4, []string{"ab", "cd", "ef"}, "cdef"},
+		{"zero size", 0, []string{"abc"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newRingBuffer(tt.size)
+			for _, w := range tt.writes {
+				b.Write([]byte(w))
+			}
+			if got := string(b.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingBufferSkipsPartialCharacter(t *testing.T) {
+	// "é" is two bytes; keeping three of "éé" cuts the first one in half
+	b := newRingBuffer(3)
+	b.Write([]byte("éé"))
+	if got := b.Bytes(); !bytes.Equal(got, []byte("é")) {
+		t.Errorf("Bytes() = %q, want %q", got, "é")
+	}
+}
+
+// newTestTerminalSession is a session without an SSH connection behind it,
+// fed through write
+func newTestTerminalSession(t *testing.T) *terminalSession {
+	s := &terminalSession{
+		id:       "test-session",
+		userID:   1,
+		registry: newSessionRegistry(),
+		grace:    time.Hour,
+		viewers:  make(map[*websocket.Conn]*viewer),
+		invites:  make(map[string]*ShareInvite),
+		kicked:   make(map[uint]bool),
+		output:   newRingBuffer(1024),
+	}
+	t.Cleanup(func() {
+		// Keep the grace period from closing the missing SSH session
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		if s.detachTimer != nil {
+			s.detachTimer.Stop()
+		}
+	})
+	return s
+}
+
+// attachTestViewer attaches a new client to s and returns the client end
+func attachTestViewer(t *testing.T, s *terminalSession, info ViewerInfo) *websocket.Conn {
+	t.Helper()
+	server, client := newTestWebSocket(t)
+	if !s.attach(server, info) {
+		t.Fatalf("attach user %d failed", info.UserID)
+	}
+	go s.serve(server, info.ReadOnly, nil)
+	return client
+}
+
+// readOutput collects the binary frames ws receives
+func readOutput(ws *websocket.Conn) <-chan []byte {
+	output := make(chan []byte, viewerQueueSize)
+	go func() {
+		defer close(output)
+		for {
+			kind, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if kind == websocket.BinaryMessage {
+				output <- msg
+			}
+		}
+	}()
+	return output
+}
+
+func expectOutput(t *testing.T, output <-chan []byte, want []byte) {
+	t.Helper()
+	var got []byte
+	timeout := time.After(10 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case msg, ok := <-output:
+			if !ok {
+				t.Fatalf("connection closed after %d of %d bytes", len(got), len(want))
+			}
+			got = append(got, msg...)
+		case <-timeout:
+			t.Fatalf("received %d of %d bytes", len(got), len(want))
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("received %d bytes that differ from the %d written", len(got), len(want))
+	}
+}
+
+// within fails the test if fn doesn't return in time
+func within(t *testing.T, d time.Duration, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("%s blocked for over %s", what, d)
+	}
+}
+
+// stallTestViewer attaches a viewer that never reads and fills its socket,
+// so that its writer is stuck. The owner receives the same output.
+func stallTestViewer(t *testing.T, s *terminalSession, owner <-chan []byte) ViewerInfo {
+	t.Helper()
+	attachTestViewer(t, s, ViewerInfo{UserID: 2, ReadOnly: true})
+	var stalled ViewerInfo
+	for _, v := range s.viewerList() {
+		if v.UserID == 2 {
+			stalled = v
+		}
+	}
+
+	// Larger than the kernel buffers on both ends of the connection
+	flood := bytes.Repeat([]byte("x"), 32<<20)
+	within(t, time.Second, "writing to a stalled viewer", func() { s.write(flood) })
+	expectOutput(t, owner, flood)
+	return stalled
+}
+
+func TestStalledViewerDoesNotBlockOwnerOrKick(t *testing.T) {
+	s := newTestTerminalSession(t)
+	owner := readOutput(attachTestViewer(t, s, ViewerInfo{UserID: 1, Owner: true}))
+	stalled := stallTestViewer(t, s, owner)
+
+	within(t, time.Second, "output while a viewer is stalled", func() { s.write([]byte("still here")) })
+	expectOutput(t, owner, []byte("still here"))
+
+	var kicked bool
+	within(t, time.Second, "kicking a stalled viewer", func() { kicked = s.kick(stalled.ID) })
+	if !kicked {
+		t.Fatal("kick found no viewer")
+	}
+	if viewers := s.viewerList(); len(viewers) != 1 || !viewers[0].Owner {
+		t.Errorf("viewers after kick %+v, want only the owner", viewers)
+	}
+
+	within(t, time.Second, "output after the kick", func() { s.write([]byte("and again")) })
+	expectOutput(t, owner, []byte("and again"))
+
+	server, _ := newTestWebSocket(t)
+	if s.attach(server, ViewerInfo{UserID: 2, ReadOnly: true}) {
+		t.Error("kicked user could rejoin")
+	}
+}
+
+func TestViewerThatFallsBehindIsDisconnected(t *testing.T) {
+	s := newTestTerminalSession(t)
+	owner := readOutput(attachTestViewer(t, s, ViewerInfo{UserID: 1, Owner: true}))
+	stallTestViewer(t, s, owner)
+
+	// The owner keeps up; the stalled viewer's queue overflows
+	for i := 0; i <= viewerQueueSize; i++ {
+		s.write([]byte("."))
+		expectOutput(t, owner, []byte("."))
+	}
+
+	if viewers := s.viewerList(); len(viewers) != 1 || !viewers[0].Owner {
+		t.Errorf("viewers %+v, want only the owner", viewers)
+	}
+}
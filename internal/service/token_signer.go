@@ -0,0 +1,386 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/models"
+	"ssh-terminal-app/internal/repository"
+	"ssh-terminal-app/internal/utils"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token types, sent in the typ header so one kind of token can't be passed
+// off as another, here or by a service verifying tokens against the JWKS
+const (
+	TokenTypeAccess = "at+jwt" // RFC 9068
+	tokenTypeMFA    = "mfa+jwt"
+)
+
+const (
+	// A key is published this long before it starts signing, at most, so
+	// verifiers caching the JWKS have it by the time tokens name it
+	signingKeyPublishAhead = 24 * time.Hour
+	// An unknown kid reloads the keys at most this often, in case another
+	// instance rotated
+	signingKeyReloadInterval = time.Minute
+	rsaKeyBits               = 2048
+)
+
+// TokenSigner signs and verifies the app's JWTs with rotating asymmetric
+// keys, each named by the kid header of the tokens it signs
+type TokenSigner interface {
+	// Sign signs claims with the current key as a token of type typ. The
+	// issuer claim is added.
+	Sign(typ string, claims jwt.MapClaims) (string, error)
+	// Parse verifies the signature, type, issuer and expiry of a token and
+	// returns its claims
+	Parse(typ, token string) (jwt.MapClaims, error)
+	// JWKS returns the public keys tokens may be verified with: the current
+	// key, the one about to replace it and those recently replaced
+	JWKS() (*jose.JSONWebKeySet, error)
+}
+
+type signingKey struct {
+	id         uint
+	kid        string
+	method     jwt.SigningMethod
+	private    crypto.Signer
+	public     crypto.PublicKey
+	activeFrom time.Time
+	retireAt   *time.Time
+}
+
+type tokenSigner struct {
+	repo        repository.SigningKeyRepository
+	keyring     *utils.Keyring
+	cfg         *config.Config
+	method      jwt.SigningMethod
+	publishAt   time.Duration // How long before its turn a new key is published
+	checkEvery  time.Duration
+	maxTokenTTL time.Duration
+
+	mu        sync.Mutex
+	keys      []signingKey // Oldest first
+	checkedAt time.Time
+	loadedAt  time.Time
+}
+
+// NewTokenSigner loads the signing keys, creating the first one on a new
+// install. Keys are rotated when due as tokens are signed and verified.
+func NewTokenSigner(repo repository.SigningKeyRepository, keyring *utils.Keyring, cfg *config.Config) (TokenSigner, error) {
+	method, err := signingMethod(cfg.JWTAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.JWTKeyRotation <= 0 {
+		return nil, fmt.Errorf("JWT_KEY_ROTATION must be positive")
+	}
+
+	s := &tokenSigner{
+		repo:        repo,
+		keyring:     keyring,
+		cfg:         cfg,
+		method:      method,
+		publishAt:   min(cfg.JWTKeyRotation/4, signingKeyPublishAhead),
+		maxTokenTTL: max(cfg.AccessTokenTTL, mfaTokenTTL),
+	}
+	s.checkEvery = min(s.publishAt/4, time.Hour)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *tokenSigner) Sign(typ string, claims jwt.MapClaims) (string, error) {
+	s.mu.Lock()
+	now := time.Now()
+	s.rotateIfDue(now)
+	key := s.currentKey(now)
+	s.mu.Unlock()
+	if key == nil {
+		return "", fmt.Errorf("no signing key is active")
+	}
+
+	claims["iss"] = s.cfg.JWTIssuer
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	token.Header["typ"] = typ
+	return token.SignedString(key.private)
+}
+
+func (s *tokenSigner) Parse(typ, tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(s.cfg.JWTIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	token, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if tokenType, _ := token.Header["typ"].(string); tokenType != typ {
+			return nil, fmt.Errorf("unexpected token type %q", tokenType)
+		}
+		kid, _ := token.Header["kid"].(string)
+		key := s.verificationKey(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if key.method.Alg() != token.Method.Alg() {
+			return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+		}
+		return key.public, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *tokenSigner) JWKS() (*jose.JSONWebKeySet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.rotateIfDue(now)
+
+	set := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	for _, key := range s.keys {
+		if key.retireAt != nil && now.After(*key.retireAt) {
+			continue
+		}
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       key.public,
+			KeyID:     key.kid,
+			Algorithm: key.method.Alg(),
+			Use:       "sig",
+		})
+	}
+	return set, nil
+}
+
+// currentKey returns the newest key whose turn has come
+func (s *tokenSigner) currentKey(now time.Time) *signingKey {
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if !s.keys[i].activeFrom.After(now) {
+			return &s.keys[i]
+		}
+	}
+	return nil
+}
+
+// verificationKey returns the key named kid unless it has retired
+func (s *tokenSigner) verificationKey(kid string) *signingKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.rotateIfDue(now)
+	find := func() *signingKey {
+		for i := range s.keys {
+			if s.keys[i].kid == kid {
+				return &s.keys[i]
+			}
+		}
+		return nil
+	}
+
+	key := find()
+	if key == nil && now.Sub(s.loadedAt) > signingKeyReloadInterval {
+		if err := s.load(now); err != nil {
+			log.Printf("TokenSigner: Failed to reload signing keys: %v", err)
+		}
+		key = find()
+	}
+	if key == nil || (key.retireAt != nil && now.After(*key.retireAt)) {
+		return nil
+	}
+	return key
+}
+
+// rotateIfDue runs rotate every checkEvery. A failure is logged and the
+// current keys stay in use until the next check.
+func (s *tokenSigner) rotateIfDue(now time.Time) {
+	if now.Sub(s.checkedAt) < s.checkEvery {
+		return
+	}
+	if err := s.rotate(now); err != nil {
+		log.Printf("TokenSigner: Failed to rotate signing keys: %v", err)
+	}
+}
+
+// rotate creates the next key once the current one is near the end of its
+// turn, retires keys that have been replaced and drops those that retired.
+// Callers hold mu.
+func (s *tokenSigner) rotate(now time.Time) error {
+	s.checkedAt = now
+	if err := s.load(now); err != nil {
+		return err
+	}
+
+	current := s.currentKey(now)
+	if current == nil {
+		if _, err := s.createKey(now); err != nil {
+			return err
+		}
+		return s.load(now)
+	}
+
+	upcoming := false
+	for _, key := range s.keys {
+		if key.activeFrom.After(now) {
+			upcoming = true
+		}
+	}
+	// Changing JWT_ALGORITHM brings the next key forward
+	turnEnds := current.activeFrom.Add(s.cfg.JWTKeyRotation)
+	if current.method != s.method {
+		turnEnds = now
+	}
+	if !upcoming && !now.Before(turnEnds.Add(-s.publishAt)) {
+		activeFrom := turnEnds
+		if earliest := now.Add(s.publishAt); activeFrom.Before(earliest) {
+			activeFrom = earliest
+		}
+		key, err := s.createKey(activeFrom)
+		if err != nil {
+			return err
+		}
+		log.Printf("TokenSigner: Published signing key %s, signing from %s", key.KID, activeFrom.Format(time.RFC3339))
+	}
+
+	// Tokens signed by a replaced key stay valid until they expire
+	for _, key := range s.keys {
+		if key.retireAt == nil && key.activeFrom.Before(current.activeFrom) {
+			if err := s.repo.Retire(key.id, current.activeFrom.Add(s.maxTokenTTL)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.repo.DeleteRetired(now); err != nil {
+		return err
+	}
+	return s.load(now)
+}
+
+// load replaces the cached keys with those stored. Callers hold mu.
+func (s *tokenSigner) load(now time.Time) error {
+	stored, err := s.repo.List()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]signingKey, 0, len(stored))
+	for _, record := range stored {
+		key, err := s.decodeKey(record)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", record.KID, err)
+		}
+		keys = append(keys, *key)
+	}
+	s.keys = keys
+	s.loadedAt = now
+	return nil
+}
+
+func (s *tokenSigner) decodeKey(record models.SigningKey) (*signingKey, error) {
+	method, err := signingMethod(record.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	der, err := s.keyring.Decrypt(record.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey([]byte(der))
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return &signingKey{
+		id:         record.ID,
+		kid:        record.KID,
+		method:     method,
+		private:    private,
+		public:     private.Public(),
+		activeFrom: record.ActiveFrom,
+		retireAt:   record.RetireAt,
+	}, nil
+}
+
+// createKey generates and stores a key for the configured algorithm that
+// signs from activeFrom
+func (s *tokenSigner) createKey(activeFrom time.Time) (*models.SigningKey, error) {
+	var private crypto.Signer
+	switch s.method {
+	case jwt.SigningMethodEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.keyring.Encrypt(string(der))
+	if err != nil {
+		return nil, err
+	}
+	// The RFC 7638 thumbprint names the key the same wherever it is published
+	thumbprint, err := (&jose.JSONWebKey{Key: private.Public()}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.SigningKey{
+		KID:        base64.RawURLEncoding.EncodeToString(thumbprint),
+		Algorithm:  s.method.Alg(),
+		PrivateKey: encrypted,
+		PublicKey:  public,
+		ActiveFrom: activeFrom,
+	}
+	if err := s.repo.Create(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case jwt.SigningMethodEdDSA.Alg():
+		return jwt.SigningMethodEdDSA, nil
+	case jwt.SigningMethodRS256.Alg():
+		return jwt.SigningMethodRS256, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q, use EdDSA or RS256", algorithm)
+	}
+}
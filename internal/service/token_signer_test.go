@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ssh-terminal-app/internal/config"
+	"ssh-terminal-app/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestSigner(t *testing.T, cfg *config.Config) *tokenSigner {
+	t.Helper()
+	signer, err := NewTokenSigner(repository.NewSigningKeyRepository(newTestDB(t)), newTestKeyring(t), cfg)
+	if err != nil {
+		t.Fatalf("NewTokenSigner: %v", err)
+	}
+	return signer.(*tokenSigner)
+}
+
+func signTestToken(t *testing.T, s *tokenSigner, typ string) string {
+	t.Helper()
+	token, err := s.Sign(typ, jwt.MapClaims{"sub": "1", "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return token
+}
+
+func TestTokenSignerRotatesKeys(t *testing.T) {
+	cfg := newTestConfig()
+	s := newTestSigner(t, cfg)
+	if len(s.keys) != 1 {
+		t.Fatalf("new install has %d keys, want 1", len(s.keys))
+	}
+	first := s.keys[0]
+	token := signTestToken(t, s, TokenTypeAccess)
+
+	// Ahead of the end of its turn, the next key is published but doesn't sign yet
+	nearEnd := first.activeFrom.Add(cfg.JWTKeyRotation - s.publishAt)
+	if err := s.rotate(nearEnd); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if len(s.keys) != 2 {
+		t.Fatalf("after publishing: %d keys, want 2", len(s.keys))
+	}
+	next := s.keys[1]
+	if !next.activeFrom.Equal(first.activeFrom.Add(cfg.JWTKeyRotation)) {
+		t.Errorf("next key signs from %v, want the end of the current key's turn", next.activeFrom)
+	}
+	if current := s.currentKey(nearEnd); current.kid != first.kid {
+		t.Errorf("signing with %s before its turn, want %s", current.kid, first.kid)
+	}
+	set, err := s.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+	if len(set.Keys) != 2 || len(set.Key(next.kid)) != 1 {
+		t.Errorf("JWKS doesn't publish the upcoming key: %+v", set.Keys)
+	}
+
+	// Once the next key takes over, the old one verifies until its tokens expire
+	takenOver := next.activeFrom.Add(time.Minute)
+	if err := s.rotate(takenOver); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if current := s.currentKey(takenOver); current.kid != next.kid {
+		t.Errorf("signing with %s after the handover, want %s", current.kid, next.kid)
+	}
+	retired := s.keys[0]
+	if retired.kid != first.kid || retired.retireAt == nil {
+		t.Fatalf("replaced key %s was not retired", first.kid)
+	}
+	if want := next.activeFrom.Add(s.maxTokenTTL); !retired.retireAt.Equal(want) {
+		t.Errorf("replaced key retires at %v, want %v", *retired.retireAt, want)
+	}
+	if _, err := s.Parse(TokenTypeAccess, token); err != nil {
+		t.Errorf("token signed by the replaced key: %v", err)
+	}
+
+	// After retiring, the key is dropped and its tokens no longer verify
+	if err := s.rotate(retired.retireAt.Add(time.Minute)); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if len(s.keys) != 1 || s.keys[0].kid != next.kid {
+		t.Fatalf("after retirement: keys %+v, want only %s", s.keys, next.kid)
+	}
+	if _, err := s.Parse(TokenTypeAccess, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token signed by a dropped key: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestTokenSignerAlgorithmChangeBringsNextKeyForward(t *testing.T) {
+	cfg := newTestConfig()
+	s := newTestSigner(t, cfg)
+
+	s.cfg.JWTAlgorithm = "RS256"
+	s.method = jwt.SigningMethodRS256
+	now := time.Now()
+	if err := s.rotate(now); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if len(s.keys) != 2 {
+		t.Fatalf("%d keys after changing the algorithm, want 2", len(s.keys))
+	}
+	next := s.keys[1]
+	if next.method != jwt.SigningMethodRS256 {
+		t.Errorf("next key signs with %s, want RS256", next.method.Alg())
+	}
+	if want := now.Add(s.publishAt); next.activeFrom.After(want.Add(time.Second)) {
+		t.Errorf("next key signs from %v, want by %v", next.activeFrom, want)
+	}
+}
+
+func TestTokenSignerRejectsOtherTokenTypes(t *testing.T) {
+	s := newTestSigner(t, newTestConfig())
+	token := signTestToken(t, s, tokenTypeMFA)
+
+	if _, err := s.Parse(TokenTypeAccess, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("MFA token parsed as an access token: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := s.Parse(tokenTypeMFA, token); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+}
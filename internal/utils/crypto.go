@@ -30,7 +30,8 @@ func deriveKey(key string) []byte {
 	return hash[:]
 }
 
-// Encrypt encrypts data using AES-256-GCM
+// Encrypt encrypts data using AES-256-GCM. Stored secrets are sealed with a
+// Keyring, which still reads values from Encrypt.
 func Encrypt(plaintext string, key string) (string, error) {
 	// Use SHA-256 to ensure key is exactly 32 bytes
 	keyBytes := deriveKey(key)
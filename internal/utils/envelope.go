@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// envelopePrefix marks ciphertexts sealed by a Keyring. Older values are
+// bare base64 from Encrypt.
+const envelopePrefix = "v1"
+
+// Master keys that have been published and must never protect real data
+var insecureMasterKeys = map[string]bool{
+	"a-32-byte-encryption-key-here!!": true, // The old built-in default
+	"32-byte-encryption-key-here!!":   true, // The README example
+}
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+var (
+	ErrInsecureMasterKey = errors.New("the encryption key is unset or a published example; set ENCRYPTION_KEY to a secret of your own")
+	ErrUnknownMasterKey  = errors.New("ciphertext was sealed with a master key that is not configured")
+)
+
+// Keyring seals secrets with envelope encryption: each value gets its own
+// random data key, which is wrapped by a versioned master key. The master
+// key's ID is stored with the ciphertext, so retired master keys can stay
+// configured to read old values until they have been re-wrapped.
+//
+// Sealed values look like v1:<key id>:<wrapped data key>:<ciphertext>.
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte
+	legacy    []string // Passphrases that may have sealed values with Encrypt
+}
+
+// NewKeyring builds a keyring that seals with currentKey, named currentID,
+// and can still open values sealed with oldKeys, keyed by ID. The current
+// key may not be empty or a published example.
+func NewKeyring(currentID, currentKey string, oldKeys map[string]string) (*Keyring, error) {
+	if currentKey == "" || insecureMasterKeys[currentKey] {
+		return nil, ErrInsecureMasterKey
+	}
+
+	k := &Keyring{
+		currentID: currentID,
+		keys:      map[string][]byte{currentID: deriveKey(currentKey)},
+		legacy:    []string{currentKey},
+	}
+	for id, key := range oldKeys {
+		if id == currentID {
+			return nil, fmt.Errorf("master key ID %q is both current and retired", id)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("master key %q is empty", id)
+		}
+		k.keys[id] = deriveKey(key)
+		k.legacy = append(k.legacy, key)
+	}
+	for id := range k.keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("master key ID %q may only contain letters, digits, '.', '_' and '-'", id)
+		}
+	}
+	return k, nil
+}
+
+// CurrentKeyID names the master key new values are sealed with
+func (k *Keyring) CurrentKeyID() string {
+	return k.currentID
+}
+
+// Encrypt seals plaintext under a fresh data key wrapped by the current
+// master key
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.currentID], dataKey, wrapContext(k.currentID))
+	if err != nil {
+		return "", err
+	}
+	return formatEnvelope(k.currentID, wrapped, sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt, with any configured master key,
+// or by the package-level Encrypt with one of the keyring's passphrases
+func (k *Keyring) Decrypt(ciphertext string) (string, error) {
+	keyID, wrapped, sealed, ok := parseEnvelope(ciphertext)
+	if !ok {
+		return k.decryptLegacy(ciphertext)
+	}
+
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap moves a sealed value to the current master key, reporting whether
+// it changed. Only the data key is re-wrapped; the data itself is left as
+// it is. Values from before envelope encryption are sealed afresh.
+func (k *Keyring) Rewrap(ciphertext string) (string, bool, error) {
+	if ciphertext == "" {
+		return ciphertext, false, nil
+	}
+
+	keyID, wrapped, sealed, ok := parseEnvelope(ciphertext)
+	if !ok {
+		plaintext, err := k.decryptLegacy(ciphertext)
+		if err != nil {
+			return "", false, err
+		}
+		rewrapped, err := k.Encrypt(plaintext)
+		return rewrapped, err == nil, err
+	}
+	if keyID == k.currentID {
+		return ciphertext, false, nil
+	}
+
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	wrapped, err = seal(k.keys[k.currentID], dataKey, wrapContext(k.currentID))
+	if err != nil {
+		return "", false, err
+	}
+	return formatEnvelope(k.currentID, wrapped, sealed), true, nil
+}
+
+func (k *Keyring) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	masterKey, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMasterKey, keyID)
+	}
+	return open(masterKey, wrapped, wrapContext(keyID))
+}
+
+func (k *Keyring) decryptLegacy(ciphertext string) (string, error) {
+	var err error
+	for _, passphrase := range k.legacy {
+		var plaintext string
+		if plaintext, err = Decrypt(ciphertext, passphrase); err == nil {
+			return plaintext, nil
+		}
+	}
+	return "", err
+}
+
+// wrapContext binds a wrapped data key to the ID of the master key that
+// wrapped it, so the ID stored alongside can't be swapped
+func wrapContext(keyID string) []byte {
+	return []byte(envelopePrefix + ":" + keyID)
+}
+
+func formatEnvelope(keyID string, wrapped, sealed []byte) string {
+	return strings.Join([]string{
+		envelopePrefix,
+		keyID,
+		base64.StdEncoding.EncodeToString(wrapped),
+		base64.StdEncoding.EncodeToString(sealed),
+	}, ":")
+}
+
+func parseEnvelope(ciphertext string) (keyID string, wrapped, sealed []byte, ok bool) {
+	parts := strings.Split(ciphertext, ":")
+	if len(parts) != 4 || parts[0] != envelopePrefix {
+		return "", nil, nil, false
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, false
+	}
+	sealed, err = base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, false
+	}
+	return parts[1], wrapped, sealed, true
+}
+
+// seal encrypts with AES-256-GCM, prefixing the nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
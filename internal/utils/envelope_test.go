@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring, err := NewKeyring("2024", "current master key", nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	sealed, err := keyring.Encrypt("hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !strings.HasPrefix(sealed, "v1:2024:") {
+		t.Errorf("sealed value %q doesn't name its master key", sealed)
+	}
+	again, _ := keyring.Encrypt("hunter2")
+	if again == sealed {
+		t.Error("the same plaintext sealed twice gives the same ciphertext")
+	}
+
+	plaintext, err := keyring.Decrypt(sealed)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if plaintext != "hunter2" {
+		t.Errorf("Decrypt = %q, want hunter2", plaintext)
+	}
+}
+
+func TestKeyringDecryptsLegacyValues(t *testing.T) {
+	// Values from before envelope encryption were sealed with the passphrase itself
+	legacy, err := Encrypt("hunter2", "old master key")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	for name, keyring := range map[string]func() (*Keyring, error){
+		"current key": func() (*Keyring, error) { return NewKeyring("1", "old master key", nil) },
+		"retired key": func() (*Keyring, error) {
+			return NewKeyring("2", "new master key", map[string]string{"1": "old master key"})
+		},
+	} {
+		k, err := keyring()
+		if err != nil {
+			t.Fatalf("%s: NewKeyring: %v", name, err)
+		}
+		plaintext, err := k.Decrypt(legacy)
+		if err != nil {
+			t.Fatalf("%s: Decrypt: %v", name, err)
+		}
+		if plaintext != "hunter2" {
+			t.Errorf("%s: Decrypt = %q, want hunter2", name, plaintext)
+		}
+	}
+
+	other, err := NewKeyring("3", "unrelated master key", nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if _, err := other.Decrypt(legacy); err == nil {
+		t.Error("a legacy value opened with a passphrase that didn't seal it")
+	}
+}
+
+func TestKeyringRewrap(t *testing.T) {
+	old, err := NewKeyring("1", "old master key", nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	sealed, err := old.Encrypt("hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	legacy, err := Encrypt("swordfish", "old master key")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	rotated, err := NewKeyring("2", "new master key", map[string]string{"1": "old master key"})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	for value, want := range map[string]string{sealed: "hunter2", legacy: "swordfish"} {
+		rewrapped, changed, err := rotated.Rewrap(value)
+		if err != nil {
+			t.Fatalf("Rewrap: %v", err)
+		}
+		if !changed || !strings.HasPrefix(rewrapped, "v1:2:") {
+			t.Errorf("Rewrap = %q, %v, want a value sealed under key 2", rewrapped, changed)
+		}
+
+		// Once re-wrapped, the retired key can be dropped
+		current, err := NewKeyring("2", "new master key", nil)
+		if err != nil {
+			t.Fatalf("NewKeyring: %v", err)
+		}
+		plaintext, err := current.Decrypt(rewrapped)
+		if err != nil {
+			t.Fatalf("Decrypt after Rewrap: %v", err)
+		}
+		if plaintext != want {
+			t.Errorf("Decrypt after Rewrap = %q, want %q", plaintext, want)
+		}
+
+		again, changed, err := rotated.Rewrap(rewrapped)
+		if err != nil || changed || again != rewrapped {
+			t.Errorf("Rewrap of a current value = %q, %v, %v, want it unchanged", again, changed, err)
+		}
+	}
+
+	if rewrapped, changed, err := rotated.Rewrap(""); rewrapped != "" || changed || err != nil {
+		t.Errorf("Rewrap(\"\") = %q, %v, %v, want nothing to do", rewrapped, changed, err)
+	}
+}
+
+func TestKeyringRejectsUnknownAndSwappedKeyIDs(t *testing.T) {
+	keyring, err := NewKeyring("2", "new master key", map[string]string{"1": "old master key"})
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	sealed, err := keyring.Encrypt("hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	current, err := NewKeyring("3", "newest master key", nil)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if _, err := current.Decrypt(sealed); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Errorf("value sealed with a key that is gone: got %v, want ErrUnknownMasterKey", err)
+	}
+
+	// Relabelling the master key ID doesn't get the value opened
+	swapped := strings.Replace(sealed, "v1:2:", "v1:1:", 1)
+	if _, err := keyring.Decrypt(swapped); err == nil {
+		t.Error("a value opened after its master key ID was changed")
+	}
+}
+
+func TestNewKeyringRejectsBadKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		id, key string
+		old     map[string]string
+	}{
+		{"empty key", "1", "", nil},
+		{"published example", "1", "32-byte-encryption-key-here!!", nil},
+		{"reused ID", "1", "current master key", map[string]string{"1": "old master key"}},
+		{"empty retired key", "2", "current master key", map[string]string{"1": ""}},
+		{"ID with a colon", "a:b", "current master key", nil},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.id, tt.key, tt.old); err == nil {
+			t.Errorf("%s: NewKeyring accepted it", tt.name)
+		}
+	}
+}
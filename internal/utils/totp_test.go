@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists eight digits; the code is the last six
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"previous step", -totpPeriod * time.Second, true},
+		{"current step", 0, true},
+		{"next step", totpPeriod * time.Second, true},
+		{"two steps ago", -2 * totpPeriod * time.Second, false},
+		{"two steps ahead", 2 * totpPeriod * time.Second, false},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, now.Add(tt.offset))
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+		matched, ok := ValidateTOTP(rfc6238Secret, code, now)
+		if ok != tt.ok {
+			t.Errorf("%s: ValidateTOTP ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && matched != step+int64(tt.offset/(totpPeriod*time.Second)) {
+			t.Errorf("%s: matched step %d, want %d", tt.name, matched, step+int64(tt.offset/(totpPeriod*time.Second)))
+		}
+	}
+}
+
+func TestValidateTOTPRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870822", "abcdef", "94287082"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, now); ok {
+			t.Errorf("ValidateTOTP accepted %q", code)
+		}
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, " 287082 ", now); !ok {
+		t.Error("ValidateTOTP rejected a code with surrounding spaces")
+	}
+}
@@ -12,10 +12,10 @@ import (
 	"ssh-terminal-app/internal/middleware"
 	"ssh-terminal-app/internal/repository"
 	"ssh-terminal-app/internal/service"
+	"ssh-terminal-app/internal/utils"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/mux"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
 // spaHandler implements http.Handler for serving a Single Page Application
@@ -47,40 +47,147 @@ func main() {
 	// 1. Load configuration
 	cfg := config.Load()
 
+	// Stored secrets are sealed under the master key; the server won't run
+	// with none or with a published example
+	keyring, err := utils.NewKeyring(cfg.EncryptionKeyID, cfg.EncryptionKey, cfg.EncryptionOldKeys)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	// Connection passwords and keys stay in the database unless
+	// SECRET_STORE names another backend
+	secretStore, err := service.NewSecretStore(cfg.SecretStore, keyring)
+	if err != nil {
+		log.Fatalf("Failed to configure secret store: %v", err)
+	}
+
 	// 2. Initialize database
 	db, err := database.Initialize(cfg.DatabasePath)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
-	// 3. Configure Google OAuth
-	var googleOAuth *oauth2.Config
-	if cfg.GoogleClientID != "" {
-		googleOAuth = &oauth2.Config{
-			ClientID:     cfg.GoogleClientID,
-			ClientSecret: cfg.GoogleClientSecret,
-			RedirectURL:  cfg.GoogleRedirectURL,
-			Scopes: []string{
-				"https://www.googleapis.com/auth/userinfo.email",
-				"https://www.googleapis.com/auth/userinfo.profile",
-			},
-			Endpoint: google.Endpoint,
+	// 3. Configure identity providers; Google is one when GOOGLE_CLIENT_ID is set
+	var identityProviders []service.IdentityProvider
+	for _, providerCfg := range cfg.OIDCProviders {
+		if providerCfg.IssuerURL == "" || providerCfg.ClientID == "" {
+			log.Fatalf("Identity provider %q needs an issuer URL and client ID", providerCfg.Name)
+		}
+		identityProviders = append(identityProviders, service.NewOIDCProvider(providerCfg))
+	}
+
+	// Password logins fall back to the corporate directory when LDAP_URL is set
+	var directory service.DirectoryAuthenticator
+	if cfg.LDAP.URL != "" {
+		directory, err = service.NewLDAPAuthenticator(cfg.LDAP)
+		if err != nil {
+			log.Fatalf("Failed to configure LDAP: %v", err)
 		}
 	}
 
+	// Account emails go through SMTP in production; MAIL_DRIVER=file or log
+	// keeps them local for development. Without a driver nothing is sent.
+	mailer, err := service.NewMailer(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to configure mail: %v", err)
+	}
+	if cfg.RequireVerifiedEmail && cfg.Mail.Driver == "none" {
+		log.Fatalf("REQUIRE_VERIFIED_EMAIL needs a MAIL_DRIVER to send verification links")
+	}
+
+	// Configure WebAuthn for passkeys
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     cfg.WebAuthnOrigins,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure WebAuthn: %v", err)
+	}
+
 	// 4. Initialize Repositories
 	userRepo := repository.NewUserRepository(db)
 	sshRepo := repository.NewSSHRepository(db)
+	sshIdentityRepo := repository.NewSSHIdentityRepository(db)
+	knownHostRepo := repository.NewKnownHostRepository(db)
+	recordingRepo := repository.NewRecordingRepository(db)
+	teamRepo := repository.NewTeamRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	tokenRepo := repository.NewTokenRepository(db)
+	accessTokenRepo := repository.NewAccessTokenRepository(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository(db)
+	emailTokenRepo := repository.NewEmailTokenRepository(db)
+	webAuthnRepo := repository.NewWebAuthnRepository(db)
+	identityRepo := repository.NewIdentityRepository(db)
+	signingKeyRepo := repository.NewSigningKeyRepository(db)
+	sshCARepo := repository.NewSSHCARepository(db)
+
+	// `server rewrap` moves stored secrets to the current master key. It can
+	// run next to the live server; afterwards the old key can be dropped
+	// from ENCRYPTION_OLD_KEYS.
+	if len(os.Args) > 1 && os.Args[1] == "rewrap" {
+		result, err := service.NewRewrapService(sshRepo, sshIdentityRepo, userRepo, signingKeyRepo, sshCARepo, keyring).Rewrap()
+		if err != nil {
+			log.Fatalf("Rewrap failed: %v", err)
+		}
+		log.Printf("Rewrap to master key %q: %d re-wrapped, %d already current, %d changed while running",
+			keyring.CurrentKeyID(), result.Rewrapped, result.Current, result.Skipped)
+		if result.Skipped > 0 {
+			log.Printf("Run rewrap again to pick up the values that changed")
+		}
+		return
+	}
+
+	// `server rotate-ssh-ca` adds a new SSH CA key. The previous key stays
+	// published so servers can trust both; the new one signs from the next
+	// restart, and the previous one is dropped at the rotation after.
+	if len(os.Args) > 1 && os.Args[1] == "rotate-ssh-ca" {
+		record, err := service.RotateSSHCAKey(sshCARepo, keyring)
+		if err != nil {
+			log.Fatalf("SSH CA rotation failed: %v", err)
+		}
+		log.Printf("New SSH CA key %d: %s", record.ID, record.PublicKey)
+		log.Printf("Add it to TrustedUserCAKeys on every server, then restart the app to sign with it")
+		return
+	}
 
 	// 5. Initialize Services
-	authService := service.NewAuthService(userRepo, cfg, googleOAuth)
-	sshService := service.NewSSHService(sshRepo, cfg)
-	terminalService := service.NewTerminalService(sshService)
+	tokenSigner, err := service.NewTokenSigner(signingKeyRepo, keyring, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	auditService := service.NewAuditService(auditRepo, userRepo, cfg)
+	// Failure counts behind login and key passphrase rate limiting
+	attempts := service.NewMemoryAttemptStore()
+	// Connections with auth type "certificate" log in with certificates
+	// signed by the app's own SSH CA
+	sshCAService, err := service.NewSSHCAService(sshCARepo, userRepo, teamRepo, auditService, keyring, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load SSH CA key: %v", err)
+	}
+	authService := service.NewAuthService(userRepo, tokenRepo, tokenSigner, accessTokenRepo, recoveryCodeRepo, emailTokenRepo, webAuthnRepo, identityRepo, teamRepo, attempts, auditService, mailer, keyring, cfg, identityProviders, directory, webAuthn)
+	authorizer := service.NewAuthorizer(sshRepo, teamRepo)
+	sshService := service.NewSSHService(sshRepo, sshIdentityRepo, authorizer, auditService, secretStore, cfg)
+	sshIdentityService := service.NewSSHIdentityService(sshIdentityRepo, auditService, secretStore)
+	teamService := service.NewTeamService(teamRepo, userRepo, authorizer)
+	hostKeyService := service.NewHostKeyService(knownHostRepo, sshRepo)
+	recordingService := service.NewRecordingService(recordingRepo, cfg)
+	terminalService := service.NewTerminalService(sshService, hostKeyService, sshCAService, authorizer, auditService, recordingService, userRepo, attempts, cfg)
+	fileService := service.NewFileService(sshService, hostKeyService, sshCAService, userRepo, cfg)
+	accountService := service.NewAccountService(userRepo, sshRepo, sshIdentityRepo, knownHostRepo, recordingRepo, teamRepo, identityRepo, webAuthnRepo, accessTokenRepo, auditRepo, secretStore, authService, terminalService, auditService)
 
 	// 6. Initialize Handlers with Services
-	authHandler := handlers.NewAuthHandler(authService, cfg)
-	sshHandler := handlers.NewSSHHandler(sshService, cfg)
-	terminalHandler := handlers.NewTerminalHandler(terminalService, cfg)
+	authHandler := handlers.NewAuthHandler(authService, accountService, cfg)
+	sshHandler := handlers.NewSSHHandler(sshService, authorizer, cfg)
+	sshIdentityHandler := handlers.NewSSHIdentityHandler(sshIdentityService, cfg)
+	hostKeyHandler := handlers.NewHostKeyHandler(hostKeyService, cfg)
+	fileHandler := handlers.NewFileHandler(fileService, cfg)
+	recordingHandler := handlers.NewRecordingHandler(recordingService, authService, cfg)
+	terminalHandler := handlers.NewTerminalHandler(terminalService, authService, cfg)
+	teamHandler := handlers.NewTeamHandler(teamService, cfg)
+	auditHandler := handlers.NewAuditHandler(auditService, cfg)
+	jwksHandler := handlers.NewJWKSHandler(tokenSigner)
+	sshCAHandler := handlers.NewSSHCAHandler(sshCAService, cfg)
 
 	// 7. Setup Router
 	r := mux.NewRouter()
@@ -89,24 +196,126 @@ func main() {
 	r.Use(middleware.CORS)
 
 	// Public routes
+	r.HandleFunc("/.well-known/jwks.json", jwksHandler.Get).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/ssh-ca/public-key", sshCAHandler.PublicKey).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST", "OPTIONS")
 	r.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/auth/google", authHandler.GoogleLogin).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/auth/google/callback", authHandler.GoogleCallback).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/auth/refresh", authHandler.Refresh).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/password/forgot", authHandler.ForgotPassword).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/password/reset", authHandler.ResetPassword).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/email/verify", authHandler.VerifyEmail).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/mfa/verify", authHandler.VerifyMFA).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/mfa/setup", authHandler.BeginMFASetup).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/mfa/setup/confirm", authHandler.CompleteMFASetup).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/webauthn/login/begin", authHandler.BeginPasskeyLogin).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/webauthn/login/finish", authHandler.FinishPasskeyLogin).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/webauthn/mfa/begin", authHandler.BeginPasskeyMFA).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/webauthn/mfa/finish", authHandler.FinishPasskeyMFA).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/providers", authHandler.ListProviders).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/auth/oidc/{provider}", authHandler.ProviderLogin).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/auth/oidc/{provider}/callback", authHandler.ProviderCallback).Methods("GET", "OPTIONS")
+	// Google's callback URL is registered with Google, so it keeps its old path
+	r.HandleFunc("/api/auth/{provider:google}", authHandler.ProviderLogin).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/auth/{provider:google}/callback", authHandler.ProviderCallback).Methods("GET", "OPTIONS")
 
 	// Protected routes
 	protected := r.PathPrefix("/api").Subrouter()
-	protected.Use(middleware.Auth(cfg.JWTSecret))
+	protected.Use(middleware.Auth(authService))
+
+	// can guards a /ssh/{id} route with a permission on that connection
+	can := func(perm service.Permission, handler http.HandlerFunc) http.Handler {
+		return handlers.RequireConnection(authorizer, perm)(handler)
+	}
+
+	// scoped opens a route to personal access tokens holding scope
+	scoped := func(scope string, handler http.Handler) http.Handler {
+		return middleware.RequireScope(scope)(handler)
+	}
+	read, write, open := service.ScopeConnectionsRead, service.ScopeConnectionsWrite, service.ScopeSessionsOpen
 
-	protected.HandleFunc("/ssh", sshHandler.List).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/ssh", sshHandler.Create).Methods("POST", "OPTIONS")
-	protected.HandleFunc("/ssh/{id}", sshHandler.Get).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/ssh/{id}", sshHandler.Update).Methods("PUT", "OPTIONS")
-	protected.HandleFunc("/ssh/{id}", sshHandler.Delete).Methods("DELETE", "OPTIONS")
+	protected.Handle("/ssh", scoped(read, http.HandlerFunc(sshHandler.List))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh", scoped(write, http.HandlerFunc(sshHandler.Create))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh/{id}", scoped(read, can(service.PermView, sshHandler.Get))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh/{id}", scoped(write, can(service.PermEdit, sshHandler.Update))).Methods("PUT", "OPTIONS")
+	protected.Handle("/ssh/{id}", scoped(write, can(service.PermDelete, sshHandler.Delete))).Methods("DELETE", "OPTIONS")
+	protected.Handle("/ssh/{id}/hostkey", scoped(read, can(service.PermView, hostKeyHandler.Get))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh/{id}/hostkey", scoped(write, can(service.PermEdit, hostKeyHandler.Pin))).Methods("PUT", "OPTIONS")
+	protected.Handle("/ssh/{id}/hostkey/accept", scoped(write, can(service.PermEdit, hostKeyHandler.AcceptChanged))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh/{id}/hostkey", scoped(write, can(service.PermEdit, hostKeyHandler.Clear))).Methods("DELETE", "OPTIONS")
+	protected.Handle("/ssh/{id}/files", scoped(open, can(service.PermConnect, fileHandler.List))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh/{id}/files", scoped(open, can(service.PermConnect, fileHandler.Delete))).Methods("DELETE", "OPTIONS")
+	protected.Handle("/ssh/{id}/files/stat", scoped(open, can(service.PermConnect, fileHandler.Stat))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh/{id}/files/download", scoped(open, can(service.PermConnect, fileHandler.Download))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh/{id}/files/upload", scoped(open, can(service.PermConnect, fileHandler.Upload))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh/{id}/files/rename", scoped(open, can(service.PermConnect, fileHandler.Rename))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh/{id}/files/chmod", scoped(open, can(service.PermConnect, fileHandler.Chmod))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh/{id}/files/mkdir", scoped(open, can(service.PermConnect, fileHandler.Mkdir))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh-identities", scoped(read, http.HandlerFunc(sshIdentityHandler.List))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh-identities", scoped(write, http.HandlerFunc(sshIdentityHandler.Create))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh-identities/generate", scoped(write, http.HandlerFunc(sshIdentityHandler.Generate))).Methods("POST", "OPTIONS")
+	protected.Handle("/ssh-identities/{id}", scoped(read, http.HandlerFunc(sshIdentityHandler.Get))).Methods("GET", "OPTIONS")
+	protected.Handle("/ssh-identities/{id}", scoped(write, http.HandlerFunc(sshIdentityHandler.Update))).Methods("PUT", "OPTIONS")
+	protected.Handle("/ssh-identities/{id}", scoped(write, http.HandlerFunc(sshIdentityHandler.Delete))).Methods("DELETE", "OPTIONS")
+	protected.Handle("/ssh-identities/{id}/connections", scoped(read, http.HandlerFunc(sshIdentityHandler.Connections))).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/recordings", recordingHandler.List).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/recordings/search", recordingHandler.Search).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/recordings/{id}", recordingHandler.Get).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/recordings/{id}/download", recordingHandler.Download).Methods("GET", "OPTIONS")
+	protected.Handle("/terminal/sessions", scoped(open, http.HandlerFunc(terminalHandler.ListSessions))).Methods("GET", "OPTIONS")
+	protected.Handle("/terminal/sessions/{sessionID}", scoped(open, http.HandlerFunc(terminalHandler.TerminateSession))).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/terminal/sessions/{sessionID}/shares", terminalHandler.ListShares).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/terminal/sessions/{sessionID}/shares", terminalHandler.CreateShare).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/terminal/sessions/{sessionID}/shares/{shareToken}", terminalHandler.RevokeShare).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/terminal/sessions/{sessionID}/viewers", terminalHandler.ListViewers).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/terminal/sessions/{sessionID}/viewers/{viewerID}", terminalHandler.KickViewer).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/teams", teamHandler.List).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/teams", teamHandler.Create).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/teams/{id}", teamHandler.Get).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/teams/{id}", teamHandler.Update).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/teams/{id}", teamHandler.Delete).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/teams/{id}/members", teamHandler.ListMembers).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/teams/{id}/members", teamHandler.AddMember).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/teams/{id}/members/{userID}", teamHandler.UpdateMember).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/teams/{id}/members/{userID}", teamHandler.RemoveMember).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/audit", auditHandler.List).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/audit/export", auditHandler.Export).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/audit/verify", auditHandler.Verify).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/auth/me", authHandler.Me).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/email/verify/resend", authHandler.ResendVerification).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/password", authHandler.ChangePassword).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/identities", authHandler.ListIdentities).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/identities", authHandler.LinkIdentity).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/identities/confirm", authHandler.ConfirmIdentityLink).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/identities/directory", authHandler.LinkDirectory).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/identities/{id}", authHandler.UnlinkIdentity).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/account/export", authHandler.ExportAccount).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/account", authHandler.DeleteAccount).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/mfa", authHandler.MFAStatus).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/mfa/enroll", authHandler.BeginMFAEnrollment).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/mfa/enroll/confirm", authHandler.ConfirmMFAEnrollment).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/mfa/disable", authHandler.DisableMFA).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/mfa/passkeys", authHandler.SetPasskeyMFA).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/auth/webauthn/register/begin", authHandler.BeginPasskeyRegistration).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/webauthn/register/finish", authHandler.FinishPasskeyRegistration).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/webauthn/credentials", authHandler.ListPasskeys).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/webauthn/credentials/{id}", authHandler.DeletePasskey).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/auth/tokens", authHandler.ListAccessTokens).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/tokens", authHandler.CreateAccessToken).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/auth/tokens/{id}", authHandler.RevokeAccessToken).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/admin/users/{id}/mfa", authHandler.SetMFARequired).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/admin/users/{id}/mfa", authHandler.ResetMFA).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/admin/users/{id}/ssh-principals", sshCAHandler.GetUserPrincipals).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/admin/users/{id}/ssh-principals", sshCAHandler.SetUserPrincipals).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/admin/teams/{id}/ssh-principals", sshCAHandler.GetTeamPrincipals).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/admin/teams/{id}/ssh-principals", sshCAHandler.SetTeamPrincipals).Methods("PUT", "OPTIONS")
 
 	// WebSocket route for terminal (handshakes auth internally via query token)
 	r.HandleFunc("/ws/terminal/{id}", terminalHandler.HandleWebSocket)
+	r.HandleFunc("/ws/terminal/session/{sessionID}", terminalHandler.HandleAttach)
+	r.HandleFunc("/ws/terminal/shared/{shareToken}", terminalHandler.HandleJoinShared)
+	r.HandleFunc("/ws/recordings/{id}", recordingHandler.Playback)
 
 	// Serve static files for frontend with SPA fallback
 	spa := spaHandler{staticPath: "./frontend/dist", indexPath: "index.html"}